@@ -0,0 +1,107 @@
+package dbrelation_test
+
+import (
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/dbrelation"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type suite struct{}
+
+var _ = gc.Suite(&suite{})
+
+var testParams = dbrelation.ConnParams{
+	Host:     "10.0.0.1",
+	Port:     5432,
+	User:     "someuser",
+	Password: "secret",
+	Database: "somedb",
+}
+
+func (*suite) TestRoundTrip(c *gc.C) {
+	// Run the provider side and record the settings it publishes.
+	provider := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"db": {"db:0"},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			var p dbrelation.Provider
+			p.Register(r, "db", "pgsql")
+			r.RegisterHook("config-changed", func() error {
+				return p.SetConnection(testParams)
+			})
+		},
+	}
+	err := provider.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	settings := provider.LocalRelations["db:0"]
+	c.Assert(settings, gc.HasLen, 5)
+
+	// Feed those settings to the requirer side.
+	var conn *dbrelation.ConnParams
+	requirer := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"db": {"db:1"},
+		},
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"db:1": {
+				"postgresql/0": settings,
+			},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			var req dbrelation.Requirer
+			req.Register(r, "db", "pgsql")
+			r.RegisterHook("*", func() error {
+				var err error
+				conn, err = req.Connection()
+				return err
+			})
+		},
+	}
+	err = requirer.RunHook("db-relation-changed", "db:1", "postgresql/0")
+	c.Assert(err, gc.IsNil)
+	c.Assert(conn, jc.DeepEquals, &testParams)
+}
+
+func (*suite) TestNotReady(c *gc.C) {
+	var connErr error
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"db": {"db:1"},
+		},
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"db:1": {
+				"postgresql/0": {
+					"host": "10.0.0.1",
+					"port": "5432",
+				},
+			},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			var req dbrelation.Requirer
+			req.Register(r, "db", "pgsql")
+			r.RegisterHook("*", func() error {
+				_, connErr = req.Connection()
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("db-relation-changed", "db:1", "postgresql/0")
+	c.Assert(err, gc.IsNil)
+	c.Assert(connErr, gc.Equals, dbrelation.ErrNotReady)
+}
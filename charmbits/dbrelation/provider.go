@@ -0,0 +1,50 @@
+// The dbrelation package implements a generic database relation,
+// where the provider publishes the parameters needed to connect to
+// a database (host, port, user, password and database name) and the
+// requirer reads them.
+package dbrelation
+
+import (
+	"strconv"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/simplerelation"
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// ConnParams holds the parameters needed to connect to a database.
+type ConnParams struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+}
+
+// Provider represents the provider side of a database relation.
+type Provider struct {
+	prov simplerelation.Provider
+}
+
+// Register registers the provider side of a database relation with
+// the given relation name and interface (for example "pgsql" or
+// "mysql").
+func (p *Provider) Register(r *hook.Registry, relationName, interfaceName string) {
+	p.prov.Register(r.Clone("db"), relationName, interfaceName)
+}
+
+// SetConnection makes the given connection parameters available
+// to all requirer-side units of the relation.
+func (p *Provider) SetConnection(params ConnParams) error {
+	if err := p.prov.SetValues(map[string]string{
+		"host":     params.Host,
+		"port":     strconv.Itoa(params.Port),
+		"user":     params.User,
+		"password": params.Password,
+		"database": params.Database,
+	}); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
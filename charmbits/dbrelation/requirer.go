@@ -0,0 +1,74 @@
+package dbrelation
+
+import (
+	"sort"
+	"strconv"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/simplerelation"
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// ErrNotReady is returned by Requirer.Connection when no provider
+// unit has yet published a complete set of connection parameters.
+var ErrNotReady = errgo.New("database connection parameters not ready")
+
+// Requirer represents the requirer side of a database relation.
+type Requirer struct {
+	req simplerelation.Requirer
+}
+
+// Register registers a database requirer relation with the given
+// relation name and interface with the given hook registry.
+//
+// To find out when the connection parameters change, register
+// a wildcard ("*") hook.
+func (req *Requirer) Register(r *hook.Registry, relationName, interfaceName string) {
+	req.req.Register(r, relationName, interfaceName)
+}
+
+// Connection returns the connection parameters published by the
+// provider. If more than one provider unit has published parameters,
+// those of the unit with the lowest id are used. It returns an error
+// with an ErrNotReady cause if no unit has published all of them yet.
+func (req *Requirer) Connection() (*ConnParams, error) {
+	unitVals := req.req.Values()
+	unitIds := make([]string, 0, len(unitVals))
+	for unitId := range unitVals {
+		unitIds = append(unitIds, string(unitId))
+	}
+	sort.Strings(unitIds)
+	for _, unitId := range unitIds {
+		params, err := connParams(unitVals[hook.UnitId(unitId)])
+		if errgo.Cause(err) == ErrNotReady {
+			continue
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "unit %s has invalid connection parameters", unitId)
+		}
+		return params, nil
+	}
+	return nil, ErrNotReady
+}
+
+var requiredAttrs = []string{"host", "port", "user", "password", "database"}
+
+func connParams(vals map[string]string) (*ConnParams, error) {
+	for _, attr := range requiredAttrs {
+		if vals[attr] == "" {
+			return nil, ErrNotReady
+		}
+	}
+	port, err := strconv.Atoi(vals["port"])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errgo.Newf("invalid port %q", vals["port"])
+	}
+	return &ConnParams{
+		Host:     vals["host"],
+		Port:     port,
+		User:     vals["user"],
+		Password: vals["password"],
+		Database: vals["database"],
+	}, nil
+}
@@ -0,0 +1,6 @@
+package firewall
+
+var (
+	ExecCommand = &execCommand
+	LookPath    = &lookPath
+)
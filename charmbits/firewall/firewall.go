@@ -0,0 +1,327 @@
+// The firewall package provides a charmbit that manages
+// host firewall rules, using nftables if it is available
+// and iptables otherwise. Rules are applied idempotently,
+// and all the rules added by the charm are removed when
+// the stop hook runs.
+package firewall
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// execCommand is used to run firewall commands, returning their
+// combined standard output and standard error. It is defined as a
+// variable so that it can be replaced for testing purposes.
+var execCommand = func(name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	c := exec.Command(name, args...)
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// lookPath is used to find out which firewall commands are
+// available. It is defined as a variable so that it can be
+// replaced for testing purposes.
+var lookPath = exec.LookPath
+
+// Rule holds a firewall rule for incoming traffic.
+type Rule struct {
+	// Proto holds the protocol that the rule applies
+	// to: "tcp" or "udp".
+	Proto string
+
+	// Port holds the destination port that the rule applies to.
+	Port int
+
+	// Source holds the source address or CIDR range that the
+	// rule applies to. If it is empty, the rule applies to
+	// traffic from any address (any IPv4 address when
+	// iptables is used).
+	Source string
+
+	// Drop specifies that matching traffic should be dropped
+	// rather than accepted.
+	Drop bool
+}
+
+// Validate checks that the rule is valid.
+func (r Rule) Validate() error {
+	if r.Proto != "tcp" && r.Proto != "udp" {
+		return errgo.Newf("invalid protocol %q", r.Proto)
+	}
+	if r.Port <= 0 || r.Port > 65535 {
+		return errgo.Newf("invalid port %d", r.Port)
+	}
+	if strings.ContainsAny(r.Source, " \t\n;{}#") {
+		return errgo.Newf("invalid source %q", r.Source)
+	}
+	return nil
+}
+
+// ipv6 reports whether the rule applies to IPv6 traffic.
+func (r Rule) ipv6() bool {
+	return strings.Contains(r.Source, ":")
+}
+
+// Firewall represents the set of firewall rules
+// managed by the charm.
+type Firewall struct {
+	ctxt  *hook.Context
+	state localState
+}
+
+type localState struct {
+	// Rules holds the rules added by EnsureRule
+	// that have not since been removed.
+	Rules []Rule
+}
+
+// Register registers the firewall with the given registry.
+// All the rules added with EnsureRule are removed when the stop
+// hook runs.
+func (f *Firewall) Register(r *hook.Registry) {
+	r.RegisterContext(f.setContext, &f.state)
+	r.RegisterHook("stop", f.stopHook)
+}
+
+func (f *Firewall) setContext(ctxt *hook.Context) error {
+	f.ctxt = ctxt
+	return nil
+}
+
+// EnsureRule adds the given rule to the host firewall
+// unless it is already there.
+func (f *Firewall) EnsureRule(r Rule) error {
+	if err := r.Validate(); err != nil {
+		return errgo.Mask(err)
+	}
+	b, err := newBackend()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := b.ensure(r); err != nil {
+		return errgo.Notef(err, "cannot add firewall rule")
+	}
+	for _, r1 := range f.state.Rules {
+		if r1 == r {
+			return nil
+		}
+	}
+	f.state.Rules = append(f.state.Rules, r)
+	return nil
+}
+
+// RemoveRule removes the given rule from the host firewall
+// if it is there.
+func (f *Firewall) RemoveRule(r Rule) error {
+	if err := r.Validate(); err != nil {
+		return errgo.Mask(err)
+	}
+	b, err := newBackend()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := b.remove(r); err != nil {
+		return errgo.Notef(err, "cannot remove firewall rule")
+	}
+	rules := f.state.Rules[:0]
+	for _, r1 := range f.state.Rules {
+		if r1 != r {
+			rules = append(rules, r1)
+		}
+	}
+	f.state.Rules = rules
+	return nil
+}
+
+func (f *Firewall) stopHook() error {
+	for len(f.state.Rules) > 0 {
+		f.ctxt.Logf("removing firewall rule %+v", f.state.Rules[0])
+		if err := f.RemoveRule(f.state.Rules[0]); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// backend is implemented by the firewall backends. Both
+// methods must be idempotent.
+type backend interface {
+	ensure(r Rule) error
+	remove(r Rule) error
+}
+
+// newBackend returns the backend for the firewall
+// command available on the host.
+func newBackend() (backend, error) {
+	if _, err := lookPath("nft"); err == nil {
+		return nftBackend{}, nil
+	}
+	if _, err := lookPath("iptables"); err == nil {
+		return iptablesBackend{}, nil
+	}
+	return nil, errgo.New("neither nft nor iptables found")
+}
+
+// iptablesBackend manages rules in the INPUT chain with
+// iptables, or ip6tables for IPv6 rules.
+type iptablesBackend struct{}
+
+func (iptablesBackend) ensure(r Rule) error {
+	if iptablesHasRule(r) {
+		return nil
+	}
+	return errgo.Mask(iptables(r, "-A"))
+}
+
+func (iptablesBackend) remove(r Rule) error {
+	if !iptablesHasRule(r) {
+		return nil
+	}
+	return errgo.Mask(iptables(r, "-D"))
+}
+
+// iptablesHasRule reports whether the given rule is in the
+// INPUT chain. iptables -C fails if the rule is not found.
+func iptablesHasRule(r Rule) bool {
+	return iptables(r, "-C") == nil
+}
+
+// iptables runs iptables with the given operation
+// on the given rule.
+func iptables(r Rule, op string) error {
+	cmd := "iptables"
+	if r.ipv6() {
+		cmd = "ip6tables"
+	}
+	args := []string{op, "INPUT", "-p", r.Proto, "--dport", strconv.Itoa(r.Port)}
+	if r.Source != "" {
+		args = append(args, "-s", r.Source)
+	}
+	target := "ACCEPT"
+	if r.Drop {
+		target = "DROP"
+	}
+	args = append(args, "-j", target)
+	if out, err := execCommand(cmd, args...); err != nil {
+		return errgo.Newf("%s %s failed: %v: %s", cmd, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// nftTable and nftChain hold the names of the nftables
+// table and chain that hold the charm's rules.
+const (
+	nftTable = "gocharm"
+	nftChain = "input"
+)
+
+// nftBackend manages rules in a chain of its own with nft.
+type nftBackend struct{}
+
+func (nftBackend) ensure(r Rule) error {
+	if err := nft("add", "table", "inet", nftTable); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := nft("add", "chain", "inet", nftTable, nftChain, "{ type filter hook input priority 0 ; policy accept ; }"); err != nil {
+		return errgo.Mask(err)
+	}
+	handle, err := nftRuleHandle(r)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if handle != "" {
+		return nil
+	}
+	return errgo.Mask(nft(append([]string{"add", "rule", "inet", nftTable, nftChain}, nftExpr(r)...)...))
+}
+
+func (nftBackend) remove(r Rule) error {
+	handle, err := nftRuleHandle(r)
+	if err != nil {
+		// The chain does not exist, so neither can the rule.
+		return nil
+	}
+	if handle == "" {
+		return nil
+	}
+	return errgo.Mask(nft("delete", "rule", "inet", nftTable, nftChain, "handle", handle))
+}
+
+// nftRuleHandle returns the handle of the given rule in
+// the charm's chain, or the empty string if it is not there.
+func nftRuleHandle(r Rule) (string, error) {
+	out, err := execCommand("nft", "-a", "list", "chain", "inet", nftTable, nftChain)
+	if err != nil {
+		return "", errgo.Newf("cannot list nft chain: %v: %s", err, bytes.TrimSpace(out))
+	}
+	expr := strings.Join(nftExpr(r), " ")
+	for _, line := range strings.Split(string(out), "\n") {
+		i := strings.Index(line, " # handle ")
+		if i == -1 {
+			continue
+		}
+		if strings.TrimSpace(line[:i]) == expr {
+			return strings.TrimSpace(line[i+len(" # handle "):]), nil
+		}
+	}
+	return "", nil
+}
+
+// nftExpr returns the nft rule expression for the given rule,
+// in the form that nft prints it.
+func nftExpr(r Rule) []string {
+	var expr []string
+	if r.Source != "" {
+		family := "ip"
+		if r.ipv6() {
+			family = "ip6"
+		}
+		expr = append(expr, family, "saddr", nftSource(r.Source))
+	}
+	expr = append(expr, r.Proto, "dport", strconv.Itoa(r.Port))
+	if r.Drop {
+		return append(expr, "drop")
+	}
+	return append(expr, "accept")
+}
+
+// nftSource returns the given source address or CIDR range in the
+// canonical form that nft prints it in, so that an existing rule can
+// be found: host bits are cleared from a range, a range holding a
+// single address is printed as the address, and IPv6 addresses are
+// compressed. A source that cannot be parsed is returned unchanged.
+func nftSource(src string) string {
+	if !strings.Contains(src, "/") {
+		if ip := net.ParseIP(src); ip != nil {
+			return ip.String()
+		}
+		return src
+	}
+	_, ipnet, err := net.ParseCIDR(src)
+	if err != nil {
+		return src
+	}
+	if ones, bits := ipnet.Mask.Size(); ones == bits {
+		return ipnet.IP.String()
+	}
+	return ipnet.String()
+}
+
+// nft runs nft with the given arguments.
+func nft(args ...string) error {
+	if out, err := execCommand("nft", args...); err != nil {
+		return errgo.Newf("nft %s failed: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
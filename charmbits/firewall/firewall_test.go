@@ -0,0 +1,297 @@
+package firewall_test
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/firewall"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct {
+	jujutesting.CleanupSuite
+}
+
+var _ = gc.Suite(&suite{})
+
+// fakeFirewall simulates the iptables and nft commands,
+// recording the commands that change the rules.
+type fakeFirewall struct {
+	// rules holds the current rules, keyed by
+	// iptables rule specification or nft handle.
+	rules map[string]string
+
+	// nextHandle holds the next nft rule handle.
+	nextHandle int
+
+	// changes records the commands that changed the rules.
+	changes []string
+}
+
+func (s *suite) patch(c *gc.C, backend string) *fakeFirewall {
+	f := &fakeFirewall{
+		rules:      make(map[string]string),
+		nextHandle: 1,
+	}
+	s.PatchValue(firewall.LookPath, func(name string) (string, error) {
+		if name == backend {
+			return "/usr/sbin/" + name, nil
+		}
+		return "", exec.ErrNotFound
+	})
+	s.PatchValue(firewall.ExecCommand, func(name string, args ...string) ([]byte, error) {
+		c.Check(name, gc.Matches, backend+"|ip6tables")
+		switch name {
+		case "nft":
+			return f.nft(args)
+		default:
+			return f.iptables(name, args)
+		}
+	})
+	return f
+}
+
+func (f *fakeFirewall) iptables(cmd string, args []string) ([]byte, error) {
+	spec := cmd + " " + strings.Join(args[1:], " ")
+	_, ok := f.rules[spec]
+	switch args[0] {
+	case "-C":
+		if !ok {
+			return []byte("iptables: Bad rule"), fmt.Errorf("exit status 1")
+		}
+	case "-A":
+		f.rules[spec] = spec
+		f.changes = append(f.changes, cmd+" "+strings.Join(args, " "))
+	case "-D":
+		if !ok {
+			return []byte("iptables: Bad rule"), fmt.Errorf("exit status 1")
+		}
+		delete(f.rules, spec)
+		f.changes = append(f.changes, cmd+" "+strings.Join(args, " "))
+	}
+	return nil, nil
+}
+
+func (f *fakeFirewall) nft(args []string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	switch {
+	case strings.HasPrefix(cmd, "add table ") || strings.HasPrefix(cmd, "add chain "):
+	case cmd == "-a list chain inet gocharm input":
+		out := "table inet gocharm {\n\tchain input {\n\t\ttype filter hook input priority filter; policy accept;\n"
+		for handle, rule := range f.rules {
+			out += fmt.Sprintf("\t\t%s # handle %s\n", rule, handle)
+		}
+		return []byte(out + "\t}\n}\n"), nil
+	case strings.HasPrefix(cmd, "add rule inet gocharm input "):
+		handle := fmt.Sprint(f.nextHandle)
+		f.nextHandle++
+		f.rules[handle] = strings.TrimPrefix(cmd, "add rule inet gocharm input ")
+		f.changes = append(f.changes, "nft "+cmd)
+	case strings.HasPrefix(cmd, "delete rule inet gocharm input handle "):
+		delete(f.rules, strings.TrimPrefix(cmd, "delete rule inet gocharm input handle "))
+		f.changes = append(f.changes, "nft "+cmd)
+	default:
+		return nil, fmt.Errorf("unexpected nft command %q", cmd)
+	}
+	return nil, nil
+}
+
+var rules = []firewall.Rule{{
+	Proto: "tcp",
+	Port:  80,
+}, {
+	Proto:  "udp",
+	Port:   53,
+	Source: "10.0.0.0/8",
+}, {
+	Proto:  "tcp",
+	Port:   22,
+	Source: "2001:db8::/32",
+	Drop:   true,
+}}
+
+var firewallTests = []struct {
+	backend       string
+	expectAdded   []string
+	expectRemoved []string
+}{{
+	backend: "iptables",
+	expectAdded: []string{
+		"iptables -A INPUT -p tcp --dport 80 -j ACCEPT",
+		"iptables -A INPUT -p udp --dport 53 -s 10.0.0.0/8 -j ACCEPT",
+		"ip6tables -A INPUT -p tcp --dport 22 -s 2001:db8::/32 -j DROP",
+	},
+	expectRemoved: []string{
+		"iptables -D INPUT -p tcp --dport 80 -j ACCEPT",
+		"iptables -D INPUT -p udp --dport 53 -s 10.0.0.0/8 -j ACCEPT",
+		"ip6tables -D INPUT -p tcp --dport 22 -s 2001:db8::/32 -j DROP",
+	},
+}, {
+	backend: "nft",
+	expectAdded: []string{
+		"nft add rule inet gocharm input tcp dport 80 accept",
+		"nft add rule inet gocharm input ip saddr 10.0.0.0/8 udp dport 53 accept",
+		"nft add rule inet gocharm input ip6 saddr 2001:db8::/32 tcp dport 22 drop",
+	},
+	expectRemoved: []string{
+		"nft delete rule inet gocharm input handle 1",
+		"nft delete rule inet gocharm input handle 2",
+		"nft delete rule inet gocharm input handle 3",
+	},
+}}
+
+func (s *suite) TestEnsureRuleIdempotent(c *gc.C) {
+	for i, test := range firewallTests {
+		c.Logf("test %d: %s", i, test.backend)
+		f := s.patch(c, test.backend)
+		var fw firewall.Firewall
+		runner := &hooktest.Runner{
+			HookStateDir: c.MkDir(),
+			Logger:       c,
+			RegisterHooks: func(r *hook.Registry) {
+				fw.Register(r.Clone("firewall"))
+				r.RegisterHook("install", func() error {
+					for _, rule := range rules {
+						if err := fw.EnsureRule(rule); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+		}
+		err := runner.RunHook("install", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(f.changes, jc.DeepEquals, test.expectAdded)
+
+		// Running the hook again changes nothing.
+		err = runner.RunHook("install", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(f.changes, jc.DeepEquals, test.expectAdded)
+
+		// All the rules are removed by the stop hook.
+		f.changes = nil
+		err = runner.RunHook("stop", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(f.changes, jc.DeepEquals, test.expectRemoved)
+		c.Assert(f.rules, gc.HasLen, 0)
+	}
+}
+
+func (s *suite) TestRemoveRuleIdempotent(c *gc.C) {
+	for i, test := range firewallTests {
+		c.Logf("test %d: %s", i, test.backend)
+		f := s.patch(c, test.backend)
+		var fw firewall.Firewall
+		runner := &hooktest.Runner{
+			HookStateDir: c.MkDir(),
+			Logger:       c,
+			RegisterHooks: func(r *hook.Registry) {
+				fw.Register(r)
+				r.RegisterHook("install", func() error {
+					return fw.EnsureRule(rules[0])
+				})
+				r.RegisterHook("config-changed", func() error {
+					if err := fw.RemoveRule(rules[0]); err != nil {
+						return err
+					}
+					return fw.RemoveRule(rules[0])
+				})
+			},
+		}
+		err := runner.RunHook("install", "", "")
+		c.Assert(err, gc.IsNil)
+		err = runner.RunHook("config-changed", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(f.changes, jc.DeepEquals, []string{test.expectAdded[0], test.expectRemoved[0]})
+
+		// The rule is no longer managed, so the stop hook
+		// does nothing.
+		f.changes = nil
+		err = runner.RunHook("stop", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(f.changes, gc.HasLen, 0)
+	}
+}
+
+func (s *suite) TestEnsureRuleNonCanonicalSource(c *gc.C) {
+	f := s.patch(c, "nft")
+	var fw firewall.Firewall
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			fw.Register(r)
+			r.RegisterHook("install", func() error {
+				for _, rule := range []firewall.Rule{{
+					Proto:  "tcp",
+					Port:   80,
+					Source: "10.1.2.3/8",
+				}, {
+					Proto:  "tcp",
+					Port:   443,
+					Source: "192.168.1.1/32",
+				}, {
+					Proto:  "tcp",
+					Port:   22,
+					Source: "2001:DB8:0:0::1",
+				}} {
+					if err := fw.EnsureRule(rule); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+	expectAdded := []string{
+		"nft add rule inet gocharm input ip saddr 10.0.0.0/8 tcp dport 80 accept",
+		"nft add rule inet gocharm input ip saddr 192.168.1.1 tcp dport 443 accept",
+		"nft add rule inet gocharm input ip6 saddr 2001:db8::1 tcp dport 22 accept",
+	}
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.changes, jc.DeepEquals, expectAdded)
+
+	// The rules are found in nft's canonical output,
+	// so running the hook again adds no duplicates.
+	err = runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.changes, jc.DeepEquals, expectAdded)
+}
+
+func (s *suite) TestNoBackend(c *gc.C) {
+	s.PatchValue(firewall.LookPath, func(name string) (string, error) {
+		return "", exec.ErrNotFound
+	})
+	var fw firewall.Firewall
+	err := fw.EnsureRule(rules[0])
+	c.Assert(err, gc.ErrorMatches, "neither nft nor iptables found")
+}
+
+var invalidRuleTests = []struct {
+	rule        firewall.Rule
+	expectError string
+}{{
+	rule:        firewall.Rule{Proto: "icmp", Port: 80},
+	expectError: `invalid protocol "icmp"`,
+}, {
+	rule:        firewall.Rule{Proto: "tcp", Port: 70000},
+	expectError: `invalid port 70000`,
+}, {
+	rule:        firewall.Rule{Proto: "tcp", Port: 80, Source: "10.0.0.0/8; flush"},
+	expectError: `invalid source "10.0.0.0/8; flush"`,
+}}
+
+func (s *suite) TestInvalidRule(c *gc.C) {
+	for i, test := range invalidRuleTests {
+		c.Logf("test %d: %+v", i, test.rule)
+		c.Assert(test.rule.Validate(), gc.ErrorMatches, test.expectError)
+	}
+}
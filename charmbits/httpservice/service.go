@@ -263,11 +263,17 @@ func (svc *Service) changed() error {
 		// TODO set charm status instead?
 		return errgo.Mask(err)
 	}
-	if !svc.state.Started || httpPort == 0 && (httpsPort == 0 || cert == "") {
+	// The OS service is stopped when the charm is stopped,
+	// so it must not be started again by the stop hook.
+	if !svc.state.Started || svc.ctxt.HookName == "stop" || httpPort == 0 && (httpsPort == 0 || cert == "") {
 		svc.ctxt.Logf("httpservice: stopping service")
 		return svc.svc.Stop()
 	}
-	if !svc.svc.Started() {
+	started, err := svc.svc.Started()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !started {
 		svc.ctxt.Logf("httpservice: starting service")
 		if err := svc.svc.Start(svc.ctxt.StateDir()); err != nil {
 			return errgo.Notef(err, "cannot start service")
@@ -0,0 +1,3 @@
+package logrotate
+
+var ConfigDir = &configDir
@@ -0,0 +1,181 @@
+// The logrotate package provides a charmbit that maintains
+// a logrotate configuration snippet for the log files
+// written by a charm's services.
+package logrotate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// configDir holds the directory that logrotate
+// reads configuration snippets from.
+var configDir = "/etc/logrotate.d"
+
+// LogrotateParams holds the rotation settings
+// for a set of log files.
+type LogrotateParams struct {
+	// Paths holds the absolute paths of the log files
+	// to rotate. Paths may contain glob patterns.
+	Paths []string
+
+	// Frequency holds how often the logs are rotated:
+	// one of "daily", "weekly", "monthly" or "yearly".
+	// If it is empty, logrotate's default is used.
+	Frequency string
+
+	// Size holds the size that a log file must reach before
+	// it is rotated, for example "100k", "10M" or "1G".
+	// If it is empty, logs are rotated regardless of size.
+	Size string
+
+	// Count holds the number of rotated logs to keep.
+	// If it is zero, rotated logs are removed.
+	Count int
+
+	// Compress specifies that rotated logs should be compressed.
+	Compress bool
+
+	// CopyTruncate specifies that logs should be truncated
+	// in place after being copied, for services that
+	// cannot be told to reopen their log files.
+	CopyTruncate bool
+}
+
+var (
+	validFrequencies = map[string]bool{
+		"daily":   true,
+		"weekly":  true,
+		"monthly": true,
+		"yearly":  true,
+	}
+	validSize = regexp.MustCompile(`^[0-9]+[kMG]?$`)
+)
+
+// Validate checks that the parameters are valid.
+func (p LogrotateParams) Validate() error {
+	if len(p.Paths) == 0 {
+		return errgo.New("no log paths specified")
+	}
+	for _, path := range p.Paths {
+		if !filepath.IsAbs(path) {
+			return errgo.Newf("log path %q is not absolute", path)
+		}
+		if strings.ContainsAny(path, " \t\n{}") {
+			return errgo.Newf("log path %q contains invalid characters", path)
+		}
+	}
+	if p.Frequency != "" && !validFrequencies[p.Frequency] {
+		return errgo.Newf("invalid rotation frequency %q", p.Frequency)
+	}
+	if p.Size != "" && !validSize.MatchString(p.Size) {
+		return errgo.Newf("invalid rotation size %q", p.Size)
+	}
+	if p.Count < 0 {
+		return errgo.Newf("negative rotation count %d", p.Count)
+	}
+	return nil
+}
+
+// Logrotate represents a logrotate configuration snippet
+// managed by the charm.
+type Logrotate struct {
+	ctxt  *hook.Context
+	name  string
+	state localState
+}
+
+type localState struct {
+	// Path holds the path of the snippet written
+	// by Configure, if any.
+	Path string
+}
+
+// Register registers the logrotate snippet with the given registry.
+// If name is non-empty, it specifies the name of the snippet file,
+// otherwise the snippet will be named after the charm's application.
+//
+// The snippet is removed when the stop hook runs.
+func (l *Logrotate) Register(r *hook.Registry, name string) {
+	l.name = name
+	r.RegisterContext(l.setContext, &l.state)
+	r.RegisterHook("stop", l.stopHook)
+}
+
+func (l *Logrotate) setContext(ctxt *hook.Context) error {
+	l.ctxt = ctxt
+	return nil
+}
+
+// Configure writes the logrotate snippet for the given parameters,
+// replacing any snippet written earlier. The file is replaced
+// atomically, so logrotate never sees a partially written snippet.
+func (l *Logrotate) Configure(p LogrotateParams) error {
+	if err := p.Validate(); err != nil {
+		return errgo.Mask(err)
+	}
+	path := l.path()
+	if _, err := hook.WriteFileAtomic(path, l.snippet(p), 0644); err != nil {
+		return errgo.Notef(err, "cannot write logrotate configuration")
+	}
+	l.state.Path = path
+	return nil
+}
+
+// Remove removes the logrotate snippet, if it has been written.
+func (l *Logrotate) Remove() error {
+	if l.state.Path == "" {
+		return nil
+	}
+	if err := os.Remove(l.state.Path); err != nil && !os.IsNotExist(err) {
+		return errgo.Notef(err, "cannot remove logrotate configuration")
+	}
+	l.state.Path = ""
+	return nil
+}
+
+func (l *Logrotate) stopHook() error {
+	return l.Remove()
+}
+
+// path returns the path of the snippet file.
+func (l *Logrotate) path() string {
+	name := l.name
+	if name == "" {
+		name = strings.SplitN(string(l.ctxt.Unit), "/", 2)[0]
+	}
+	return filepath.Join(configDir, name)
+}
+
+// snippet returns the contents of the logrotate
+// snippet for the given parameters.
+func (l *Logrotate) snippet(p LogrotateParams) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by the %s charm; do not edit.\n", l.ctxt.Unit)
+	fmt.Fprintf(&buf, "%s {\n", strings.Join(p.Paths, " "))
+	if p.Frequency != "" {
+		fmt.Fprintf(&buf, "\t%s\n", p.Frequency)
+	}
+	if p.Size != "" {
+		fmt.Fprintf(&buf, "\tsize %s\n", p.Size)
+	}
+	fmt.Fprintf(&buf, "\trotate %d\n", p.Count)
+	if p.Compress {
+		fmt.Fprintf(&buf, "\tcompress\n")
+	}
+	if p.CopyTruncate {
+		fmt.Fprintf(&buf, "\tcopytruncate\n")
+	}
+	fmt.Fprintf(&buf, "\tmissingok\n")
+	fmt.Fprintf(&buf, "\tnotifempty\n")
+	fmt.Fprintf(&buf, "}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,182 @@
+package logrotate_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jujutesting "github.com/juju/testing"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/logrotate"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct {
+	jujutesting.CleanupSuite
+	dir string
+}
+
+var _ = gc.Suite(&suite{})
+
+func (s *suite) SetUpTest(c *gc.C) {
+	s.CleanupSuite.SetUpTest(c)
+	s.dir = c.MkDir()
+	s.PatchValue(logrotate.ConfigDir, s.dir)
+}
+
+// newRunner returns a runner that registers l under the given
+// name and configures it with p in the install hook.
+func newRunner(c *gc.C, l *logrotate.Logrotate, name string, p logrotate.LogrotateParams) *hooktest.Runner {
+	return &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			l.Register(r.Clone("logrotate"), name)
+			r.RegisterHook("install", func() error {
+				return l.Configure(p)
+			})
+		},
+	}
+}
+
+func (s *suite) TestConfigureAndRemove(c *gc.C) {
+	var l logrotate.Logrotate
+	runner := newRunner(c, &l, "", logrotate.LogrotateParams{
+		Paths:        []string{"/var/log/foo/*.log", "/var/log/bar.log"},
+		Frequency:    "daily",
+		Size:         "100M",
+		Count:        7,
+		Compress:     true,
+		CopyTruncate: true,
+	})
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+
+	path := filepath.Join(s.dir, "someunit")
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `# Generated by the someunit/0 charm; do not edit.
+/var/log/foo/*.log /var/log/bar.log {
+	daily
+	size 100M
+	rotate 7
+	compress
+	copytruncate
+	missingok
+	notifempty
+}
+`)
+	info, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0644))
+
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (s *suite) TestConfigureMinimal(c *gc.C) {
+	var l logrotate.Logrotate
+	runner := newRunner(c, &l, "myservice", logrotate.LogrotateParams{
+		Paths: []string{"/var/log/myservice.log"},
+	})
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "myservice"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `# Generated by the someunit/0 charm; do not edit.
+/var/log/myservice.log {
+	rotate 0
+	missingok
+	notifempty
+}
+`)
+}
+
+func (s *suite) TestStopWithoutConfigure(c *gc.C) {
+	var l logrotate.Logrotate
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			l.Register(r.Clone("logrotate"), "")
+		},
+	}
+	err := runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+}
+
+var validateTests = []struct {
+	about       string
+	params      logrotate.LogrotateParams
+	expectError string
+}{{
+	about:       "no paths",
+	expectError: `no log paths specified`,
+}, {
+	about: "relative path",
+	params: logrotate.LogrotateParams{
+		Paths: []string{"foo.log"},
+	},
+	expectError: `log path "foo.log" is not absolute`,
+}, {
+	about: "path with brace",
+	params: logrotate.LogrotateParams{
+		Paths: []string{"/var/log/{foo}.log"},
+	},
+	expectError: `log path "/var/log/{foo}.log" contains invalid characters`,
+}, {
+	about: "invalid frequency",
+	params: logrotate.LogrotateParams{
+		Paths:     []string{"/var/log/foo.log"},
+		Frequency: "hourly-ish",
+	},
+	expectError: `invalid rotation frequency "hourly-ish"`,
+}, {
+	about: "invalid size",
+	params: logrotate.LogrotateParams{
+		Paths: []string{"/var/log/foo.log"},
+		Size:  "10 MB",
+	},
+	expectError: `invalid rotation size "10 MB"`,
+}, {
+	about: "negative count",
+	params: logrotate.LogrotateParams{
+		Paths: []string{"/var/log/foo.log"},
+		Count: -1,
+	},
+	expectError: `negative rotation count -1`,
+}, {
+	about: "valid",
+	params: logrotate.LogrotateParams{
+		Paths:     []string{"/var/log/foo.log"},
+		Frequency: "weekly",
+		Size:      "10k",
+		Count:     4,
+	},
+}}
+
+func (s *suite) TestValidate(c *gc.C) {
+	for i, test := range validateTests {
+		c.Logf("test %d: %s", i, test.about)
+		err := test.params.Validate()
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (s *suite) TestConfigureInvalidParams(c *gc.C) {
+	var l logrotate.Logrotate
+	runner := newRunner(c, &l, "", logrotate.LogrotateParams{})
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.ErrorMatches, `.*no log paths specified`)
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 0)
+}
@@ -0,0 +1,8 @@
+package mdns
+
+type Responder = responder
+
+var (
+	NewResponder   = &newResponder
+	StartResponder = startResponder
+)
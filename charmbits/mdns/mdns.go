@@ -0,0 +1,128 @@
+// The mdns package provides a charmbit that advertises a service
+// on the local network with multicast DNS service discovery
+// (DNS-SD). Because hooks are short lived, the mDNS responder
+// runs as a long-running service (see the service package).
+package mdns
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/mdns"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// Advertiser represents a service advertised with mDNS.
+type Advertiser struct {
+	svc service.Service
+}
+
+// Register registers the advertiser with the given registry.
+// The serviceName argument holds the name of the service
+// that runs the mDNS responder (see service.Service.Register).
+//
+// The advertisement is withdrawn when the stop hook runs.
+func (a *Advertiser) Register(r *hook.Registry, serviceName string) {
+	a.svc.Register(r.Clone("service"), serviceName, startResponder)
+}
+
+// validName matches a DNS-SD service type
+// such as "_http._tcp".
+var validName = regexp.MustCompile(`^_[a-z0-9][a-z0-9-]*\._(tcp|udp)$`)
+
+// Advertise advertises the service with the given DNS-SD
+// service type, for example "_http._tcp", on the given port,
+// with the host name as the instance name. It replaces any
+// advertisement made earlier.
+func (a *Advertiser) Advertise(name string, port int) error {
+	if !validName.MatchString(name) {
+		return errgo.Newf("invalid service name %q", name)
+	}
+	if port <= 0 || port > 65535 {
+		return errgo.Newf("invalid port %d", port)
+	}
+	if err := a.svc.Start(name, strconv.Itoa(port)); err != nil {
+		return errgo.Notef(err, "cannot start mDNS responder")
+	}
+	return nil
+}
+
+// Withdraw withdraws the advertisement, if any.
+func (a *Advertiser) Withdraw() error {
+	if err := a.svc.StopAndRemove(); err != nil {
+		return errgo.Notef(err, "cannot stop mDNS responder")
+	}
+	return nil
+}
+
+// responder is implemented by a running mDNS responder.
+type responder interface {
+	Shutdown() error
+}
+
+// newResponder starts an mDNS responder advertising the given
+// service. It is defined as a variable so that it can be
+// replaced for testing purposes.
+var newResponder = func(name string, port int) (responder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get host name")
+	}
+	zone, err := mdns.NewMDNSService(host, name, "", "", port, nil, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	srv, err := mdns.NewServer(&mdns.Config{Zone: zone})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return srv, nil
+}
+
+// startResponder runs the mDNS responder inside the service.
+// The arguments hold the service name and port passed to
+// Service.Start by Advertise.
+func startResponder(ctxt *service.Context, args []string) (hook.Command, error) {
+	if len(args) != 2 {
+		return nil, errgo.Newf("expected two arguments, found %q", args)
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errgo.Newf("invalid port %q", args[1])
+	}
+	r, err := newResponder(args[0], port)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot start mDNS responder")
+	}
+	return &responderCommand{
+		responder: r,
+		dying:     make(chan struct{}),
+	}, nil
+}
+
+// responderCommand implements hook.Command
+// for a running mDNS responder.
+type responderCommand struct {
+	responder responder
+	once      sync.Once
+	dying     chan struct{}
+}
+
+// Kill implements hook.Command.Kill.
+func (c *responderCommand) Kill() {
+	c.once.Do(func() {
+		close(c.dying)
+	})
+}
+
+// Wait implements hook.Command.Wait by waiting until the
+// command is killed and then shutting down the responder.
+func (c *responderCommand) Wait() error {
+	<-c.dying
+	return c.responder.Shutdown()
+}
@@ -0,0 +1,142 @@
+package mdns_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/mdns"
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct {
+	jujutesting.CleanupSuite
+}
+
+var _ = gc.Suite(&suite{})
+
+// serviceArgs returns the arguments that the responder
+// service created with the given parameters will be started with.
+func serviceArgs(c *gc.C, params service.OSServiceParams) []string {
+	data, err := base64.StdEncoding.DecodeString(params.Args[1])
+	c.Assert(err, gc.IsNil)
+	var p struct {
+		Args []string
+	}
+	err = json.Unmarshal(data, &p)
+	c.Assert(err, gc.IsNil)
+	return p.Args
+}
+
+func (s *suite) TestAdvertiseAndWithdraw(c *gc.C) {
+	osSvc := &hooktest.RecordingService{}
+	s.PatchValue(&service.NewService, osSvc.NewService)
+	var a mdns.Advertiser
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			a.Register(r.Clone("mdns"), "myapp-mdns")
+			r.RegisterHook("start", func() error {
+				return a.Advertise("_http._tcp", 8080)
+			})
+			r.RegisterHook("config-changed", func() error {
+				return a.Withdraw()
+			})
+		},
+	}
+	err := runner.RunHook("start", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(osSvc.Calls, jc.DeepEquals, []string{"Install", "Start"})
+	c.Assert(osSvc.Params.Name, gc.Equals, "myapp-mdns")
+	c.Assert(serviceArgs(c, osSvc.Params), jc.DeepEquals, []string{"_http._tcp", "8080"})
+
+	osSvc.Calls = nil
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(osSvc.Calls, jc.DeepEquals, []string{"StopAndRemove"})
+
+	// The advertisement is withdrawn by the stop hook.
+	err = runner.RunHook("start", "", "")
+	c.Assert(err, gc.IsNil)
+	osSvc.Calls = nil
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(osSvc.Calls, jc.DeepEquals, []string{"Stop"})
+}
+
+var invalidAdvertiseTests = []struct {
+	name        string
+	port        int
+	expectError string
+}{{
+	name:        "http",
+	port:        80,
+	expectError: `invalid service name "http"`,
+}, {
+	name:        "_http._sctp",
+	port:        80,
+	expectError: `invalid service name "_http._sctp"`,
+}, {
+	name:        "_http._tcp",
+	port:        0,
+	expectError: `invalid port 0`,
+}}
+
+func (*suite) TestAdvertiseInvalid(c *gc.C) {
+	var a mdns.Advertiser
+	for i, test := range invalidAdvertiseTests {
+		c.Logf("test %d: %s %d", i, test.name, test.port)
+		err := a.Advertise(test.name, test.port)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+// fakeResponder records the service it advertises.
+type fakeResponder struct {
+	name     string
+	port     int
+	shutdown bool
+}
+
+func (r *fakeResponder) Shutdown() error {
+	r.shutdown = true
+	return nil
+}
+
+func (s *suite) TestResponderLifecycle(c *gc.C) {
+	var r *fakeResponder
+	s.PatchValue(mdns.NewResponder, func(name string, port int) (mdns.Responder, error) {
+		r = &fakeResponder{
+			name: name,
+			port: port,
+		}
+		return r, nil
+	})
+	cmd, err := mdns.StartResponder(nil, []string{"_http._tcp", "8080"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r, jc.DeepEquals, &fakeResponder{
+		name: "_http._tcp",
+		port: 8080,
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	cmd.Kill()
+	c.Assert(<-done, gc.IsNil)
+	c.Assert(r.shutdown, jc.IsTrue)
+}
+
+func (*suite) TestResponderBadArgs(c *gc.C) {
+	_, err := mdns.StartResponder(nil, []string{"_http._tcp"})
+	c.Assert(err, gc.ErrorMatches, `expected two arguments, found \["_http._tcp"\]`)
+	_, err = mdns.StartResponder(nil, []string{"_http._tcp", "x"})
+	c.Assert(err, gc.ErrorMatches, `invalid port "x"`)
+}
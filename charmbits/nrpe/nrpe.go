@@ -0,0 +1,156 @@
+// The nrpe package provides a charmbit that registers Nagios checks
+// with an NRPE subordinate charm over a relation with interface type
+// "nrpe-external-master".
+//
+// The checks are published in the "monitors" relation setting,
+// in the YAML format understood by the nrpe charm, for example:
+//
+//	monitors:
+//	  remote:
+//	    nrpe:
+//	      myapp-http:
+//	        command: check_http -H localhost -p 8080
+package nrpe
+
+import (
+	"regexp"
+
+	"github.com/juju/charm/v9"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// Interface holds the interface name of the relation
+// registered by Checker.Register.
+const Interface = "nrpe-external-master"
+
+// monitorsKey holds the relation setting that
+// the checks are published in.
+const monitorsKey = "monitors"
+
+// Checker represents a set of Nagios checks published
+// over an NRPE relation.
+type Checker struct {
+	relationName string
+	ctxt         *hook.Context
+
+	// checks holds the command of each check, keyed by check name.
+	checks map[string]string
+}
+
+// Register registers the checker with the given registry, providing
+// the relation with the given name. The checks added with AddCheck
+// are published to the remote NRPE unit when the relation is joined
+// or changed, and after the charm is upgraded, and are removed from
+// the relation when the remote unit departs.
+func (c *Checker) Register(r *hook.Registry, relationName string) {
+	c.relationName = relationName
+	c.checks = make(map[string]string)
+	r.RegisterRelation(charm.Relation{
+		Name:      relationName,
+		Interface: Interface,
+		Role:      charm.RoleProvider,
+		Scope:     charm.ScopeContainer,
+	})
+	r.RegisterHook(relationName+"-relation-joined", c.publish)
+	r.RegisterHook(relationName+"-relation-changed", c.publish)
+	r.RegisterHook(relationName+"-relation-departed", c.withdraw)
+	r.RegisterHook("upgrade-charm", c.publishAll)
+	r.RegisterContext(c.setContext, nil)
+}
+
+func (c *Checker) setContext(ctxt *hook.Context) error {
+	c.ctxt = ctxt
+	return nil
+}
+
+// validCheckName matches the names that Nagios
+// allows for NRPE commands.
+var validCheckName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// AddCheck adds a check with the given name that runs the given
+// Nagios plugin command line, replacing any check with the same name.
+//
+// AddCheck is usually called when the checker is registered, in which
+// case the check is published by the checker's hooks. When it is
+// called from a hook function, the check is also published to all the
+// existing NRPE relations immediately.
+func (c *Checker) AddCheck(name, command string) error {
+	if !validCheckName.MatchString(name) {
+		return errgo.Newf("invalid check name %q", name)
+	}
+	if command == "" {
+		return errgo.Newf("empty command for check %q", name)
+	}
+	c.checks[name] = command
+	if c.ctxt == nil {
+		return nil
+	}
+	if err := c.publishAll(); err != nil {
+		return errgo.Notef(err, "cannot publish check %q", name)
+	}
+	return nil
+}
+
+// monitors returns the value of the monitors setting
+// describing all the checks.
+func (c *Checker) monitors() (string, error) {
+	type check struct {
+		Command string `yaml:"command"`
+	}
+	checks := make(map[string]check)
+	for name, command := range c.checks {
+		checks[name] = check{command}
+	}
+	var m struct {
+		Monitors struct {
+			Remote struct {
+				NRPE map[string]check `yaml:"nrpe"`
+			} `yaml:"remote"`
+		} `yaml:"monitors"`
+	}
+	m.Monitors.Remote.NRPE = checks
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot marshal checks")
+	}
+	return string(data), nil
+}
+
+// publish publishes the checks to the relation
+// of the current relation hook.
+func (c *Checker) publish() error {
+	return errgo.Mask(c.publishTo(c.ctxt.RelationId))
+}
+
+// publishAll publishes the checks to all the NRPE relations.
+func (c *Checker) publishAll() error {
+	for _, id := range c.ctxt.RelationIds[c.relationName] {
+		if err := c.publishTo(id); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func (c *Checker) publishTo(id hook.RelationId) error {
+	monitors, err := c.monitors()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := c.ctxt.SetRelationWithId(id, monitorsKey, monitors); err != nil {
+		return errgo.Notef(err, "cannot publish checks")
+	}
+	return nil
+}
+
+// withdraw removes the checks from the relation
+// of the current relation hook.
+func (c *Checker) withdraw() error {
+	if err := c.ctxt.SetRelation(monitorsKey, ""); err != nil {
+		return errgo.Notef(err, "cannot remove checks")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package nrpe_test
+
+import (
+	"sort"
+
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/nrpe"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct{}
+
+var _ = gc.Suite(&suite{})
+
+func (*suite) TestRegister(c *gc.C) {
+	r := hook.NewRegistry()
+	var checker nrpe.Checker
+	checker.Register(r, "nrpe-external-master")
+	c.Assert(r.RegisteredRelations(), jc.DeepEquals, map[string]charm.Relation{
+		"nrpe-external-master": {
+			Name:      "nrpe-external-master",
+			Role:      charm.RoleProvider,
+			Interface: "nrpe-external-master",
+			Scope:     charm.ScopeContainer,
+		},
+	})
+	hooks := r.RegisteredHooks()
+	sort.Strings(hooks)
+	c.Assert(hooks, jc.DeepEquals, []string{
+		"nrpe-external-master-relation-changed",
+		"nrpe-external-master-relation-departed",
+		"nrpe-external-master-relation-joined",
+		"upgrade-charm",
+	})
+}
+
+const expectMonitors = `monitors:
+  remote:
+    nrpe:
+      myapp-disk:
+        command: check_disk -w 20% -c 10%
+      myapp-http:
+        command: check_http -H localhost -p 8080
+`
+
+// newRunner returns a runner for a charm with the
+// two NRPE relations, each with one remote unit, that
+// adds the checks in expectMonitors. If register is
+// not nil, it is called to register additional hooks.
+func newRunner(c *gc.C, register func(*nrpe.Checker, *hook.Registry)) *hooktest.Runner {
+	return &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var checker nrpe.Checker
+			checker.Register(r.Clone("nrpe"), "nrpe")
+			err := checker.AddCheck("myapp-http", "check_http -H localhost -p 8080")
+			c.Assert(err, gc.IsNil)
+			err = checker.AddCheck("myapp-disk", "check_disk -w 20% -c 10%")
+			c.Assert(err, gc.IsNil)
+			if register != nil {
+				register(&checker, r)
+			}
+		},
+		RelationIds: map[string][]hook.RelationId{
+			"nrpe": {"nrpe:0", "nrpe:1"},
+		},
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"nrpe:0": {"nrpe/0": {}},
+			"nrpe:1": {"nrpe/1": {}},
+		},
+	}
+}
+
+// relationSets returns the relation-set calls
+// in the given hook tool record.
+func relationSets(record [][]string) [][]string {
+	var sets [][]string
+	for _, r := range record {
+		if r[0] == "relation-set" {
+			sets = append(sets, r)
+		}
+	}
+	return sets
+}
+
+func (*suite) TestPublishOnJoinedAndChanged(c *gc.C) {
+	for _, hookName := range []string{"nrpe-relation-joined", "nrpe-relation-changed"} {
+		c.Logf("hook %s", hookName)
+		runner := newRunner(c, nil)
+		err := runner.RunHook(hookName, "nrpe:1", "nrpe/1")
+		c.Assert(err, gc.IsNil)
+		c.Assert(relationSets(runner.Record), jc.DeepEquals, [][]string{
+			{"relation-set", "-r", "nrpe:1", "--", "monitors=" + expectMonitors},
+		})
+	}
+}
+
+func (*suite) TestPublishUnchanged(c *gc.C) {
+	runner := newRunner(c, nil)
+	runner.LocalRelations = map[hook.RelationId]map[string]string{
+		"nrpe:1": {"monitors": expectMonitors},
+	}
+	err := runner.RunHook("nrpe-relation-changed", "nrpe:1", "nrpe/1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(relationSets(runner.Record), gc.HasLen, 0)
+}
+
+func (*suite) TestRemoveOnDeparted(c *gc.C) {
+	runner := newRunner(c, nil)
+	runner.LocalRelations = map[hook.RelationId]map[string]string{
+		"nrpe:1": {"monitors": expectMonitors},
+	}
+	err := runner.RunHook("nrpe-relation-departed", "nrpe:1", "nrpe/1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(relationSets(runner.Record), jc.DeepEquals, [][]string{
+		{"relation-set", "-r", "nrpe:1", "--", "monitors="},
+	})
+}
+
+func (*suite) TestPublishOnUpgrade(c *gc.C) {
+	runner := newRunner(c, nil)
+	err := runner.RunHook("upgrade-charm", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(relationSets(runner.Record), jc.DeepEquals, [][]string{
+		{"relation-set", "-r", "nrpe:0", "--", "monitors=" + expectMonitors},
+		{"relation-set", "-r", "nrpe:1", "--", "monitors=" + expectMonitors},
+	})
+}
+
+func (*suite) TestAddCheckInHook(c *gc.C) {
+	runner := newRunner(c, func(checker *nrpe.Checker, r *hook.Registry) {
+		r.RegisterHook("config-changed", func() error {
+			return checker.AddCheck("myapp-disk", "check_disk -w 30% -c 20%")
+		})
+	})
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	expect := `monitors:
+  remote:
+    nrpe:
+      myapp-disk:
+        command: check_disk -w 30% -c 20%
+      myapp-http:
+        command: check_http -H localhost -p 8080
+`
+	c.Assert(relationSets(runner.Record), jc.DeepEquals, [][]string{
+		{"relation-set", "-r", "nrpe:0", "--", "monitors=" + expect},
+		{"relation-set", "-r", "nrpe:1", "--", "monitors=" + expect},
+	})
+}
+
+func (*suite) TestAddCheckInvalid(c *gc.C) {
+	var checker nrpe.Checker
+	checker.Register(hook.NewRegistry(), "nrpe")
+	err := checker.AddCheck("bad name", "check_http")
+	c.Assert(err, gc.ErrorMatches, `invalid check name "bad name"`)
+	err = checker.AddCheck("myapp-http", "")
+	c.Assert(err, gc.ErrorMatches, `empty command for check "myapp-http"`)
+}
@@ -0,0 +1,3 @@
+package peerrelation
+
+var SetContext = (*Peer).setContext
@@ -0,0 +1,110 @@
+// The peerrelation package implements a peer relation that
+// can be used to choose a single coordinating unit among
+// the units of an application.
+package peerrelation
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/juju/charm/v9"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// Peer represents a peer relation between
+// the units of an application.
+type Peer struct {
+	ctxt         *hook.Context
+	relationName string
+}
+
+// Register registers a peer relation with the given
+// relation name and interface with the given hook registry.
+//
+// To find out when the set of peers changes, register
+// a wildcard ("*") hook, which will trigger when any
+// peer joins or departs.
+func (p *Peer) Register(r *hook.Registry, relationName, interfaceName string) {
+	p.relationName = relationName
+	r.RegisterContext(p.setContext, nil)
+	r.RegisterRelation(charm.Relation{
+		Name:      relationName,
+		Interface: interfaceName,
+		Role:      charm.RolePeer,
+	})
+	// We don't actually need to do anything in these hooks,
+	// but we need them so the hook is actually created
+	// and the user of this package will have a "*" hook
+	// triggered.
+	r.RegisterHook(relationName+"-relation-joined", nop)
+	r.RegisterHook(relationName+"-relation-departed", nop)
+}
+
+func nop() error {
+	return nil
+}
+
+func (p *Peer) setContext(ctxt *hook.Context) error {
+	p.ctxt = ctxt
+	return nil
+}
+
+// Units returns the ids of all the units currently
+// in the peer relation, not including the local unit.
+func (p *Peer) Units() []hook.UnitId {
+	var units []hook.UnitId
+	for _, id := range p.ctxt.RelationIds[p.relationName] {
+		for unit := range p.ctxt.Relations[id] {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
+// Coordinator reports whether the local unit should coordinate
+// the work of its peers. When Juju leadership is available, the
+// coordinator is the leader; otherwise, as on Juju versions
+// without leadership, it is the unit with the lowest unit number
+// among the local unit and its peers. Any other error finding
+// out about leadership is returned, so that two units never
+// both act as coordinator.
+func (p *Peer) Coordinator() (bool, error) {
+	leader, err := p.ctxt.IsLeader()
+	if err == nil {
+		return leader, nil
+	}
+	if errgo.Cause(err) != hook.ErrUnimplemented {
+		return false, errgo.Mask(err)
+	}
+	p.ctxt.Debugf("falling back to peer coordination: %v", err)
+	local, err := unitNumber(p.ctxt.Unit)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	for _, unit := range p.Units() {
+		n, err := unitNumber(unit)
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		if n < local {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unitNumber returns the number of the given
+// unit, for example 3 for "myapp/3".
+func unitNumber(unit hook.UnitId) (int, error) {
+	i := strings.LastIndex(string(unit), "/")
+	if i < 0 {
+		return 0, errgo.Newf("invalid unit name %q", unit)
+	}
+	n, err := strconv.Atoi(string(unit)[i+1:])
+	if err != nil || n < 0 {
+		return 0, errgo.Newf("invalid unit name %q", unit)
+	}
+	return n, nil
+}
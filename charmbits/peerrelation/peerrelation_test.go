@@ -0,0 +1,130 @@
+package peerrelation_test
+
+import (
+	"sort"
+
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/peerrelation"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct{}
+
+var _ = gc.Suite(&suite{})
+
+func (*suite) TestRegister(c *gc.C) {
+	r := hook.NewRegistry()
+	var p peerrelation.Peer
+	p.Register(r, "cluster", "myapp-cluster")
+	c.Assert(r.RegisteredRelations(), jc.DeepEquals, map[string]charm.Relation{
+		"cluster": {
+			Name:      "cluster",
+			Role:      charm.RolePeer,
+			Interface: "myapp-cluster",
+			Limit:     1,
+			Scope:     charm.ScopeGlobal,
+		},
+	})
+	hooks := r.RegisteredHooks()
+	sort.Strings(hooks)
+	c.Assert(hooks, jc.DeepEquals, []string{"cluster-relation-departed", "cluster-relation-joined"})
+}
+
+var allUnits = []hook.UnitId{"myapp/10", "myapp/2", "myapp/7"}
+
+// newPeer returns a Peer for the given local unit, with all the
+// other units in allUnits as peers. The is-leader tool reports
+// leadership if leader is non-empty, otherwise it fails as it
+// does on Juju versions without leadership.
+func newPeer(c *gc.C, unit hook.UnitId, leader hook.UnitId) *peerrelation.Peer {
+	return newPeerWithIsLeader(c, unit, func() ([]byte, error) {
+		if leader == "" {
+			return nil, errgo.WithCausef(nil, hook.ErrUnimplemented, "is-leader")
+		}
+		if leader == unit {
+			return []byte("true"), nil
+		}
+		return []byte("false"), nil
+	})
+}
+
+// newPeerWithIsLeader is like newPeer except that the
+// is-leader tool is implemented by the given function.
+func newPeerWithIsLeader(c *gc.C, unit hook.UnitId, isLeader func() ([]byte, error)) *peerrelation.Peer {
+	peers := make(map[hook.UnitId]map[string]string)
+	for _, u := range allUnits {
+		if u != unit {
+			peers[u] = map[string]string{}
+		}
+	}
+	ctxt := &hook.Context{
+		Unit: unit,
+		RelationIds: map[string][]hook.RelationId{
+			"cluster": {"cluster:0"},
+		},
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"cluster:0": peers,
+		},
+		Runner: &hooktest.Runner{
+			Logger: c,
+			RunFunc: func(cmd string, args ...string) ([]byte, error) {
+				if cmd != "is-leader" {
+					return nil, errgo.Newf("unexpected command %q", cmd)
+				}
+				return isLeader()
+			},
+		},
+	}
+	var p peerrelation.Peer
+	p.Register(hook.NewRegistry(), "cluster", "myapp-cluster")
+	err := peerrelation.SetContext(&p, ctxt)
+	c.Assert(err, gc.IsNil)
+	return &p
+}
+
+func (*suite) TestCoordinatorWithoutLeadership(c *gc.C) {
+	var coordinators []hook.UnitId
+	for _, unit := range allUnits {
+		p := newPeer(c, unit, "")
+		c.Assert(p.Units(), gc.HasLen, len(allUnits)-1)
+		ok, err := p.Coordinator()
+		c.Assert(err, gc.IsNil)
+		if ok {
+			coordinators = append(coordinators, unit)
+		}
+	}
+	c.Assert(coordinators, jc.DeepEquals, []hook.UnitId{"myapp/2"})
+}
+
+func (*suite) TestCoordinatorWithLeadership(c *gc.C) {
+	var coordinators []hook.UnitId
+	for _, unit := range allUnits {
+		ok, err := newPeer(c, unit, "myapp/7").Coordinator()
+		c.Assert(err, gc.IsNil)
+		if ok {
+			coordinators = append(coordinators, unit)
+		}
+	}
+	c.Assert(coordinators, jc.DeepEquals, []hook.UnitId{"myapp/7"})
+}
+
+func (*suite) TestCoordinatorLeadershipError(c *gc.C) {
+	// A unit that cannot find out whether it is the leader
+	// does not fall back to peer coordination, which could
+	// make it a coordinator as well as the leader.
+	p := newPeerWithIsLeader(c, "myapp/2", func() ([]byte, error) {
+		return nil, errgo.New("connection refused")
+	})
+	_, err := p.Coordinator()
+	c.Assert(err, gc.ErrorMatches, `cannot determine leadership: connection refused`)
+}
+
+func (*suite) TestCoordinatorWithInvalidUnitName(c *gc.C) {
+	_, err := newPeer(c, "myapp", "").Coordinator()
+	c.Assert(err, gc.ErrorMatches, `invalid unit name "myapp"`)
+}
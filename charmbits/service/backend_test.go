@@ -0,0 +1,1181 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	mservice "github.com/mever/service"
+	pkgerrors "github.com/pkg/errors"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+)
+
+type backendSuite struct{}
+
+var _ = gc.Suite(&backendSuite{})
+
+// fakeBackend is an in-memory implementation of the
+// service backend that records the calls made to it.
+type fakeBackend struct {
+	cfg       *mservice.Config
+	installed bool
+	running   bool
+	calls     []string
+}
+
+func (b *fakeBackend) Install() error {
+	b.calls = append(b.calls, "Install")
+	b.installed = true
+	return nil
+}
+
+func (b *fakeBackend) Uninstall() error {
+	b.calls = append(b.calls, "Uninstall")
+	b.installed = false
+	return nil
+}
+
+func (b *fakeBackend) Start() error {
+	b.calls = append(b.calls, "Start")
+	b.running = true
+	return nil
+}
+
+func (b *fakeBackend) Stop() error {
+	b.calls = append(b.calls, "Stop")
+	b.running = false
+	return nil
+}
+
+func (b *fakeBackend) Status() (mservice.Status, error) {
+	switch {
+	case !b.installed:
+		return mservice.StatusUnknown, mservice.ErrNotInstalled
+	case b.running:
+		return mservice.StatusRunning, nil
+	}
+	return mservice.StatusStopped, nil
+}
+
+// assertRunning asserts that the running status
+// of svc is as expected.
+func assertRunning(c *gc.C, svc service.OSService, expect bool) {
+	c.Assert(svc.Running(), gc.Equals, expect)
+}
+
+func (*backendSuite) injectBackend(c *gc.C, b *fakeBackend) func() {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		b.cfg = cfg
+		return b, nil
+	}
+	return func() {
+		*service.NewBackend = old
+	}
+}
+
+func (s *backendSuite) TestServiceWithInjectedBackend(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+
+	// The user must exist when the service is installed.
+	u, err := user.Current()
+	c.Assert(err, gc.IsNil)
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:        "mysvc",
+		Description: "my service",
+		Exe:         "/bin/mysvc",
+		Args:        []string{"-v"},
+		UserName:    u.Username,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg, jc.DeepEquals, &mservice.Config{
+		Name:        "mysvc",
+		DisplayName: "my service",
+		Executable:  "/bin/mysvc",
+		Arguments:   []string{"-v"},
+		UserName:    u.Username,
+	})
+	assertRunning(c, svc, false)
+
+	c.Assert(svc.Install(), gc.IsNil)
+	// Installing an installed service does nothing.
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(svc.Start(), gc.IsNil)
+	assertRunning(c, svc, true)
+	c.Assert(svc.Stop(), gc.IsNil)
+	assertRunning(c, svc, false)
+	c.Assert(svc.Start(), gc.IsNil)
+	c.Assert(svc.StopAndRemove(), gc.IsNil)
+	assertRunning(c, svc, false)
+
+	c.Assert(b.calls, jc.DeepEquals, []string{
+		"Install",
+		"Start",
+		"Stop",
+		"Start",
+		"Stop",
+		"Uninstall",
+	})
+}
+
+// restartingBackend is a fakeBackend that can
+// also restart the service in one operation.
+type restartingBackend struct {
+	*fakeBackend
+}
+
+func (b restartingBackend) Restart() error {
+	b.calls = append(b.calls, "Restart")
+	b.running = true
+	return nil
+}
+
+func (s *backendSuite) TestRestartWithoutBackendRestart(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+	// Restarting a stopped service does nothing.
+	c.Assert(svc.Restart(), gc.IsNil)
+	assertRunning(c, svc, false)
+
+	c.Assert(svc.Start(), gc.IsNil)
+	c.Assert(svc.Restart(), gc.IsNil)
+	assertRunning(c, svc, true)
+	c.Assert(b.calls, jc.DeepEquals, []string{
+		"Install",
+		"Start",
+		"Stop",
+		"Start",
+	})
+}
+
+func (s *backendSuite) TestRestartWithBackendRestart(c *gc.C) {
+	b := &fakeBackend{}
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return restartingBackend{b}, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(svc.Restart(), gc.IsNil)
+	c.Assert(svc.Start(), gc.IsNil)
+	c.Assert(svc.Restart(), gc.IsNil)
+	assertRunning(c, svc, true)
+	c.Assert(b.calls, jc.DeepEquals, []string{
+		"Install",
+		"Start",
+		"Restart",
+	})
+}
+
+func (s *backendSuite) TestRestartError(c *gc.C) {
+	b := &failingStartBackend{&fakeBackend{}}
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return b, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+	b.running = true
+	c.Assert(svc.Restart(), gc.ErrorMatches, "can not start service: start failed")
+}
+
+// failingStartBackend is a fakeBackend that
+// always fails to start the service.
+type failingStartBackend struct {
+	*fakeBackend
+}
+
+func (b *failingStartBackend) Start() error {
+	return errors.New("start failed")
+}
+
+func (s *backendSuite) TestNewServiceError(c *gc.C) {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return nil, errors.New("no init system")
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": no init system`)
+	c.Assert(svc, gc.IsNil)
+}
+
+// statusErrorBackend is a fakeBackend whose
+// status cannot be determined.
+type statusErrorBackend struct {
+	*fakeBackend
+}
+
+func (b statusErrorBackend) Status() (mservice.Status, error) {
+	return mservice.StatusUnknown, errors.New("bus unavailable")
+}
+
+func (s *backendSuite) TestStatusError(c *gc.C) {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return statusErrorBackend{&fakeBackend{}}, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Running(), jc.IsFalse)
+	st, err := svc.Status()
+	c.Assert(err, gc.ErrorMatches, `can not get service status: bus unavailable`)
+	c.Assert(st, gc.Equals, service.ServiceUnknown)
+	err = svc.Install()
+	c.Assert(err, gc.ErrorMatches, `can not install service: can not get service status: bus unavailable`)
+	err = svc.Restart()
+	c.Assert(err, gc.ErrorMatches, `can not restart service: can not get service status: bus unavailable`)
+}
+
+func (s *backendSuite) injectSystem(name string) func() {
+	old := *service.ChosenSystem
+	*service.ChosenSystem = func() string {
+		return name
+	}
+	return func() {
+		*service.ChosenSystem = old
+	}
+}
+
+func (s *backendSuite) TestEnvWithSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	params := service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+		Env: map[string]string{
+			"JAVA_HOME": "/usr/lib/jvm/default",
+			"APP_OPTS":  `-Dname="x y" 100% {{.Name}}`,
+			"B":         "",
+		},
+	}
+	_, err := service.NewService(params)
+	c.Assert(err, gc.IsNil)
+	script, ok := b.cfg.Option["SystemdScript"].(string)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(script, jc.Contains, `
+Environment="APP_OPTS=-Dname=\"x y\" 100%% {{"{{"}}.Name}}"
+Environment="B="
+Environment="JAVA_HOME=/usr/lib/jvm/default"
+EnvironmentFile=-/etc/sysconfig/{{.Name}}
+`)
+
+	// The generated script is the same every time.
+	for i := 0; i < 5; i++ {
+		_, err := service.NewService(params)
+		c.Assert(err, gc.IsNil)
+		c.Assert(b.cfg.Option["SystemdScript"], gc.Equals, script)
+	}
+}
+
+func (s *backendSuite) TestEnvNotSupported(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-upstart")()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+		Env:  map[string]string{"FOO": "bar"},
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": environment variables not supported by init system "linux-upstart"`)
+	c.Assert(svc, gc.IsNil)
+
+	// Without environment variables, the default unit template is used.
+	_, err = service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option, gc.IsNil)
+}
+
+func (s *backendSuite) TestEnvInvalid(c *gc.C) {
+	defer s.injectBackend(c, &fakeBackend{})()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+		Env:  map[string]string{"BAD-NAME": "x"},
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": invalid environment variable name "BAD-NAME"`)
+
+	_, err = service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+		Env:  map[string]string{"FOO": "a\nb"},
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": invalid value for environment variable "FOO"`)
+}
+
+func (s *backendSuite) TestWorkingDir(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+
+	dir := c.MkDir()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:       "mysvc",
+		Exe:        "/bin/mysvc",
+		WorkingDir: dir,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.WorkingDirectory, gc.Equals, dir)
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Install"})
+}
+
+func (s *backendSuite) TestWorkingDirNotFound(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+
+	dir := filepath.Join(c.MkDir(), "nonexistent")
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:       "mysvc",
+		Exe:        "/bin/mysvc",
+		WorkingDir: dir,
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.Install()
+	c.Assert(err, gc.ErrorMatches, `can not install service: bad working directory ".*/nonexistent": stat .*/nonexistent: no such file or directory`)
+	c.Assert(pkgerrors.Cause(err), jc.Satisfies, os.IsNotExist)
+	c.Assert(b.calls, gc.HasLen, 0)
+
+	// A file is not a working directory.
+	err = ioutil.WriteFile(dir, nil, 0666)
+	c.Assert(err, gc.IsNil)
+	err = svc.Install()
+	c.Assert(err, gc.ErrorMatches, `can not install service: bad working directory ".*/nonexistent": not a directory`)
+	c.Assert(b.calls, gc.HasLen, 0)
+}
+
+func (s *backendSuite) TestGroupWithSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	u, err := user.Current()
+	c.Assert(err, gc.IsNil)
+	g, err := user.LookupGroupId(u.Gid)
+	c.Assert(err, gc.IsNil)
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:      "mysvc",
+		Exe:       "/bin/mysvc",
+		UserName:  u.Username,
+		GroupName: g.Name,
+		Env:       map[string]string{"FOO": "bar"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.UserName, gc.Equals, u.Username)
+	c.Assert(b.cfg.Option["SystemdScript"], jc.Contains, `
+Group=`+g.Name+`
+Environment="FOO=bar"
+`)
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Install"})
+}
+
+func (s *backendSuite) TestGroupIgnoredWithoutSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-upstart")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:      "mysvc",
+		Exe:       "/bin/mysvc",
+		GroupName: "webapp",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option, gc.IsNil)
+}
+
+func (s *backendSuite) TestInvalidGroupName(c *gc.C) {
+	defer s.injectBackend(c, &fakeBackend{})()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:      "mysvc",
+		Exe:       "/bin/mysvc",
+		GroupName: "web app",
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": invalid group name "web app"`)
+}
+
+func (s *backendSuite) TestUnknownUserOrGroup(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:     "mysvc",
+		Exe:      "/bin/mysvc",
+		UserName: "nosuchuser-gocharm",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.Install()
+	c.Assert(err, gc.ErrorMatches, `can not install service: bad user "nosuchuser-gocharm": user: unknown user nosuchuser-gocharm`)
+	c.Assert(pkgerrors.Cause(err), gc.FitsTypeOf, user.UnknownUserError(""))
+
+	svc, err = service.NewService(service.OSServiceParams{
+		Name:      "mysvc",
+		Exe:       "/bin/mysvc",
+		GroupName: "nosuchgroup-gocharm",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.Install()
+	c.Assert(err, gc.ErrorMatches, `can not install service: bad group "nosuchgroup-gocharm": group: unknown group nosuchgroup-gocharm`)
+	c.Assert(b.calls, gc.HasLen, 0)
+}
+
+// slowStopBackend is a fakeBackend whose service keeps
+// running for a while after it has been told to stop.
+type slowStopBackend struct {
+	*fakeBackend
+	mu      sync.Mutex
+	stopped bool
+
+	// polls holds the number of status checks after Stop
+	// before the service exits. If it is negative, the
+	// service never exits.
+	polls int
+}
+
+func (b *slowStopBackend) Stop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, "Stop")
+	b.stopped = true
+	return nil
+}
+
+func (b *slowStopBackend) Status() (mservice.Status, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped && b.running {
+		switch {
+		case b.polls == 0:
+			b.running = false
+		case b.polls > 0:
+			b.polls--
+		}
+	}
+	return b.fakeBackend.Status()
+}
+
+func (s *backendSuite) injectSlowStopBackend(b *slowStopBackend) func() {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return b, nil
+	}
+	return func() {
+		*service.NewBackend = old
+	}
+}
+
+func (s *backendSuite) TestStopWithTimeoutWaits(c *gc.C) {
+	b := &slowStopBackend{
+		fakeBackend: &fakeBackend{installed: true, running: true},
+		polls:       3,
+	}
+	defer s.injectSlowStopBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StopWithTimeout(5 * time.Second)
+	c.Assert(err, gc.IsNil)
+	assertRunning(c, svc, false)
+}
+
+func (s *backendSuite) TestStopWithTimeoutTimesOut(c *gc.C) {
+	b := &slowStopBackend{
+		fakeBackend: &fakeBackend{installed: true, running: true},
+		polls:       -1,
+	}
+	defer s.injectSlowStopBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	t0 := time.Now()
+	err = svc.StopWithTimeout(250 * time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `service "mysvc" still running after 250ms: timed out waiting for service to stop`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, service.ErrStopTimeout)
+	c.Assert(time.Since(t0) >= 250*time.Millisecond, gc.Equals, true)
+
+	// The service can be stopped again once it exits.
+	b.polls = 0
+	err = svc.StopWithTimeout(5 * time.Second)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *backendSuite) TestStopWithTimeoutStatusError(c *gc.C) {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return statusErrorBackend{&fakeBackend{}}, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StopWithTimeout(5 * time.Second)
+	c.Assert(err, gc.ErrorMatches, `can not get service status: bus unavailable`)
+}
+
+// assertStatus asserts that the status
+// of svc is as expected.
+func assertStatus(c *gc.C, svc service.OSService, expect service.ServiceStatus) {
+	st, err := svc.Status()
+	c.Assert(err, gc.IsNil)
+	c.Assert(st, gc.Equals, expect)
+}
+
+func (s *backendSuite) TestStatus(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	assertStatus(c, svc, service.ServiceNotInstalled)
+	c.Assert(svc.Install(), gc.IsNil)
+	assertStatus(c, svc, service.ServiceStopped)
+	c.Assert(svc.Start(), gc.IsNil)
+	assertStatus(c, svc, service.ServiceRunning)
+	assertRunning(c, svc, true)
+	c.Assert(svc.StopAndRemove(), gc.IsNil)
+	assertStatus(c, svc, service.ServiceNotInstalled)
+	assertRunning(c, svc, false)
+}
+
+// unknownStatusBackend is a fakeBackend whose
+// service daemon does not know the service status.
+type unknownStatusBackend struct {
+	*fakeBackend
+}
+
+func (b unknownStatusBackend) Status() (mservice.Status, error) {
+	return mservice.StatusUnknown, nil
+}
+
+func (s *backendSuite) TestStatusUnknown(c *gc.C) {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return unknownStatusBackend{&fakeBackend{}}, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	assertStatus(c, svc, service.ServiceUnknown)
+	assertRunning(c, svc, false)
+}
+
+func (s *backendSuite) TestServiceStatusString(c *gc.C) {
+	c.Assert(service.ServiceUnknown.String(), gc.Equals, "unknown")
+	c.Assert(service.ServiceNotInstalled.String(), gc.Equals, "not installed")
+	c.Assert(service.ServiceStopped.String(), gc.Equals, "stopped")
+	c.Assert(service.ServiceRunning.String(), gc.Equals, "running")
+	c.Assert(service.ServiceStatus(99).String(), gc.Equals, "ServiceStatus(99)")
+}
+
+// lockedBackend is a fakeBackend that may be used
+// concurrently, as it is by health checks.
+type lockedBackend struct {
+	*fakeBackend
+	mu sync.Mutex
+}
+
+func (b *lockedBackend) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeBackend.Start()
+}
+
+func (b *lockedBackend) Stop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeBackend.Stop()
+}
+
+func (b *lockedBackend) Status() (mservice.Status, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeBackend.Status()
+}
+
+func (b *lockedBackend) setRunning(running bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = running
+}
+
+// healthChecker provides a health check whose
+// result can be changed by a test.
+type healthChecker struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (h *healthChecker) check() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	return h.err
+}
+
+func (h *healthChecker) setErr(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+}
+
+func (h *healthChecker) numCalls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
+
+// waitHealth waits for svc to report the expected health: healthy
+// if expectErr is empty, and otherwise unhealthy with an error
+// with the given message.
+func waitHealth(c *gc.C, svc service.OSService, expectErr string) {
+	var healthy bool
+	var err error
+	for t0 := time.Now(); time.Since(t0) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		healthy, err = svc.Healthy()
+		if expectErr == "" && healthy && err == nil {
+			return
+		}
+		if expectErr != "" && !healthy && err != nil && err.Error() == expectErr {
+			return
+		}
+	}
+	c.Fatalf("unexpected service health after 5s; got %v (error %v)", healthy, err)
+}
+
+func (s *backendSuite) TestHealthCheck(c *gc.C) {
+	b := &lockedBackend{fakeBackend: &fakeBackend{installed: true}}
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return b, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	h := &healthChecker{}
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:           "mysvc",
+		Exe:            "/bin/mysvc",
+		HealthCheck:    h.check,
+		HealthInterval: 10 * time.Millisecond,
+	})
+	c.Assert(err, gc.IsNil)
+
+	healthy, err := svc.Healthy()
+	c.Assert(healthy, jc.IsFalse)
+	c.Assert(err, gc.ErrorMatches, `service "mysvc" not checked since it was started: service health not known`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, service.ErrHealthUnknown)
+
+	c.Assert(svc.Start(), gc.IsNil)
+	waitHealth(c, svc, "")
+
+	h.setErr(errors.New("health endpoint returned 503"))
+	waitHealth(c, svc, `service "mysvc" health check failed: health endpoint returned 503`)
+
+	// A service that has stopped is not healthy.
+	h.setErr(nil)
+	b.setRunning(false)
+	waitHealth(c, svc, `service "mysvc" health check failed: service is not running`)
+	b.setRunning(true)
+	waitHealth(c, svc, "")
+
+	// The health check stops when the service does.
+	c.Assert(svc.Stop(), gc.IsNil)
+	calls := h.numCalls()
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(h.numCalls(), gc.Equals, calls)
+	_, err = svc.Healthy()
+	c.Assert(pkgerrors.Cause(err), gc.Equals, service.ErrHealthUnknown)
+
+	// It starts again when the service is restarted,
+	// and stops when it is removed.
+	c.Assert(svc.Start(), gc.IsNil)
+	waitHealth(c, svc, "")
+	c.Assert(svc.StopAndRemove(), gc.IsNil)
+	calls = h.numCalls()
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(h.numCalls(), gc.Equals, calls)
+}
+
+func (s *backendSuite) TestHealthyWithoutHealthCheck(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(svc.Start(), gc.IsNil)
+	healthy, err := svc.Healthy()
+	c.Assert(healthy, jc.IsFalse)
+	c.Assert(err, gc.ErrorMatches, `service "mysvc" has no health check: service health not known`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, service.ErrHealthUnknown)
+}
+
+func (s *backendSuite) TestNegativeHealthInterval(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	_, err := service.NewService(service.OSServiceParams{
+		Name:           "mysvc",
+		Exe:            "/bin/mysvc",
+		HealthCheck:    func() error { return nil },
+		HealthInterval: -time.Second,
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": negative health check interval`)
+}
+
+func (s *backendSuite) TestRestartPolicyWithSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:          "mysvc",
+		Exe:           "/bin/mysvc",
+		RestartPolicy: service.RestartOnFailure,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option, jc.DeepEquals, mservice.KeyValue{
+		"Restart": "on-failure",
+	})
+
+	_, err = service.NewService(service.OSServiceParams{
+		Name:          "mysvc",
+		Exe:           "/bin/mysvc",
+		RestartPolicy: service.RestartAlways,
+		RestartSec:    1500 * time.Millisecond,
+		Env:           map[string]string{"FOO": "bar"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option["Restart"], gc.Equals, "always")
+	script := b.cfg.Option["SystemdScript"].(string)
+	c.Assert(script, jc.Contains, `
+RestartSec=1500ms
+Environment="FOO=bar"
+`)
+	c.Assert(strings.Count(script, "RestartSec="), gc.Equals, 1)
+}
+
+func (s *backendSuite) TestRestartSecWithSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:       "mysvc",
+		Exe:        "/bin/mysvc",
+		RestartSec: 5 * time.Second,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option["Restart"], gc.IsNil)
+	c.Assert(b.cfg.Option["SystemdScript"], jc.Contains, "\nRestartSec=5s\n")
+
+	// Without restart settings, the default
+	// unit template is used as before.
+	_, err = service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+		Env:  map[string]string{"FOO": "bar"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Option["SystemdScript"], jc.Contains, "\nRestartSec=120s\n")
+}
+
+var restartPolicyTests = []struct {
+	system      string
+	policy      service.RestartPolicy
+	expectOpt   mservice.KeyValue
+	expectError string
+}{{
+	system: "linux-upstart",
+	policy: service.RestartAlways,
+}, {
+	system:      "linux-upstart",
+	policy:      service.RestartNever,
+	expectError: `can not create service "mysvc": restart policy "no" not supported by init system "linux-upstart"`,
+}, {
+	system: "unix-systemv",
+	policy: service.RestartNever,
+}, {
+	system:      "linux-openrc",
+	policy:      service.RestartOnFailure,
+	expectError: `can not create service "mysvc": restart policy "on-failure" not supported by init system "linux-openrc"`,
+}, {
+	system:    "darwin-launchd",
+	policy:    service.RestartAlways,
+	expectOpt: mservice.KeyValue{"KeepAlive": true},
+}, {
+	system:    "darwin-launchd",
+	policy:    service.RestartNever,
+	expectOpt: mservice.KeyValue{"KeepAlive": false},
+}, {
+	system:      "darwin-launchd",
+	policy:      service.RestartOnFailure,
+	expectError: `can not create service "mysvc": restart policy "on-failure" not supported by init system "darwin-launchd"`,
+}, {
+	system:      "linux-systemd",
+	policy:      "sometimes",
+	expectError: `can not create service "mysvc": invalid restart policy "sometimes"`,
+}}
+
+func (s *backendSuite) TestRestartPolicyWithoutSystemd(c *gc.C) {
+	for i, test := range restartPolicyTests {
+		c.Logf("test %d: %s %q", i, test.system, test.policy)
+		b := &fakeBackend{}
+		restore := s.injectBackend(c, b)
+		restoreSystem := s.injectSystem(test.system)
+		_, err := service.NewService(service.OSServiceParams{
+			Name:          "mysvc",
+			Exe:           "/bin/mysvc",
+			RestartPolicy: test.policy,
+		})
+		restoreSystem()
+		restore()
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(b.cfg.Option, jc.DeepEquals, test.expectOpt)
+	}
+}
+
+func (s *backendSuite) TestRestartSecNotSupported(c *gc.C) {
+	defer s.injectBackend(c, &fakeBackend{})()
+	defer s.injectSystem("linux-upstart")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:       "mysvc",
+		Exe:        "/bin/mysvc",
+		RestartSec: time.Second,
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": restart delay not supported by init system "linux-upstart"`)
+}
+
+func (s *backendSuite) TestNegativeRestartSec(c *gc.C) {
+	defer s.injectBackend(c, &fakeBackend{})()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:       "mysvc",
+		Exe:        "/bin/mysvc",
+		RestartSec: -time.Second,
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": negative restart delay`)
+}
+
+func (s *backendSuite) TestDependenciesWithSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-systemd")()
+
+	params := service.OSServiceParams{
+		Name:         "mysvc",
+		Exe:          "/bin/mysvc",
+		Dependencies: []string{"postgresql.service", "network-online.target", "postgresql.service"},
+	}
+	_, err := service.NewService(params)
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Dependencies, jc.DeepEquals, []string{
+		"After=network-online.target postgresql.service",
+		"Requires=network-online.target postgresql.service",
+	})
+	// The given dependencies are left unchanged.
+	c.Assert(params.Dependencies, jc.DeepEquals, []string{"postgresql.service", "network-online.target", "postgresql.service"})
+}
+
+func (s *backendSuite) TestDependenciesIgnoredWithoutSystemd(c *gc.C) {
+	b := &fakeBackend{}
+	defer s.injectBackend(c, b)()
+	defer s.injectSystem("linux-upstart")()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:         "mysvc",
+		Exe:          "/bin/mysvc",
+		Dependencies: []string{"postgresql.service"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.cfg.Dependencies, gc.IsNil)
+	c.Assert(buf.String(), jc.Contains, `warning: ignoring dependencies of service "mysvc": not supported by init system "linux-upstart"`)
+}
+
+func (s *backendSuite) TestInvalidDependency(c *gc.C) {
+	defer s.injectBackend(c, &fakeBackend{})()
+	defer s.injectSystem("linux-systemd")()
+
+	_, err := service.NewService(service.OSServiceParams{
+		Name:         "mysvc",
+		Exe:          "/bin/mysvc",
+		Dependencies: []string{"postgresql.service\nExecStartPre=/bin/evil"},
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": invalid dependency "postgresql.service\\nExecStartPre=/bin/evil"`)
+}
+
+// blockingStartBackend is a fakeBackend whose Start
+// method blocks until unblock is closed.
+type blockingStartBackend struct {
+	*fakeBackend
+	unblock chan struct{}
+}
+
+func (b *blockingStartBackend) Start() error {
+	<-b.unblock
+	return nil
+}
+
+func (s *backendSuite) TestStartAndStopContext(c *gc.C) {
+	b := &fakeBackend{installed: true}
+	defer s.injectBackend(c, b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StartContext(context.Background())
+	c.Assert(err, gc.IsNil)
+	assertRunning(c, svc, true)
+	err = svc.StopContext(context.Background())
+	c.Assert(err, gc.IsNil)
+	assertRunning(c, svc, false)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Start", "Stop"})
+}
+
+func (s *backendSuite) TestStartContextDone(c *gc.C) {
+	b := &blockingStartBackend{
+		fakeBackend: &fakeBackend{installed: true},
+		unblock:     make(chan struct{}),
+	}
+	defer close(b.unblock)
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return b, nil
+	}
+	defer func() {
+		*service.NewBackend = old
+	}()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = svc.StartContext(ctx)
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc": context deadline exceeded`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, context.DeadlineExceeded)
+}
+
+func (s *backendSuite) TestStartContextAlreadyDone(c *gc.C) {
+	b := &fakeBackend{installed: true}
+	defer s.injectBackend(c, b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = svc.StartContext(ctx)
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc": context canceled`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, context.Canceled)
+	c.Assert(b.calls, gc.HasLen, 0)
+}
+
+func (s *backendSuite) TestStopContextDone(c *gc.C) {
+	b := &slowStopBackend{
+		fakeBackend: &fakeBackend{installed: true, running: true},
+		polls:       -1,
+	}
+	defer s.injectSlowStopBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err = svc.StopContext(ctx)
+	c.Assert(err, gc.ErrorMatches, `can not stop service "mysvc": context canceled`)
+	c.Assert(pkgerrors.Cause(err), gc.Equals, context.Canceled)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Stop"})
+}
+
+// flakyStartBackend is a fakeBackend whose Start
+// method fails until it has been called a given
+// number of times.
+type flakyStartBackend struct {
+	*fakeBackend
+
+	// failures holds the number of calls
+	// to Start that will fail.
+	failures int
+}
+
+func (b *flakyStartBackend) Start() error {
+	b.calls = append(b.calls, "Start")
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("disk not mounted")
+	}
+	b.running = true
+	return nil
+}
+
+func (s *backendSuite) injectFlakyStartBackend(b *flakyStartBackend) func() {
+	old := *service.NewBackend
+	*service.NewBackend = func(i mservice.Interface, cfg *mservice.Config) (service.Backend, error) {
+		return b, nil
+	}
+	return func() {
+		*service.NewBackend = old
+	}
+}
+
+func (s *backendSuite) TestStartWithRetry(c *gc.C) {
+	b := &flakyStartBackend{
+		fakeBackend: &fakeBackend{installed: true},
+		failures:    2,
+	}
+	defer s.injectFlakyStartBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	t0 := time.Now()
+	err = svc.StartWithRetry(5, 10*time.Millisecond)
+	c.Assert(err, gc.IsNil)
+	// The delays were 10ms and then 20ms.
+	c.Assert(time.Since(t0) >= 30*time.Millisecond, gc.Equals, true)
+	assertRunning(c, svc, true)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Start", "Start", "Start"})
+}
+
+func (s *backendSuite) TestStartWithRetryFails(c *gc.C) {
+	b := &flakyStartBackend{
+		fakeBackend: &fakeBackend{installed: true},
+		failures:    5,
+	}
+	defer s.injectFlakyStartBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StartWithRetry(3, time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc" after 3 attempts: disk not mounted`)
+	c.Assert(b.calls, gc.HasLen, 3)
+	assertRunning(c, svc, false)
+}
+
+func (s *backendSuite) TestStartWithRetryAlreadyRunning(c *gc.C) {
+	// Start fails, but the service is running regardless,
+	// so it is not started again.
+	b := &flakyStartBackend{
+		fakeBackend: &fakeBackend{installed: true, running: true},
+		failures:    5,
+	}
+	defer s.injectFlakyStartBackend(b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StartWithRetry(3, time.Hour)
+	c.Assert(err, gc.IsNil)
+	c.Assert(b.calls, jc.DeepEquals, []string{"Start"})
+}
+
+func (s *backendSuite) TestStartWithRetryInvalidParams(c *gc.C) {
+	b := &fakeBackend{installed: true}
+	defer s.injectBackend(c, b)()
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "mysvc",
+		Exe:  "/bin/mysvc",
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.StartWithRetry(0, time.Second)
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc": invalid number of attempts 0`)
+	err = svc.StartWithRetry(3, -time.Second)
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc": negative retry delay`)
+	c.Assert(b.calls, gc.HasLen, 0)
+}
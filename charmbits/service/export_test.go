@@ -0,0 +1,11 @@
+package service
+
+type Backend = backend
+
+var NewBackend = &newBackend
+
+var ChosenSystem = &chosenSystem
+
+// DefaultNewService holds the original value of NewService,
+// which some tests replace without restoring it.
+var DefaultNewService = NewService
@@ -1,6 +1,15 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
 	"github.com/mever/service"
 	"github.com/pkg/errors"
 	"gopkg.in/tomb.v2"
@@ -22,13 +31,171 @@ type OSServiceParams struct {
 	// which should be OK to to pass to the shell
 	// without quoting.
 	Args []string
+
+	// UserName holds the name of the user that the service
+	// runs as. If it is empty, the service runs as root.
+	// The user must exist when the service is installed.
+	// It is ignored on platforms whose service backend
+	// cannot express it.
+	UserName string
+
+	// GroupName holds the name of the group that the service
+	// runs as. If it is empty, the user's primary group is used.
+	// The group must exist when the service is installed.
+	// It is only supported with systemd and is ignored
+	// on other platforms.
+	GroupName string
+
+	// SocketPath holds the absolute path of the Unix socket that
+	// the service listens on, if any (see Service.SetSocketPath).
+	// Its directory is created, owned by the service user and group,
+	// when the service is started, and any socket left behind is
+	// removed when the service is stopped.
+	SocketPath string
+
+	// WorkingDir holds the directory that the service runs in.
+	// If it is empty, the service manager's default is used.
+	// The directory must exist when the service is installed.
+	WorkingDir string
+
+	// Env holds environment variables to set for the service.
+	// It is only supported with systemd; NewService returns
+	// an error if it is not empty with other init systems.
+	Env map[string]string
+
+	// Dependencies holds the names of the units that the
+	// service depends on, such as "postgresql.service" or
+	// "network-online.target". With systemd, the service
+	// requires them and is started after them. They are
+	// only supported with systemd; with other init systems
+	// they are ignored and a warning is logged.
+	Dependencies []string
+
+	// HealthCheck, if not nil, is called periodically once the
+	// service has been started, while it is running, to check
+	// whether it is healthy, for example by requesting its HTTP
+	// health endpoint. It should return an error if the service
+	// is unhealthy. The result of the latest call is reported
+	// by OSService.Healthy.
+	HealthCheck func() error
+
+	// HealthInterval holds the interval between calls
+	// to HealthCheck. If it is zero, DefaultHealthInterval
+	// is used.
+	HealthInterval time.Duration
+
+	// RestartPolicy specifies when the service manager restarts
+	// the service after its process exits. If it is empty, the
+	// service manager's default behavior is left unchanged.
+	// NewService returns an error if the policy cannot be
+	// expressed with the current init system.
+	RestartPolicy RestartPolicy
+
+	// RestartSec holds the time that the service manager waits
+	// before restarting the service. If it is zero, the service
+	// manager's default is used. It is only supported with
+	// systemd, which is given it to millisecond precision.
+	RestartSec time.Duration
 }
 
+// RestartPolicy specifies when a service is restarted
+// after its process exits.
+type RestartPolicy string
+
+const (
+	// RestartNever specifies that the service is
+	// never restarted automatically.
+	RestartNever RestartPolicy = "no"
+
+	// RestartOnFailure specifies that the service is restarted
+	// when its process exits unsuccessfully or is killed.
+	RestartOnFailure RestartPolicy = "on-failure"
+
+	// RestartAlways specifies that the service is restarted
+	// whenever its process exits, unless it was stopped
+	// with Stop.
+	RestartAlways RestartPolicy = "always"
+)
+
+// DefaultHealthInterval holds the interval between health checks
+// of a service when OSServiceParams.HealthInterval is zero.
+const DefaultHealthInterval = 30 * time.Second
+
+// ExecCommand is used by RunAsServiceUser to run commands,
+// returning their combined standard output and standard error.
+// It is defined as a variable so that it can be replaced for
+// testing purposes.
+var ExecCommand = func(name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	c := exec.Command(name, args...)
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// runAsUser runs the given command as the given user
+// with sudo, returning its output.
+func runAsUser(user, cmd string, args ...string) (string, error) {
+	if user == "" {
+		return "", errors.New("no service user configured")
+	}
+	sudoArgs := append([]string{"-n", "-H", "-u", user, "--", cmd}, args...)
+	out, err := ExecCommand("sudo", sudoArgs...)
+	if err != nil {
+		return string(out), errors.Wrapf(err, "command %s failed as user %q with output %q", cmd, user, out)
+	}
+	return string(out), nil
+}
+
+// backend is the interface to the init system that is used by
+// the services created by NewService. It is implemented by
+// github.com/mever/service.Service.
+type backend interface {
+	Install() error
+	Uninstall() error
+	Start() error
+	Stop() error
+	Status() (service.Status, error)
+}
+
+// newBackend returns the backend for a service with the given
+// configuration. It is defined as a variable so that another backend
+// can be injected, for example for testing or for environments
+// without an init system.
+var newBackend = func(i service.Interface, cfg *service.Config) (backend, error) {
+	return service.New(i, cfg)
+}
 
 type srv struct {
-	p *program
-	t tomb.Tomb
-	name string
+	p          *program
+	t          tomb.Tomb
+	name       string
+	user       string
+	group      string
+	workingDir string
+	socketPath string
+
+	healthCheck    func() error
+	healthInterval time.Duration
+
+	// checking records whether the health check
+	// goroutine is running in t.
+	checking bool
+
+	// mu guards the following fields, which hold
+	// the result of the latest health check.
+	mu        sync.Mutex
+	checked   bool
+	healthErr error
+}
+
+func (s *srv) RunAsServiceUser(cmd string, args ...string) (string, error) {
+	out, err := runAsUser(s.user, cmd, args...)
+	if err != nil {
+		return out, errors.Wrapf(err, "service %q", s.name)
+	}
+	return out, nil
 }
 
 func (s *srv) StopAndRemove() error {
@@ -41,28 +208,305 @@ func (s *srv) StopAndRemove() error {
 	return nil
 }
 
+func (s *srv) Status() (ServiceStatus, error) {
+	return s.p.serviceStatus()
+}
+
 func (s *srv) Running() bool {
-	return s.p.IsRunning()
+	st, e := s.Status()
+	return e == nil && st == ServiceRunning
 }
 
+// ErrStopTimeout is the cause of the error returned by
+// OSService.StopWithTimeout when the service does not exit
+// in time.
+var ErrStopTimeout = errors.New("timed out waiting for service to stop")
+
+// defaultStopTimeout holds the time that Stop
+// waits for the service to exit.
+const defaultStopTimeout = 30 * time.Second
+
+// stopPollDelay holds the delay between checks
+// for whether a stopped service has exited.
+const stopPollDelay = 100 * time.Millisecond
+
 func (s *srv) Stop() error {
-	return s.p.Stop(s.p.Service)
+	return s.StopContext(context.Background())
+}
+
+func (s *srv) StopWithTimeout(d time.Duration) error {
+	return s.stop(context.Background(), d)
+}
+
+func (s *srv) StopContext(ctx context.Context) error {
+	return s.stop(ctx, defaultStopTimeout)
+}
+
+// stop stops the service and waits for up to d for it to exit,
+// returning early if ctx is done first.
+func (s *srv) stop(ctx context.Context, d time.Duration) error {
+	s.stopHealthCheck()
+	if e := s.runContext(ctx, "stop", s.p.backend.Stop); e != nil {
+		return e
+	}
+	// A tomb cannot be reused once it is dead,
+	// so start afresh for each stop.
+	s.t = tomb.Tomb{}
+	s.t.Go(func() error {
+		for {
+			running, e := s.p.IsRunning()
+			if e != nil || !running {
+				return e
+			}
+			select {
+			case <-s.t.Dying():
+				return nil
+			case <-time.After(stopPollDelay):
+			}
+		}
+	})
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-s.t.Dead():
+	case <-timer.C:
+		s.t.Kill(errors.Wrapf(ErrStopTimeout, "service %q still running after %v", s.name, d))
+	case <-ctx.Done():
+		s.t.Kill(errors.Wrapf(ctx.Err(), "can not stop service %q", s.name))
+	}
+	if e := s.t.Wait(); e != nil {
+		return e
+	}
+	if s.socketPath != "" {
+		return removeSocket(s.socketPath)
+	}
+	return nil
 }
 
 func (s *srv) Start() error {
-	return s.p.Start(s.p.Service)
+	return s.StartContext(context.Background())
+}
+
+func (s *srv) StartContext(ctx context.Context) error {
+	if s.socketPath != "" {
+		if e := ensureSocketDir(s.socketPath, s.user, s.group); e != nil {
+			return errors.Wrapf(e, "can not start service %q", s.name)
+		}
+	}
+	if e := s.runContext(ctx, "start", s.p.backend.Start); e != nil {
+		return e
+	}
+	s.startHealthCheck()
+	return nil
+}
+
+// MaxStartRetryDelay holds the longest delay between
+// attempts made by OSService.StartWithRetry.
+const MaxStartRetryDelay = 30 * time.Second
+
+func (s *srv) StartWithRetry(attempts int, initial time.Duration) error {
+	if attempts < 1 {
+		return errors.Errorf("can not start service %q: invalid number of attempts %d", s.name, attempts)
+	}
+	if initial < 0 {
+		return errors.Errorf("can not start service %q: negative retry delay", s.name)
+	}
+	delay := initial
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			if delay *= 2; delay > MaxStartRetryDelay {
+				delay = MaxStartRetryDelay
+			}
+		}
+		e := s.Start()
+		// The service may be running even if Start failed,
+		// for example if it was started by someone else.
+		st, re := s.Status()
+		if re == nil && st == ServiceRunning {
+			return nil
+		}
+		switch {
+		case e != nil:
+			lastErr = e
+		case re != nil:
+			lastErr = re
+		default:
+			lastErr = errors.New("service is not running")
+		}
+	}
+	return errors.Wrapf(lastErr, "can not start service %q after %d attempts", s.name, attempts)
+}
+
+// runContext calls f in a separate goroutine and returns its result,
+// unless ctx is done first, in which case it returns an error whose
+// cause is ctx.Err() and leaves f to complete in the background. It
+// does not call f at all if ctx is already done. The action names
+// the operation for the error message.
+func (s *srv) runContext(ctx context.Context, action string, f func() error) error {
+	if e := ctx.Err(); e != nil {
+		return errors.Wrapf(e, "can not %s service %q", action, s.name)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- f()
+	}()
+	select {
+	case e := <-result:
+		return e
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "can not %s service %q", action, s.name)
+	}
+}
+
+// ErrHealthUnknown is the cause of the error returned by
+// OSService.Healthy when the health of the service is not known.
+var ErrHealthUnknown = errors.New("service health not known")
+
+func (s *srv) Healthy() (bool, error) {
+	if s.healthCheck == nil {
+		return false, errors.Wrapf(ErrHealthUnknown, "service %q has no health check", s.name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checked {
+		return false, errors.Wrapf(ErrHealthUnknown, "service %q not checked since it was started", s.name)
+	}
+	if s.healthErr != nil {
+		return false, errors.Wrapf(s.healthErr, "service %q health check failed", s.name)
+	}
+	return true, nil
+}
+
+// startHealthCheck starts the goroutine that calls the service's
+// health check, unless there is no health check or it is already
+// running. The goroutine runs in s.t until stopHealthCheck is called.
+func (s *srv) startHealthCheck() {
+	if s.healthCheck == nil || s.checking {
+		return
+	}
+	s.setHealth(false, nil)
+	// A tomb cannot be reused once it is dead,
+	// so start afresh each time.
+	s.t = tomb.Tomb{}
+	s.checking = true
+	s.t.Go(func() error {
+		ticker := time.NewTicker(s.healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.t.Dying():
+				return nil
+			case <-ticker.C:
+			}
+			// Only a running service can be healthy, but
+			// a service that has stopped by itself may be
+			// started again, so keep checking.
+			running, e := s.p.IsRunning()
+			if e == nil && !running {
+				e = errors.New("service is not running")
+			}
+			if e == nil {
+				e = s.healthCheck()
+			}
+			s.setHealth(true, e)
+		}
+	})
+}
+
+// stopHealthCheck stops the health check goroutine
+// started by startHealthCheck, if it is running,
+// and waits for it to finish.
+func (s *srv) stopHealthCheck() {
+	if !s.checking {
+		return
+	}
+	s.t.Kill(nil)
+	s.t.Wait()
+	s.checking = false
+	s.setHealth(false, nil)
+}
+
+func (s *srv) setHealth(checked bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = checked
+	s.healthErr = err
+}
+
+// restarter is implemented by backends that can
+// restart a service in a single operation.
+type restarter interface {
+	Restart() error
+}
+
+func (s *srv) Restart() error {
+	st, e := s.Status()
+	if e != nil {
+		return errors.Wrap(e, "can not restart service")
+	}
+	if st != ServiceRunning {
+		return nil
+	}
+	if r, ok := s.p.backend.(restarter); ok {
+		if e := r.Restart(); e != nil {
+			return errors.Wrap(e, "can not restart service")
+		}
+		return nil
+	}
+	if e := s.Stop(); e != nil {
+		return errors.Wrap(e, "can not stop service")
+	}
+	if e := s.Start(); e != nil {
+		return errors.Wrap(e, "can not start service")
+	}
+	return nil
 }
 
 func (s *srv) Install() error {
-	if  s.p.IsNotInstalled() {
+	if s.user != "" {
+		if _, e := user.Lookup(s.user); e != nil {
+			return errors.Wrapf(e, "can not install service: bad user %q", s.user)
+		}
+	}
+	if s.group != "" {
+		if _, e := user.LookupGroup(s.group); e != nil {
+			return errors.Wrapf(e, "can not install service: bad group %q", s.group)
+		}
+	}
+	if s.workingDir != "" {
+		if e := checkDir(s.workingDir); e != nil {
+			return errors.Wrapf(e, "can not install service: bad working directory %q", s.workingDir)
+		}
+	}
+	notInstalled, e := s.p.IsNotInstalled()
+	if e != nil {
+		return errors.Wrap(e, "can not install service")
+	}
+	if notInstalled {
 		return s.p.Install()
-	} else {
-		return nil
 	}
+	return nil
+}
+
+// checkDir returns an error if dir
+// is not an existing directory.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+	return nil
 }
 
+// program implements service.Interface, and
+// controls the service through its backend.
 type program struct {
-	service.Service
+	backend
 	name string
 }
 
@@ -74,30 +518,46 @@ func (p *program) Stop(s service.Service) error {
 	return s.Stop()
 }
 
-func (p *program) IsNotInstalled() bool {
-	if s, er := p.Service.Status(); s == service.StatusUnknown {
-		if er == nil {
-			panic("service daemon reports an unknown status")
-		} else {
-			return er == service.ErrNotInstalled
-		}
-	} else {
-		return false
+func (p *program) IsNotInstalled() (bool, error) {
+	s, er := p.Status()
+	if s != service.StatusUnknown {
+		return false, nil
+	}
+	if er == nil {
+		return false, errors.New("service daemon reports an unknown status")
+	}
+	if er == service.ErrNotInstalled {
+		return true, nil
 	}
+	return false, errors.Wrap(er, "can not get service status")
 }
 
-func (p *program) IsRunning() bool {
-	if s, er := p.Service.Status(); er == nil {
-		return s == service.StatusRunning
-	} else {
-		panic(er)
+func (p *program) IsRunning() (bool, error) {
+	st, er := p.serviceStatus()
+	return st == ServiceRunning, er
+}
+
+// serviceStatus returns the status of the service
+// as reported by the backend.
+func (p *program) serviceStatus() (ServiceStatus, error) {
+	s, er := p.Status()
+	switch {
+	case er == service.ErrNotInstalled:
+		return ServiceNotInstalled, nil
+	case er != nil:
+		return ServiceUnknown, errors.Wrap(er, "can not get service status")
+	case s == service.StatusRunning:
+		return ServiceRunning, nil
+	case s == service.StatusStopped:
+		return ServiceStopped, nil
 	}
+	return ServiceUnknown, nil
 }
 
 func SystemLogger(osServiceName string) {
 	p := &program{name: osServiceName}
 	s, er := service.New(p, &service.Config{
-		Name:        osServiceName,
+		Name: osServiceName,
 	})
 	if er != nil {
 		panic(er)
@@ -112,20 +572,45 @@ func SystemLogger(osServiceName string) {
 // NewService is used to create a new service.
 // It is defined as a variable so that it can be
 // replaced for testing purposes.
-var NewService = func(p OSServiceParams) OSService {
+var NewService = func(p OSServiceParams) (OSService, error) {
 	cfg := &service.Config{
-		Name:        p.Name,
-		DisplayName: p.Description,
-		Executable:  p.Exe,
-		Arguments:   p.Args,
+		Name:             p.Name,
+		DisplayName:      p.Description,
+		Executable:       p.Exe,
+		Arguments:        p.Args,
+		UserName:         p.UserName,
+		WorkingDirectory: p.WorkingDir,
+	}
+	if er := setUnitOptions(cfg, p); er != nil {
+		return nil, errors.Wrapf(er, "can not create service %q", p.Name)
+	}
+	if er := setDependencies(cfg, p.Name, p.Dependencies); er != nil {
+		return nil, errors.Wrapf(er, "can not create service %q", p.Name)
 	}
 
+	if p.HealthInterval < 0 {
+		return nil, errors.Errorf("can not create service %q: negative health check interval", p.Name)
+	}
+	if p.SocketPath != "" && !filepath.IsAbs(p.SocketPath) {
+		return nil, errors.Errorf("can not create service %q: socket path %q is not absolute", p.Name, p.SocketPath)
+	}
 	var er error
-	s := &srv{p: &program{name: p.Name}}
-	s.p.Service, er = service.New(s.p, cfg)
+	s := &srv{
+		p:              &program{name: p.Name},
+		name:           p.Name,
+		user:           p.UserName,
+		group:          p.GroupName,
+		workingDir:     p.WorkingDir,
+		socketPath:     p.SocketPath,
+		healthCheck:    p.HealthCheck,
+		healthInterval: p.HealthInterval,
+	}
+	if s.healthInterval == 0 {
+		s.healthInterval = DefaultHealthInterval
+	}
+	s.p.backend, er = newBackend(s.p, cfg)
 	if er != nil {
-		panic(er)
+		return nil, errors.Wrapf(er, "can not create service %q", p.Name)
 	}
-
-	return s
-}
\ No newline at end of file
+	return s, nil
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"errors"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type runAsSuite struct{}
+
+var _ = gc.Suite(&runAsSuite{})
+
+func (*runAsSuite) TestRunAsServiceUser(c *gc.C) {
+	var calls [][]string
+	defer func(old func(string, ...string) ([]byte, error)) {
+		service.ExecCommand = old
+	}(service.ExecCommand)
+	service.ExecCommand = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{name}, args...))
+		return []byte("migrated\n"), nil
+	}
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:     "gocharm-runas-test",
+		Exe:      "/bin/true",
+		UserName: "webapp",
+	})
+	c.Assert(err, gc.IsNil)
+	out, err := svc.RunAsServiceUser("migrate", "--all")
+	c.Assert(err, gc.IsNil)
+	c.Assert(out, gc.Equals, "migrated\n")
+	c.Assert(calls, jc.DeepEquals, [][]string{
+		{"sudo", "-n", "-H", "-u", "webapp", "--", "migrate", "--all"},
+	})
+}
+
+func (*runAsSuite) TestRunAsServiceUserFailure(c *gc.C) {
+	defer func(old func(string, ...string) ([]byte, error)) {
+		service.ExecCommand = old
+	}(service.ExecCommand)
+	service.ExecCommand = func(name string, args ...string) ([]byte, error) {
+		return []byte("no such table"), errors.New("exit status 1")
+	}
+	svc, err := service.NewService(service.OSServiceParams{
+		Name:     "gocharm-runas-test",
+		Exe:      "/bin/true",
+		UserName: "webapp",
+	})
+	c.Assert(err, gc.IsNil)
+	out, err := svc.RunAsServiceUser("migrate")
+	c.Assert(err, gc.ErrorMatches, `service "gocharm-runas-test": command migrate failed as user "webapp" with output "no such table": exit status 1`)
+	c.Assert(out, gc.Equals, "no such table")
+}
+
+func (*runAsSuite) TestRunAsServiceUserWithNoUser(c *gc.C) {
+	defer func(old func(string, ...string) ([]byte, error)) {
+		service.ExecCommand = old
+	}(service.ExecCommand)
+	service.ExecCommand = nil // Must not be called.
+	svc, err := service.NewService(service.OSServiceParams{
+		Name: "gocharm-runas-test",
+		Exe:  "/bin/true",
+	})
+	c.Assert(err, gc.IsNil)
+	_, err = svc.RunAsServiceUser("migrate")
+	c.Assert(err, gc.ErrorMatches, `service "gocharm-runas-test": no service user configured`)
+}
+
+func (*runAsSuite) TestHooktestRunAsServiceUser(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return []byte("ok"), nil
+		},
+	}
+	newService := hooktest.NewServiceFunc(runner, nil)
+	svc, err := newService(service.OSServiceParams{
+		Name:     "svc",
+		UserName: "webapp",
+	})
+	c.Assert(err, gc.IsNil)
+	out, err := svc.RunAsServiceUser("migrate", "up")
+	c.Assert(err, gc.IsNil)
+	c.Assert(out, gc.Equals, "ok")
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"sudo", "-n", "-H", "-u", "webapp", "--", "migrate", "up"},
+	})
+
+	svc, err = newService(service.OSServiceParams{Name: "svc"})
+	c.Assert(err, gc.IsNil)
+	_, err = svc.RunAsServiceUser("migrate")
+	c.Assert(err, gc.ErrorMatches, `service "svc": no service user configured`)
+}
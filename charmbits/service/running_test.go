@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type runningSuite struct{}
+
+var _ = gc.Suite(&runningSuite{})
+
+// newRunningRunner returns a runner for a charm whose install
+// hook calls EnsureNotRunning with a check that reports
+// *running and records that it was called in *checked,
+// and then starts the service.
+func newRunningRunner(c *gc.C, osSvc *hooktest.RecordingService, running, checked *bool) *hooktest.Runner {
+	service.NewService = func(service.OSServiceParams) (service.OSService, error) {
+		return osSvc, nil
+	}
+	return &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var svc service.Service
+			svc.Register(r.Clone("svc"), "servicename", func(*service.Context, []string) (hook.Command, error) {
+				return nil, nil
+			})
+			r.RegisterHook("install", func() error {
+				err := svc.EnsureNotRunning(func() bool {
+					*checked = true
+					return *running
+				})
+				if err != nil {
+					c.Check(errgo.Cause(err), gc.Equals, service.ErrAlreadyRunning)
+					return errgo.Mask(err)
+				}
+				return svc.Start()
+			})
+		},
+	}
+}
+
+func (*runningSuite) TestEnsureNotRunningConflict(c *gc.C) {
+	defer func(old func(service.OSServiceParams) (service.OSService, error)) {
+		service.NewService = old
+	}(service.NewService)
+	osSvc := &hooktest.RecordingService{}
+	running, checked := true, false
+	runner := newRunningRunner(c, osSvc, &running, &checked)
+
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.ErrorMatches, `cannot install service "servicename": another instance is already running`)
+	c.Assert(checked, jc.IsTrue)
+	c.Assert(osSvc.Calls, gc.HasLen, 0)
+}
+
+func (*runningSuite) TestEnsureNotRunningClear(c *gc.C) {
+	defer func(old func(service.OSServiceParams) (service.OSService, error)) {
+		service.NewService = old
+	}(service.NewService)
+	osSvc := &hooktest.RecordingService{}
+	running, checked := false, false
+	runner := newRunningRunner(c, osSvc, &running, &checked)
+
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, jc.IsTrue)
+	c.Assert(osSvc.Calls, jc.DeepEquals, []string{"Install", "Start"})
+
+	// Once the service is installed, the running
+	// instance is our own, so it is not checked.
+	running, checked = true, false
+	err = runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, jc.IsFalse)
+}
@@ -3,6 +3,7 @@
 package service
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
@@ -26,17 +27,112 @@ import (
 type OSService interface {
 	Install() error
 	StopAndRemove() error
+
+	// Status returns the status of the service. It returns
+	// an error if the status of the service cannot be
+	// determined.
+	Status() (ServiceStatus, error)
+
+	// Running reports whether the service is running. It is
+	// a thin wrapper over Status that returns false if the
+	// status of the service cannot be determined.
 	Running() bool
+
+	// Stop stops the service and waits for it to exit, for
+	// up to a default timeout (see StopWithTimeout).
 	Stop() error
+
+	// StopWithTimeout stops the service and waits for up to
+	// the given duration for it to exit. It returns an error
+	// with an ErrStopTimeout cause if the service is still
+	// running after that.
+	StopWithTimeout(d time.Duration) error
+
+	// StopContext is like Stop except that it returns early
+	// if ctx is done before the service has exited, with an
+	// error whose cause is ctx.Err().
+	StopContext(ctx context.Context) error
+
 	Start() error
+
+	// StartContext is like Start except that it returns early
+	// if ctx is done before the service has been started, with
+	// an error whose cause is ctx.Err(). The service may still
+	// be started after that.
+	StartContext(ctx context.Context) error
+
+	// StartWithRetry calls Start up to the given number of times
+	// until the service is running, waiting for the given initial
+	// delay after the first failed attempt and doubling it after
+	// each subsequent one, up to MaxStartRetryDelay. It returns
+	// the error from the last attempt if the service is not
+	// running after all of them.
+	StartWithRetry(attempts int, initial time.Duration) error
+
+	// Restart stops the service and starts it again, so that
+	// it picks up any changes to its configuration. It does
+	// nothing if the service is not running.
+	Restart() error
+
+	// RunAsServiceUser runs the given command as the user
+	// that the service runs as (see OSServiceParams.UserName)
+	// and returns its combined standard output and standard
+	// error. It returns an error if no user is configured.
+	RunAsServiceUser(cmd string, args ...string) (string, error)
+
+	// Healthy reports the result of the latest call to the
+	// service's health check (see OSServiceParams.HealthCheck):
+	// true if it succeeded, or false and the error it returned.
+	// It returns false and an error with an ErrHealthUnknown
+	// cause if there is no health check or it has not been
+	// called since the service was started.
+	Healthy() (bool, error)
+}
+
+// ServiceStatus represents the status of an operating
+// system service.
+type ServiceStatus int
+
+const (
+	// ServiceUnknown is returned when the service
+	// daemon cannot say what state the service is in.
+	ServiceUnknown ServiceStatus = iota
+
+	// ServiceNotInstalled is returned when the
+	// service has not been installed.
+	ServiceNotInstalled
+
+	// ServiceStopped is returned when the service
+	// is installed but not running.
+	ServiceStopped
+
+	// ServiceRunning is returned when the
+	// service is running.
+	ServiceRunning
+)
+
+var serviceStatusNames = []string{
+	ServiceUnknown:      "unknown",
+	ServiceNotInstalled: "not installed",
+	ServiceStopped:      "stopped",
+	ServiceRunning:      "running",
+}
+
+// String implements fmt.Stringer.
+func (st ServiceStatus) String() string {
+	if st < 0 || int(st) >= len(serviceStatusNames) {
+		return fmt.Sprintf("ServiceStatus(%d)", int(st))
+	}
+	return serviceStatusNames[st]
 }
 
 // Service represents a long running service that runs
 // outside of the usual charm hook context.
 type Service struct {
-	ctxt        *hook.Context
-	serviceName string
-	state       localState
+	ctxt               *hook.Context
+	serviceName        string
+	workloadSocketPath string
+	state              localState
 }
 
 type localState struct {
@@ -59,12 +155,19 @@ type localState struct {
 // will not be available, as at that point the hook will be
 // running in the context of the OS-provided service runner
 // (e.g. upstart).
+//
+// The service is stopped when the charm is stopped. The stop is
+// registered with hook.Registry.RegisterStop, so any stop functions
+// registered after Register is called run before it, while the
+// service is still available to be called, so they can be used to
+// ask it to drain gracefully.
 func (svc *Service) Register(r *hook.Registry, serviceName string, start func(ctxt *Context, args []string) (hook.Command, error)) {
 	if start == nil {
 		panic("nil start function passed to Service.Register")
 	}
 	svc.serviceName = serviceName
 	r.RegisterContext(svc.setContext, &svc.state)
+	r.RegisterStop(svc.stopHook)
 	// TODO Perhaps provide some way to do zero-downtime
 	// upgrades?
 	//r.RegisterHook("upgrade-charm", svc.Restart)
@@ -73,6 +176,15 @@ func (svc *Service) Register(r *hook.Registry, serviceName string, start func(ct
 	})
 }
 
+// SetSocketPath declares that the service listens on a Unix socket
+// at the given absolute path rather than on a port. The directory
+// holding the socket is created when the service is started, and
+// any socket left behind is removed when the service is stopped
+// or removed. It should be called before any hooks run.
+func (svc *Service) SetSocketPath(path string) {
+	svc.workloadSocketPath = path
+}
+
 func (svc *Service) setContext(ctxt *hook.Context) error {
 	svc.ctxt = ctxt
 	return nil
@@ -100,7 +212,10 @@ func (svc *Service) Start(args ...string) error {
 		return errgo.Notef(err, "cannot create state directory")
 	}
 	svc.ctxt.Logf("starting service")
-	usvc := svc.osService(args)
+	usvc, err := svc.osService(args)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	// Note: Install will restart the service if the configuration
 	// file has changed.
 	if err := usvc.Install(); err != nil {
@@ -117,17 +232,62 @@ func (svc *Service) Start(args ...string) error {
 	return nil
 }
 
+// ErrAlreadyRunning is the cause of the error returned by
+// Service.EnsureNotRunning when another instance of the
+// service is found to be running.
+var ErrAlreadyRunning = errgo.New("another instance is already running")
+
+// EnsureNotRunning returns an error with an ErrAlreadyRunning
+// cause if the service has not been installed by the charm but
+// check reports that an instance of it is already running, for
+// example because a process is listening on the service's port
+// or socket. It should be called before Start so that the charm
+// refuses to install a service that would conflict with the
+// existing instance.
+//
+// Once the service has been installed, the running instance is
+// the charm's own, so check is not called.
+func (svc *Service) EnsureNotRunning(check func() bool) error {
+	if svc.state.Installed {
+		return nil
+	}
+	if check() {
+		return errgo.WithCausef(nil, ErrAlreadyRunning, "cannot install service %q: another instance is already running", svc.osServiceName())
+	}
+	return nil
+}
+
+// stopHook stops the service when the charm is stopped.
+func (svc *Service) stopHook() error {
+	if !svc.state.Installed {
+		return nil
+	}
+	return svc.Stop()
+}
+
 // Stop stops the service running.
 func (svc *Service) Stop() error {
-	if err := svc.osService(nil).Stop(); err != nil {
+	usvc, err := svc.osService(nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := usvc.Stop(); err != nil {
 		return errgo.Mask(err)
 	}
 	return nil
 }
 
 // Started reports whether the service has been started.
-func (svc *Service) Started() bool {
-	return svc.osService(nil).Running()
+func (svc *Service) Started() (bool, error) {
+	usvc, err := svc.osService(nil)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	st, err := usvc.Status()
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	return st == ServiceRunning, nil
 }
 
 // StopAndRemove stops and removes the service completely.
@@ -135,7 +295,11 @@ func (svc *Service) StopAndRemove() error {
 	if !svc.state.Installed {
 		return nil
 	}
-	if err := svc.osService(nil).StopAndRemove(); err != nil {
+	usvc, err := svc.osService(nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := usvc.StopAndRemove(); err != nil {
 		return errgo.Mask(err)
 	}
 	svc.state.Installed = false
@@ -176,13 +340,10 @@ func (svc *Service) Call(method string, args interface{}, reply interface{}) err
 	return nil
 }
 
-func (svc *Service) osService(args []string) OSService {
+func (svc *Service) osService(args []string) (OSService, error) {
 	svc.ctxt.Logf("osService with args: %q", args)
 	exe := filepath.Join(svc.ctxt.CharmDir, "bin", "runhook")
-	serviceName := svc.serviceName
-	if serviceName == "" {
-		serviceName = svc.ctxt.Unit.Tag().String()
-	}
+	serviceName := svc.osServiceName()
 	// Marshal all arguments as JSON to avoid upstart quoting hassles.
 	p := serviceParams{
 		SocketPath: svc.socketPath(),
@@ -197,6 +358,7 @@ func (svc *Service) osService(args []string) OSService {
 		Name:        serviceName,
 		Description: fmt.Sprintf("service for juju unit %q", svc.ctxt.Unit),
 		Exe:         exe,
+		SocketPath:  svc.workloadSocketPath,
 		Args: []string{
 			svc.ctxt.CommandName(),
 			base64.StdEncoding.EncodeToString(paramData),
@@ -204,6 +366,16 @@ func (svc *Service) osService(args []string) OSService {
 	})
 }
 
+// osServiceName returns the name of the OS service,
+// which is named after the unit if no name was
+// passed to Register.
+func (svc *Service) osServiceName() string {
+	if svc.serviceName != "" {
+		return svc.serviceName
+	}
+	return svc.ctxt.Unit.Tag().String()
+}
+
 func dialRPC(path string) (*rpc.Client, error) {
 	c, err := net.Dial("unix", path)
 	if err != nil {
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// socketDirMode holds the permissions of the directory created
+// to hold a service's socket. Clients must be able to reach the
+// socket, but only the owner can create or remove entries.
+const socketDirMode = 0755
+
+// ensureSocketDir creates the directory that will hold the Unix
+// socket at the given path if it does not already exist, owned by
+// the given user and group so that a service that does not run as
+// root can create its socket there. An existing directory, such as
+// /run, is left as it is.
+func ensureSocketDir(path, userName, groupName string) error {
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	uid, gid, err := socketDirOwner(userName, groupName)
+	if err != nil {
+		return errors.Wrap(err, "can not create socket directory")
+	}
+	if err := os.MkdirAll(dir, socketDirMode); err != nil {
+		return errors.Wrap(err, "can not create socket directory")
+	}
+	// Make sure that the umask has not
+	// restricted the permissions.
+	if err := os.Chmod(dir, socketDirMode); err != nil {
+		return errors.Wrap(err, "can not set permissions of socket directory")
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return errors.Wrap(err, "can not set owner of socket directory")
+	}
+	return nil
+}
+
+// socketDirOwner returns the user and group ids that should own
+// the socket directory of a service running as the given user and
+// group. As with os.Chown, -1 means that the id is left unchanged.
+func socketDirOwner(userName, groupName string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return -1, -1, errors.Wrapf(err, "bad user %q", userName)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, errors.Wrapf(err, "bad user id for %q", userName)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return -1, -1, errors.Wrapf(err, "bad group id for %q", userName)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return -1, -1, errors.Wrapf(err, "bad group %q", groupName)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, errors.Wrapf(err, "bad group id for %q", groupName)
+		}
+	}
+	return uid, gid, nil
+}
+
+// removeSocket removes any socket left at the given path
+// after the service has stopped. It is not an error if
+// there is no socket.
+func removeSocket(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "can not remove socket")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package service_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type socketSuite struct{}
+
+var _ = gc.Suite(&socketSuite{})
+
+func (*socketSuite) TestSocketPathPassedToOSService(c *gc.C) {
+	defer func(old func(service.OSServiceParams) (service.OSService, error)) {
+		service.NewService = old
+	}(service.NewService)
+	osSvc := &hooktest.RecordingService{}
+	service.NewService = osSvc.NewService
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var svc service.Service
+			svc.Register(r.Clone("svc"), "servicename", func(*service.Context, []string) (hook.Command, error) {
+				return nil, nil
+			})
+			svc.SetSocketPath("/run/myapp/myapp.sock")
+			r.RegisterHook("start", func() error {
+				return svc.Start()
+			})
+		},
+	}
+	err := runner.RunHook("start", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(osSvc.Params.SocketPath, gc.Equals, "/run/myapp/myapp.sock")
+}
+
+func (*socketSuite) TestSocketDirCreatedAndSocketRemoved(c *gc.C) {
+	defer (&backendSuite{}).injectBackend(c, &fakeBackend{})()
+	dir := filepath.Join(c.MkDir(), "run", "myapp")
+	socketPath := filepath.Join(dir, "myapp.sock")
+	svc, err := service.DefaultNewService(service.OSServiceParams{
+		Name:       "mysvc",
+		SocketPath: socketPath,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+
+	c.Assert(svc.Start(), gc.IsNil)
+	info, err := os.Stat(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.IsDir(), jc.IsTrue)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0755))
+
+	// Simulate the socket left behind by the service.
+	err = ioutil.WriteFile(socketPath, nil, 0666)
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Stop(), gc.IsNil)
+	_, err = os.Stat(socketPath)
+	c.Assert(os.IsNotExist(err), jc.IsTrue)
+
+	// Restarting the service works when the directory exists.
+	c.Assert(svc.Start(), gc.IsNil)
+	err = ioutil.WriteFile(socketPath, nil, 0666)
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.StopAndRemove(), gc.IsNil)
+	_, err = os.Stat(socketPath)
+	c.Assert(os.IsNotExist(err), jc.IsTrue)
+}
+
+func (*socketSuite) TestSocketDirOwnedByServiceUser(c *gc.C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a directory requires root")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		c.Skip("no nobody user")
+	}
+	defer (&backendSuite{}).injectBackend(c, &fakeBackend{})()
+	dir := filepath.Join(c.MkDir(), "myapp")
+	svc, err := service.DefaultNewService(service.OSServiceParams{
+		Name:       "mysvc",
+		UserName:   "nobody",
+		SocketPath: filepath.Join(dir, "myapp.sock"),
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Install(), gc.IsNil)
+	c.Assert(svc.Start(), gc.IsNil)
+
+	info, err := os.Stat(dir)
+	c.Assert(err, gc.IsNil)
+	st := info.Sys().(*syscall.Stat_t)
+	c.Assert(strconv.Itoa(int(st.Uid)), gc.Equals, u.Uid)
+	c.Assert(strconv.Itoa(int(st.Gid)), gc.Equals, u.Gid)
+}
+
+func (*socketSuite) TestSocketDirBadUser(c *gc.C) {
+	defer (&backendSuite{}).injectBackend(c, &fakeBackend{})()
+	dir := filepath.Join(c.MkDir(), "myapp")
+	svc, err := service.DefaultNewService(service.OSServiceParams{
+		Name:       "mysvc",
+		UserName:   "no-such-user-gocharm",
+		SocketPath: filepath.Join(dir, "myapp.sock"),
+	})
+	c.Assert(err, gc.IsNil)
+	err = svc.Start()
+	c.Assert(err, gc.ErrorMatches, `can not start service "mysvc": can not create socket directory: bad user "no-such-user-gocharm": .*`)
+	_, err = os.Stat(dir)
+	c.Assert(os.IsNotExist(err), jc.IsTrue)
+}
+
+func (*socketSuite) TestExistingSocketDirLeftAlone(c *gc.C) {
+	defer (&backendSuite{}).injectBackend(c, &fakeBackend{})()
+	dir := c.MkDir()
+	err := os.Chmod(dir, 0700)
+	c.Assert(err, gc.IsNil)
+	svc, err := service.DefaultNewService(service.OSServiceParams{
+		Name:       "mysvc",
+		SocketPath: filepath.Join(dir, "myapp.sock"),
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(svc.Start(), gc.IsNil)
+	info, err := os.Stat(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, os.FileMode(0700))
+}
+
+func (*socketSuite) TestRelativeSocketPath(c *gc.C) {
+	defer (&backendSuite{}).injectBackend(c, &fakeBackend{})()
+	_, err := service.DefaultNewService(service.OSServiceParams{
+		Name:       "mysvc",
+		SocketPath: "myapp.sock",
+	})
+	c.Assert(err, gc.ErrorMatches, `can not create service "mysvc": socket path "myapp.sock" is not absolute`)
+}
@@ -0,0 +1,51 @@
+package service_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type stopSuite struct{}
+
+var _ = gc.Suite(&stopSuite{})
+
+func (*stopSuite) TestStopHandlersRunBeforeServiceStop(c *gc.C) {
+	osSvc := &hooktest.RecordingService{}
+	defer func(old func(service.OSServiceParams) (service.OSService, error)) {
+		service.NewService = old
+	}(service.NewService)
+	service.NewService = func(service.OSServiceParams) (service.OSService, error) {
+		return osSvc, nil
+	}
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var svc service.Service
+			svc.Register(r.Clone("svc"), "servicename", func(*service.Context, []string) (hook.Command, error) {
+				return nil, nil
+			})
+			r.RegisterHook("start", func() error {
+				return svc.Start()
+			})
+			r.RegisterStop(func() error {
+				started, err := svc.Started()
+				c.Check(err, gc.IsNil)
+				c.Check(started, jc.IsTrue)
+				osSvc.Calls = append(osSvc.Calls, "drain")
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("start", "", "")
+	c.Assert(err, gc.IsNil)
+	osSvc.Calls = nil
+
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(osSvc.Calls, jc.DeepEquals, []string{"drain", "Stop"})
+}
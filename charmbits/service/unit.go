@@ -0,0 +1,236 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mever/service"
+	"github.com/pkg/errors"
+)
+
+// chosenSystem returns the name of the init system that
+// services are installed with, such as "linux-systemd".
+// It is defined as a variable so that it can be replaced
+// for testing purposes.
+var chosenSystem = func() string {
+	s := service.ChosenSystem()
+	if s == nil {
+		return ""
+	}
+	return s.String()
+}
+
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envLines returns the systemd Environment lines
+// for the given variables, sorted by name so that
+// the generated unit file is always the same.
+func envLines(env map[string]string) ([]string, error) {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		if !validEnvName.MatchString(name) {
+			return nil, errors.Errorf("invalid environment variable name %q", name)
+		}
+		val := env[name]
+		if strings.ContainsAny(val, "\n\r\x00") {
+			return nil, errors.Errorf("invalid value for environment variable %q", name)
+		}
+		lines[i] = "Environment=" + systemdQuote(name+"="+val)
+	}
+	return lines, nil
+}
+
+// systemdQuote quotes s so that systemd reads it
+// as a single word with no specifiers expanded.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, `%`, `%%`, -1)
+	return `"` + s + `"`
+}
+
+// validGroupName matches the group names accepted by systemd.
+var validGroupName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// setUnitOptions arranges for the service with the given configuration
+// to run with the group, environment variables and restart settings
+// in p, which github.com/mever/service does not support directly. With
+// systemd, they are added to the unit file with a custom unit template.
+// Environment variables and restart delays are not supported with
+// other init systems, and restart policies only where the init system
+// can express them; the group is ignored.
+func setUnitOptions(cfg *service.Config, p OSServiceParams) error {
+	if p.GroupName == "" && len(p.Env) == 0 && p.RestartPolicy == "" && p.RestartSec == 0 {
+		return nil
+	}
+	if p.GroupName != "" && !validGroupName.MatchString(p.GroupName) {
+		return errors.Errorf("invalid group name %q", p.GroupName)
+	}
+	if err := checkRestart(p); err != nil {
+		return err
+	}
+	sys := chosenSystem()
+	if sys != "linux-systemd" {
+		if len(p.Env) > 0 {
+			return errors.Errorf("environment variables not supported by init system %q", sys)
+		}
+		if p.RestartSec != 0 {
+			return errors.Errorf("restart delay not supported by init system %q", sys)
+		}
+		return setRestartOption(cfg, sys, p.RestartPolicy)
+	}
+	if p.RestartPolicy != "" {
+		setOption(cfg, "Restart", string(p.RestartPolicy))
+	}
+	if p.GroupName == "" && len(p.Env) == 0 && p.RestartSec == 0 {
+		// The default unit template suffices.
+		return nil
+	}
+	lines := []string{"RestartSec=" + systemdTimeSpan(defaultRestartSec)}
+	if p.RestartSec != 0 {
+		lines[0] = "RestartSec=" + systemdTimeSpan(p.RestartSec)
+	}
+	if p.GroupName != "" {
+		lines = append(lines, "Group="+p.GroupName)
+	}
+	env, err := envLines(p.Env)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, env...)
+	// The lines become part of a Go template, so
+	// any template delimiters must be escaped.
+	text := strings.Replace(strings.Join(lines, "\n"), "{{", `{{"{{"}}`, -1)
+	setOption(cfg, "SystemdScript", fmt.Sprintf(systemdScript, text))
+	return nil
+}
+
+func setOption(cfg *service.Config, name string, val interface{}) {
+	if cfg.Option == nil {
+		cfg.Option = make(service.KeyValue)
+	}
+	cfg.Option[name] = val
+}
+
+// defaultRestartSec holds the restart delay used in the
+// unit template of github.com/mever/service.
+const defaultRestartSec = 120 * time.Second
+
+// checkRestart checks that the restart settings in p are valid.
+func checkRestart(p OSServiceParams) error {
+	switch p.RestartPolicy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return errors.Errorf("invalid restart policy %q", p.RestartPolicy)
+	}
+	if p.RestartSec < 0 {
+		return errors.New("negative restart delay")
+	}
+	return nil
+}
+
+// setRestartOption arranges for the service with the given
+// configuration to be restarted according to the given policy by
+// the given init system, which is not systemd. Init systems without
+// process supervision never restart services, and upstart services
+// are always restarted.
+func setRestartOption(cfg *service.Config, sys string, policy RestartPolicy) error {
+	switch {
+	case policy == "":
+		return nil
+	case sys == "darwin-launchd" && policy != RestartOnFailure:
+		setOption(cfg, "KeepAlive", policy == RestartAlways)
+		return nil
+	case sys == "linux-upstart" && policy == RestartAlways:
+		return nil
+	case (sys == "unix-systemv" || sys == "linux-openrc" || sys == "windows-service") && policy == RestartNever:
+		return nil
+	}
+	return errors.Errorf("restart policy %q not supported by init system %q", policy, sys)
+}
+
+// validUnitName matches the unit names accepted by systemd.
+var validUnitName = regexp.MustCompile(`^[A-Za-z0-9:_.@\-]+$`)
+
+// setDependencies arranges for the service with the given configuration
+// to require the given units and to be started after them. The units
+// are sorted so that the generated unit file is always the same. Only
+// systemd can express dependencies; with other init systems, they are
+// ignored and a warning is logged.
+func setDependencies(cfg *service.Config, name string, deps []string) error {
+	if len(deps) == 0 {
+		return nil
+	}
+	units := make([]string, 0, len(deps))
+	seen := make(map[string]bool)
+	for _, dep := range deps {
+		if !validUnitName.MatchString(dep) {
+			return errors.Errorf("invalid dependency %q", dep)
+		}
+		if !seen[dep] {
+			seen[dep] = true
+			units = append(units, dep)
+		}
+	}
+	sort.Strings(units)
+	if sys := chosenSystem(); sys != "linux-systemd" {
+		log.Printf("warning: ignoring dependencies of service %q: not supported by init system %q", name, sys)
+		return nil
+	}
+	list := strings.Join(units, " ")
+	cfg.Dependencies = []string{
+		"After=" + list,
+		"Requires=" + list,
+	}
+	return nil
+}
+
+// systemdTimeSpan returns d as a systemd time span,
+// to millisecond precision.
+func systemdTimeSpan(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// systemdScript holds the unit template used by
+// github.com/mever/service, with a placeholder for
+// the lines added by setUnitOptions.
+const systemdScript = `[Unit]
+Description={{.Description}}
+ConditionFileIsExecutable={{.Path|cmdEscape}}
+{{range $i, $dep := .Dependencies}} 
+{{$dep}} {{end}}
+
+[Service]
+StartLimitInterval=5
+StartLimitBurst=10
+ExecStart={{.Path|cmdEscape}}{{range .Arguments}} {{.|cmd}}{{end}}
+{{if .ChRoot}}RootDirectory={{.ChRoot|cmd}}{{end}}
+{{if .WorkingDirectory}}WorkingDirectory={{.WorkingDirectory|cmdEscape}}{{end}}
+{{if .UserName}}User={{.UserName}}{{end}}
+{{if .ReloadSignal}}ExecReload=/bin/kill -{{.ReloadSignal}} "$MAINPID"{{end}}
+{{if .PIDFile}}PIDFile={{.PIDFile|cmd}}{{end}}
+{{if and .LogOutput .HasOutputFileSupport -}}
+StandardOutput=file:/var/log/{{.Name}}.out
+StandardError=file:/var/log/{{.Name}}.err
+{{- end}}
+{{if gt .LimitNOFILE -1 }}LimitNOFILE={{.LimitNOFILE}}{{end}}
+{{if .Restart}}Restart={{.Restart}}{{end}}
+{{if .SuccessExitStatus}}SuccessExitStatus={{.SuccessExitStatus}}{{end}}
+%s
+EnvironmentFile=-/etc/sysconfig/{{.Name}}
+
+[Install]
+WantedBy=multi-user.target
+`
@@ -0,0 +1,9 @@
+package timer
+
+var (
+	SystemdDir    = &systemdDir
+	CronDir       = &cronDir
+	HasSystemd    = &hasSystemd
+	ExecCommand   = &execCommand
+	ParseSchedule = parseSchedule
+)
@@ -0,0 +1,412 @@
+// The timer package provides a charmbit that runs commands
+// periodically. Each job is installed as a systemd timer and
+// service pair, or as a cron entry on hosts that do not use
+// systemd. Jobs are installed idempotently, and all the jobs
+// installed by the charm are removed when the stop hook runs.
+package timer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// systemdDir and cronDir hold the directories that
+// systemd units and cron entries are written to.
+var (
+	systemdDir = "/etc/systemd/system"
+	cronDir    = "/etc/cron.d"
+)
+
+// hasSystemd reports whether the host uses systemd. It is defined
+// as a variable so that it can be replaced for testing purposes.
+var hasSystemd = func() bool {
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
+
+// execCommand is used to run systemctl, returning its combined
+// standard output and standard error. It is defined as a
+// variable so that it can be replaced for testing purposes.
+var execCommand = func(name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	c := exec.Command(name, args...)
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// Backends that a job may be installed with.
+const (
+	backendSystemd = "systemd"
+	backendCron    = "cron"
+)
+
+// Timers represents the periodic jobs managed by the charm.
+type Timers struct {
+	ctxt  *hook.Context
+	state localState
+}
+
+type localState struct {
+	// Jobs maps the name of each job installed by Ensure
+	// to the backend that it was installed with.
+	Jobs map[string]string
+}
+
+// Register registers the timers with the given registry.
+// All the jobs installed with Ensure are removed when the
+// stop hook runs.
+func (t *Timers) Register(r *hook.Registry) {
+	r.RegisterContext(t.setContext, &t.state)
+	r.RegisterHook("stop", t.stopHook)
+}
+
+func (t *Timers) setContext(ctxt *hook.Context) error {
+	t.ctxt = ctxt
+	return nil
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Ensure installs a job with the given name that runs the given
+// shell command as root according to the given schedule, replacing
+// any job installed earlier with the same name. It does nothing if
+// the job is already installed as specified.
+//
+// The schedule is in the five-field crontab format ("minute hour
+// day-of-month month day-of-week"), or one of @hourly, @daily,
+// @weekly, @monthly and @yearly (see ValidateSchedule).
+//
+// With systemd, the job is installed as the units $app-$name.timer
+// and $app-$name.service, where $app is the name of the charm's
+// application; otherwise it is installed as /etc/cron.d/$app-$name.
+func (t *Timers) Ensure(name, schedule, command string) error {
+	if !validName.MatchString(name) {
+		return errgo.Newf("invalid job name %q", name)
+	}
+	calendar, err := parseSchedule(schedule)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if strings.TrimSpace(command) == "" {
+		return errgo.New("empty command")
+	}
+	if strings.ContainsAny(command, "\n\r\x00") {
+		return errgo.Newf("invalid command %q", command)
+	}
+	backend := backendCron
+	if hasSystemd() {
+		backend = backendSystemd
+	}
+	if old, ok := t.state.Jobs[name]; ok && old != backend {
+		if err := t.Remove(name); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	unit := t.unitName(name)
+	if backend == backendSystemd {
+		err = t.ensureSystemd(unit, calendar, command)
+	} else {
+		err = t.ensureCron(unit, schedule, command)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot install job %q", name)
+	}
+	if t.state.Jobs == nil {
+		t.state.Jobs = make(map[string]string)
+	}
+	t.state.Jobs[name] = backend
+	return nil
+}
+
+// Remove removes the job with the given name,
+// if it has been installed.
+func (t *Timers) Remove(name string) error {
+	backend, ok := t.state.Jobs[name]
+	if !ok {
+		return nil
+	}
+	unit := t.unitName(name)
+	var err error
+	if backend == backendSystemd {
+		err = removeSystemd(unit)
+	} else {
+		err = removeFile(filepath.Join(cronDir, unit))
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot remove job %q", name)
+	}
+	delete(t.state.Jobs, name)
+	return nil
+}
+
+func (t *Timers) stopHook() error {
+	names := make([]string, 0, len(t.state.Jobs))
+	for name := range t.state.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.ctxt.Logf("removing job %q", name)
+		if err := t.Remove(name); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// unitName returns the name of the units or
+// cron entry for the job with the given name.
+func (t *Timers) unitName(name string) string {
+	app := strings.SplitN(string(t.ctxt.Unit), "/", 2)[0]
+	return app + "-" + name
+}
+
+func (t *Timers) ensureSystemd(unit, calendar, command string) error {
+	header := fmt.Sprintf("# Generated by the %s charm; do not edit.\n", t.ctxt.Unit)
+	service := header + fmt.Sprintf(`[Unit]
+Description=%s job
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -c %s
+`, unit, systemdQuote(command))
+	timer := header + fmt.Sprintf(`[Unit]
+Description=%s timer
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, unit, calendar)
+	changed := false
+	for _, f := range []struct {
+		path    string
+		content string
+	}{
+		{filepath.Join(systemdDir, unit+".service"), service},
+		{filepath.Join(systemdDir, unit+".timer"), timer},
+	} {
+		written, err := hook.WriteFileAtomic(f.path, []byte(f.content), 0644)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		changed = changed || written
+	}
+	if !changed {
+		return nil
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := systemctl("enable", unit+".timer"); err != nil {
+		return errgo.Mask(err)
+	}
+	// Restart the timer so that a changed schedule
+	// takes effect immediately.
+	return errgo.Mask(systemctl("restart", unit+".timer"))
+}
+
+func removeSystemd(unit string) error {
+	timerPath := filepath.Join(systemdDir, unit+".timer")
+	if _, err := os.Stat(timerPath); err == nil {
+		if err := systemctl("disable", "--now", unit+".timer"); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, path := range []string{timerPath, filepath.Join(systemdDir, unit+".service")} {
+		if err := removeFile(path); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return errgo.Mask(systemctl("daemon-reload"))
+}
+
+func (t *Timers) ensureCron(unit, schedule, command string) error {
+	// An unescaped % in a crontab command is
+	// treated as a newline.
+	command = strings.Replace(command, "%", `\%`, -1)
+	entry := fmt.Sprintf("# Generated by the %s charm; do not edit.\nSHELL=/bin/sh\n%s root %s\n", t.ctxt.Unit, schedule, command)
+	_, err := hook.WriteFileAtomic(filepath.Join(cronDir, unit), []byte(entry), 0644)
+	return errgo.Mask(err)
+}
+
+// systemdQuote quotes s so that systemd reads it
+// as a single word with no specifiers expanded.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, `%`, `%%`, -1)
+	return `"` + s + `"`
+}
+
+// systemctl runs systemctl with the given arguments.
+func systemctl(args ...string) error {
+	if out, err := execCommand("systemctl", args...); err != nil {
+		return errgo.Newf("systemctl %s failed: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// removeFile removes the file at path
+// if it exists.
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// ValidateSchedule checks that the given schedule is valid. A
+// schedule holds five space-separated fields, as in a crontab:
+// minute (0-59), hour (0-23), day of month (1-31), month (1-12)
+// and day of week (0-7, where both 0 and 7 mean Sunday). Each field
+// is "*", "*/step", or a comma-separated list of values and
+// ranges such as "1-5". The day of month and day of week cannot
+// both be restricted, because cron and systemd interpret that
+// differently. A schedule may also be one of @hourly, @daily,
+// @weekly, @monthly and @yearly.
+func ValidateSchedule(schedule string) error {
+	_, err := parseSchedule(schedule)
+	return errgo.Mask(err)
+}
+
+// macros maps the schedule macros to their
+// systemd calendar equivalents.
+var macros = map[string]string{
+	"@hourly":  "hourly",
+	"@daily":   "daily",
+	"@weekly":  "weekly",
+	"@monthly": "monthly",
+	"@yearly":  "yearly",
+}
+
+// weekdays holds the systemd names of the
+// days of the week, starting with Sunday.
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// scheduleField describes a field of a schedule.
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var scheduleFields = []scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses the given schedule (see ValidateSchedule)
+// and returns the equivalent systemd calendar expression.
+func parseSchedule(schedule string) (string, error) {
+	if strings.HasPrefix(schedule, "@") {
+		if calendar, ok := macros[schedule]; ok {
+			return calendar, nil
+		}
+		return "", errgo.Newf("invalid schedule %q: unknown macro", schedule)
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != len(scheduleFields) {
+		return "", errgo.Newf("invalid schedule %q: expected %d fields, found %d", schedule, len(scheduleFields), len(fields))
+	}
+	if fields[2] != "*" && fields[4] != "*" {
+		return "", errgo.Newf("invalid schedule %q: day of month and day of week cannot both be restricted", schedule)
+	}
+	var cal [5]string
+	for i, f := range scheduleFields {
+		v, err := f.parse(fields[i])
+		if err != nil {
+			return "", errgo.Notef(err, "invalid schedule %q", schedule)
+		}
+		cal[i] = v
+	}
+	calendar := fmt.Sprintf("*-%s-%s %s:%s:00", cal[3], cal[2], cal[1], cal[0])
+	if cal[4] != "*" {
+		calendar = cal[4] + " " + calendar
+	}
+	return calendar, nil
+}
+
+// parse parses the given value of the field and returns its
+// systemd calendar equivalent.
+func (f scheduleField) parse(s string) (string, error) {
+	if s == "*" {
+		return "*", nil
+	}
+	if strings.HasPrefix(s, "*/") {
+		if f.name == "day of week" {
+			return "", errgo.Newf("step not allowed in %s", f.name)
+		}
+		step, err := strconv.Atoi(s[2:])
+		if err != nil || step < 1 || step > f.max {
+			return "", errgo.Newf("invalid %s step %q", f.name, s[2:])
+		}
+		return fmt.Sprintf("%d/%d", f.min, step), nil
+	}
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		a, err := f.value(from)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		b, err := f.value(to)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		if a > b {
+			return "", errgo.Newf("invalid %s range %q", f.name, item)
+		}
+		switch {
+		case f.name == "day of week":
+			for d := a; d <= b; d++ {
+				if day := weekdays[d%7]; !contains(items, day) {
+					items = append(items, day)
+				}
+			}
+		case a == b:
+			items = append(items, strconv.Itoa(a))
+		default:
+			items = append(items, fmt.Sprintf("%d..%d", a, b))
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+// value parses a single value of the field.
+func (f scheduleField) value(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, errgo.Newf("invalid %s %q", f.name, s)
+	}
+	return n, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, s1 := range ss {
+		if s1 == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package timer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/charmbits/timer"
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type suite struct {
+	jujutesting.CleanupSuite
+	systemdDir string
+	cronDir    string
+	systemd    bool
+	commands   []string
+}
+
+var _ = gc.Suite(&suite{})
+
+func (s *suite) SetUpTest(c *gc.C) {
+	s.CleanupSuite.SetUpTest(c)
+	s.systemdDir = c.MkDir()
+	s.cronDir = c.MkDir()
+	s.systemd = true
+	s.commands = nil
+	s.PatchValue(timer.SystemdDir, s.systemdDir)
+	s.PatchValue(timer.CronDir, s.cronDir)
+	s.PatchValue(timer.HasSystemd, func() bool {
+		return s.systemd
+	})
+	s.PatchValue(timer.ExecCommand, func(name string, args ...string) ([]byte, error) {
+		s.commands = append(s.commands, name+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+}
+
+// newRunner returns a runner that registers t and
+// runs f in the config-changed hook.
+func newRunner(c *gc.C, t *timer.Timers, f func() error) *hooktest.Runner {
+	return &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			t.Register(r.Clone("timer"))
+			r.RegisterHook("config-changed", f)
+		},
+	}
+}
+
+func (s *suite) TestEnsureSystemd(c *gc.C) {
+	var t timer.Timers
+	schedule := "30 2 * * *"
+	runner := newRunner(c, &t, func() error {
+		return t.Ensure("backup", schedule, `/usr/bin/backup --to "/srv/backup" 100%`)
+	})
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+
+	data, err := ioutil.ReadFile(filepath.Join(s.systemdDir, "someunit-backup.service"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `# Generated by the someunit/0 charm; do not edit.
+[Unit]
+Description=someunit-backup job
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -c "/usr/bin/backup --to \"/srv/backup\" 100%%"
+`)
+	data, err = ioutil.ReadFile(filepath.Join(s.systemdDir, "someunit-backup.timer"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `# Generated by the someunit/0 charm; do not edit.
+[Unit]
+Description=someunit-backup timer
+
+[Timer]
+OnCalendar=*-*-* 2:30:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`)
+	c.Assert(s.commands, jc.DeepEquals, []string{
+		"systemctl daemon-reload",
+		"systemctl enable someunit-backup.timer",
+		"systemctl restart someunit-backup.timer",
+	})
+
+	// Ensuring the same job again does nothing.
+	s.commands = nil
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.commands, gc.HasLen, 0)
+
+	// Changing the schedule rewrites the timer.
+	schedule = "@daily"
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(s.systemdDir, "someunit-backup.timer"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), jc.Contains, "\nOnCalendar=daily\n")
+	c.Assert(s.commands, jc.DeepEquals, []string{
+		"systemctl daemon-reload",
+		"systemctl enable someunit-backup.timer",
+		"systemctl restart someunit-backup.timer",
+	})
+
+	// The job is removed when the stop hook runs.
+	s.commands = nil
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.commands, jc.DeepEquals, []string{
+		"systemctl disable --now someunit-backup.timer",
+		"systemctl daemon-reload",
+	})
+	assertNoFiles(c, s.systemdDir)
+
+	// Nothing is left to remove.
+	s.commands = nil
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.commands, gc.HasLen, 0)
+}
+
+func (s *suite) TestEnsureCron(c *gc.C) {
+	s.systemd = false
+	var t timer.Timers
+	runner := newRunner(c, &t, func() error {
+		return t.Ensure("report", "*/15 * * * 1-5", "date +%s >> /var/log/report")
+	})
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	path := filepath.Join(s.cronDir, "someunit-report")
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `# Generated by the someunit/0 charm; do not edit.
+SHELL=/bin/sh
+*/15 * * * 1-5 root date +\%s >> /var/log/report
+`)
+	info, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+
+	// Ensuring the same job again does not rewrite the file.
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	info1, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info1.ModTime(), gc.Equals, info.ModTime())
+
+	err = runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	assertNoFiles(c, s.cronDir)
+	c.Assert(s.commands, gc.HasLen, 0)
+}
+
+func (s *suite) TestRemove(c *gc.C) {
+	var t timer.Timers
+	runner := newRunner(c, &t, func() error {
+		if err := t.Ensure("one", "@hourly", "true"); err != nil {
+			return err
+		}
+		if err := t.Ensure("two", "@hourly", "true"); err != nil {
+			return err
+		}
+		return t.Remove("one")
+	})
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	files, err := ioutil.ReadDir(s.systemdDir)
+	c.Assert(err, gc.IsNil)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	c.Assert(names, jc.DeepEquals, []string{"someunit-two.service", "someunit-two.timer"})
+}
+
+var ensureErrorTests = []struct {
+	name        string
+	schedule    string
+	command     string
+	expectError string
+}{{
+	name:        "Backup",
+	schedule:    "@daily",
+	command:     "true",
+	expectError: `invalid job name "Backup"`,
+}, {
+	name:        "backup",
+	schedule:    "* * *",
+	command:     "true",
+	expectError: `invalid schedule "\* \* \*": expected 5 fields, found 3`,
+}, {
+	name:        "backup",
+	schedule:    "@daily",
+	command:     " ",
+	expectError: `empty command`,
+}, {
+	name:        "backup",
+	schedule:    "@daily",
+	command:     "true\nfalse",
+	expectError: `invalid command "true\\nfalse"`,
+}}
+
+func (s *suite) TestEnsureError(c *gc.C) {
+	for i, test := range ensureErrorTests {
+		c.Logf("test %d: %q %q %q", i, test.name, test.schedule, test.command)
+		var t timer.Timers
+		runner := newRunner(c, &t, func() error {
+			return t.Ensure(test.name, test.schedule, test.command)
+		})
+		err := runner.RunHook("config-changed", "", "")
+		c.Check(err, gc.ErrorMatches, test.expectError)
+	}
+	assertNoFiles(c, s.systemdDir)
+	c.Assert(s.commands, gc.HasLen, 0)
+}
+
+var parseScheduleTests = []struct {
+	schedule    string
+	expect      string
+	expectError string
+}{{
+	schedule: "* * * * *",
+	expect:   "*-*-* *:*:00",
+}, {
+	schedule: "0 */6 * * *",
+	expect:   "*-*-* 0/6:0:00",
+}, {
+	schedule: "5,35 8-17 * * 1-5",
+	expect:   "Mon,Tue,Wed,Thu,Fri *-*-* 8..17:5,35:00",
+}, {
+	schedule: "0 0 1 */3 *",
+	expect:   "*-1/3-1 0:0:00",
+}, {
+	schedule: "0 12 * * 0,6-7",
+	expect:   "Sun,Sat *-*-* 12:0:00",
+}, {
+	schedule: "@weekly",
+	expect:   "weekly",
+}, {
+	schedule:    "@reboot",
+	expectError: `invalid schedule "@reboot": unknown macro`,
+}, {
+	schedule:    "60 * * * *",
+	expectError: `invalid schedule "60 \* \* \* \*": invalid minute "60"`,
+}, {
+	schedule:    "0 5-2 * * *",
+	expectError: `invalid schedule "0 5-2 \* \* \*": invalid hour range "5-2"`,
+}, {
+	schedule:    "*/0 * * * *",
+	expectError: `invalid schedule "\*/0 \* \* \* \*": invalid minute step "0"`,
+}, {
+	schedule:    "0 0 * * */2",
+	expectError: `invalid schedule "0 0 \* \* \*/2": step not allowed in day of week`,
+}, {
+	schedule:    "0 0 1 * 1",
+	expectError: `invalid schedule "0 0 1 \* 1": day of month and day of week cannot both be restricted`,
+}, {
+	schedule:    "0 0 * jan *",
+	expectError: `invalid schedule "0 0 \* jan \*": invalid month "jan"`,
+}}
+
+func (s *suite) TestParseSchedule(c *gc.C) {
+	for i, test := range parseScheduleTests {
+		c.Logf("test %d: %q", i, test.schedule)
+		calendar, err := timer.ParseSchedule(test.schedule)
+		if test.expectError != "" {
+			c.Check(err, gc.ErrorMatches, test.expectError)
+			c.Check(timer.ValidateSchedule(test.schedule), gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Check(err, gc.IsNil)
+		c.Check(calendar, gc.Equals, test.expect)
+		c.Check(timer.ValidateSchedule(test.schedule), gc.IsNil)
+	}
+}
+
+// assertNoFiles asserts that dir is empty.
+func assertNoFiles(c *gc.C, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 0)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/utils/fs"
+	"gopkg.in/errgo.v1"
+)
+
+// hookBackupSuffix holds the suffix added to the name
+// of a hook to make the name of its latest backup.
+const hookBackupSuffix = ".gocharm.bak"
+
+// maxHookBackups holds the number of backups kept for
+// each hook. Older backups are discarded.
+const maxHookBackups = 3
+
+// backupHooks arranges for backups to be kept of the hooks in the
+// charm directory dir that would be removed or replaced with different
+// contents when the charm built in genDir is copied there. The backups
+// are added to the hooks directory in genDir, so that they are copied
+// along with the rest of the charm.
+//
+// The latest backup of a hook named name is held in
+// name.gocharm.bak; when that is backed up again, the
+// earlier backups are rotated to name.gocharm.bak.1,
+// name.gocharm.bak.2 and so on, up to maxHookBackups
+// backups in all. Existing backups of hooks that are
+// not backed up again are kept as they are.
+func backupHooks(dir, genDir string) error {
+	hookDir := filepath.Join(dir, "hooks")
+	genHookDir := filepath.Join(genDir, "hooks")
+	names, err := readDirNames(hookDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	// backups holds the existing backups that
+	// have not yet been copied to genHookDir.
+	backups := make(map[string]bool)
+	for _, name := range names {
+		if isHookBackup(name) {
+			backups[name] = true
+		}
+	}
+	for _, name := range names {
+		if isHookBackup(name) {
+			continue
+		}
+		path := filepath.Join(hookDir, name)
+		cur, _, err := readFileIfPresent(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		gen, genOK, err := readFileIfPresent(filepath.Join(genHookDir, name))
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if genOK && gen == cur {
+			continue
+		}
+		delete(backups, hookBackupName(name, maxHookBackups-1))
+		for i := maxHookBackups - 1; i > 0; i-- {
+			old := hookBackupName(name, i-1)
+			if !backups[old] {
+				continue
+			}
+			delete(backups, old)
+			if err := copyFile(filepath.Join(hookDir, old), filepath.Join(genHookDir, hookBackupName(name, i))); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		backupPath := filepath.Join(genHookDir, hookBackupName(name, 0))
+		if err := copyFile(path, backupPath); err != nil {
+			return errgo.Mask(err)
+		}
+		if *verbose {
+			log.Printf("backing up hook %s to %s", path, filepath.Join(hookDir, filepath.Base(backupPath)))
+		}
+	}
+	for name := range backups {
+		if err := copyFile(filepath.Join(hookDir, name), filepath.Join(genHookDir, name)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// hookBackupName returns the name of the nth most recent
+// backup of the hook with the given file name, counting
+// from zero.
+func hookBackupName(name string, n int) string {
+	if n == 0 {
+		return name + hookBackupSuffix
+	}
+	return name + hookBackupSuffix + "." + strconv.Itoa(n)
+}
+
+// isHookBackup reports whether the file with the given
+// name in the hooks directory is a backup of a hook.
+func isHookBackup(name string) bool {
+	return strings.Contains(name, hookBackupSuffix)
+}
+
+// copyFile copies the file at src to dst, preserving its mode
+// and creating the directory that holds dst if needed.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := fs.Copy(src, dst); err != nil {
+		return errgo.Notef(err, "cannot copy %s", src)
+	}
+	return nil
+}
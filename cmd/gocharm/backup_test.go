@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_backupHooks(t *testing.T) {
+	dir := writeTestHooks(t, "a charm", "stop")
+	hookDir := filepath.Join(dir, "hooks")
+	if err := os.WriteFile(filepath.Join(hookDir, "stop"), []byte("#!/bin/sh\necho customized\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(hookDir, "stop"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	genDir := writeTestHooks(t, "a charm", "upgrade-charm")
+	if err := backupHooks(dir, genDir); err != nil {
+		t.Fatalf("cannot back up hooks: %v", err)
+	}
+	// The unchanged install and start stubs are not backed up.
+	got := readTestHooks(t, filepath.Join(genDir, "hooks"))
+	want := map[string]string{
+		"install":                 string((&charmBuilder{}).hookStub("install")),
+		"start":                   string((&charmBuilder{}).hookStub("start")),
+		"upgrade-charm":           string((&charmBuilder{}).hookStub("upgrade-charm")),
+		"stop" + hookBackupSuffix: "#!/bin/sh\necho customized\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected hooks; got %q want %q", got, want)
+	}
+	info, err := os.Stat(filepath.Join(genDir, "hooks", "stop"+hookBackupSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("backup has mode %v; want %v", info.Mode().Perm(), os.FileMode(0700))
+	}
+}
+
+func Test_backupHooksRotates(t *testing.T) {
+	dir := writeTestHooks(t, "a charm")
+	hookDir := filepath.Join(dir, "hooks")
+	for i := 0; i < maxHookBackups+1; i++ {
+		if err := os.WriteFile(filepath.Join(hookDir, "start"), []byte(fmt.Sprintf("version %d\n", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		genDir := writeTestHooks(t, "a charm")
+		if err := backupHooks(dir, genDir); err != nil {
+			t.Fatalf("cannot back up hooks: %v", err)
+		}
+		// Replace the hooks as gocharm would.
+		if err := os.RemoveAll(hookDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(genDir, "hooks"), hookDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readTestHooks(t, hookDir)
+	want := map[string]string{
+		"install":                         string((&charmBuilder{}).hookStub("install")),
+		"start":                           string((&charmBuilder{}).hookStub("start")),
+		"start" + hookBackupSuffix:        "version 3\n",
+		"start" + hookBackupSuffix + ".1": "version 2\n",
+		"start" + hookBackupSuffix + ".2": "version 1\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected hooks; got %q want %q", got, want)
+	}
+}
+
+// readTestHooks returns the contents of all
+// the files in dir, keyed by file name.
+func readTestHooks(t *testing.T, dir string) map[string]string {
+	names, err := readDirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(data)
+	}
+	return files
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// manifestName holds the name of the file in the charm's bin
+// directory that recorded the inputs to the build of the runhook
+// binary in earlier versions of gocharm. Manifests are now kept
+// in the user's cache directory (see manifestPath) so that they
+// are not shipped with the charm, but old ones are still cleaned up.
+const manifestName = "runhook.manifest"
+
+// userCacheDir returns the directory that build manifests are kept
+// in. It is a variable so that it can be replaced in tests.
+var userCacheDir = os.UserCacheDir
+
+// buildManifest records the inputs to a build of the runhook binary,
+// so that the binary need not be built again when they are unchanged.
+type buildManifest struct {
+	// Settings holds the build settings that affect the
+	// binary, such as the target platform and the arguments
+	// to the go command.
+	Settings []string
+
+	// Sources holds the SHA-256 hash of each source file in the
+	// charm's module and in any local directories that its go.mod
+	// file replaces modules with, keyed by slash-separated path
+	// relative to the module's root directory.
+	Sources map[string]string
+
+	// Binary holds the SHA-256 hash of the runhook
+	// binary built from these inputs.
+	Binary string
+
+	// Info holds the charm information found by
+	// inspecting the charm's registered hooks.
+	Info *charmInfo
+}
+
+// newBuildManifest returns a manifest, without any charm
+// information, for building the charm with the given import path
+// from the module in moduleDir with the current flags.
+func newBuildManifest(moduleDir, importPath string) (*buildManifest, error) {
+	sources, err := sourceHashes(moduleDir)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot hash charm sources")
+	}
+	// A replace directive pointing to a local directory, as used
+	// when developing against a local copy of a dependency, brings
+	// source from outside the module into the build.
+	goMod, err := ioutil.ReadFile(filepath.Join(moduleDir, "go.mod"))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, target := range parseLocalReplaces(goMod) {
+		dir := filepath.FromSlash(target)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(moduleDir, dir)
+		}
+		hashes, err := sourceHashes(dir)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot hash sources of replacement %s", target)
+		}
+		for name, hash := range hashes {
+			rel, err := filepath.Rel(moduleDir, filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			sources[filepath.ToSlash(rel)] = hash
+		}
+	}
+	settings := []string{
+		"module=" + moduleDir,
+		"package=" + importPath,
+		"runhook=" + hashBytes(generateCode(hookMainCode, importPath)),
+		"inspect=" + hashBytes(generateCode(inspectCode, importPath)),
+	}
+	// The names of the files are not significant.
+	settings = append(settings, compileArgs("runhook.go", "runhook")...)
+	for _, e := range buildEnv(os.Environ()) {
+		for _, name := range []string{"GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS"} {
+			if strings.HasPrefix(e, name+"=") {
+				settings = append(settings, e)
+			}
+		}
+	}
+	return &buildManifest{
+		Settings: settings,
+		Sources:  sources,
+	}, nil
+}
+
+// sameInputs reports whether m and m1 record
+// the same inputs to the build.
+func (m *buildManifest) sameInputs(m1 *buildManifest) bool {
+	return reflect.DeepEqual(m.Settings, m1.Settings) && reflect.DeepEqual(m.Sources, m1.Sources)
+}
+
+// sourceHashes returns the hashes of all the files in the module
+// rooted at dir that can affect the build: the Go source files,
+// excluding tests, and the go.mod and go.sum files. Hidden
+// directories, testdata directories and nested modules are skipped.
+func sourceHashes(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		isSource := strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")
+		if !isSource && name != "go.mod" && name != "go.sum" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(rel)] = hashBytes(data)
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return hashes, nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+// manifestPath returns the path of the file that records the build
+// of the runhook binary in the charm directory dir. It is kept in the
+// user's cache directory, named after the absolute path of dir.
+func manifestPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	cacheDir, err := userCacheDir()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	return filepath.Join(cacheDir, "gocharm", "manifests", hashBytes([]byte(dir))+".json"), nil
+}
+
+// readBuildManifest reads the manifest for the charm directory dir.
+// It returns nil if there is no manifest or it cannot be parsed,
+// so that the charm is built as usual.
+func readBuildManifest(dir string) *buildManifest {
+	path, err := manifestPath(dir)
+	if err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var m buildManifest
+	if err := json.Unmarshal(data, &m); err != nil || m.Info == nil {
+		return nil
+	}
+	return &m
+}
+
+// writeBuildManifest writes m as the manifest for the charm
+// directory dir, which will hold the runhook binary built in the
+// charm directory buildDir. The hash of the binary is recorded so
+// that a manifest that does not describe the binary found in dir,
+// for example because the charm was not copied there, is ignored.
+func writeBuildManifest(dir, buildDir string, m *buildManifest) error {
+	hash, err := hashFile(filepath.Join(buildDir, "bin", exeName("runhook")))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	m.Binary = hash
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	path, err := manifestPath(dir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// reuseBuild checks whether the charm directory dir holds a runhook
+// binary built from the same inputs as recorded in m. If so, it
+// copies the binary and the source it was built from to the charm
+// directory genDir and returns the charm information recorded with
+// it. Otherwise it returns nil.
+func reuseBuild(dir, genDir string, m *buildManifest) (*charmInfo, error) {
+	old := readBuildManifest(dir)
+	if old == nil || !old.sameInputs(m) {
+		return nil, nil
+	}
+	exe := filepath.Join("bin", exeName("runhook"))
+	files := []string{exe}
+	for _, name := range generatedSourceFiles {
+		files = append(files, filepath.Join("src", "runhook", name))
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, errgo.Mask(err)
+		}
+	}
+	hash, err := hashFile(filepath.Join(dir, exe))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if hash != old.Binary {
+		return nil, nil
+	}
+	for _, file := range files {
+		if err := copyFile(filepath.Join(dir, file), filepath.Join(genDir, file)); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return old.Info, nil
+}
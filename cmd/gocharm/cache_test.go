@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTestFiles writes the given files, keyed by slash-separated
+// path, to dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_sourceHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"go.mod":           "module example.com/x\n",
+		"go.sum":           "",
+		"charm.go":         "package x\n",
+		"charm_test.go":    "package x\n",
+		"README.md":        "readme",
+		"sub/sub.go":       "package sub\n",
+		"testdata/data.go": "package data\n",
+		".git/hook.go":     "package git\n",
+		"other/go.mod":     "module example.com/other\n",
+		"other/other.go":   "package other\n",
+	})
+	hashes, err := sourceHashes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"charm.go", "go.mod", "go.sum", "sub/sub.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected files hashed; got %q want %q", names, want)
+	}
+	if hashes["go.sum"] != hashBytes(nil) {
+		t.Errorf("unexpected hash %q for empty file", hashes["go.sum"])
+	}
+}
+
+// useTestCacheDir arranges for build manifests
+// to be kept in a temporary directory.
+func useTestCacheDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	old := userCacheDir
+	userCacheDir = func() (string, error) {
+		return cacheDir, nil
+	}
+	t.Cleanup(func() {
+		userCacheDir = old
+	})
+}
+
+func Test_reuseBuild(t *testing.T) {
+	defer func(old string) { *buildTags = old }(*buildTags)
+	useTestCacheDir(t)
+	moduleDir := t.TempDir()
+	writeTestFiles(t, moduleDir, map[string]string{
+		"go.mod":   "module example.com/x\n",
+		"charm.go": "package x\n",
+	})
+	newManifest := func() *buildManifest {
+		m, err := newBuildManifest(moduleDir, "example.com/x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	// Make a charm directory as left by a previous build.
+	prevDir := t.TempDir()
+	writeTestFiles(t, prevDir, map[string]string{
+		"bin/runhook":            "binary",
+		"src/runhook/runhook.go": "package main\n",
+	})
+	m := newManifest()
+	m.Info = &charmInfo{
+		Hooks: []string{"install", "start"},
+	}
+	if err := writeBuildManifest(prevDir, prevDir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	genDir := t.TempDir()
+	info, err := reuseBuild(prevDir, genDir, newManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, m.Info) {
+		t.Fatalf("unexpected charm info; got %#v want %#v", info, m.Info)
+	}
+	for _, name := range []string{"bin/runhook", "src/runhook/runhook.go"} {
+		if _, err := os.Stat(filepath.Join(genDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("file not copied: %v", err)
+		}
+	}
+	// The manifest is not shipped with the charm.
+	if _, err := os.Stat(filepath.Join(genDir, "bin", manifestName)); !os.IsNotExist(err) {
+		t.Errorf("manifest found in charm directory (error %v)", err)
+	}
+
+	// Changing the build tags invalidates the build.
+	*buildTags = "integration"
+	info, err = reuseBuild(prevDir, t.TempDir(), newManifest())
+	if err != nil || info != nil {
+		t.Errorf("build reused after tags changed (info %v, error %v)", info, err)
+	}
+	*buildTags = ""
+
+	// Changing a source file invalidates the build.
+	writeTestFiles(t, moduleDir, map[string]string{
+		"charm.go": "package x\n\nvar x int\n",
+	})
+	info, err = reuseBuild(prevDir, t.TempDir(), newManifest())
+	if err != nil || info != nil {
+		t.Errorf("build reused after source changed (info %v, error %v)", info, err)
+	}
+}
+
+func Test_reuseBuildChangedBinary(t *testing.T) {
+	useTestCacheDir(t)
+	moduleDir := t.TempDir()
+	writeTestFiles(t, moduleDir, map[string]string{
+		"go.mod": "module example.com/x\n",
+	})
+	m, err := newBuildManifest(moduleDir, "example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Info = &charmInfo{}
+	prevDir := t.TempDir()
+	writeTestFiles(t, prevDir, map[string]string{
+		"bin/runhook":            "binary",
+		"src/runhook/runhook.go": "package main\n",
+	})
+	if err := writeBuildManifest(prevDir, prevDir, m); err != nil {
+		t.Fatal(err)
+	}
+	// The binary in the charm directory is not
+	// the one that the manifest describes.
+	writeTestFiles(t, prevDir, map[string]string{
+		"bin/runhook": "other binary",
+	})
+	info, err := reuseBuild(prevDir, t.TempDir(), m)
+	if err != nil || info != nil {
+		t.Errorf("build reused with different binary (info %v, error %v)", info, err)
+	}
+}
+
+func Test_reuseBuildLocalReplace(t *testing.T) {
+	useTestCacheDir(t)
+	root := t.TempDir()
+	moduleDir := filepath.Join(root, "charm")
+	writeTestFiles(t, root, map[string]string{
+		"charm/go.mod":    "module example.com/x\n\nreplace example.com/dep => ../dep\n",
+		"charm/charm.go":  "package x\n",
+		"dep/go.mod":      "module example.com/dep\n",
+		"dep/dep.go":      "package dep\n",
+		"dep/dep_test.go": "package dep\n",
+		"other/other.go":  "package other\n",
+	})
+	m, err := newBuildManifest(moduleDir, "example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range m.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"../dep/dep.go", "../dep/go.mod", "charm.go", "go.mod"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected files hashed; got %q want %q", names, want)
+	}
+	m.Info = &charmInfo{}
+	prevDir := t.TempDir()
+	writeTestFiles(t, prevDir, map[string]string{
+		"bin/runhook":            "binary",
+		"src/runhook/runhook.go": "package main\n",
+	})
+	if err := writeBuildManifest(prevDir, prevDir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the source of the replacement
+	// module invalidates the build.
+	writeTestFiles(t, root, map[string]string{
+		"dep/dep.go": "package dep\n\nvar x int\n",
+	})
+	m, err = newBuildManifest(moduleDir, "example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := reuseBuild(prevDir, t.TempDir(), m)
+	if err != nil || info != nil {
+		t.Errorf("build reused after replacement source changed (info %v, error %v)", info, err)
+	}
+}
+
+func Test_reuseBuildMissingBinary(t *testing.T) {
+	useTestCacheDir(t)
+	moduleDir := t.TempDir()
+	writeTestFiles(t, moduleDir, map[string]string{
+		"go.mod": "module example.com/x\n",
+	})
+	m, err := newBuildManifest(moduleDir, "example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Info = &charmInfo{}
+	prevDir := t.TempDir()
+	writeTestFiles(t, prevDir, map[string]string{
+		"bin/runhook": "binary",
+	})
+	if err := writeBuildManifest(prevDir, prevDir, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(prevDir, "bin", "runhook")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := reuseBuild(prevDir, t.TempDir(), m)
+	if err != nil || info != nil {
+		t.Errorf("build reused without binary (info %v, error %v)", info, err)
+	}
+}
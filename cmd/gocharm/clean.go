@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+)
+
+// cleanMain implements the clean subcommand, which removes the
+// files that gocharm generated from the given charm directory.
+func cleanMain(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	fs.BoolVar(verbose, "v", false, "print the files that are removed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gocharm clean [flags] dir\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+	if err := cleanCharmDir(fs.Arg(0)); err != nil {
+		return errgo.Notef(err, "cannot clean %s", fs.Arg(0))
+	}
+	return nil
+}
+
+// generatedSourceFiles holds the files that gocharm generates in the
+// src/runhook directory of the charm when building the runhook binary.
+var generatedSourceFiles = []string{"runhook.go"}
+
+// legacySourceFiles holds the files that earlier versions of gocharm
+// generated in the src/runhook directory, which built the runhook
+// binary in a module of its own there.
+var legacySourceFiles = []string{"go.mod", "go.sum"}
+
+// cleanCharmDir removes the files that gocharm generated from the
+// charm directory dir: hook stubs and the dispatch script that have
+// not been changed since they were generated, the runhook binary and
+// its source, any build manifest left in the charm by an earlier
+// version of gocharm, and the autogenerated metadata.yaml, config.yaml
+// and actions.yaml files. Any other files are left alone,
+// as are directories that are not empty once the generated files have
+// been removed.
+func cleanCharmDir(dir string) error {
+	hookDir := filepath.Join(dir, "hooks")
+	names, err := readDirNames(hookDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, name := range names {
+		path := filepath.Join(hookDir, name)
+		data, _, err := readFileIfPresent(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !isHookStub(name, data) {
+			if *verbose {
+				log.Printf("keeping customized hook %s", path)
+			}
+			continue
+		}
+		if err := removeGenerated(path); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if data, ok, err := readFileIfPresent(filepath.Join(dir, "dispatch")); err != nil {
+		return errgo.Mask(err)
+	} else if ok && data == dispatchScript {
+		if err := removeGenerated(filepath.Join(dir, "dispatch")); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range []string{"runhook", "runhook.exe", manifestName} {
+		if err := removeGenerated(filepath.Join(dir, "bin", name)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	srcDir := filepath.Join(dir, "src", "runhook")
+	for _, name := range append(append([]string(nil), generatedSourceFiles...), legacySourceFiles...) {
+		if err := removeGenerated(filepath.Join(srcDir, name)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range []string{"metadata.yaml", "config.yaml", "actions.yaml"} {
+		path := filepath.Join(dir, name)
+		if !autogenerated(path) {
+			continue
+		}
+		if err := removeGenerated(path); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, d := range []string{hookDir, filepath.Join(dir, "bin"), srcDir, filepath.Dir(srcDir)} {
+		if err := removeIfEmpty(d); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// removeGenerated removes the generated file at path,
+// if it exists.
+func removeGenerated(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if *verbose {
+		log.Printf("removed %s", path)
+	}
+	return nil
+}
+
+// removeIfEmpty removes the directory dir if it
+// exists and is empty.
+func removeIfEmpty(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errgo.Mask(err)
+	}
+	if len(infos) > 0 {
+		return nil
+	}
+	return removeGenerated(dir)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_cleanCharmDir(t *testing.T) {
+	defer func(old string) { *goos = old }(*goos)
+	dir := writeTestHooks(t, "a charm", "stop", "upgrade-charm")
+	*goos = "windows"
+	// Write a Windows stub too, as left by an earlier build.
+	if err := os.WriteFile(filepath.Join(dir, "hooks", "stop.cmd"), (&charmBuilder{}).hookStub("stop"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	*goos = "linux"
+	files := map[string]string{
+		"hooks/upgrade-charm":    "#!/bin/sh\necho customized\n",
+		"bin/runhook":            "binary",
+		"bin/helper":             "user binary",
+		"src/runhook/runhook.go": "package main\n",
+		"src/runhook/go.mod":     "module x\n",
+		"dispatch":               dispatchScript,
+		"README.md":              "readme",
+		"revision":               "3",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cleanCharmDir(dir); err != nil {
+		t.Fatalf("cannot clean: %v", err)
+	}
+	var remaining []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			remaining = append(remaining, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(remaining)
+	want := []string{"README.md", "bin/helper", "hooks/upgrade-charm", "revision"}
+	if !reflect.DeepEqual(remaining, want) {
+		t.Errorf("unexpected files after clean; got %q want %q", remaining, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src")); !os.IsNotExist(err) {
+		t.Errorf("empty src directory not removed: %v", err)
+	}
+}
+
+func Test_cleanCharmDirKeepsCustomizedYAML(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("options: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanCharmDir(dir); err != nil {
+		t.Fatalf("cannot clean: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Errorf("customized config.yaml removed: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/charm/v9"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultsMain implements the defaults subcommand, which
+// merges configuration default overrides into the config.yaml
+// of a built charm.
+func defaultsMain(args []string) error {
+	fs := flag.NewFlagSet("defaults", flag.ExitOnError)
+	from := fs.String("from", "", "YAML file holding the configuration defaults to apply")
+	fs.StringVar(repo, "repo", "", "charm repo directory (defaults to $JUJU_REPOSITORY)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gocharm defaults -from file [flags] [package]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if *from == "" || fs.NArg() > 1 {
+		fs.Usage()
+	}
+	pkgPath := "."
+	if fs.NArg() == 1 {
+		pkgPath = fs.Arg(0)
+	}
+	if err := setRepo(); err != nil {
+		return errgo.Mask(err)
+	}
+	dir, err := packageDir(pkgPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	configPath := filepath.Join(*repo, filepath.Base(dir), "config.yaml")
+	if err := applyDefaults(configPath, *from); err != nil {
+		return errgo.Notef(err, "cannot apply defaults from %s", *from)
+	}
+	return nil
+}
+
+// applyDefaults reads configuration option values from the YAML
+// file at overridesPath and sets them as the defaults of the
+// corresponding options in the config.yaml file at configPath.
+// Each value must be valid for its option, and every key must
+// name an option declared in config.yaml.
+func applyDefaults(configPath, overridesPath string) error {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer f.Close()
+	config, err := charm.ReadConfig(f)
+	if err != nil {
+		return errgo.Notef(err, "cannot read %s", configPath)
+	}
+	data, err := ioutil.ReadFile(overridesPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var overrides charm.Settings
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return errgo.Notef(err, "cannot parse %s", overridesPath)
+	}
+	overrides, err = config.ValidateSettings(overrides)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for name, val := range overrides {
+		opt := config.Options[name]
+		opt.Default = val
+		config.Options[name] = opt
+	}
+	if err := writeYAML(configPath, config); err != nil {
+		return errgo.Notef(err, "cannot write %s", configPath)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juju/charm/v9"
+)
+
+func writeTestConfig(t *testing.T, dir string) string {
+	configPath := filepath.Join(dir, "config.yaml")
+	err := writeYAML(configPath, &charm.Config{
+		Options: map[string]charm.Option{
+			"port": {
+				Type:    "int",
+				Default: 80,
+			},
+			"hostname": {
+				Type:        "string",
+				Description: "The host name.",
+			},
+			"debug": {
+				Type:    "boolean",
+				Default: false,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func Test_applyDefaults(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeTestConfig(t, dir)
+	overridesPath := filepath.Join(dir, "overrides.yaml")
+	if err := os.WriteFile(overridesPath, []byte("port: 8080\nhostname: example.com\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyDefaults(configPath, overridesPath); err != nil {
+		t.Fatalf("cannot apply defaults: %v", err)
+	}
+	if !autogenerated(configPath) {
+		t.Errorf("config.yaml no longer marked as autogenerated")
+	}
+	f, err := os.Open(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := charm.ReadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := config.DefaultSettings()
+	if defaults["port"] != int64(8080) {
+		t.Errorf("unexpected port default %#v", defaults["port"])
+	}
+	if defaults["hostname"] != "example.com" {
+		t.Errorf("unexpected hostname default %#v", defaults["hostname"])
+	}
+	if defaults["debug"] != false {
+		t.Errorf("unexpected debug default %#v", defaults["debug"])
+	}
+	if desc := config.Options["hostname"].Description; desc != "The host name." {
+		t.Errorf("description not preserved; got %q", desc)
+	}
+}
+
+func Test_applyDefaultsUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeTestConfig(t, dir)
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overridesPath := filepath.Join(dir, "overrides.yaml")
+	if err := os.WriteFile(overridesPath, []byte("port: 8080\nnosuchoption: 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = applyDefaults(configPath, overridesPath)
+	if err == nil || !strings.Contains(err.Error(), `unknown option "nosuchoption"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	after, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("config.yaml changed after failure")
+	}
+}
+
+func Test_applyDefaultsInvalidValue(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeTestConfig(t, dir)
+	overridesPath := filepath.Join(dir, "overrides.yaml")
+	if err := os.WriteFile(overridesPath, []byte("port: eighty\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := applyDefaults(configPath, overridesPath)
+	if err == nil || !strings.Contains(err.Error(), `option "port"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/errgo.v1"
+)
+
+// diffFiles holds the generated charm files
+// compared by the diff subcommand.
+var diffFiles = []string{"metadata.yaml", "config.yaml", "actions.yaml"}
+
+// errDiffer is returned by diffMain when the generated
+// files differ from those in the charm directory.
+var errDiffer = errgo.New("generated files differ from those in the charm directory")
+
+// diffMain implements the diff subcommand, which generates
+// the charm's metadata.yaml, config.yaml and actions.yaml files and prints
+// a unified diff between them and the files in the charm
+// directory, without changing the charm directory.
+func diffMain(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(repo, "repo", "", "charm repo directory (defaults to $JUJU_REPOSITORY)")
+	fs.BoolVar(verbose, "v", false, "print information about the charm being inspected")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gocharm diff [flags] [package]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	pkgPath := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		pkgPath = fs.Arg(0)
+	default:
+		fs.Usage()
+	}
+	if err := setRepo(); err != nil {
+		return errgo.Mask(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errgo.Notef(err, "cannot get current directory")
+	}
+	pkg, err := build.Default.Import(pkgPath, cwd, 0)
+	if err != nil {
+		return errgo.Notef(err, "cannot import %q", pkgPath)
+	}
+	tempDir, err := ioutil.TempDir("", "gocharm")
+	if err != nil {
+		return errgo.Notef(err, "cannot make temporary directory")
+	}
+	defer os.RemoveAll(tempDir)
+
+	mod, err := findModule(pkg.Dir)
+	if err != nil {
+		return errgo.Notef(err, "cannot find charm's module")
+	}
+	importPath, err := mod.importPath(pkg.Dir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	info, err := registeredCharmInfo(mod, importPath, tempDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	b := &charmBuilder{
+		pkg:      pkg,
+		charmDir: filepath.Join(tempDir, "charm"),
+		tempDir:  tempDir,
+	}
+	if err := os.MkdirAll(b.charmDir, 0777); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := b.writeMetadataFiles(info); err != nil {
+		return errgo.Mask(err)
+	}
+	differ, err := diffCharmFiles(os.Stdout, filepath.Join(*repo, filepath.Base(pkg.Dir)), b.charmDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if differ {
+		return errDiffer
+	}
+	return nil
+}
+
+// diffCharmFiles writes to w a unified diff between each of the
+// diffFiles in the charm directory dir and the generated file of
+// the same name in genDir. A missing file is treated as empty.
+// It reports whether any of the files differ.
+func diffCharmFiles(w io.Writer, dir, genDir string) (bool, error) {
+	differ := false
+	for _, name := range diffFiles {
+		committed, err := readFileIfExists(filepath.Join(dir, name))
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		generated, err := readFileIfExists(filepath.Join(genDir, name))
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		if committed == generated {
+			continue
+		}
+		differ = true
+		err = difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+			A:        difflib.SplitLines(committed),
+			B:        difflib.SplitLines(generated),
+			FromFile: filepath.Join(dir, name),
+			ToFile:   name + " (generated)",
+			Context:  3,
+		})
+		if err != nil {
+			return false, errgo.Notef(err, "cannot write diff")
+		}
+	}
+	return differ, nil
+}
+
+// readFileIfExists returns the contents of the file at the
+// given path, or the empty string if it does not exist.
+func readFileIfExists(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errgo.Mask(err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juju/charm/v9"
+)
+
+// writeTestCharmFiles writes the metadata and config files for
+// a charm with the given summary and options into a new
+// directory and returns the directory.
+func writeTestCharmFiles(t *testing.T, summary string, config map[string]charm.Option) string {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	err := b.writeMetadataFiles(&charmInfo{
+		Meta: charm.Meta{
+			Summary:     summary,
+			Description: "a charm description",
+		},
+		Config: config,
+	})
+	if err != nil {
+		t.Fatalf("cannot write charm files: %v", err)
+	}
+	return b.charmDir
+}
+
+var testDiffConfig = map[string]charm.Option{
+	"port": {
+		Type:        "int",
+		Description: "The port to listen on",
+		Default:     8080,
+	},
+}
+
+func Test_diffCharmFilesNoChange(t *testing.T) {
+	dir := writeTestCharmFiles(t, "a charm", testDiffConfig)
+	genDir := writeTestCharmFiles(t, "a charm", testDiffConfig)
+	var buf bytes.Buffer
+	differ, err := diffCharmFiles(&buf, dir, genDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if differ {
+		t.Errorf("files reported as different")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected diff output:\n%s", buf.Bytes())
+	}
+}
+
+func Test_diffCharmFilesChanged(t *testing.T) {
+	dir := writeTestCharmFiles(t, "a charm", testDiffConfig)
+	genDir := writeTestCharmFiles(t, "a better charm", nil)
+	var buf bytes.Buffer
+	differ, err := diffCharmFiles(&buf, dir, genDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !differ {
+		t.Errorf("files reported as the same")
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"--- " + filepath.Join(dir, "metadata.yaml") + "\n",
+		"+++ metadata.yaml (generated)\n",
+		"\n-summary: a charm\n",
+		"\n+summary: a better charm\n",
+		// No config options are generated, so
+		// config.yaml is not written at all.
+		"--- " + filepath.Join(dir, "config.yaml") + "\n",
+		"+++ config.yaml (generated)\n",
+		"\n-    default: 8080\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q not found in diff output:\n%s", want, out)
+		}
+	}
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"github.com/juju/charm/v9"
 	"go/build"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"text/template"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/yaml.v2"
+
+	"github.com/mever/gocharm/v2/hook"
 )
 
 const (
@@ -33,6 +40,10 @@ import (
 	{{.HookPackage | printf "%q"}}
 )
 
+// gitCommit is set by gocharm -stamp-git to the git
+// commit that the charm was built from.
+var gitCommit string
+
 func main() {
 	r := hook.NewRegistry()
 	charm.RegisterHooks(r)
@@ -40,6 +51,14 @@ func main() {
 	if len(os.Args) < 2 {
 		fatalf("hook name argument required")
 	}
+	if os.Args[1] == "--version" {
+		if gitCommit == "" {
+			fmt.Println("unknown")
+		} else {
+			fmt.Println(gitCommit)
+		}
+		return
+	}
 	// TODO would /etc/init be a better place for local state?
 	ctxt, state, err := hook.NewContextFromEnvironment(r, "/var/lib/juju-localstate", os.Args[1], os.Args[2:])
 	if err != nil {
@@ -75,120 +94,211 @@ type buildCharmParams struct {
 	// tempDir holds a temporary directory to use for
 	// any temporary build artifacts.
 	tempDir string
+
+	// prevDir holds the charm directory holding the previous
+	// build of the charm, if any. Its runhook binary is reused
+	// if the inputs to the build have not changed.
+	prevDir string
 }
 
 type charmBuilder buildCharmParams
 
-func getGoModuleNameFromCurrentDir() string {
-	cmd := runCmd("", nil, "go", "list", "-m")
-	cmd.Stdout = nil
-	data, er := cmd.Output()
-	if er != nil {
-		panic("failed to get Go module name")
+// buildCharm builds the runhook executable,
+// and all the other charm pieces (hooks, metadata.yaml,
+// config.yaml). It puts the runhook source file into goFile
+// and the runhook executable into exe.
+func buildCharm(p buildCharmParams) error {
+	b := (*charmBuilder)(&p)
+
+	mod, err := findModule(b.pkg.Dir)
+	if err != nil {
+		return errgo.Notef(err, "cannot find charm's module")
+	}
+	importPath, err := mod.importPath(b.pkg.Dir)
+	if err != nil {
+		return errgo.Mask(err)
 	}
-	return strings.SplitN(string(data), "\n", 2)[0]
-}
 
-func prepareTempSource(goFile, exeFile, modulePath, importPath string) ([]string, error) {
-	code := generateCode(hookMainCode, importPath)
-	if err := os.MkdirAll(filepath.Dir(goFile), 0777); err != nil {
-		return nil, errgo.Mask(err)
+	gitCommit = ""
+	if *stampGit {
+		commit, err := charmGitCommit(b.pkg.Dir)
+		if err != nil {
+			return errgo.Notef(err, "cannot stamp git commit")
+		}
+		if *verbose {
+			log.Printf("stamping git commit %s", commit)
+		}
+		gitCommit = commit
 	}
-	if err := os.MkdirAll(filepath.Dir(exeFile), 0777); err != nil {
-		return nil, errgo.Mask(err)
+	manifest, err := newBuildManifest(mod.Dir, importPath)
+	if err != nil {
+		return errgo.Mask(err)
 	}
-	if err := ioutil.WriteFile(goFile, code, 0666); err != nil {
-		return nil, errgo.Mask(err)
+	var info *charmInfo
+	if b.prevDir != "" && !*force {
+		info, err = reuseBuild(b.prevDir, b.charmDir, manifest)
+		if err != nil {
+			return errgo.Notef(err, "cannot reuse previous build")
+		}
+		if info != nil && *verbose {
+			log.Printf("sources unchanged; reusing runhook binary from %s", b.prevDir)
+		}
 	}
-	env := os.Environ()
-	env = setenv(env, "CGOENABLED=false")
-	env = setenv(env, "GOARCH=amd64")
-	env = setenv(env, "GOOS=linux")
-
-	goDir := filepath.Dir(goFile)
-	if *verbose {
-		log.Printf("building in: %s", goDir)
+	if info == nil {
+		info, err = b.buildRunhook(mod, importPath)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		manifest.Info = info
+		if b.prevDir != "" {
+			if err := writeBuildManifest(b.prevDir, b.charmDir, manifest); err != nil {
+				return errgo.Notef(err, "cannot write build manifest")
+			}
+		}
 	}
-	if err := runCmd(goDir, env, "go", "mod", "init", "github.com/mever/gocharm/tmp-build").Run(); err != nil {
-		return nil, errgo.Notef(err, "failed to initialize Go modules")
+	if !*allowUnknownHooks {
+		if err := checkHookNames(info); err != nil {
+			return errgo.Notef(err, "invalid hooks (use -allow-unknown-hooks to allow them)")
+		}
 	}
-
-	localPath, er := os.Getwd()
-	if er != nil {
-		return nil, errgo.Notef(er, "can not get current directory")
+	if err := b.writeHooks(info.Hooks); err != nil {
+		return errgo.Notef(err, "cannot write hooks to charm")
 	}
-
-	localModulePath := getLocalPathToGoModule(modulePath, importPath, localPath)
-	if err := runCmd(goDir, env, "go", "mod", "edit", "-replace", modulePath+"="+localModulePath).Run(); err != nil {
-		return nil, errgo.Notef(err, "failed to replace package path with a local path")
+	if *dispatch {
+		if err := b.writeDispatch(); err != nil {
+			return errgo.Notef(err, "cannot write dispatch script")
+		}
 	}
-
-	if err := runCmd(goDir, env, "go", "mod", "tidy").Run(); err != nil {
-		return nil, errgo.Notef(err, "failed to tidy Go modules")
+	if err := b.writeMetadataFiles(info); err != nil {
+		return errgo.Mask(err)
 	}
-	return env, nil
+	// Sanity check that the new config files parse correctly.
+	if err := b.checkCharm(info.Assumes); err != nil {
+		return errgo.Notef(err, "charm will not read correctly; we've broken it, sorry")
+	}
+	return nil
 }
 
-func getLocalPathToGoModule(modulePath, importPath, localPath string) string {
-	if modulePath == importPath {
-		return localPath
-	} else {
-		return localPath[0:len(localPath)-len(importPath[len(modulePath):])]
+// checkCharm checks that the charm package can read the charm
+// written to the charm directory, which has the given assumes
+// expressions. The charm package only understands assumes
+// expressions that are plain features, so when there are any nested
+// expressions, the check is made on a copy of the charm's top level
+// files with the nested expressions removed from metadata.yaml.
+func (b *charmBuilder) checkCharm(assumes []interface{}) error {
+	dir := b.charmDir
+	if hasNestedAssumes(assumes) {
+		checkDir, err := ioutil.TempDir(b.tempDir, "check")
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer os.RemoveAll(checkDir)
+		if err := copyWithoutNestedAssumes(b.charmDir, checkDir); err != nil {
+			return errgo.Mask(err)
+		}
+		dir = checkDir
+	}
+	if _, err := charm.ReadCharmDir(dir); err != nil {
+		return errgo.Mask(err)
 	}
+	return nil
 }
 
-// buildCharm builds the runhook executable,
-// and all the other charm pieces (hooks, metadata.yaml,
-// config.yaml). It puts the runhook source file into goFile
-// and the runhook executable into exe.
-func buildCharm(p buildCharmParams) error {
-	b := (*charmBuilder)(&p)
-
-	modulePath := getGoModuleNameFromCurrentDir()
-	importPath := b.pkg.ImportPath
-	if importPath == "." {
-		importPath = modulePath
+// copyWithoutNestedAssumes copies the regular files at the top level
+// of the charm directory dir to the directory checkDir, removing any
+// nested assumes expressions from metadata.yaml.
+func copyWithoutNestedAssumes(dir, checkDir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errgo.Mask(err)
 	}
-
-	exeFile := filepath.Join(b.charmDir, "bin", "runhook")
-	goFile := filepath.Join(b.charmDir, "src", "runhook", "runhook.go")
-	env, err := prepareTempSource(goFile, exeFile, modulePath, importPath)
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || info.Name() == "metadata.yaml" {
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, info.Name()), filepath.Join(checkDir, info.Name())); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "metadata.yaml"))
 	if err != nil {
-		return errgo.Notef(err, "cannot build hooks main package")
+		return errgo.Mask(err)
 	}
-
-	if err := compile(goFile, exeFile, env); err != nil {
-		return errgo.Notef(err, "cannot build hooks main package")
+	var meta yaml.MapSlice
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return errgo.Notef(err, "cannot parse metadata.yaml")
 	}
-	if _, err := os.Stat(exeFile); err != nil {
-		return errgo.New("runhook command not built")
+	for i, item := range meta {
+		if item.Key != "assumes" {
+			continue
+		}
+		exprs, _ := item.Value.([]interface{})
+		var features []interface{}
+		for _, expr := range exprs {
+			if _, ok := expr.(string); ok {
+				features = append(features, expr)
+			}
+		}
+		meta[i].Value = features
 	}
-
-	info, err := registeredCharmInfo(importPath, p.tempDir)
-	if err != nil {
+	if err := writeYAML(filepath.Join(checkDir, "metadata.yaml"), meta); err != nil {
 		return errgo.Mask(err)
 	}
-	if err := b.writeHooks(info.Hooks); err != nil {
-		return errgo.Notef(err, "cannot write hooks to charm")
+	return nil
+}
+
+// buildRunhook builds the runhook executable for the charm with the
+// given import path in the module mod, and returns the charm
+// information found by inspecting the charm's registered hooks.
+// The runhook source is kept in the charm's src/runhook directory
+// for reference.
+func (b *charmBuilder) buildRunhook(mod *goModule, importPath string) (*charmInfo, error) {
+	exeFile := filepath.Join(b.charmDir, "bin", exeName("runhook"))
+	goFile := filepath.Join(b.charmDir, "src", "runhook", "runhook.go")
+	code := generateCode(hookMainCode, importPath)
+	for _, dir := range []string{filepath.Dir(goFile), filepath.Dir(exeFile)} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, errgo.Mask(err)
+		}
 	}
-	if err := b.writeMeta(info.Meta); err != nil {
-		return errgo.Notef(err, "cannot write metadata.yaml")
+	if err := ioutil.WriteFile(goFile, code, 0666); err != nil {
+		return nil, errgo.Mask(err)
 	}
-	if err := b.writeConfig(info.Config); err != nil {
-		return errgo.Notef(err, "cannot write config.yaml")
+	if err := compile(mod, code, exeFile); err != nil {
+		return nil, errgo.Notef(err, "cannot build hooks main package")
 	}
-	// Sanity check that the new config files parse correctly.
-	_, err = charm.ReadCharmDir(b.charmDir)
+	if _, err := os.Stat(exeFile); err != nil {
+		return nil, errgo.New("runhook command not built")
+	}
+
+	info, err := registeredCharmInfo(mod, importPath, b.tempDir)
 	if err != nil {
-		return errgo.Notef(err, "charm will not read correctly; we've broken it, sorry")
+		return nil, errgo.Mask(err)
 	}
-	return nil
+	return info, nil
+}
+
+// hasNestedAssumes reports whether any of the given
+// assumes expressions is an any-of or all-of expression.
+func hasNestedAssumes(assumes []interface{}) bool {
+	for _, a := range assumes {
+		if _, ok := a.(string); !ok {
+			return true
+		}
+	}
+	return false
 }
 
-// writeHooks ensures that the charm has the given set of hooks.
-// TODO write install and start hooks even if they're not registered,
-// because otherwise it won't be treated as a valid charm.
+// writeHooks ensures that the charm has the given set of hooks,
+// as well as the hooks required by Juju (see hook.RequiredHooks),
+// even if they are not registered.
+// Existing hooks are replaced.
 func (b *charmBuilder) writeHooks(hooks []string) error {
+	for _, name := range hook.RequiredHooks() {
+		if !containsString(hooks, name) {
+			hooks = append(hooks, name)
+		}
+	}
 	if *verbose {
 		log.Printf("writing hooks in %s", b.charmDir)
 	}
@@ -205,7 +315,7 @@ func (b *charmBuilder) writeHooks(hooks []string) error {
 	}
 	// Add any new hooks we need to the charm directory.
 	for _, hookName := range hooks {
-		hookPath := filepath.Join(hookDir, hookName)
+		hookPath := filepath.Join(hookDir, hookFileName(hookName))
 		if *verbose {
 			log.Printf("creating hook %s", hookPath)
 		}
@@ -216,27 +326,184 @@ func (b *charmBuilder) writeHooks(hooks []string) error {
 	return nil
 }
 
+// keepCustomizedHooks arranges for hooks in the charm directory dir
+// that have been customized to survive when the charm built in genDir
+// is copied there. Customized hooks that the new charm does not
+// replace are added to the hooks directory in genDir, so that they are
+// copied along with the rest of the charm. Hook stubs that the new
+// charm does not replace, such as those written for a different target
+// operating system, are stale and are left to be removed.
+func keepCustomizedHooks(dir, genDir string) error {
+	hookDir := filepath.Join(dir, "hooks")
+	genHookDir := filepath.Join(genDir, "hooks")
+	names, err := readDirNames(hookDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, name := range names {
+		if isHookBackup(name) {
+			// Backups are dealt with by backupHooks.
+			continue
+		}
+		if _, ok, err := readFileIfPresent(filepath.Join(genHookDir, name)); err != nil {
+			return errgo.Mask(err)
+		} else if ok {
+			continue
+		}
+		path := filepath.Join(hookDir, name)
+		data, _, err := readFileIfPresent(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if isHookStub(name, data) {
+			if *verbose {
+				log.Printf("removing stale hook %s", path)
+			}
+			continue
+		}
+		if *verbose {
+			log.Printf("keeping customized hook %s", path)
+		}
+		if err := copyFile(path, filepath.Join(genHookDir, name)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
 // hookStubTemplate holds the template for the generated hook code.
 var hookStubTemplate = template.Must(template.New("").Parse(`#!/bin/sh
 set -ex
 $CHARM_DIR/bin/runhook {{.HookName}}
 `))
 
+// windowsHookStubTemplate holds the template for the generated
+// hook code when the charm is built for Windows. Batch files
+// need CRLF line endings to be parsed reliably by cmd.exe.
+var windowsHookStubTemplate = template.Must(template.New("").Parse(
+	"@echo off\r\n" +
+		"\"%CHARM_DIR%\\bin\\runhook.exe\" {{.HookName}}\r\n" +
+		"exit /b %ERRORLEVEL%\r\n",
+))
+
+// windowsHookExt holds the file name extension
+// of hook stubs written for Windows.
+const windowsHookExt = ".cmd"
+
 type hookStubParams struct {
 	HookName  string
 }
 
 func (b *charmBuilder) hookStub(hookName string) []byte {
-	return executeTemplate(hookStubTemplate, hookStubParams{
+	t := hookStubTemplate
+	if targetWindows() {
+		t = windowsHookStubTemplate
+	}
+	return executeTemplate(t, hookStubParams{
 		HookName:  hookName,
 	})
 }
 
-func (b *charmBuilder) writeMeta(meta charm.Meta) error {
+// targetWindows reports whether the charm
+// is being built for Windows.
+func targetWindows() bool {
+	return *goos == "windows"
+}
+
+// hookFileName returns the name of the file in the hooks
+// directory that holds the stub for the given hook.
+func hookFileName(hookName string) string {
+	if targetWindows() {
+		return hookName + windowsHookExt
+	}
+	return hookName
+}
+
+// exeName returns the file name of the
+// executable with the given base name.
+func exeName(name string) string {
+	if targetWindows() {
+		return name + ".exe"
+	}
+	return name
+}
+
+// dispatchScript holds the contents of the dispatch file run by
+// versions of Juju that support it. JUJU_DISPATCH_PATH holds the path
+// of the hook relative to the charm directory, such as "hooks/install",
+// so the hook name is its last element.
+const dispatchScript = `#!/bin/sh
+set -e
+exec "$CHARM_DIR/bin/runhook" "${JUJU_DISPATCH_PATH##*/}"
+`
+
+// writeDispatch writes the dispatch script
+// to the top level of the charm directory.
+func (b *charmBuilder) writeDispatch() error {
+	path := filepath.Join(b.charmDir, "dispatch")
+	if *verbose {
+		log.Printf("creating %s", path)
+	}
+	if err := ioutil.WriteFile(path, []byte(dispatchScript), 0755); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// writeMetadataFiles writes the charm's metadata.yaml
+// config.yaml and actions.yaml files from the given charm
+// information.
+func (b *charmBuilder) writeMetadataFiles(info *charmInfo) error {
+	if err := b.writeMeta(info.Meta, info.Assumes); err != nil {
+		return errgo.Notef(err, "cannot write metadata.yaml")
+	}
+	if err := b.writeConfig(info.Config); err != nil {
+		return errgo.Notef(err, "cannot write config.yaml")
+	}
+	if err := b.writeActions(info.Actions); err != nil {
+		return errgo.Notef(err, "cannot write actions.yaml")
+	}
+	return nil
+}
+
+// writeMeta writes the charm's metadata.yaml file, including
+// the given assumes expressions, if any. If the charm's source
+// directory holds a metadata.yaml file, its name, summary,
+// maintainer and relation fields are merged with the registered
+// metadata.
+func (b *charmBuilder) writeMeta(meta charm.Meta, assumes []interface{}) error {
 	// The metadata name must match the directory name otherwise
 	// juju deploy will ignore the charm.
 	meta.Name = filepath.Base(b.pkg.Dir)
-	if err := writeYAML(filepath.Join(b.charmDir, "metadata.yaml"), meta); err != nil {
+	um, err := readUserMeta(filepath.Join(b.pkg.Dir, "metadata.yaml"))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var extra yaml.MapSlice
+	if um != nil {
+		if err := mergeMeta(&meta, um); err != nil {
+			return errgo.Mask(err)
+		}
+		extra = append(extra, um.maintainerFields()...)
+	}
+	if len(assumes) > 0 {
+		extra = append(extra, yaml.MapItem{Key: "assumes", Value: assumes})
+	}
+	var val interface{} = meta
+	if len(extra) > 0 {
+		// Marshal via a MapSlice so that the fields that
+		// charm.Meta cannot represent are retained.
+		data, err := yaml.Marshal(meta)
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal YAML")
+		}
+		var fields yaml.MapSlice
+		if err := yaml.Unmarshal(data, &fields); err != nil {
+			return errgo.Mask(err)
+		}
+		val = append(fields, extra...)
+	}
+	if err := writeYAML(filepath.Join(b.charmDir, "metadata.yaml"), val); err != nil {
 		return errgo.Notef(err, "cannot write metadata.yaml")
 	}
 	return nil
@@ -259,19 +526,136 @@ func writeYAML(file string, val interface{}) error {
 	return nil
 }
 
+// writeConfig writes the charm's config.yaml file holding the
+// given options. If the charm's source directory holds a
+// config.yaml file, any options declared there that have not
+// been registered are preserved, with a warning.
 func (b *charmBuilder) writeConfig(config map[string]charm.Option) error {
 	configPath := filepath.Join(b.charmDir, "config.yaml")
-	if len(config) == 0 {
+	options := make(map[string]charm.Option)
+	for name, opt := range config {
+		options[name] = normalizeOption(opt)
+	}
+	userConfig, err := readUserConfig(filepath.Join(b.pkg.Dir, "config.yaml"))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for name, opt := range userConfig {
+		if _, ok := options[name]; ok {
+			continue
+		}
+		log.Printf("warning: option %q in config.yaml is not registered by the charm", name)
+		options[name] = opt
+	}
+	if len(options) == 0 {
 		return nil
 	}
 	if err := writeYAML(configPath, &charm.Config{
-		Options: config,
+		Options: options,
 	}); err != nil {
 		return errgo.Notef(err, "cannot write config.yaml")
 	}
 	return nil
 }
 
+// writeActions writes the charm's actions.yaml file holding
+// the given actions. No file is written if there are no actions.
+func (b *charmBuilder) writeActions(actions map[string]charm.ActionSpec) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	val := make(map[string]yaml.MapSlice)
+	for name, spec := range actions {
+		val[name] = actionYAML(name, spec)
+	}
+	if err := writeYAML(filepath.Join(b.charmDir, "actions.yaml"), val); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// actionYAML returns the actions.yaml entry for the action with
+// the given name and spec. The spec's params hold the JSON schema
+// of the whole action (see hook.ActionParams.Schema), whereas
+// actions.yaml holds the properties of the schema as the action's
+// params, with the other schema fields alongside them, so this is
+// the inverse of the conversion made by charm.ReadActionsYaml.
+func actionYAML(name string, spec charm.ActionSpec) yaml.MapSlice {
+	entry := yaml.MapSlice{{
+		Key:   "description",
+		Value: spec.Description,
+	}}
+	if spec.Parallel {
+		entry = append(entry, yaml.MapItem{Key: "parallel", Value: true})
+	}
+	if spec.ExecutionGroup != "" {
+		entry = append(entry, yaml.MapItem{Key: "execution-group", Value: spec.ExecutionGroup})
+	}
+	if props, ok := spec.Params["properties"]; ok {
+		entry = append(entry, yaml.MapItem{Key: "params", Value: props})
+	}
+	keys := make([]string, 0, len(spec.Params))
+	for key := range spec.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "description", "properties":
+			continue
+		case "title":
+			if spec.Params[key] == name {
+				continue
+			}
+		case "type":
+			if spec.Params[key] == "object" {
+				continue
+			}
+		}
+		entry = append(entry, yaml.MapItem{Key: key, Value: spec.Params[key]})
+	}
+	return entry
+}
+
+// readUserConfig reads the options from the config.yaml file
+// at the given path. It returns no options if the file
+// does not exist.
+func readUserConfig(path string) (map[string]charm.Option, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	defer f.Close()
+	config, err := charm.ReadConfig(f)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read %s", path)
+	}
+	return config.Options, nil
+}
+
+// normalizeOption returns opt with its default value converted
+// to the Go type appropriate to the option's type. The options
+// are passed from the inspect command as JSON, so integer
+// defaults arrive as float64 values.
+func normalizeOption(opt charm.Option) charm.Option {
+	if f, ok := opt.Default.(float64); ok && opt.Type == "int" && f == math.Trunc(f) {
+		opt.Default = int64(f)
+	}
+	return opt
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
 func setenv(env []string, entry string) []string {
 	i := strings.Index(entry, "=")
 	if i == -1 {
@@ -287,6 +671,29 @@ func setenv(env []string, entry string) []string {
 	return append(env, entry)
 }
 
+// buildEnv returns the environment used to build the runhook
+// executable, derived from the given environment. The executable
+// is built for the platform specified by the -goos and -goarch
+// flags; cgo is disabled when that differs from the host platform.
+func buildEnv(env []string) []string {
+	env = setenv(env, "CGOENABLED=false")
+	env = setenv(env, "GOARCH="+*goarch)
+	env = setenv(env, "GOOS="+*goos)
+	if *static || *goos != runtime.GOOS || *goarch != runtime.GOARCH {
+		env = setenv(env, "CGO_ENABLED=0")
+	}
+	return env
+}
+
+// hostEnv returns the given environment modified so that
+// executables are built for the host platform, so that
+// they can be run by gocharm itself.
+func hostEnv(env []string) []string {
+	env = setenv(env, "GOARCH="+runtime.GOARCH)
+	env = setenv(env, "GOOS="+runtime.GOOS)
+	return env
+}
+
 type templateParams struct {
 	AutogenMessage string
 	CharmPackage   string
@@ -301,13 +708,124 @@ func generateCode(tmpl *template.Template, charmPackage string) []byte {
 	})
 }
 
-func compile(goFile, exeFile string, env []string) error {
-	if err := runCmd("", env, "go", "build", "-o", exeFile, goFile).Run(); err != nil {
+// compile builds the runhook executable from the given
+// code into exeFile. It is built in module mode from
+// a temporary package inside the module mod.
+func compile(mod *goModule, code []byte, exeFile string) error {
+	goFile, remove, err := mod.tempPackage(code)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer remove()
+	env := moduleEnv(buildEnv(os.Environ()))
+	if err := runWithTimeout(runCmd(mod.Dir, env, "go", compileArgs(goFile, exeFile)...), "building runhook"); err != nil {
 		return errgo.Notef(err, "failed to build")
 	}
 	return nil
 }
 
+// compileArgs returns the arguments to the go command
+// used to build the runhook executable.
+func compileArgs(goFile, exeFile string) []string {
+	args := []string{"build", "-o", exeFile}
+	args = append(args, tagsArgs()...)
+	var ldflags []string
+	if *release {
+		// Omit the symbol table and DWARF information.
+		ldflags = append(ldflags, "-s", "-w")
+	}
+	if *static {
+		// Make sure that the external linker, if it is
+		// used at all, does not link dynamically either.
+		ldflags = append(ldflags, "-extldflags=-static")
+	}
+	if *buildLdflags != "" {
+		ldflags = append(ldflags, *buildLdflags)
+	}
+	ldflags = append(ldflags, stampLdflags()...)
+	if len(ldflags) > 0 {
+		args = append(args, "-ldflags="+strings.Join(ldflags, " "))
+	}
+	return append(args, goFile)
+}
+
+// tagsArgs returns the go build arguments that select the build
+// tags given by the -build-tags flag. They are used when building
+// both the runhook executable and the code that inspects the
+// charm, so that the same hooks are registered by each.
+func tagsArgs() []string {
+	if *buildTags == "" {
+		return nil
+	}
+	return []string{"-tags=" + *buildTags}
+}
+
+// gitCommit holds the git commit stamped into the charm's
+// executables. It is set by buildCharm when the -stamp-git
+// flag is given.
+var gitCommit string
+
+// stampLdflags returns the linker flags that stamp the git commit
+// found by the -stamp-git flag into the main package of the runhook
+// executable and of the code that inspects the charm.
+func stampLdflags() []string {
+	if gitCommit == "" {
+		return nil
+	}
+	return []string{"-X", "main.gitCommit=" + gitCommit}
+}
+
+// validTags matches a comma-separated list of build tags.
+var validTags = regexp.MustCompile(`^[A-Za-z0-9_.]+(,[A-Za-z0-9_.]+)*$`)
+
+// checkBuildFlags checks that the -build-tags and -ldflags flags
+// hold values that can be passed to the go command. They are passed
+// as single arguments without using a shell, so shell metacharacters
+// would not be interpreted; they are rejected because they cannot
+// be intended.
+func checkBuildFlags() error {
+	if *buildTags != "" && !validTags.MatchString(*buildTags) {
+		return errgo.Newf("invalid build tags %q", *buildTags)
+	}
+	if strings.ContainsAny(*buildLdflags, ";&|<>`$\n\r\x00") {
+		return errgo.Newf("invalid linker flags %q: shell metacharacters not allowed", *buildLdflags)
+	}
+	return nil
+}
+
+// checkNoCgo returns an error if any non-standard package that the
+// package in the given directory depends on requires cgo, which
+// would prevent it from being built as a static binary. Standard
+// library packages are not considered, because they all have pure
+// Go implementations that are used when cgo is disabled.
+func checkNoCgo(dir string) error {
+	c := runCmd(dir, setenv(os.Environ(), "CGO_ENABLED=1"), "go", "list", "-deps", "-f", "{{if and .CgoFiles (not .Standard)}}{{.ImportPath}}{{end}}", ".")
+	var out, stderr bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return errgo.Newf("cannot list dependencies: %v\n%s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if pkgs := strings.Fields(out.String()); len(pkgs) > 0 {
+		return errgo.Newf("cannot build static binary: charm depends on packages that require cgo: %s", strings.Join(pkgs, ", "))
+	}
+	return nil
+}
+
+// vetCharm runs go vet on all the packages in the given directory
+// and below it. If vet reports any problems, the returned error
+// includes its output.
+func vetCharm(dir string) error {
+	c := runCmd(dir, nil, "go", "vet", "./...")
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	if err := c.Run(); err != nil {
+		return errgo.Newf("go vet failed: %v\n%s", err, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
+
 func runCmd(dir string, env []string, cmd string, args ...string) *exec.Cmd {
 	if *verbose {
 		log.Printf("run %s %s", cmd, strings.Join(args, " "))
@@ -322,6 +840,42 @@ func runCmd(dir string, env []string, cmd string, args ...string) *exec.Cmd {
 	return c
 }
 
+// errTimeout is the cause of the error returned by
+// runWithTimeout when a command takes too long.
+var errTimeout = errgo.New("timed out")
+
+// runWithTimeout runs the command c, killing it along with any
+// processes that it has started if it does not complete within the
+// duration given by the -build-timeout flag. The phase argument
+// describes what the command is doing, for the error returned if
+// it times out, which has errTimeout as its cause.
+func runWithTimeout(c *exec.Cmd, phase string) error {
+	ctx := context.Background()
+	if *buildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *buildTimeout)
+		defer cancel()
+	}
+	setProcessGroup(c)
+	if err := c.Start(); err != nil {
+		return errgo.Mask(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(c)
+		case <-done:
+		}
+	}()
+	err := c.Wait()
+	close(done)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return errgo.WithCausef(nil, errTimeout, "%s timed out after %v", phase, *buildTimeout)
+	}
+	return errgo.Mask(err)
+}
+
 func executeTemplate(t *template.Template, param interface{}) []byte {
 	var w bytes.Buffer
 	if err := t.Execute(&w, param); err != nil {
@@ -1,19 +1,1053 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/juju/charm/v9"
+	"github.com/juju/charm/v9/resource"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
 )
 
-func Test_getLocalPathToGoModule(t *testing.T)  {
-	if getLocalPathToGoModule("example.org/hw", "example.org/hw",
-		"/home/user/gocharms/hw") != "/home/user/gocharms/hw" {
-		t.Fail()
+func Test_writeMetaSeriesAndTags(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+		Series:      []string{"focal", "bionic"},
+		Tags:        []string{"databases"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	f, err := os.Open(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := charm.ReadMeta(f)
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if meta.Name != "mycharm" {
+		t.Errorf("unexpected name %q", meta.Name)
+	}
+	if !reflect.DeepEqual(meta.Series, []string{"focal", "bionic"}) {
+		t.Errorf("unexpected series %q", meta.Series)
+	}
+	if !reflect.DeepEqual(meta.Tags, []string{"databases"}) {
+		t.Errorf("unexpected tags %q", meta.Tags)
+	}
+}
+
+func Test_compileArgsRelease(t *testing.T) {
+	defer func(old bool) { *release = old }(*release)
+
+	*release = false
+	args := compileArgs("runhook.go", "runhook")
+	if want := []string{"build", "-o", "runhook", "runhook.go"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected default args; got %q want %q", args, want)
+	}
+	*release = true
+	args = compileArgs("runhook.go", "runhook")
+	if want := []string{"build", "-o", "runhook", "-ldflags=-s -w", "runhook.go"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected release args; got %q want %q", args, want)
+	}
+}
+
+func Test_writeMetaResources(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	resources := map[string]resource.Meta{
+		"software": {
+			Name:        "software",
+			Type:        resource.TypeFile,
+			Path:        "software.tgz",
+			Description: "The software to install",
+		},
+		"image": {
+			Name:        "image",
+			Type:        resource.TypeContainerImage,
+			Description: "The workload image",
+		},
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+		Resources:   resources,
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	f, err := os.Open(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := charm.ReadMeta(f)
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if !reflect.DeepEqual(meta.Resources, resources) {
+		t.Errorf("unexpected resources; got %#v want %#v", meta.Resources, resources)
+	}
+}
+
+func Test_writeMetaContainers(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	resources := map[string]resource.Meta{
+		"workload-image": {
+			Name:        "workload-image",
+			Type:        resource.TypeContainerImage,
+			Description: "The workload image",
+		},
+	}
+	containers := map[string]charm.Container{
+		"workload": {
+			Resource: "workload-image",
+		},
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a sidecar charm",
+		Description: "a sidecar charm description",
+		Resources:   resources,
+		Containers:  containers,
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "containers:\n  workload:\n    resource: workload-image\n") {
+		t.Errorf("containers not found in metadata:\n%s", data)
+	}
+	// Reading the metadata checks that the container's
+	// resource exists and is an oci-image resource.
+	meta, err := charm.ReadMeta(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if !reflect.DeepEqual(meta.Containers, containers) {
+		t.Errorf("unexpected containers; got %#v want %#v", meta.Containers, containers)
+	}
+	if !reflect.DeepEqual(meta.Resources, resources) {
+		t.Errorf("unexpected resources; got %#v want %#v", meta.Resources, resources)
+	}
+}
+
+func Test_vetCharm(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/vetcharm\n\ngo 1.16\n")
+	writeFile("charm.go", `package vetcharm
+
+import "fmt"
+
+func Hello() string {
+	return fmt.Sprintf("hello %d", "world")
+}
+`)
+	err := vetCharm(dir)
+	if err == nil {
+		t.Fatalf("expected vet failure, got nil error")
+	}
+	if !strings.Contains(err.Error(), "go vet failed") || !strings.Contains(err.Error(), "Sprintf format %d has arg \"world\" of wrong type string") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeFile("charm.go", `package vetcharm
+
+import "fmt"
+
+func Hello() string {
+	return fmt.Sprintf("hello %s", "world")
+}
+`)
+	if err := vetCharm(dir); err != nil {
+		t.Fatalf("unexpected vet failure: %v", err)
+	}
+}
+
+func Test_writeMetaSubordinate(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+		Subordinate: true,
+		Requires: map[string]charm.Relation{
+			"juju-info": {
+				Name:      "juju-info",
+				Role:      charm.RoleRequirer,
+				Interface: "juju-info",
+				Scope:     charm.ScopeContainer,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\nsubordinate: true\n") {
+		t.Errorf("subordinate flag not found in metadata:\n%s", data)
+	}
+	meta, err := charm.ReadMeta(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if !meta.Subordinate {
+		t.Errorf("charm is not subordinate")
+	}
+}
+
+func Test_compileArgsStatic(t *testing.T) {
+	defer func(old bool) { *release = old }(*release)
+	defer func(old bool) { *static = old }(*static)
+
+	*release = false
+	*static = true
+	args := compileArgs("runhook.go", "runhook")
+	if want := []string{"build", "-o", "runhook", "-ldflags=-extldflags=-static", "runhook.go"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected static args; got %q want %q", args, want)
+	}
+	*release = true
+	args = compileArgs("runhook.go", "runhook")
+	if want := []string{"build", "-o", "runhook", "-ldflags=-s -w -extldflags=-static", "runhook.go"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected static release args; got %q want %q", args, want)
+	}
+}
+
+func Test_buildEnvStatic(t *testing.T) {
+	defer func(old bool) { *static = old }(*static)
+	defer func(old string) { *goos = old }(*goos)
+	defer func(old string) { *goarch = old }(*goarch)
+
+	// Build for the host so that cgo is not disabled
+	// because we are cross compiling.
+	*goos, *goarch = runtime.GOOS, runtime.GOARCH
+	*static = false
+	env := buildEnv([]string{"HOME=/home/user", "CGO_ENABLED=1"})
+	if want := []string{"HOME=/home/user", "CGO_ENABLED=1", "CGOENABLED=false", "GOARCH=" + runtime.GOARCH, "GOOS=" + runtime.GOOS}; !reflect.DeepEqual(env, want) {
+		t.Errorf("unexpected default env; got %q want %q", env, want)
+	}
+	*static = true
+	env = buildEnv([]string{"HOME=/home/user", "CGO_ENABLED=1"})
+	if want := []string{"HOME=/home/user", "CGO_ENABLED=0", "CGOENABLED=false", "GOARCH=" + runtime.GOARCH, "GOOS=" + runtime.GOOS}; !reflect.DeepEqual(env, want) {
+		t.Errorf("unexpected static env; got %q want %q", env, want)
+	}
+}
+
+func Test_buildEnvCross(t *testing.T) {
+	defer func(old string) { *goos = old }(*goos)
+	defer func(old string) { *goarch = old }(*goarch)
+
+	*goos, *goarch = "plan9", "386"
+	env := buildEnv([]string{"HOME=/home/user", "CGO_ENABLED=1"})
+	if want := []string{"HOME=/home/user", "CGO_ENABLED=0", "CGOENABLED=false", "GOARCH=386", "GOOS=plan9"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("unexpected cross env; got %q want %q", env, want)
+	}
+}
+
+func Test_hostEnv(t *testing.T) {
+	env := hostEnv([]string{"HOME=/home/user", "GOOS=plan9", "GOARCH=386"})
+	if want := []string{"HOME=/home/user", "GOOS=" + runtime.GOOS, "GOARCH=" + runtime.GOARCH}; !reflect.DeepEqual(env, want) {
+		t.Errorf("unexpected host env; got %q want %q", env, want)
+	}
+}
+
+func Test_checkNoCgo(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/cgocharm\n\ngo 1.16\n")
+	writeFile("charm.go", `package cgocharm
+
+import (
+	"net"
+
+	"example.com/cgocharm/native"
+)
+
+func Hello() string {
+	return native.Hello() + net.IPv4zero.String()
+}
+`)
+	writeFile("native/native.go", `package native
+
+// #include <stdlib.h>
+import "C"
+
+func Hello() string {
+	return "hello"
+}
+`)
+	err := checkNoCgo(dir)
+	if err == nil {
+		t.Fatalf("expected cgo failure, got nil error")
+	}
+	if want := "cannot build static binary: charm depends on packages that require cgo: example.com/cgocharm/native"; err.Error() != want {
+		t.Fatalf("unexpected error; got %q want %q", err, want)
+	}
+
+	writeFile("native/native.go", `package native
+
+func Hello() string {
+	return "hello"
+}
+`)
+	if err := checkNoCgo(dir); err != nil {
+		t.Fatalf("unexpected cgo failure: %v", err)
+	}
+}
+
+func Test_writeDispatch(t *testing.T) {
+	b := &charmBuilder{
+		charmDir: t.TempDir(),
+	}
+	if err := b.writeDispatch(); err != nil {
+		t.Fatalf("cannot write dispatch script: %v", err)
+	}
+	path := filepath.Join(b.charmDir, "dispatch")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0111 != 0111 {
+		t.Errorf("dispatch script is not executable; mode %v", perm)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != dispatchScript {
+		t.Errorf("unexpected dispatch script %q", data)
+	}
+}
+
+func Test_dispatchScriptHookName(t *testing.T) {
+	b := &charmBuilder{
+		charmDir: t.TempDir(),
+	}
+	if err := b.writeDispatch(); err != nil {
+		t.Fatalf("cannot write dispatch script: %v", err)
+	}
+	// Replace runhook with a script that prints its arguments.
+	binDir := filepath.Join(b.charmDir, "bin")
+	if err := os.MkdirAll(binDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(binDir, "runhook"), []byte("#!/bin/sh\necho \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dispatchPath string
+		expect       string
+	}{
+		{"hooks/install", "install"},
+		{"hooks/db-relation-changed", "db-relation-changed"},
+		{"actions/backup", "backup"},
+	}
+	for _, test := range tests {
+		cmd := exec.Command(filepath.Join(b.charmDir, "dispatch"))
+		cmd.Env = []string{
+			"CHARM_DIR=" + b.charmDir,
+			"JUJU_DISPATCH_PATH=" + test.dispatchPath,
+		}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("dispatch failed: %v: %s", err, out)
+		}
+		if got := strings.TrimSpace(string(out)); got != test.expect {
+			t.Errorf("unexpected hook name for %q; got %q want %q", test.dispatchPath, got, test.expect)
+		}
+	}
+}
+
+func Test_writeMetaMergesUserMeta(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "mycharm")
+	if err := os.Mkdir(pkgDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(pkgDir, "metadata.yaml"), []byte(`
+name: mycharm
+summary: a hand-written summary
+maintainer: Someone <someone@example.com>
+requires:
+  db: mysql
+  logs:
+    interface: syslog
+    scope: container
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: pkgDir},
+		charmDir: t.TempDir(),
+	}
+	err = b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+		Provides: map[string]charm.Relation{
+			"website": {
+				Name:      "website",
+				Role:      charm.RoleProvider,
+				Interface: "http",
+				Scope:     charm.ScopeGlobal,
+			},
+		},
+		Requires: map[string]charm.Relation{
+			"db": {
+				Name:      "db",
+				Role:      charm.RoleRequirer,
+				Interface: "mysql",
+				Scope:     charm.ScopeGlobal,
+			},
+		},
+		Peers: map[string]charm.Relation{
+			"cluster": {
+				Name:      "cluster",
+				Role:      charm.RolePeer,
+				Interface: "mycharm-peer",
+				Scope:     charm.ScopeGlobal,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	path := filepath.Join(b.charmDir, "metadata.yaml")
+	if !autogenerated(path) {
+		t.Errorf("metadata.yaml not marked as autogenerated")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\nmaintainer: Someone <someone@example.com>\n") {
+		t.Errorf("maintainer not found in metadata:\n%s", data)
+	}
+	meta, err := charm.ReadMeta(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if meta.Summary != "a hand-written summary" {
+		t.Errorf("unexpected summary %q", meta.Summary)
+	}
+	if meta.Description != "a charm description" {
+		t.Errorf("unexpected description %q", meta.Description)
+	}
+	if rel := meta.Provides["website"]; rel.Interface != "http" {
+		t.Errorf("unexpected website relation %#v", rel)
+	}
+	if rel := meta.Requires["db"]; rel.Interface != "mysql" {
+		t.Errorf("unexpected db relation %#v", rel)
+	}
+	if rel := meta.Requires["logs"]; rel.Interface != "syslog" || rel.Scope != charm.ScopeContainer {
+		t.Errorf("unexpected logs relation %#v", rel)
+	}
+	if rel := meta.Peers["cluster"]; rel.Interface != "mycharm-peer" {
+		t.Errorf("unexpected cluster relation %#v", rel)
+	}
+}
+
+func Test_writeMetaRelationConflict(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "mycharm")
+	if err := os.Mkdir(pkgDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(pkgDir, "metadata.yaml"), []byte("requires:\n  db: pgsql\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: pkgDir},
+		charmDir: t.TempDir(),
+	}
+	err = b.writeMeta(charm.Meta{
+		Requires: map[string]charm.Relation{
+			"db": {
+				Name:      "db",
+				Role:      charm.RoleRequirer,
+				Interface: "mysql",
+				Scope:     charm.ScopeGlobal,
+			},
+		},
+	}, nil)
+	want := `relation "db" registered with interface "mysql" but declared in metadata.yaml with interface "pgsql"`
+	if err == nil || err.Error() != want {
+		t.Fatalf("unexpected error; got %v want %q", err, want)
+	}
+}
+
+func Test_writeConfigDefaults(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: t.TempDir()},
+		charmDir: t.TempDir(),
+	}
+	// Integer defaults arrive from the inspect command as float64.
+	err := b.writeConfig(map[string]charm.Option{
+		"port": {
+			Type:        "int",
+			Description: "The port.",
+			Default:     float64(0),
+		},
+		"hostname": {
+			Type:    "string",
+			Default: "",
+		},
+		"debug": {
+			Type:    "boolean",
+			Default: false,
+		},
+		"name": {
+			Type: "string",
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot write config: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  port:\n    type: int\n    description: The port.\n    default: 0\n",
+		"  hostname:\n    type: string\n    default: \"\"\n",
+		"  debug:\n    type: boolean\n    default: false\n",
+		"  name:\n    type: string\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%q not found in config:\n%s", want, data)
+		}
+	}
+	config, err := charm.ReadConfig(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot read config: %v", err)
+	}
+	want := charm.Settings{
+		"port":     int64(0),
+		"hostname": "",
+		"debug":    false,
+		"name":     nil,
+	}
+	if got := config.DefaultSettings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected defaults; got %#v want %#v", got, want)
+	}
+}
+
+func Test_writeConfigPreservesUserOptions(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: t.TempDir()},
+		charmDir: t.TempDir(),
+	}
+	err := os.WriteFile(filepath.Join(b.pkg.Dir, "config.yaml"), []byte(`
+options:
+  port:
+    type: int
+    default: 8080
+  extra:
+    type: string
+    description: A hand-written option.
+    default: foo
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.writeConfig(map[string]charm.Option{
+		"port": {
+			Type:    "int",
+			Default: float64(80),
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot write config: %v", err)
+	}
+	f, err := os.Open(filepath.Join(b.charmDir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := charm.ReadConfig(f)
+	if err != nil {
+		t.Fatalf("cannot read config: %v", err)
+	}
+	want := map[string]charm.Option{
+		"port": {
+			Type:    "int",
+			Default: int64(80),
+		},
+		"extra": {
+			Type:        "string",
+			Description: "A hand-written option.",
+			Default:     "foo",
+		},
+	}
+	if !reflect.DeepEqual(config.Options, want) {
+		t.Errorf("unexpected options; got %#v want %#v", config.Options, want)
+	}
+}
+
+func Test_writeHooksRequired(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	if err := b.writeHooks([]string{"config-changed", "start"}); err != nil {
+		t.Fatalf("cannot write hooks: %v", err)
+	}
+	for _, name := range []string{"config-changed", "install", "start"} {
+		data, err := os.ReadFile(filepath.Join(b.charmDir, "hooks", name))
+		if err != nil {
+			t.Fatalf("hook %q not written: %v", name, err)
+		}
+		if want := "$CHARM_DIR/bin/runhook " + name + "\n"; !strings.HasSuffix(string(data), want) {
+			t.Errorf("unexpected %s hook stub:\n%s", name, data)
+		}
 	}
 }
 
-func Test_getLocalPathToGoModule_otherImportPath(t *testing.T)  {
-	if getLocalPathToGoModule("example.org/foo", "example.org/foo/charms/bar",
-		"/home/user/go/src/example.org/foo/charms/bar") != "/home/user/go/src/example.org/foo" {
-		t.Fail()
+// inspectedAssumes returns the given expressions as
+// they are received from the inspect executable.
+func inspectedAssumes(t *testing.T, exprs ...hook.AssumesExpr) []interface{} {
+	data, err := json.Marshal(exprs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assumes []interface{}
+	if err := json.Unmarshal(data, &assumes); err != nil {
+		t.Fatal(err)
+	}
+	return assumes
+}
+
+func Test_writeMetaAssumes(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+	}, inspectedAssumes(t,
+		hook.AssumesFeature("juju >= 3.0"),
+		hook.AssumesFeature("k8s-api"),
+	))
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `
+assumes:
+- juju >= 3.0
+- k8s-api
+`
+	if !strings.HasSuffix(string(data), want) {
+		t.Errorf("assumes not found in metadata:\n%s", data)
+	}
+	meta, err := charm.ReadMeta(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot read metadata: %v", err)
+	}
+	if !reflect.DeepEqual(meta.Assumes, []string{"juju >= 3.0", "k8s-api"}) {
+		t.Errorf("unexpected assumes %q", meta.Assumes)
+	}
+}
+
+func Test_writeMetaAssumesNested(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	assumes := inspectedAssumes(t,
+		hook.AssumesFeature("k8s-api"),
+		hook.AnyOf(
+			hook.AssumesFeature("juju >= 3.0"),
+			hook.AllOf(
+				hook.AssumesFeature("juju >= 2.9"),
+				hook.AssumesFeature("juju < 3"),
+			),
+		),
+	)
+	if !hasNestedAssumes(assumes) {
+		t.Errorf("nested assumes not detected")
+	}
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+	}, assumes)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `
+assumes:
+- k8s-api
+- any-of:
+  - juju >= 3.0
+  - all-of:
+    - juju >= 2.9
+    - juju < 3
+`
+	if !strings.HasSuffix(string(data), want) {
+		t.Errorf("assumes not found in metadata:\n%s", data)
+	}
+}
+
+func Test_checkCharmNestedAssumes(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+		tempDir:  t.TempDir(),
+	}
+	assumes := inspectedAssumes(t,
+		hook.AssumesFeature("k8s-api"),
+		hook.AnyOf(
+			hook.AssumesFeature("juju >= 3.0"),
+			hook.AssumesFeature("juju >= 2.9"),
+		),
+	)
+	err := b.writeMeta(charm.Meta{
+		Summary:     "a charm",
+		Description: "a charm description",
+	}, assumes)
+	if err != nil {
+		t.Fatalf("cannot write metadata: %v", err)
+	}
+	if err := b.checkCharm(assumes); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+	// The check must still notice problems elsewhere in the charm.
+	err = os.WriteFile(filepath.Join(b.charmDir, "config.yaml"), []byte("options:\n  foo:\n    type: bogus\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.checkCharm(assumes); err == nil {
+		t.Errorf("expected error from bad config.yaml")
+	}
+	// The metadata in the charm itself is left untouched.
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "metadata.yaml"))
+	if err != nil {
+		t.Fatal(err)
 	}
-}
\ No newline at end of file
+	if !strings.Contains(string(data), "any-of:") {
+		t.Errorf("nested assumes removed from metadata:\n%s", data)
+	}
+}
+
+func Test_writeHooksWindows(t *testing.T) {
+	defer func(old string) { *goos = old }(*goos)
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	*goos = "windows"
+	if err := b.writeHooks([]string{"start"}); err != nil {
+		t.Fatalf("cannot write hooks: %v", err)
+	}
+	infos, err := os.ReadDir(filepath.Join(b.charmDir, "hooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if want := []string{"install.cmd", "start.cmd"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected hooks; got %q want %q", names, want)
+	}
+	data, err := os.ReadFile(filepath.Join(b.charmDir, "hooks", "start.cmd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "@echo off\r\n\"%CHARM_DIR%\\bin\\runhook.exe\" start\r\nexit /b %ERRORLEVEL%\r\n"
+	if string(data) != want {
+		t.Fatalf("unexpected start hook stub; got %q want %q", data, want)
+	}
+}
+
+func Test_runWithTimeout(t *testing.T) {
+	defer func(old time.Duration) { *buildTimeout = old }(*buildTimeout)
+	*buildTimeout = 100 * time.Millisecond
+	// The shell starts a sleep process that holds on to
+	// standard output, so the command only completes
+	// if the whole process group is killed.
+	c := exec.Command("sh", "-c", "sleep 10; echo done")
+	var out bytes.Buffer
+	c.Stdout = &out
+	start := time.Now()
+	err := runWithTimeout(c, "running test")
+	if err == nil {
+		t.Fatalf("unexpected success")
+	}
+	if want := "running test timed out after 100ms"; err.Error() != want {
+		t.Errorf("unexpected error; got %q want %q", err, want)
+	}
+	if errgo.Cause(err) != errTimeout {
+		t.Errorf("unexpected error cause %#v", errgo.Cause(err))
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command not killed in time; took %v", d)
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output %q", out.Bytes())
+	}
+}
+
+func Test_runWithTimeoutCompletes(t *testing.T) {
+	defer func(old time.Duration) { *buildTimeout = old }(*buildTimeout)
+	*buildTimeout = 10 * time.Second
+	if err := runWithTimeout(exec.Command("true"), "running test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := runWithTimeout(exec.Command("false"), "running test")
+	if err == nil || errgo.Cause(err) == errTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_compileArgsBuildFlags(t *testing.T) {
+	defer func(old bool) { *release = old }(*release)
+	defer func(old string) { *buildTags = old }(*buildTags)
+	defer func(old string) { *buildLdflags = old }(*buildLdflags)
+
+	*release = true
+	*buildTags = "netgo,prod"
+	*buildLdflags = "-X main.version=1.2.3"
+	args := compileArgs("runhook.go", "runhook")
+	want := []string{"build", "-o", "runhook", "-tags=netgo,prod", "-ldflags=-s -w -X main.version=1.2.3", "runhook.go"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected args; got %q want %q", args, want)
+	}
+}
+
+var checkBuildFlagsTests = []struct {
+	tags        string
+	ldflags     string
+	expectError string
+}{{}, {
+	tags:    "prod",
+	ldflags: "-X 'main.version=1.2 beta'",
+}, {
+	tags:        "prod linux",
+	expectError: `invalid build tags "prod linux"`,
+}, {
+	tags:        "prod,",
+	expectError: `invalid build tags "prod,"`,
+}, {
+	tags:        "prod;rm",
+	expectError: `invalid build tags "prod;rm"`,
+}, {
+	ldflags:     "-X main.version=$(git describe)",
+	expectError: `invalid linker flags "-X main.version=\$\(git describe\)": shell metacharacters not allowed`,
+}, {
+	ldflags:     "-s\n-w",
+	expectError: `invalid linker flags "-s\\n-w": shell metacharacters not allowed`,
+}}
+
+func Test_checkBuildFlags(t *testing.T) {
+	defer func(old string) { *buildTags = old }(*buildTags)
+	defer func(old string) { *buildLdflags = old }(*buildLdflags)
+	for i, test := range checkBuildFlagsTests {
+		*buildTags, *buildLdflags = test.tags, test.ldflags
+		err := checkBuildFlags()
+		if test.expectError == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil || !regexp.MustCompile("^"+test.expectError+"$").MatchString(err.Error()) {
+			t.Errorf("test %d: unexpected error; got %v want %q", i, err, test.expectError)
+		}
+	}
+}
+
+func Test_writeActions(t *testing.T) {
+	r := hook.NewRegistry()
+	r.RegisterAction("backup", hook.ActionParams{
+		Description: "Back up the database.",
+		Params: map[string]hook.ActionParam{
+			"target": {
+				Type:        "string",
+				Description: "Where to put the backup.",
+				Required:    true,
+			},
+			"compression": {
+				Type: "object",
+				Properties: map[string]hook.ActionParam{
+					"kind": {
+						Type: "string",
+						Enum: []interface{}{"gzip", "xz"},
+					},
+					"level": {
+						Type:    "integer",
+						Default: 6,
+					},
+				},
+			},
+		},
+	})
+	r.RegisterAction("restart", hook.ActionParams{})
+	want := r.RegisteredActions()
+
+	// The actions arrive from the inspect command as JSON.
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions map[string]charm.ActionSpec
+	if err := json.Unmarshal(data, &actions); err != nil {
+		t.Fatal(err)
+	}
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	if err := b.writeActions(actions); err != nil {
+		t.Fatalf("cannot write actions: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(b.charmDir, "actions.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantYAML := yamlAutogenComment + `backup:
+  description: Back up the database.
+  params:
+    compression:
+      properties:
+        kind:
+          enum:
+          - gzip
+          - xz
+          type: string
+        level:
+          default: 6
+          type: integer
+      type: object
+    target:
+      description: Where to put the backup.
+      type: string
+  required:
+  - target
+restart:
+  description: No description
+  params: {}
+`
+	if string(data) != wantYAML {
+		t.Errorf("unexpected actions.yaml; got:\n%s\nwant:\n%s", data, wantYAML)
+	}
+	got, err := charm.ReadActionsYaml("mycharm", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("cannot read actions.yaml: %v", err)
+	}
+	if !reflect.DeepEqual(got.ActionSpecs, want) {
+		t.Errorf("unexpected actions read back; got %#v want %#v", got.ActionSpecs, want)
+	}
+}
+
+func Test_writeActionsNone(t *testing.T) {
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: t.TempDir(),
+	}
+	if err := b.writeActions(nil); err != nil {
+		t.Fatalf("cannot write actions: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(b.charmDir, "actions.yaml")); !os.IsNotExist(err) {
+		t.Errorf("actions.yaml written with no actions: %v", err)
+	}
+}
+
+func Test_compileArgsStamp(t *testing.T) {
+	defer func(old string) { gitCommit = old }(gitCommit)
+	gitCommit = "0123abcd-dirty"
+	args := compileArgs("runhook.go", "runhook")
+	want := []string{"build", "-o", "runhook", "-ldflags=-X main.gitCommit=0123abcd-dirty", "runhook.go"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected args; got %q want %q", args, want)
+	}
+}
+
+// stampedCharm holds the import path of the charm
+// used to test stamping the git commit.
+const stampedCharm = "github.com/mever/gocharm/v2/example-charms/do-nothing"
+
+func Test_registeredCharmInfoGitCommit(t *testing.T) {
+	defer func(old string) { gitCommit = old }(gitCommit)
+	gitCommit = "0123abcd-dirty"
+	info, err := registeredCharmInfo(testModule(t), stampedCharm, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GitCommit != gitCommit {
+		t.Errorf("unexpected git commit %q", info.GitCommit)
+	}
+}
+
+func Test_runhookVersion(t *testing.T) {
+	defer func(old string) { gitCommit = old }(gitCommit)
+	mod := testModule(t)
+	exe := filepath.Join(t.TempDir(), "runhook")
+	for _, commit := range []string{"", "0123abcd-dirty"} {
+		gitCommit = commit
+		if err := compile(mod, generateCode(hookMainCode, stampedCharm), exe); err != nil {
+			t.Fatalf("cannot build runhook: %v", err)
+		}
+		out, err := exec.Command(exe, "--version").Output()
+		if err != nil {
+			t.Fatalf("runhook --version failed: %v", err)
+		}
+		want := commit
+		if want == "" {
+			want = "unknown"
+		}
+		if got := strings.TrimSpace(string(out)); got != want {
+			t.Errorf("unexpected version; got %q want %q", got, want)
+		}
+	}
+}
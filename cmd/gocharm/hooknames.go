@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juju/charm/v9"
+	"github.com/juju/charm/v9/hooks"
+	"gopkg.in/errgo.v1"
+)
+
+// extraHookKinds holds the hook kinds that the hook package
+// can register but that are not included in hooks.UnitHooks.
+var extraHookKinds = []hooks.Kind{
+	hooks.Action,
+	"secret-rotate",
+	"secret-expired",
+}
+
+// maxHookSuggestions holds the maximum number of valid hook
+// names suggested for each unknown hook name.
+const maxHookSuggestions = 3
+
+// checkHookNames checks that all the hooks registered by the charm
+// are hooks that Juju can run: the unit hooks, the relation hooks
+// of the charm's relations and the pebble-ready hooks of its
+// workload containers. The error lists the unknown hooks, along
+// with the closest valid names for each.
+func checkHookNames(info *charmInfo) error {
+	valid := validHookNames(info.Meta)
+	isValid := make(map[string]bool)
+	for _, name := range valid {
+		isValid[name] = true
+	}
+	var unknown []string
+	for _, name := range info.Hooks {
+		if isValid[name] {
+			continue
+		}
+		msg := name
+		if closest := closestNames(name, valid, maxHookSuggestions); len(closest) > 0 {
+			msg += fmt.Sprintf(" (did you mean %s?)", strings.Join(closest, " or "))
+		}
+		unknown = append(unknown, msg)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return errgo.Newf("charm registers unknown hooks: %s", strings.Join(unknown, ", "))
+}
+
+// validHookNames returns the sorted names of all the
+// hooks that Juju can run for a charm with the given
+// metadata.
+func validHookNames(meta charm.Meta) []string {
+	var names []string
+	for _, kind := range append(hooks.UnitHooks(), extraHookKinds...) {
+		names = append(names, string(kind))
+	}
+	for _, rels := range []map[string]charm.Relation{meta.Provides, meta.Requires, meta.Peers} {
+		for relName := range rels {
+			for _, kind := range hooks.RelationHooks() {
+				names = append(names, relName+"-"+string(kind))
+			}
+		}
+	}
+	for container := range meta.Containers {
+		for _, kind := range hooks.WorkloadHooks() {
+			names = append(names, container+"-"+string(kind))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closestNames returns up to max of the given candidates that are
+// closest to name by edit distance, all at the same distance.
+// Candidates that are too different from name to be a plausible
+// misspelling of it are not included.
+func closestNames(name string, candidates []string, max int) []string {
+	best := len(name)/6 + 1
+	var names []string
+	for _, c := range candidates {
+		d := editDistance(name, c)
+		switch {
+		case d < best:
+			best = d
+			names = []string{c}
+		case d == best && len(names) < max:
+			names = append(names, c)
+		}
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance
+// between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(x int, ys ...int) int {
+	for _, y := range ys {
+		if y < x {
+			x = y
+		}
+	}
+	return x
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/juju/charm/v9"
+)
+
+var testHookMeta = charm.Meta{
+	Requires: map[string]charm.Relation{
+		"db": {Name: "db", Role: charm.RoleRequirer, Interface: "pgsql"},
+	},
+	Peers: map[string]charm.Relation{
+		"cluster": {Name: "cluster", Role: charm.RolePeer, Interface: "cluster"},
+	},
+	Containers: map[string]charm.Container{
+		"workload": {Resource: "workload-image"},
+	},
+}
+
+func Test_checkHookNames(t *testing.T) {
+	info := &charmInfo{
+		Hooks: []string{
+			"install",
+			"config-changed",
+			"leader-elected",
+			"secret-rotate",
+			"db-relation-joined",
+			"cluster-relation-departed",
+			"workload-pebble-ready",
+		},
+		Meta: testHookMeta,
+	}
+	if err := checkHookNames(info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_checkHookNamesUnknown(t *testing.T) {
+	info := &charmInfo{
+		Hooks: []string{
+			"install",
+			"dbb-relation-joined",
+			"website-relation-joined",
+			"other-pebble-ready",
+			"update-statuses",
+		},
+		Meta: testHookMeta,
+	}
+	err := checkHookNames(info)
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	want := "charm registers unknown hooks: " +
+		"dbb-relation-joined (did you mean db-relation-joined?), " +
+		"other-pebble-ready, " +
+		"update-statuses (did you mean update-status?), " +
+		"website-relation-joined"
+	if err.Error() != want {
+		t.Errorf("unexpected error; got %q want %q", err, want)
+	}
+}
+
+func Test_closestNames(t *testing.T) {
+	candidates := validHookNames(testHookMeta)
+	got := closestNames("db-relation-change", candidates, 3)
+	want := []string{"db-relation-changed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected closest names; got %q want %q", got, want)
+	}
+	// Names at the same distance are all included, up to the maximum.
+	got = closestNames("leader-sett", []string{"leader-get", "leader-set", "leader-setx", "leader-seta"}, 2)
+	want = []string{"leader-set", "leader-setx"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected closest names; got %q want %q", got, want)
+	}
+	if got := closestNames("frobnicate", candidates, 3); len(got) != 0 {
+		t.Errorf("unexpected closest names %q", got)
+	}
+}
@@ -4,25 +4,36 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/juju/charm/v9"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
 
 	"gopkg.in/errgo.v1"
 )
 
-func registeredCharmInfo(pkg, tempDir string) (*charmInfo, error) {
-	code := generateCode(inspectCode, pkg)
-	goFile := filepath.Join(tempDir, "inspect.go")
-	if err := ioutil.WriteFile(goFile, code, 0666); err != nil {
+// registeredCharmInfo returns the charm information registered by
+// the charm with the given module-qualified import path in the module
+// mod. It builds and runs a program that inspects the charm's
+// registry, using tempDir for the executable.
+func registeredCharmInfo(mod *goModule, pkg, tempDir string) (*charmInfo, error) {
+	goFile, remove, err := mod.tempPackage(generateCode(inspectCode, pkg))
+	if err != nil {
 		return nil, errgo.Notef(err, "cannot write hook inspection code")
 	}
+	defer remove()
 
 	inspectExe := filepath.Join(tempDir, "inspect")
-	if err := runCmd("",nil, "go", "build", "-o", inspectExe, goFile).Run(); err != nil {
+	// The inspect executable is run here, so it is always
+	// built for the host, whatever the target platform.
+	args := append([]string{"build", "-o", inspectExe}, tagsArgs()...)
+	if ldflags := stampLdflags(); len(ldflags) > 0 {
+		args = append(args, "-ldflags="+strings.Join(ldflags, " "))
+	}
+	args = append(args, goFile)
+	if err := runWithTimeout(runCmd(mod.Dir, moduleEnv(hostEnv(os.Environ())), "go", args...), "building inspect"); err != nil {
 		return nil, errgo.Notef(err, "cannot build hook inspection code")
 	}
 
@@ -30,7 +41,7 @@ func registeredCharmInfo(pkg, tempDir string) (*charmInfo, error) {
 	var buf bytes.Buffer
 	c.Stdout = &buf
 	c.Stderr = os.Stderr
-	if err := c.Run(); err != nil {
+	if err := runWithTimeout(c, "running inspect"); err != nil {
 		return nil, errgo.Notef(err, "failed to run inspect")
 	}
 	var out charmInfo
@@ -44,6 +55,7 @@ func registeredCharmInfo(pkg, tempDir string) (*charmInfo, error) {
 		log.Printf("registered hooks: %v", out.Hooks)
 		log.Printf("%d registered relations", len(out.Meta.Requires)+len(out.Meta.Provides)+len(out.Meta.Peers))
 		log.Printf("%d registered config options", len(out.Config))
+		log.Printf("%d registered actions", len(out.Actions))
 	}
 
 	return &out, nil
@@ -54,9 +66,20 @@ func registeredCharmInfo(pkg, tempDir string) (*charmInfo, error) {
 // Note that this must be kept in sync with the
 // version in inspectCode below.
 type charmInfo struct {
-	Hooks  []string
-	Config map[string]charm.Option
-	Meta   charm.Meta
+	Hooks   []string
+	Config  map[string]charm.Option
+	Meta    charm.Meta
+	Actions map[string]charm.ActionSpec
+
+	// GitCommit holds the git commit that the charm was built
+	// from, if the -stamp-git flag was given.
+	GitCommit string
+
+	// Assumes holds the registered assumes expressions in
+	// the form that they take in metadata.yaml. They are held
+	// separately because charm.Meta cannot represent nested
+	// expressions.
+	Assumes []interface{}
 }
 
 var inspectCode = template.Must(template.New("").Parse(`
@@ -77,25 +100,43 @@ import (
 // charmInfo must be kept in sync with the charmInfo
 // type above.
 type charmInfo struct {
-	Hooks  []string
-	Config map[string]charm.Option
-	Meta   charm.Meta
+	Hooks     []string
+	Config    map[string]charm.Option
+	Meta      charm.Meta
+	Actions   map[string]charm.ActionSpec
+	GitCommit string
+	Assumes   []hook.AssumesExpr
 }
 
+// gitCommit is set by gocharm -stamp-git.
+var gitCommit string
+
 func main() {
 	r := hook.NewRegistry()
 	inspect.RegisterHooks(r)
 	hook.RegisterMainHooks(r)
+	if err := r.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid charm: %v\n", err)
+		os.Exit(1)
+	}
 	info := charmInfo{
 		Hooks:	   r.RegisteredHooks(),
 		Config:	   r.RegisteredConfig(),
+		Actions:   r.RegisteredActions(),
+		GitCommit: gitCommit,
+		Assumes:   r.RegisteredAssumes(),
 	}
 
 	info.Meta.Summary = r.CharmInfo().Summary
 	info.Meta.Description = r.CharmInfo().Description
 	info.Meta.Resources = r.RegisteredResources()
+	info.Meta.Containers = r.RegisteredContainers()
+	info.Meta.Series = r.RegisteredSeries()
+	info.Meta.Tags = r.RegisteredTags()
+	info.Meta.Subordinate = r.IsSubordinate()
 	info.Meta.Provides = make(map[string]charm.Relation)
 	info.Meta.Requires = make(map[string]charm.Relation)
+	info.Meta.Peers = make(map[string]charm.Relation)
 	for name, rel := range r.RegisteredRelations() {
 		switch rel.Role {
 		case charm.RoleProvider:
@@ -7,6 +7,212 @@
 //
 //	  -repo="": charm repo directory (defaults to $JUJU_REPOSITORY)
 //	  -v=false: print information about charms being built
+//	  -release=false: strip debug information from the runhook binary
+//	  -watch=false: rebuild the charm whenever its Go source files change
+//	  -vet=false: run go vet on the charm before building it
+//	  -revision="": the charm revision to use (a number, or "git")
+//	  -static=false: build a statically linked runhook binary with cgo disabled
+//	  -dispatch=false: also generate a dispatch script for newer versions of Juju
+//	  -goos="linux": the operating system to build the runhook binary for
+//	  -goarch="amd64": the architecture to build the runhook binary for
+//	  -dry-run=false: print the changes that would be made to the charm directory without making them
+//	  -build-tags="": a comma-separated list of build tags to use when building the charm
+//	  -ldflags="": flags to pass to the linker when building the runhook binary
+//	  -build-timeout=5m0s: the maximum time allowed to build or run each of the charm's executables
+//	  -backup=false: keep backups of hooks that are removed or replaced
+//	  -allow-unknown-hooks=false: allow the charm to register hooks that Juju does not know about
+//	  -force=false: rebuild the runhook binary even if its inputs have not changed
+//	  -stamp-git=false: stamp the git commit of the charm's source into the runhook binary
+//
+// By default, the charm revision is one more than the revision found in
+// the destination directory, if any. The -revision flag sets it
+// explicitly instead: either to a non-negative integer, or, if the
+// flag is "git", to the number in the most recent git tag reachable
+// from the charm's package directory, which must be of the form "N"
+// or "vN". The revision is written to the charm's revision file.
+//
+// With the -vet flag, gocharm runs "go vet ./..." in the charm's
+// package directory before building anything, and fails, printing the
+// vet output, if any problems are reported.
+//
+// With the -dry-run flag, gocharm builds the charm in a temporary
+// directory as usual, including compiling the charm to find its
+// registered hooks, but instead of replacing the charm directory it
+// prints the changes that would be made to it, such as "would create
+// hook install" or "would remove hand-edited hook upgrade-charm", and
+// leaves it untouched. A hook is described as hand-edited if its
+// contents are not a stub generated by gocharm. Files that gocharm
+// would refuse to remove or replace are printed as well, and gocharm
+// then exits with an error.
+//
+// The -build-tags flag passes the given comma-separated build tags
+// to the go command, both when building the runhook binary and when
+// building the code that inspects the charm's registered hooks, so
+// that the hooks found by inspection are those that the charm
+// registers when it runs. The -ldflags flag passes the given flags
+// to the linker when building the runhook binary, after those added
+// by the -release and -static flags; it can be used, for example, to
+// set a version string with -X. Neither flag may contain shell
+// metacharacters.
+//
+// The -build-timeout flag limits the time taken by each of the steps
+// that build the runhook binary, build the code that gocharm runs to
+// inspect the charm's registered hooks, and run that code. A step
+// that takes longer is killed, along with any processes it has
+// started, and gocharm fails with an error naming the step. A zero
+// duration means that there is no limit.
+//
+// With the -backup flag, gocharm copies each hook in the charm
+// directory that would be removed or replaced with different contents
+// to a file with the same name and a ".gocharm.bak" suffix in the
+// hooks directory, so that a hook can be recovered if it was replaced
+// by mistake. Earlier backups of the same hook are rotated to
+// ".gocharm.bak.1" and ".gocharm.bak.2" rather than overwritten; older
+// ones are discarded. Backups are removed when the charm is built
+// without the -backup flag.
+//
+// Gocharm fails if the charm registers a hook that Juju cannot run,
+// such as a relation hook for a relation that the charm does not
+// register, printing the unknown hook names along with the closest
+// valid names. The valid names are the unit hooks, such as "install"
+// and "config-changed", the relation hooks of the charm's relations,
+// such as "db-relation-joined", and the pebble-ready hooks of its
+// workload containers. The -allow-unknown-hooks flag disables the
+// check, for hooks added by newer versions of Juju.
+//
+// With the -stamp-git flag, gocharm finds the git commit checked out
+// in the charm's package directory, with "-dirty" appended if the
+// working tree has uncommitted changes, and stamps it into the runhook
+// binary, which prints it when run as "runhook --version". The commit
+// is also recorded, along with the charm's registered hooks, in the
+// information that gocharm gathers by inspecting the charm.
+//
+// Gocharm records the inputs to the build of the runhook binary in a
+// manifest kept in the user's cache directory, outside the charm: a
+// hash of each Go source file in the charm's module, excluding tests,
+// and of its go.mod and go.sum files, and likewise for any local
+// directory that a replace directive in go.mod points to, along with
+// the hash of the binary and the build settings, including the target
+// platform and the -release, -static, -build-tags and -ldflags flags.
+// When the charm is built again and none of those has changed, the
+// runhook binary and the hook information recorded with it are reused
+// rather than built again, so that changes that do not affect the Go
+// code, such as edits to README.md or a hand-written metadata.yaml,
+// are quick to apply. The -force flag always rebuilds the binary;
+// it can be used, for example, after upgrading the Go toolchain or a
+// dependency that is outside the charm's module.
+//
+// With the -watch flag, gocharm builds the charm and then keeps running,
+// rebuilding it each time a Go source file in the charm's package
+// directory changes, until it is interrupted.
+//
+// The -release flag builds the runhook binary with -ldflags="-s -w",
+// omitting the symbol table and DWARF debugging information, which
+// makes the binary considerably smaller. Panics still print Go stack
+// traces with file and line information, but the binary can no longer
+// be inspected with a debugger such as delve or gdb, and profiles
+// cannot be symbolized from it.
+//
+// The -static flag builds the runhook binary with CGO_ENABLED=0, and
+// asks the linker not to link dynamically, so that the charm can run
+// in minimal environments that lack a C library. Gocharm fails before
+// building anything if the charm depends on a non-standard package
+// that requires cgo.
+//
+// The -dispatch flag generates an executable dispatch file at the top
+// level of the charm as well as the per-hook scripts in the hooks
+// directory. Versions of Juju that support dispatch run that file for
+// every hook in preference to the per-hook scripts, with the path of
+// the hook that would otherwise have run (for example
+// "hooks/install") in $JUJU_DISPATCH_PATH; the script runs runhook
+// with the hook name taken from the last element of that path. Older
+// versions of Juju ignore the file and continue to use the per-hook
+// scripts.
+//
+// The runhook binary is built for the operating system and
+// architecture given by the -goos and -goarch flags, which default to
+// linux and amd64, so a charm can be built on a different platform
+// from the units it is deployed to. Cgo cannot be used when cross
+// compiling, so when the target platform differs from the host,
+// runhook is built with CGO_ENABLED=0, as with the -static flag.
+// The code that gocharm runs to inspect the charm's registered hooks
+// is always built for the host. When -goos is windows, the binary is
+// named runhook.exe and each hook is written as a batch file with a
+// .cmd extension that runs %CHARM_DIR%\bin\runhook.exe; any hook
+// scripts previously written for another operating system are
+// removed.
+//
+// The charm's package must be part of a Go module: gocharm looks for
+// a go.mod file in the package directory and then in each of its
+// parents. The runhook binary and the inspection code are built in
+// module mode from temporary packages inside that module, which
+// import the charm by its module-qualified import path, so the
+// module's requirements, replace directives and vendor directory all
+// apply. The generated runhook source is kept in $charmdir/src/runhook
+// for reference.
+//
+// Gocharm also supports the following subcommands:
+//
+//	gocharm clean [-v] dir
+//
+// The clean subcommand removes the files that gocharm generated from
+// the given charm directory: the runhook binary and the source it was
+// built from, the autogenerated metadata.yaml, config.yaml and
+// actions.yaml files, and the hook stubs and dispatch script, but only
+// those whose contents are still exactly as gocharm wrote them, so
+// customized hooks are never removed. Directories left empty are removed too.
+// With the -v flag, each removed file is printed.
+//
+//	gocharm defaults -from file [-repo dir] [package]
+//
+// The defaults subcommand sets the default values of configuration
+// options in the config.yaml of a charm that has already been built,
+// taking them from the given YAML file, which should hold a map from
+// option name to value. It fails if any key in the file does not name
+// an option declared by the charm, or if any value is not valid for
+// its option.
+//
+//	gocharm diff [-repo dir] [-v] [package]
+//
+// The diff subcommand generates the metadata.yaml, config.yaml and
+// actions.yaml files for the charm and prints a unified diff between them and
+// the files in the charm's directory in the repository, without
+// changing anything. It exits with a non-zero status if they
+// differ, so it can be used to check that a built charm is up to
+// date, or to see how a code change affects the charm's metadata.
+//
+//	gocharm relation -schema file [-v] [package]
+//
+// The relation subcommand generates typed accessors for the settings
+// of a relation interface, so that relation settings are accessed
+// with compile-time checking rather than by name. The schema file
+// holds YAML of the following form:
+//
+//	interface: http
+//	type: WebsiteRelation
+//	settings:
+//	  hostname: string
+//	  port: int
+//
+// Each setting has the type string, int, bool or float. The type
+// field is optional; by default the Go type is named after the
+// interface (HttpRelation in this case). The generated code is
+// written to the package directory in a file named after the
+// interface (http_relation.go in this case). For each setting, it
+// has a method to read the remote unit's value, such as
+// RemoteHostname or RemotePort, and a method to set the local unit's
+// value, such as SetHostname or SetPort. Values that are not strings
+// are parsed when read, so their methods also return an error.
+//
+//	gocharm test [-repo dir] [-v] [package] [-- go test flags]
+//
+// The test subcommand builds the charm as gocharm does by default,
+// then runs "go test ./..." in the charm's package directory, passing
+// it any flags given after "--". The tests run with $CHARM_DIR set to
+// the directory of the built charm and $JUJU_REPOSITORY set to the
+// charm repository, so that they can find the charm's files. The go
+// command's build cache is shared by both steps, so only packages
+// that have changed are rebuilt.
 //
 // In order to qualify as a charm, a Go package must implement
 // a RegisterHooks function with the following signature:
@@ -24,9 +230,21 @@
 // If there is a file named README.md, a copy of it will be
 // created in $charmdir.
 //
-// The charm binary will be installed into $charmdir/bin/runhook.
+// The charm binary will be installed into $charmdir/bin/runhook
+// ($charmdir/bin/runhook.exe when building for Windows).
 // A $charmdir/config.yaml file will be created containing
-// all registered charm configuration options.
+// all registered charm configuration options. If the package
+// directory holds a config.yaml file, any options declared there
+// that are not registered are also included, with a warning.
+// A $charmdir/metadata.yaml file will be created containing
+// all registered relations and assumes expressions. If the package directory holds a
+// metadata.yaml file, its name, summary, maintainer and relation
+// fields are merged into the generated file; it is an error for
+// a relation declared there to have a different interface from
+// the registered relation of the same name.
+// If the charm registers any actions, a $charmdir/actions.yaml
+// file will be created containing them, with the JSON schema of
+// each action's parameters.
 // A hooks directory will be created containing an entry
 // for each registered hook.
 package main
@@ -41,19 +259,38 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/juju/utils/fs"
 	"gopkg.in/errgo.v1"
 )
 
 var (
-	repo    = flag.String("repo", "", "charm repo directory (defaults to $JUJU_REPOSITORY)")
-	verbose = flag.Bool("v", false, "print information about charms being built")
-	keep    = flag.Bool("keep", false, "do not delete temporary files")
+	repo              = flag.String("repo", "", "charm repo directory (defaults to $JUJU_REPOSITORY)")
+	verbose           = flag.Bool("v", false, "print information about charms being built")
+	keep              = flag.Bool("keep", false, "do not delete temporary files")
+	release           = flag.Bool("release", false, "strip debug information from the runhook binary")
+	watch             = flag.Bool("watch", false, "rebuild the charm whenever its Go source files change")
+	vet               = flag.Bool("vet", false, "run go vet on the charm before building it")
+	revision          = flag.String("revision", "", `the charm revision to use (a number, or "git")`)
+	static            = flag.Bool("static", false, "build a statically linked runhook binary with cgo disabled")
+	dispatch          = flag.Bool("dispatch", false, "also generate a dispatch script for newer versions of Juju")
+	goos              = flag.String("goos", "linux", "the operating system to build the runhook binary for")
+	goarch            = flag.String("goarch", "amd64", "the architecture to build the runhook binary for")
+	dryRun            = flag.Bool("dry-run", false, "print the changes that would be made to the charm directory without making them")
+	buildTags         = flag.String("build-tags", "", "a comma-separated list of build tags to use when building the charm")
+	buildLdflags      = flag.String("ldflags", "", "flags to pass to the linker when building the runhook binary")
+	buildTimeout      = flag.Duration("build-timeout", 5*time.Minute, "the maximum time allowed to build or run each of the charm's executables")
+	backup            = flag.Bool("backup", false, "keep backups of hooks that are removed or replaced")
+	allowUnknownHooks = flag.Bool("allow-unknown-hooks", false, "allow the charm to register hooks that Juju does not know about")
+	force             = flag.Bool("force", false, "rebuild the runhook binary even if its inputs have not changed")
+	stampGit          = flag.Bool("stamp-git", false, "stamp the git commit of the charm's source into the runhook binary")
 )
 
 func main() {
@@ -62,12 +299,18 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
-	flag.Parse()
-	if *repo == "" {
-		if *repo = os.Getenv("JUJU_REPOSITORY"); *repo == "" {
-			fatalf("JUJU_REPOSITORY environment variable not set")
+	if len(os.Args) > 1 {
+		if cmd := subcommands[os.Args[1]]; cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				fatalf("%v", err)
+			}
+			return
 		}
 	}
+	flag.Parse()
+	if err := setRepo(); err != nil {
+		fatalf("%v", err)
+	}
 	var pkgPath string
 	switch flag.NArg() {
 	case 0:
@@ -77,37 +320,116 @@ func main() {
 	default:
 		flag.Usage()
 	}
+	if *watch {
+		if err := watchMain(pkgPath); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
 	if err := main1(pkgPath); err != nil {
 		fatalf("%v", err)
 	}
 }
 
+// subcommands holds the gocharm subcommands, keyed by name. If the
+// first argument to gocharm names a subcommand, the subcommand is run
+// with the remaining arguments; otherwise gocharm builds a charm.
+var subcommands = map[string]func(args []string) error{
+	"clean":    cleanMain,
+	"defaults": defaultsMain,
+	"diff":     diffMain,
+	"relation": relationMain,
+	"test":     testMain,
+}
+
+// setRepo sets the -repo flag from $JUJU_REPOSITORY
+// if it has not been set explicitly.
+func setRepo() error {
+	if *repo == "" {
+		if *repo = os.Getenv("JUJU_REPOSITORY"); *repo == "" {
+			return errgo.New("JUJU_REPOSITORY environment variable not set")
+		}
+	}
+	return nil
+}
+
+// packageDir returns the source directory
+// of the package with the given path.
+func packageDir(pkgPath string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", errgo.Notef(err, "cannot get current directory")
+	}
+	pkg, err := build.Default.Import(pkgPath, cwd, build.FindOnly)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot find %q", pkgPath)
+	}
+	return pkg.Dir, nil
+}
+
+// watchMain builds the charm in pkgPath every time
+// its source changes, until interrupted.
+func watchMain(pkgPath string) error {
+	dir, err := packageDir(pkgPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	stop := make(chan struct{})
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		<-sigc
+		close(stop)
+	}()
+	return watchAndBuild(dir, watchDebounce, func() error {
+		return main1(pkgPath)
+	}, stop)
+}
+
 func main1(pkgPath string) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return errgo.Notef(err, "cannot get current directory")
 	}
+	if err := checkBuildFlags(); err != nil {
+		return errgo.Mask(err)
+	}
 	// Ensure that the package and all its dependencies are
 	// installed before generating anything. This ensures
 	// that we can generate the binary quickly, and that
 	// it will be in sync with any package that have uninstalled
 	// changes.
-	if err := runCmd("", nil, "go", "install", pkgPath).Run(); err != nil {
+	installArgs := append(append([]string{"install"}, tagsArgs()...), pkgPath)
+	if err := runCmd("", nil, "go", installArgs...).Run(); err != nil {
 		return errgo.Notef(err, "cannot install %q", pkgPath)
 	}
 	pkg, err := build.Default.Import(pkgPath, cwd, 0)
 	if err != nil {
 		return errgo.Notef(err, "cannot import %q", pkgPath)
 	}
+	if *vet {
+		if err := vetCharm(pkg.Dir); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if *static {
+		if err := checkNoCgo(pkg.Dir); err != nil {
+			return errgo.Mask(err)
+		}
+	}
 	charmName := path.Base(pkg.Dir)
 	dest := filepath.Join(*repo, charmName)
 
-	if _, err := canClean(dest); err != nil {
-		return errgo.Notef(err, "cannot clean destination directory")
+	// In dry-run mode, files that cannot be cleaned
+	// are reported along with the other changes.
+	if !*dryRun {
+		if _, err := canClean(dest); err != nil {
+			return errgo.Notef(err, "cannot clean destination directory")
+		}
 	}
-	rev, err := readRevision(dest)
+	rev, err := charmRevision(*revision, pkg.Dir, dest)
 	if err != nil {
-		return errgo.Notef(err, "cannot read revision")
+		return errgo.Mask(err)
 	}
 
 	// We put everything into a directory in /tmp first,
@@ -127,6 +449,7 @@ func main1(pkgPath string) error {
 		pkg:      pkg,
 		charmDir: tempCharmDir,
 		tempDir:  tempDir,
+		prevDir:  dest,
 	}); err != nil {
 		return errgo.Mask(err)
 	}
@@ -134,13 +457,24 @@ func main1(pkgPath string) error {
 	// The local revision number should not matter, but
 	// there is a bug in juju that means that the charm
 	// will not be correctly uploaded if it is not there, so we
-	// preserve the revision found in the destination directory.
+	// preserve the revision found in the destination directory
+	// unless it has been specified explicitly.
 	if rev != -1 {
-		rev++
 		if err := writeRevision(tempCharmDir, rev); err != nil {
 			return errgo.Notef(err, "cannot write revision file")
 		}
 	}
+	if err := keepCustomizedHooks(dest, tempCharmDir); err != nil {
+		return errgo.Notef(err, "cannot keep customized hooks")
+	}
+	if *backup {
+		if err := backupHooks(dest, tempCharmDir); err != nil {
+			return errgo.Notef(err, "cannot back up hooks")
+		}
+	}
+	if *dryRun {
+		return errgo.Mask(planCharmChanges(os.Stdout, dest, tempCharmDir), errgo.Is(errRefused))
+	}
 	if err := cleanDestination(dest); err != nil {
 		return errgo.Mask(err)
 	}
@@ -188,8 +522,10 @@ var allowed = map[string]bool{
 	"assets":           true,
 	"bin":              true,
 	"compile":          true,
+	"actions.yaml":     true,
 	"config.yaml":      true,
 	"dependencies.tsv": true,
+	"dispatch":         true,
 	"hooks":            true,
 	"metadata.yaml":    true,
 	"pkg":              true, // This allows us to test the compile scripts in the charm dir.
@@ -236,6 +572,94 @@ func autogenerated(path string) bool {
 	return bytes.Equal(buf, []byte(yamlAutogenComment))
 }
 
+// charmRevision returns the revision to write to the charm, or -1
+// if no revision file should be written. The flag holds the value
+// of the -revision flag, pkgDir holds the charm's source directory
+// and dest holds the destination charm directory.
+func charmRevision(flagVal, pkgDir, dest string) (int, error) {
+	switch flagVal {
+	case "":
+		rev, err := readRevision(dest)
+		if err != nil {
+			return 0, errgo.Notef(err, "cannot read revision")
+		}
+		if rev != -1 {
+			rev++
+		}
+		return rev, nil
+	case "git":
+		rev, err := gitRevision(pkgDir)
+		if err != nil {
+			return 0, errgo.Notef(err, "cannot derive revision from git")
+		}
+		return rev, nil
+	}
+	rev, err := strconv.Atoi(flagVal)
+	if err != nil || rev < 0 {
+		return 0, errgo.Newf("invalid revision %q: must be a non-negative integer", flagVal)
+	}
+	return rev, nil
+}
+
+// gitRevision returns the revision number held in the most recent
+// git tag reachable from the current revision in dir.
+func gitRevision(dir string) (int, error) {
+	c := runCmd(dir, nil, "git", "describe", "--tags", "--abbrev=0")
+	c.Stdout = nil
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return 0, errgo.Newf("git describe failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	tag := strings.TrimSpace(string(out))
+	rev, err := strconv.Atoi(strings.TrimPrefix(tag, "v"))
+	if err != nil || rev < 0 {
+		return 0, errgo.Newf("tag %q is not a revision number", tag)
+	}
+	return rev, nil
+}
+
+// validCommit matches the output of git rev-parse HEAD.
+var validCommit = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// charmGitCommit returns the git commit checked out in the working
+// tree that holds dir, with "-dirty" appended if the tree has
+// uncommitted changes.
+func charmGitCommit(dir string) (string, error) {
+	out, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	commit := strings.TrimSpace(string(out))
+	if !validCommit.MatchString(commit) {
+		return "", errgo.Newf("unexpected commit %q from git rev-parse", commit)
+	}
+	status, err := gitOutput(dir, "status", "--porcelain")
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if len(bytes.TrimSpace(status)) > 0 {
+		commit += "-dirty"
+	}
+	return commit, nil
+}
+
+// gitOutput runs git with the given arguments in dir and returns
+// its standard output. It is a variable so that it can be
+// replaced in tests.
+var gitOutput = func(dir string, args ...string) ([]byte, error) {
+	c := runCmd(dir, nil, "git", args...)
+	c.Stdout = nil
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, errgo.Newf("git %s failed: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
 func readRevision(charmDir string) (int, error) {
 	p := revisionPath(charmDir)
 	data, err := ioutil.ReadFile(p)
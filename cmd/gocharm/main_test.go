@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_charmRevisionExplicit(t *testing.T) {
+	rev, err := charmRevision("42", t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 42 {
+		t.Errorf("unexpected revision %d", rev)
+	}
+	for _, bad := range []string{"-1", "1.5", "latest"} {
+		if _, err := charmRevision(bad, t.TempDir(), t.TempDir()); err == nil {
+			t.Errorf("expected error for revision %q", bad)
+		}
+	}
+}
+
+func Test_charmRevisionFromDestination(t *testing.T) {
+	dest := t.TempDir()
+	rev, err := charmRevision("", t.TempDir(), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != -1 {
+		t.Errorf("unexpected revision %d with no revision file", rev)
+	}
+	if err := writeRevision(dest, 6); err != nil {
+		t.Fatal(err)
+	}
+	rev, err = charmRevision("", t.TempDir(), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 7 {
+		t.Errorf("unexpected revision %d", rev)
+	}
+}
+
+func Test_charmRevisionFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		c := exec.Command("git", args...)
+		c.Dir = dir
+		c.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, "charm.go"), []byte("package charm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "charm.go")
+	git("commit", "-q", "-m", "initial")
+
+	if _, err := charmRevision("git", dir, t.TempDir()); err == nil {
+		t.Errorf("expected error with no tags")
+	}
+	git("tag", "v12")
+	rev, err := charmRevision("git", dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 12 {
+		t.Errorf("unexpected revision %d", rev)
+	}
+	git("tag", "-a", "-m", "release", "release-13")
+	if _, err := charmRevision("git", dir, t.TempDir()); err == nil {
+		t.Errorf("expected error for non-numeric tag")
+	}
+}
+
+// fakeGit returns a replacement for gitOutput that reports
+// the given commit and working tree status, recording
+// the commands run in *calls.
+func fakeGit(commit, status string, calls *[]string) func(string, ...string) ([]byte, error) {
+	return func(dir string, args ...string) ([]byte, error) {
+		*calls = append(*calls, dir+": git "+strings.Join(args, " "))
+		switch args[0] {
+		case "rev-parse":
+			return []byte(commit + "\n"), nil
+		case "status":
+			return []byte(status), nil
+		}
+		return nil, fmt.Errorf("unexpected git command %q", args)
+	}
+}
+
+var charmGitCommitTests = []struct {
+	about       string
+	commit      string
+	status      string
+	expect      string
+	expectError string
+}{{
+	about:  "clean tree",
+	commit: "0123456789abcdef0123456789abcdef01234567",
+	expect: "0123456789abcdef0123456789abcdef01234567",
+}, {
+	about:  "dirty tree",
+	commit: "0123456789abcdef0123456789abcdef01234567",
+	status: " M charm.go\n?? notes.txt\n",
+	expect: "0123456789abcdef0123456789abcdef01234567-dirty",
+}, {
+	about:       "unexpected output",
+	commit:      "HEAD",
+	expectError: `unexpected commit "HEAD" from git rev-parse`,
+}}
+
+func Test_charmGitCommit(t *testing.T) {
+	defer func(old func(string, ...string) ([]byte, error)) { gitOutput = old }(gitOutput)
+	for _, test := range charmGitCommitTests {
+		var calls []string
+		gitOutput = fakeGit(test.commit, test.status, &calls)
+		commit, err := charmGitCommit("/src/mycharm")
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("%s: unexpected error; got %v want %q", test.about, err, test.expectError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.about, err)
+			continue
+		}
+		if commit != test.expect {
+			t.Errorf("%s: unexpected commit; got %q want %q", test.about, commit, test.expect)
+		}
+		if want := []string{"/src/mycharm: git rev-parse HEAD", "/src/mycharm: git status --porcelain"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("%s: unexpected git calls; got %q want %q", test.about, calls, want)
+		}
+	}
+}
+
+func Test_main1KeepsCustomizedHooks(t *testing.T) {
+	defer func(old string) { *repo = old }(*repo)
+	defer func(old string) { *goos = old }(*goos)
+	*repo = t.TempDir()
+	hookDir := filepath.Join(*repo, "do-nothing", "hooks")
+	readHooks := func() []string {
+		names, err := readDirNames(hookDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	*goos = "linux"
+	if err := main1(stampedCharm); err != nil {
+		t.Fatalf("cannot build charm: %v", err)
+	}
+	if names := readHooks(); !containsString(names, "install") {
+		t.Fatalf("install hook not written; got %q", names)
+	}
+	custom := "#!/bin/sh\necho collecting\n"
+	if err := os.WriteFile(filepath.Join(hookDir, "collect-metrics"), []byte(custom), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebuilding for Windows removes the shell stubs, but
+	// keeps the hook that has been customized.
+	*goos = "windows"
+	if err := main1(stampedCharm); err != nil {
+		t.Fatalf("cannot rebuild charm: %v", err)
+	}
+	names := readHooks()
+	for _, name := range names {
+		if name != "collect-metrics" && !strings.HasSuffix(name, windowsHookExt) {
+			t.Errorf("stale hook %s not removed", name)
+		}
+	}
+	if !containsString(names, "install.cmd") {
+		t.Errorf("install.cmd not written; got %q", names)
+	}
+	data, err := os.ReadFile(filepath.Join(hookDir, "collect-metrics"))
+	if err != nil {
+		t.Fatalf("customized hook removed: %v", err)
+	}
+	if string(data) != custom {
+		t.Errorf("customized hook changed; got %q want %q", data, custom)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/juju/charm/v9"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// userMeta holds the fields of a hand-written metadata.yaml
+// file in the charm's source directory that are merged
+// into the generated metadata.
+type userMeta struct {
+	Name        string                 `yaml:"name"`
+	Summary     string                 `yaml:"summary"`
+	Maintainer  string                 `yaml:"maintainer"`
+	Maintainers []string               `yaml:"maintainers"`
+	Provides    map[string]interface{} `yaml:"provides"`
+	Requires    map[string]interface{} `yaml:"requires"`
+	Peers       map[string]interface{} `yaml:"peers"`
+}
+
+// readUserMeta reads the metadata.yaml file at the given path.
+// It returns a nil userMeta if the file does not exist.
+func readUserMeta(path string) (*userMeta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	var m userMeta
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, errgo.Notef(err, "cannot parse %s", path)
+	}
+	return &m, nil
+}
+
+// mergeMeta merges the relations and the name and summary
+// fields declared in um into meta. It returns an error if
+// a relation registered in meta is declared in um with
+// a different interface or role.
+func mergeMeta(meta *charm.Meta, um *userMeta) error {
+	if um.Name != "" && um.Name != meta.Name {
+		return errgo.Newf("metadata name %q does not match charm directory name %q", um.Name, meta.Name)
+	}
+	if um.Summary != "" {
+		meta.Summary = um.Summary
+	}
+	sections := []struct {
+		role charm.RelationRole
+		rels map[string]interface{}
+		dest *map[string]charm.Relation
+	}{
+		{charm.RoleProvider, um.Provides, &meta.Provides},
+		{charm.RoleRequirer, um.Requires, &meta.Requires},
+		{charm.RolePeer, um.Peers, &meta.Peers},
+	}
+	registered := make(map[string]charm.Relation)
+	for _, s := range sections {
+		for name, rel := range *s.dest {
+			registered[name] = rel
+		}
+	}
+	for _, s := range sections {
+		for name, val := range s.rels {
+			declared, err := parseRelation(name, s.role, val)
+			if err != nil {
+				return errgo.Notef(err, "bad relation %q in metadata.yaml", name)
+			}
+			if rel, ok := registered[name]; ok {
+				if rel.Interface != declared.Interface {
+					return errgo.Newf("relation %q registered with interface %q but declared in metadata.yaml with interface %q", name, rel.Interface, declared.Interface)
+				}
+				if rel.Role != declared.Role {
+					return errgo.Newf("relation %q registered with role %q but declared in metadata.yaml with role %q", name, rel.Role, declared.Role)
+				}
+				continue
+			}
+			if *s.dest == nil {
+				*s.dest = make(map[string]charm.Relation)
+			}
+			(*s.dest)[name] = declared
+		}
+	}
+	return nil
+}
+
+// parseRelation parses a relation declared in metadata.yaml,
+// which may be either the interface name or a map holding
+// "interface" and optional "scope" keys.
+func parseRelation(name string, role charm.RelationRole, val interface{}) (charm.Relation, error) {
+	rel := charm.Relation{
+		Name:  name,
+		Role:  role,
+		Scope: charm.ScopeGlobal,
+	}
+	switch val := val.(type) {
+	case string:
+		rel.Interface = val
+	case map[interface{}]interface{}:
+		rel.Interface, _ = val["interface"].(string)
+		if scope, ok := val["scope"].(string); ok {
+			rel.Scope = charm.RelationScope(scope)
+		}
+	}
+	if rel.Interface == "" {
+		return charm.Relation{}, errgo.New("no interface specified")
+	}
+	return rel, nil
+}
+
+// maintainerFields returns the maintainer fields of um
+// in a form suitable for appending to the generated metadata.
+func (um *userMeta) maintainerFields() yaml.MapSlice {
+	var fields yaml.MapSlice
+	if um.Maintainer != "" {
+		fields = append(fields, yaml.MapItem{Key: "maintainer", Value: um.Maintainer})
+	}
+	if len(um.Maintainers) > 0 {
+		fields = append(fields, yaml.MapItem{Key: "maintainers", Value: um.Maintainers})
+	}
+	return fields
+}
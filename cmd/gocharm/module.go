@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// goModule holds the Go module that a charm is built from.
+type goModule struct {
+	// Dir holds the root directory of the module,
+	// the one holding its go.mod file.
+	Dir string
+
+	// Path holds the module path declared in go.mod.
+	Path string
+}
+
+// findModule returns the module holding the package in the directory
+// dir, found by looking for a go.mod file in dir and then in each of
+// its parent directories in turn.
+func findModule(dir string) (*goModule, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for d := dir; ; {
+		data, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			modPath := parseModulePath(data)
+			if modPath == "" {
+				return nil, errgo.Newf("no module path found in %s", filepath.Join(d, "go.mod"))
+			}
+			return &goModule{
+				Dir:  d,
+				Path: modPath,
+			}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, errgo.Mask(err)
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return nil, errgo.Newf("no go.mod file found in %s or any parent directory", dir)
+		}
+		d = parent
+	}
+}
+
+// parseModulePath returns the module path declared by the module
+// directive in the given go.mod file contents, or the empty string
+// if there is none.
+func parseModulePath(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[0:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if modPath, err := strconv.Unquote(fields[1]); err == nil {
+			return modPath
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// parseLocalReplaces returns the directories named as the targets of
+// replace directives in the given go.mod file contents that point to
+// local directories rather than to module versions, in the order that
+// they appear. Relative directories are returned as written, relative
+// to the directory holding the go.mod file.
+func parseLocalReplaces(data []byte) []string {
+	var dirs []string
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[0:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case inBlock:
+		case fields[0] != "replace":
+			continue
+		case len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		default:
+			fields = fields[1:]
+		}
+		// The target is the path following "=>"; it is
+		// a local directory when no version follows it.
+		for i, f := range fields {
+			if f != "=>" || i != len(fields)-2 {
+				continue
+			}
+			target := fields[i+1]
+			if t, err := strconv.Unquote(target); err == nil {
+				target = t
+			}
+			if isLocalReplace(target) {
+				dirs = append(dirs, target)
+			}
+		}
+	}
+	return dirs
+}
+
+// isLocalReplace reports whether the target of a replace
+// directive is a local directory, which the go command
+// recognizes by a leading ./ or ../ or an absolute path.
+func isLocalReplace(target string) bool {
+	return filepath.IsAbs(target) ||
+		target == "." || target == ".." ||
+		strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../") ||
+		strings.HasPrefix(target, `.\`) || strings.HasPrefix(target, `..\`)
+}
+
+// importPath returns the module-qualified import path
+// of the package in the directory dir within m.
+func (m *goModule) importPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	rel, err := filepath.Rel(m.Dir, dir)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if rel == "." {
+		return m.Path, nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errgo.Newf("%s is outside module %s", dir, m.Path)
+	}
+	return path.Join(m.Path, filepath.ToSlash(rel)), nil
+}
+
+// tempPackage writes the given code as the main package in a new
+// temporary directory inside m, so that when it is built with the go
+// command run in m.Dir, its imports are resolved by m's go.mod file,
+// including any replace directives and vendored packages. The
+// directory is hidden, so that the go command does not match it with
+// "./..." and watch mode does not rebuild when it appears. It returns
+// the path to the Go file and a function that removes the directory.
+func (m *goModule) tempPackage(code []byte) (goFile string, remove func(), err error) {
+	dir, err := ioutil.TempDir(m.Dir, ".gocharm-build")
+	if err != nil {
+		return "", nil, errgo.Notef(err, "cannot make temporary package")
+	}
+	remove = func() {
+		os.RemoveAll(dir)
+	}
+	goFile = filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(goFile, code, 0666); err != nil {
+		remove()
+		return "", nil, errgo.Mask(err)
+	}
+	return goFile, remove, nil
+}
+
+// moduleEnv returns the given environment modified so
+// that the go command runs in module mode.
+func moduleEnv(env []string) []string {
+	return setenv(env, "GO111MODULE=on")
+}
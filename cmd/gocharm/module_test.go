@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var parseModulePathTests = []struct {
+	about  string
+	goMod  string
+	expect string
+}{{
+	about:  "plain module directive",
+	goMod:  "module example.com/foo\n\ngo 1.16\n",
+	expect: "example.com/foo",
+}, {
+	about:  "quoted path with comments",
+	goMod:  "// The foo module.\nmodule \"example.com/foo/v2\" // major version 2\n",
+	expect: "example.com/foo/v2",
+}, {
+	about:  "no module directive",
+	goMod:  "go 1.16\n",
+	expect: "",
+}}
+
+func Test_parseModulePath(t *testing.T) {
+	for _, test := range parseModulePathTests {
+		if got := parseModulePath([]byte(test.goMod)); got != test.expect {
+			t.Errorf("%s: got %q want %q", test.about, got, test.expect)
+		}
+	}
+}
+
+func Test_findModule(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":                 "module example.com/foo\n",
+		"charms/bar/bar.go":      "package bar\n",
+		"charms/baz/go.mod":      "module example.com/baz\n",
+		"charms/baz/hooks/hooks": "",
+	})
+	for _, test := range []struct {
+		dir        string
+		expectDir  string
+		expectPath string
+		expectPkg  string
+	}{{
+		dir:        root,
+		expectDir:  root,
+		expectPath: "example.com/foo",
+		expectPkg:  "example.com/foo",
+	}, {
+		dir:        filepath.Join(root, "charms", "bar"),
+		expectDir:  root,
+		expectPath: "example.com/foo",
+		expectPkg:  "example.com/foo/charms/bar",
+	}, {
+		dir:        filepath.Join(root, "charms", "baz", "hooks"),
+		expectDir:  filepath.Join(root, "charms", "baz"),
+		expectPath: "example.com/baz",
+		expectPkg:  "example.com/baz/hooks",
+	}} {
+		mod, err := findModule(test.dir)
+		if err != nil {
+			t.Fatalf("cannot find module for %s: %v", test.dir, err)
+		}
+		if mod.Dir != test.expectDir || mod.Path != test.expectPath {
+			t.Errorf("unexpected module for %s; got %+v", test.dir, mod)
+		}
+		pkg, err := mod.importPath(test.dir)
+		if err != nil {
+			t.Fatalf("cannot get import path of %s: %v", test.dir, err)
+		}
+		if pkg != test.expectPkg {
+			t.Errorf("unexpected import path for %s; got %q want %q", test.dir, pkg, test.expectPkg)
+		}
+	}
+}
+
+func Test_findModuleNotFound(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "go.mod")); err == nil {
+		t.Skip("temporary directory is inside a module")
+	}
+	_, err := findModule(dir)
+	if err == nil || err.Error() != "no go.mod file found in "+dir+" or any parent directory" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_importPathOutsideModule(t *testing.T) {
+	mod := &goModule{
+		Dir:  filepath.Join(t.TempDir(), "foo"),
+		Path: "example.com/foo",
+	}
+	other := filepath.Join(filepath.Dir(mod.Dir), "other")
+	if _, err := mod.importPath(other); err == nil || err.Error() != other+" is outside module example.com/foo" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_tempPackage(t *testing.T) {
+	mod := &goModule{
+		Dir:  t.TempDir(),
+		Path: "example.com/foo",
+	}
+	goFile, remove, err := mod.tempPackage([]byte("package main\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := filepath.Dir(filepath.Dir(goFile)); dir != mod.Dir {
+		t.Errorf("temporary package not directly inside module; got %s", goFile)
+	}
+	// The package must not affect the charm's source hashes.
+	hashes, err := sourceHashes(mod.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 0 {
+		t.Errorf("temporary package included in source hashes: %v", hashes)
+	}
+	remove()
+	if _, err := os.Stat(filepath.Dir(goFile)); !os.IsNotExist(err) {
+		t.Errorf("temporary package not removed (error %v)", err)
+	}
+}
+
+// testModule returns the module holding gocharm itself,
+// which holds the example charms used by the tests.
+func testModule(t *testing.T) *goModule {
+	mod, err := findModule(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mod
+}
+
+var parseLocalReplacesTests = []struct {
+	about  string
+	goMod  string
+	expect []string
+}{{
+	about: "no replace directives",
+	goMod: "module example.com/x\n\nrequire example.com/dep v1.0.0\n",
+}, {
+	about: "single local replacements",
+	goMod: `module example.com/x
+
+replace example.com/dep => ../dep
+replace example.com/other v1.2.0 => /home/me/other // local copy
+replace example.com/remote => example.com/fork v1.0.0
+`,
+	expect: []string{"../dep", "/home/me/other"},
+}, {
+	about: "replace block",
+	goMod: `module example.com/x
+
+replace (
+	example.com/dep => ./third_party/dep
+	example.com/remote v1.0.0 => example.com/fork v1.1.0
+	"example.com/quoted" => "../quoted"
+)
+`,
+	expect: []string{"./third_party/dep", "../quoted"},
+}}
+
+func Test_parseLocalReplaces(t *testing.T) {
+	for _, test := range parseLocalReplacesTests {
+		got := parseLocalReplaces([]byte(test.goMod))
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: got %q want %q", test.about, got, test.expect)
+		}
+	}
+}
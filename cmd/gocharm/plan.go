@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// errRefused is returned by planCharmChanges when the charm
+// directory holds files that gocharm would refuse to replace.
+var errRefused = errgo.New("charm directory cannot be updated")
+
+// planCharmChanges writes to w the changes that copying the charm
+// built in genDir to the charm directory dir would make, without
+// changing anything. Hooks are listed individually; other entries
+// are listed by their name in the charm directory. Entries that
+// would be left unchanged are not listed.
+//
+// If dir holds entries that gocharm would refuse to remove, they
+// are listed too, and errRefused is returned.
+func planCharmChanges(w io.Writer, dir, genDir string) error {
+	existing, err := readDirNames(dir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	refused := make(map[string]bool)
+	for _, name := range existing {
+		path := filepath.Join(dir, name)
+		switch {
+		case !allowed[name]:
+			fmt.Fprintf(w, "would refuse to remove %s: unexpected file\n", path)
+			refused[name] = true
+		case strings.HasSuffix(name, ".yaml") && !autogenerated(path):
+			fmt.Fprintf(w, "would refuse to replace %s: not generated by gocharm\n", path)
+			refused[name] = true
+		}
+	}
+	generated, err := readDirNames(genDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, name := range unionNames(existing, generated) {
+		if refused[name] {
+			continue
+		}
+		if name == "hooks" {
+			if err := planHookChanges(w, filepath.Join(dir, name), filepath.Join(genDir, name)); err != nil {
+				return errgo.Mask(err)
+			}
+			continue
+		}
+		if err := planEntryChange(w, filepath.Join(dir, name), filepath.Join(genDir, name)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if len(refused) > 0 {
+		return errRefused
+	}
+	return nil
+}
+
+// planHookChanges writes to w the changes that replacing the hooks
+// directory dir with genDir would make. Hooks whose contents are not
+// a stub generated by gocharm are described as hand-edited.
+func planHookChanges(w io.Writer, dir, genDir string) error {
+	existing, err := readDirNames(dir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	generated, err := readDirNames(genDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, name := range unionNames(existing, generated) {
+		cur, curOK, err := readFileIfPresent(filepath.Join(dir, name))
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		gen, genOK, err := readFileIfPresent(filepath.Join(genDir, name))
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		kind := "hook"
+		if curOK && !isHookStub(name, cur) {
+			kind = "hand-edited hook"
+		}
+		switch {
+		case !curOK:
+			fmt.Fprintf(w, "would create hook %s\n", name)
+		case !genOK:
+			fmt.Fprintf(w, "would remove %s %s\n", kind, name)
+		case cur != gen:
+			fmt.Fprintf(w, "would replace %s %s\n", kind, name)
+		}
+	}
+	return nil
+}
+
+// planEntryChange writes to w the change that replacing the
+// entry at path with the entry at genPath would make.
+// Directories are always considered to have changed.
+func planEntryChange(w io.Writer, path, genPath string) error {
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errgo.Mask(err)
+	}
+	genInfo, err := os.Stat(genPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errgo.Mask(err)
+	}
+	switch {
+	case info == nil:
+		fmt.Fprintf(w, "would create %s\n", path)
+	case genInfo == nil:
+		fmt.Fprintf(w, "would remove %s\n", path)
+	case info.IsDir() || genInfo.IsDir():
+		fmt.Fprintf(w, "would replace %s\n", path)
+	default:
+		cur, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		gen, err := ioutil.ReadFile(genPath)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !bytes.Equal(cur, gen) {
+			fmt.Fprintf(w, "would replace %s\n", path)
+		}
+	}
+	return nil
+}
+
+// isHookStub reports whether data holds the hook stub
+// that gocharm generates for the hook file with the given
+// name, for any target operating system.
+func isHookStub(name, data string) bool {
+	p := hookStubParams{
+		HookName: strings.TrimSuffix(name, windowsHookExt),
+	}
+	return data == string(executeTemplate(hookStubTemplate, p)) ||
+		data == string(executeTemplate(windowsHookStubTemplate, p))
+}
+
+// readDirNames returns the sorted names of the entries in dir,
+// excluding hidden entries. A missing directory is treated
+// as empty.
+func readDirNames(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errgo.Mask(err)
+	}
+	var names []string
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// readFileIfPresent returns the contents of the file at the
+// given path and whether it exists.
+func readFileIfPresent(path string) (string, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, errgo.Mask(err)
+	}
+	return string(data), true, nil
+}
+
+// unionNames returns the sorted union of the given names.
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(append([]string(nil), a...), b...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestHooks writes a charm with stubs for the given
+// hooks and the test metadata files into a new directory
+// and returns the directory.
+func writeTestHooks(t *testing.T, summary string, hooks ...string) string {
+	dir := writeTestCharmFiles(t, summary, testDiffConfig)
+	b := &charmBuilder{
+		pkg:      &build.Package{Dir: "/somewhere/mycharm"},
+		charmDir: dir,
+	}
+	if err := b.writeHooks(hooks); err != nil {
+		t.Fatalf("cannot write hooks: %v", err)
+	}
+	return dir
+}
+
+func Test_planCharmChangesNoChange(t *testing.T) {
+	dir := writeTestHooks(t, "a charm", "start")
+	genDir := writeTestHooks(t, "a charm", "start")
+	var buf bytes.Buffer
+	if err := planCharmChanges(&buf, dir, genDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected changes:\n%s", buf.Bytes())
+	}
+}
+
+func Test_planCharmChanges(t *testing.T) {
+	dir := writeTestHooks(t, "a charm", "stop", "upgrade-charm")
+	genDir := writeTestHooks(t, "a better charm", "config-changed", "stop")
+	// Hand-edit two of the existing hooks.
+	for _, name := range []string{"stop", "upgrade-charm"} {
+		if err := os.WriteFile(filepath.Join(dir, "hooks", name), []byte("#!/bin/sh\necho hello\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(genDir, "dispatch"), []byte(dispatchScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := planCharmChanges(&buf, dir, genDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "would create " + filepath.Join(dir, "dispatch") + "\n" +
+		"would create hook config-changed\n" +
+		"would replace hand-edited hook stop\n" +
+		"would remove hand-edited hook upgrade-charm\n" +
+		"would replace " + filepath.Join(dir, "metadata.yaml") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected changes; got\n%s\nwant\n%s", got, want)
+	}
+	// Nothing should have changed.
+	if _, err := os.Stat(filepath.Join(dir, "dispatch")); !os.IsNotExist(err) {
+		t.Errorf("dispatch file created: %v", err)
+	}
+}
+
+func Test_planCharmChangesRefused(t *testing.T) {
+	dir := writeTestHooks(t, "a charm", "start")
+	genDir := writeTestHooks(t, "a charm", "start")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("options: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err := planCharmChanges(&buf, dir, genDir)
+	if err != errRefused {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "would refuse to replace " + filepath.Join(dir, "config.yaml") + ": not generated by gocharm\n" +
+		"would refuse to remove " + filepath.Join(dir, "notes.txt") + ": unexpected file\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected changes; got\n%s\nwant\n%s", got, want)
+	}
+}
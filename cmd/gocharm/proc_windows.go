@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows, where processes
+// are not killed as a group.
+func setProcessGroup(c *exec.Cmd) {}
+
+// killProcessGroup kills the started command c.
+func killProcessGroup(c *exec.Cmd) {
+	c.Process.Kill()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// relationMain implements the relation subcommand, which generates
+// typed accessors for the settings of a relation interface from a
+// schema file and writes them into the charm's package directory.
+func relationMain(args []string) error {
+	fs := flag.NewFlagSet("relation", flag.ExitOnError)
+	schemaPath := fs.String("schema", "", "YAML file holding the relation schema")
+	fs.BoolVar(verbose, "v", false, "print the name of the generated file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gocharm relation -schema file [flags] [package]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if *schemaPath == "" || fs.NArg() > 1 {
+		fs.Usage()
+	}
+	pkgPath := "."
+	if fs.NArg() == 1 {
+		pkgPath = fs.Arg(0)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errgo.Notef(err, "cannot get current directory")
+	}
+	pkg, err := build.Default.Import(pkgPath, cwd, 0)
+	if err != nil {
+		return errgo.Notef(err, "cannot import %q", pkgPath)
+	}
+	schema, err := readRelationSchema(*schemaPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	code, err := generateRelationCode(pkg.Name, schema)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	path := filepath.Join(pkg.Dir, relationFileName(schema))
+	if err := ioutil.WriteFile(path, code, 0666); err != nil {
+		return errgo.Mask(err)
+	}
+	if *verbose {
+		log.Printf("wrote %s", path)
+	}
+	return nil
+}
+
+// relationSchema holds the schema of a relation interface,
+// as read from a schema file.
+type relationSchema struct {
+	// Interface holds the name of the relation interface.
+	Interface string `yaml:"interface"`
+
+	// Type holds the name of the generated Go type. If it is
+	// empty, the name is derived from the interface name.
+	Type string `yaml:"type"`
+
+	// Settings holds the type of each relation setting,
+	// keyed by setting name. See relationSettingTypes
+	// for the allowed types.
+	Settings map[string]string `yaml:"settings"`
+}
+
+// relationSettingTypes maps each type allowed in a
+// relation schema to the Go type used for it.
+var relationSettingTypes = map[string]string{
+	"string": "string",
+	"int":    "int",
+	"bool":   "bool",
+	"float":  "float64",
+}
+
+var (
+	validInterfaceName = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+	validSettingName   = regexp.MustCompile(`^[a-z][a-z0-9]*([-_.][a-z0-9]+)*$`)
+	validTypeName      = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+)
+
+// readRelationSchema reads and validates the
+// relation schema in the file at the given path.
+func readRelationSchema(path string) (*relationSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var schema relationSchema
+	if err := yaml.UnmarshalStrict(data, &schema); err != nil {
+		return nil, errgo.Notef(err, "cannot parse %s", path)
+	}
+	if err := schema.validate(); err != nil {
+		return nil, errgo.Notef(err, "invalid schema in %s", path)
+	}
+	return &schema, nil
+}
+
+func (s *relationSchema) validate() error {
+	if !validInterfaceName.MatchString(s.Interface) {
+		return errgo.Newf("invalid interface name %q", s.Interface)
+	}
+	if s.Type != "" && !validTypeName.MatchString(s.Type) {
+		return errgo.Newf("invalid type name %q", s.Type)
+	}
+	if len(s.Settings) == 0 {
+		return errgo.New("no settings declared")
+	}
+	names := make(map[string]string)
+	for _, key := range sortedSettingKeys(s.Settings) {
+		if !validSettingName.MatchString(key) {
+			return errgo.Newf("invalid setting name %q", key)
+		}
+		if relationSettingTypes[s.Settings[key]] == "" {
+			return errgo.Newf("invalid type %q for setting %q", s.Settings[key], key)
+		}
+		name := goName(key)
+		if other, ok := names[name]; ok {
+			return errgo.Newf("settings %q and %q have the same Go name", other, key)
+		}
+		names[name] = key
+	}
+	return nil
+}
+
+// typeName returns the name of the generated Go type.
+func (s *relationSchema) typeName() string {
+	if s.Type != "" {
+		return s.Type
+	}
+	return goName(s.Interface) + "Relation"
+}
+
+// relationFileName returns the name of the file
+// generated for the given schema.
+func relationFileName(s *relationSchema) string {
+	return strings.Replace(s.Interface, "-", "_", -1) + "_relation.go"
+}
+
+// goName returns the exported Go name for the given
+// setting or interface name, for example "PrivateAddress"
+// for "private-address".
+func goName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func sortedSettingKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// relationTemplateParams holds the parameters
+// for relationTemplate.
+type relationTemplateParams struct {
+	AutogenMessage string
+	HookPackage    string
+	Package        string
+	Interface      string
+	Type           string
+	NeedStrconv    bool
+	Settings       []relationSettingParams
+}
+
+type relationSettingParams struct {
+	Key    string
+	Name   string
+	GoType string
+}
+
+// generateRelationCode returns the Go source, in the package with
+// the given name, of the typed accessors for the given schema.
+func generateRelationCode(pkgName string, s *relationSchema) ([]byte, error) {
+	p := relationTemplateParams{
+		AutogenMessage: autogenMessage,
+		HookPackage:    hookPackage,
+		Package:        pkgName,
+		Interface:      s.Interface,
+		Type:           s.typeName(),
+	}
+	for _, key := range sortedSettingKeys(s.Settings) {
+		goType := relationSettingTypes[s.Settings[key]]
+		if goType != "string" {
+			p.NeedStrconv = true
+		}
+		p.Settings = append(p.Settings, relationSettingParams{
+			Key:    key,
+			Name:   goName(key),
+			GoType: goType,
+		})
+	}
+	code, err := format.Source(executeTemplate(relationTemplate, p))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot format generated code")
+	}
+	return code, nil
+}
+
+var relationTemplate = template.Must(template.New("").Parse(`
+// {{.AutogenMessage}}
+
+package {{.Package}}
+
+import (
+	"fmt"
+{{if .NeedStrconv}}	"strconv"
+{{end}}
+	"{{.HookPackage}}"
+)
+
+// {{.Type}} provides typed access to the settings of a
+// remote unit in a relation with the {{printf "%q" .Interface}} interface.
+type {{.Type}} struct {
+	ctxt *hook.Context
+	id   hook.RelationId
+	unit hook.UnitId
+}
+
+// New{{.Type}} returns a {{.Type}} for the given remote
+// unit in the relation with the given id.
+func New{{.Type}}(ctxt *hook.Context, id hook.RelationId, unit hook.UnitId) *{{.Type}} {
+	return &{{.Type}}{
+		ctxt: ctxt,
+		id:   id,
+		unit: unit,
+	}
+}
+
+// Current{{.Type}} returns a {{.Type}} for the relation
+// and remote unit of the currently running relation hook.
+func Current{{.Type}}(ctxt *hook.Context) (*{{.Type}}, error) {
+	if ctxt.RelationId == "" || ctxt.RemoteUnit == "" {
+		return nil, fmt.Errorf("hook %s is not running for a remote unit in a relation", ctxt.HookName)
+	}
+	return New{{.Type}}(ctxt, ctxt.RelationId, ctxt.RemoteUnit), nil
+}
+
+func (r *{{.Type}}) remote(key string) string {
+	return r.ctxt.Relations[r.id][r.unit][key]
+}
+{{range .Settings}}{{if eq .GoType "string"}}
+// Remote{{.Name}} returns the {{printf "%q" .Key}} setting of the remote unit.
+func (r *{{$.Type}}) Remote{{.Name}}() string {
+	return r.remote({{printf "%q" .Key}})
+}
+
+// Set{{.Name}} sets the {{printf "%q" .Key}} setting of the local unit.
+func (r *{{$.Type}}) Set{{.Name}}(v string) error {
+	return r.ctxt.SetRelationWithId(r.id, {{printf "%q" .Key}}, v)
+}
+{{else}}
+// Remote{{.Name}} returns the {{printf "%q" .Key}} setting of the remote
+// unit. It returns the zero value if the setting is not set.
+func (r *{{$.Type}}) Remote{{.Name}}() ({{.GoType}}, error) {
+	s := r.remote({{printf "%q" .Key}})
+	if s == "" {
+		return {{if eq .GoType "bool"}}false{{else}}0{{end}}, nil
+	}
+{{if eq .GoType "int"}}	v, err := strconv.Atoi(s)
+{{else if eq .GoType "bool"}}	v, err := strconv.ParseBool(s)
+{{else}}	v, err := strconv.ParseFloat(s, 64)
+{{end}}	if err != nil {
+		return v, fmt.Errorf("invalid %s setting %q in relation %s", {{printf "%q" .Key}}, s, r.id)
+	}
+	return v, nil
+}
+
+// Set{{.Name}} sets the {{printf "%q" .Key}} setting of the local unit.
+func (r *{{$.Type}}) Set{{.Name}}(v {{.GoType}}) error {
+{{if eq .GoType "int"}}	return r.ctxt.SetRelationWithId(r.id, {{printf "%q" .Key}}, strconv.Itoa(v))
+{{else if eq .GoType "bool"}}	return r.ctxt.SetRelationWithId(r.id, {{printf "%q" .Key}}, strconv.FormatBool(v))
+{{else}}	return r.ctxt.SetRelationWithId(r.id, {{printf "%q" .Key}}, strconv.FormatFloat(v, 'g', -1, 64))
+{{end}}}
+{{end}}{{end}}`))
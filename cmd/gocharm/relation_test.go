@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+const testRelationSchema = `
+interface: web-server
+settings:
+  hostname: string
+  port: int
+  tls: bool
+  weight: float
+  private-address: string
+`
+
+func Test_generateRelationCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping build of generated code in short mode")
+	}
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("schema.yaml", testRelationSchema)
+	schema, err := readRelationSchema(filepath.Join(dir, "schema.yaml"))
+	if err != nil {
+		t.Fatalf("cannot read schema: %v", err)
+	}
+	if name := relationFileName(schema); name != "web_server_relation.go" {
+		t.Errorf("unexpected file name %q", name)
+	}
+	code, err := generateRelationCode("webcharm", schema)
+	if err != nil {
+		t.Fatalf("cannot generate code: %v", err)
+	}
+	writeFile(relationFileName(schema), string(code))
+
+	// Build and test the generated code in a module
+	// that uses the hook package from this tree.
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile("go.sum", string(goSum))
+	writeFile("go.mod", `module example.com/webcharm
+
+go 1.16
+
+require github.com/mever/gocharm/v2 v2.0.0
+
+replace github.com/mever/gocharm/v2 => `+root+"\n")
+	writeFile("relation_test.go", `package webcharm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+func TestRelation(t *testing.T) {
+	runner := &hooktest.Runner{
+		Logger: t,
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"website:0": {
+				"client/0": {
+					"hostname":        "example.com",
+					"port":            "8080",
+					"tls":             "true",
+					"private-address": "10.0.0.1",
+					"weight":          "bad",
+				},
+			},
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:       "someunit/0",
+		HookName:   "website-relation-changed",
+		Runner:     runner,
+		Relations:  runner.Relations,
+		RelationId: "website:0",
+		RemoteUnit: "client/0",
+	}
+	r, err := CurrentWebServerRelation(ctxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.RemoteHostname(); got != "example.com" {
+		t.Errorf("unexpected hostname %q", got)
+	}
+	if got := r.RemotePrivateAddress(); got != "10.0.0.1" {
+		t.Errorf("unexpected private address %q", got)
+	}
+	if got, err := r.RemotePort(); got != 8080 || err != nil {
+		t.Errorf("unexpected port %v, %v", got, err)
+	}
+	if got, err := r.RemoteTls(); !got || err != nil {
+		t.Errorf("unexpected tls %v, %v", got, err)
+	}
+	if _, err := r.RemoteWeight(); err == nil || err.Error() != `+"`"+`invalid weight setting "bad" in relation website:0`+"`"+` {
+		t.Errorf("unexpected weight error %v", err)
+	}
+	if err := r.SetPort(80); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"relation-set", "-r", "website:0", "--", "port=80"}; !reflect.DeepEqual(runner.Record[len(runner.Record)-1], want) {
+		t.Errorf("unexpected relation-set call %q", runner.Record)
+	}
+	ctxt.RelationId = ""
+	if _, err := CurrentWebServerRelation(ctxt); err == nil {
+		t.Errorf("expected error outside a relation hook")
+	}
+}
+`)
+	c := exec.Command("go", "test", "./...")
+	c.Dir = dir
+	c.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not build or pass its tests: %v\n%s\ncode:\n%s", err, out, code)
+	}
+}
+
+var relationSchemaErrorTests = []struct {
+	schema      string
+	expectError string
+}{{
+	schema:      "interface: Http\nsettings: {a: string}\n",
+	expectError: `invalid interface name "Http"`,
+}, {
+	schema:      "interface: http\ntype: website\nsettings: {a: string}\n",
+	expectError: `invalid type name "website"`,
+}, {
+	schema:      "interface: http\n",
+	expectError: `no settings declared`,
+}, {
+	schema:      "interface: http\nsettings: {a: duration}\n",
+	expectError: `invalid type "duration" for setting "a"`,
+}, {
+	schema:      "interface: http\nsettings: {Host: string}\n",
+	expectError: `invalid setting name "Host"`,
+}, {
+	schema:      "interface: http\nsettings: {a-b: string, a_b: int}\n",
+	expectError: `settings "a-b" and "a_b" have the same Go name`,
+}}
+
+func Test_readRelationSchemaErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schema.yaml")
+	for i, test := range relationSchemaErrorTests {
+		if err := os.WriteFile(path, []byte(test.schema), 0666); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readRelationSchema(path)
+		want := "invalid schema in " + regexp.QuoteMeta(path) + ": " + test.expectError
+		if err == nil || !regexp.MustCompile("^"+want+"$").MatchString(err.Error()) {
+			t.Errorf("test %d: unexpected error; got %v want %q", i, err, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+)
+
+// testMain implements the test subcommand, which builds a charm
+// and then runs its tests with the environment set up to refer
+// to the built charm.
+func testMain(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.StringVar(repo, "repo", "", "charm repo directory (defaults to $JUJU_REPOSITORY)")
+	fs.BoolVar(verbose, "v", false, "print information about the charm being built")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gocharm test [flags] [package] [-- go test flags]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	pkgPath := "."
+	testArgs := fs.Args()
+	if len(testArgs) > 0 && testArgs[0] != "--" {
+		pkgPath, testArgs = testArgs[0], testArgs[1:]
+	}
+	if len(testArgs) > 0 {
+		if testArgs[0] != "--" {
+			fs.Usage()
+		}
+		testArgs = testArgs[1:]
+	}
+	if err := setRepo(); err != nil {
+		return errgo.Mask(err)
+	}
+	dir, err := packageDir(pkgPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return testCharm(pkgPath, dir, testArgs)
+}
+
+// testCharm builds the charm in the package with the given path and
+// source directory, then runs "go test ./..." in that directory with
+// the given extra arguments. The tests are run with CHARM_DIR set to
+// the built charm's directory and JUJU_REPOSITORY set to the charm
+// repository.
+func testCharm(pkgPath, dir string, testArgs []string) error {
+	// The go command caches build results, so only the
+	// packages that have changed are rebuilt.
+	if err := buildCharmForTest(pkgPath); err != nil {
+		return errgo.Notef(err, "cannot build charm")
+	}
+	env := os.Environ()
+	env = setenv(env, "CHARM_DIR="+filepath.Join(*repo, filepath.Base(dir)))
+	env = setenv(env, "JUJU_REPOSITORY="+*repo)
+	args := append([]string{"test"}, testArgs...)
+	args = append(args, "./...")
+	if err := runGoTest(dir, env, args); err != nil {
+		return errgo.Notef(err, "tests failed")
+	}
+	return nil
+}
+
+// buildCharmForTest builds the charm with the given package path.
+// It is defined as a variable so that it can be replaced for testing.
+var buildCharmForTest = main1
+
+// runGoTest runs the go command with the given arguments in the
+// given directory and environment, sending its output to gocharm's.
+// It is defined as a variable so that it can be replaced for testing.
+var runGoTest = func(dir string, env []string, args []string) error {
+	c := runCmd(dir, env, "go", args...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
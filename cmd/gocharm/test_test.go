@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_testCharm(t *testing.T) {
+	defer func(old string) { *repo = old }(*repo)
+	defer func(old func(string) error) { buildCharmForTest = old }(buildCharmForTest)
+	defer func(old func(string, []string, []string) error) { runGoTest = old }(runGoTest)
+
+	*repo = "/home/user/charms"
+	var calls []string
+	buildCharmForTest = func(pkgPath string) error {
+		calls = append(calls, "build "+pkgPath)
+		return nil
+	}
+	var testDir string
+	var testEnv, testArgs []string
+	runGoTest = func(dir string, env []string, args []string) error {
+		calls = append(calls, "test")
+		testDir, testEnv, testArgs = dir, env, args
+		return nil
+	}
+	err := testCharm("example.com/mycharm", "/src/mycharm", []string{"-run", "TestHooks", "-count=1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"build example.com/mycharm", "test"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("unexpected calls; got %q want %q", calls, want)
+	}
+	if testDir != "/src/mycharm" {
+		t.Errorf("unexpected test directory %q", testDir)
+	}
+	if want := []string{"test", "-run", "TestHooks", "-count=1", "./..."}; !reflect.DeepEqual(testArgs, want) {
+		t.Errorf("unexpected test args; got %q want %q", testArgs, want)
+	}
+	found := make(map[string]bool)
+	for _, e := range testEnv {
+		found[e] = true
+	}
+	for _, e := range []string{"CHARM_DIR=/home/user/charms/mycharm", "JUJU_REPOSITORY=/home/user/charms"} {
+		if !found[e] {
+			t.Errorf("%s not found in test environment", e)
+		}
+	}
+}
+
+func Test_testCharmBuildFailure(t *testing.T) {
+	defer func(old func(string) error) { buildCharmForTest = old }(buildCharmForTest)
+	defer func(old func(string, []string, []string) error) { runGoTest = old }(runGoTest)
+
+	buildCharmForTest = func(pkgPath string) error {
+		return errors.New("compile error")
+	}
+	runGoTest = func(dir string, env []string, args []string) error {
+		t.Errorf("tests run after build failure")
+		return nil
+	}
+	err := testCharm("example.com/mycharm", "/src/mycharm", nil)
+	if err == nil || err.Error() != "cannot build charm: compile error" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/errgo.v1"
+)
+
+// watchDebounce holds the time to wait after a source file
+// change before rebuilding, so that a burst of changes (for
+// example when an editor saves several files) results in
+// a single build.
+const watchDebounce = 500 * time.Millisecond
+
+// watchAndBuild calls build once, and then again each time a Go source
+// file in dir or any of its subdirectories changes, until the stop
+// channel is closed. Build errors are printed but do not stop the
+// watching.
+func watchAndBuild(dir string, debounce time.Duration, build func() error, stop <-chan struct{}) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errgo.Notef(err, "cannot create file watcher")
+	}
+	defer w.Close()
+	if err := addWatches(w, dir); err != nil {
+		return errgo.Mask(err)
+	}
+	runBuild(build)
+	var rebuild <-chan time.Time
+	for {
+		select {
+		case ev := <-w.Events:
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if strings.HasPrefix(info.Name(), ".") {
+						continue
+					}
+					if err := addWatches(w, ev.Name); err != nil {
+						errorf("%v", err)
+					}
+					continue
+				}
+			}
+			if !strings.HasSuffix(ev.Name, ".go") {
+				continue
+			}
+			if *verbose {
+				log.Printf("%s changed", ev.Name)
+			}
+			rebuild = time.After(debounce)
+		case err := <-w.Errors:
+			errorf("watch error: %v", err)
+		case <-rebuild:
+			rebuild = nil
+			runBuild(build)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func runBuild(build func() error) {
+	if err := build(); err != nil {
+		errorf("%v", err)
+		return
+	}
+	if *verbose {
+		log.Printf("build succeeded")
+	}
+}
+
+// addWatches adds a watch for dir and all its subdirectories,
+// ignoring hidden directories.
+func addWatches(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := w.Add(path); err != nil {
+			return errgo.Notef(err, "cannot watch %s", path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_watchAndBuild(t *testing.T) {
+	dir := t.TempDir()
+	goFile := filepath.Join(dir, "runhook.go")
+	if err := ioutil.WriteFile(goFile, []byte("package runhook\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	builds := make(chan struct{}, 10)
+	build := func() error {
+		builds <- struct{}{}
+		return nil
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watchAndBuild(dir, 50*time.Millisecond, build, stop)
+	}()
+	expectBuild(t, builds)
+
+	// Several changes in quick succession should
+	// result in a single rebuild.
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(goFile, []byte("package runhook\n// changed\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectBuild(t, builds)
+	expectNoBuild(t, builds)
+
+	// Changes to non-Go files are ignored.
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	expectNoBuild(t, builds)
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func expectBuild(t *testing.T, builds <-chan struct{}) {
+	select {
+	case <-builds:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no build triggered")
+	}
+}
+
+func expectNoBuild(t *testing.T, builds <-chan struct{}) {
+	select {
+	case <-builds:
+		t.Fatalf("unexpected build triggered")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -3,8 +3,11 @@ module github.com/mever/gocharm/v2
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/hashicorp/mdns v1.0.3
 	github.com/juju/charm/v9 v9.0.0-20210512004933-c21e01ffd4ad
 	github.com/juju/errors v0.0.0-20200330140219-3fe23663418f
+	github.com/juju/gojsonschema v0.0.0-20150312170016-e1ad140384f2
 	github.com/juju/mgo/v2 v2.0.0-20210414025616-e854c672032f
 	github.com/juju/names/v4 v4.0.0-20200929085019-be23e191fee0
 	github.com/juju/testing v0.0.0-20210302031854-2c7ee8570c07
@@ -12,6 +15,8 @@ require (
 	github.com/kardianos/service v1.2.0 // indirect
 	github.com/mever/service v1.2.1-0.20210512123113-570438e960f8
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
 	gopkg.in/errgo.v1 v1.0.1
 	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637
@@ -0,0 +1,188 @@
+package hook
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/juju/charm/v9"
+	gjs "github.com/juju/gojsonschema"
+	"gopkg.in/errgo.v1"
+)
+
+// ActionParams describes an action that can be invoked on the
+// charm's units, and the parameters that it accepts. It is used
+// to generate the JSON schema for the action in actions.yaml.
+type ActionParams struct {
+	// Description holds a description of the action.
+	Description string
+
+	// Params holds the parameters of the action, keyed by name.
+	Params map[string]ActionParam
+}
+
+// ActionParam describes a single action parameter.
+type ActionParam struct {
+	// Type holds the JSON schema type of the parameter. It must be
+	// one of "string", "integer", "number", "boolean", "array"
+	// or "object".
+	Type string
+
+	// Description holds a description of the parameter.
+	Description string
+
+	// Required specifies that the parameter must
+	// be provided when the action is invoked.
+	Required bool
+
+	// Default holds the default value of the parameter, if any.
+	Default interface{}
+
+	// Enum, if not empty, holds the set of values
+	// that the parameter may take.
+	Enum []interface{}
+
+	// Items holds the type of the elements of an "array"
+	// parameter. If it is nil, the elements may
+	// be of any type.
+	Items *ActionParam
+
+	// Properties holds the fields of an "object"
+	// parameter, keyed by name.
+	Properties map[string]ActionParam
+}
+
+var actionParamTypes = map[string]bool{
+	"string":  true,
+	"integer": true,
+	"number":  true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+}
+
+// Schema returns the JSON schema for the action with the given name,
+// in the form used for the Params field of charm.ActionSpec. It
+// returns an error if the parameters are invalid or if the resulting
+// schema is not well formed.
+func (p ActionParams) Schema(name string) (map[string]interface{}, error) {
+	props, required, err := paramsSchema(p.Params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	desc := p.Description
+	if desc == "" {
+		desc = "No description"
+	}
+	schema := map[string]interface{}{
+		"title":       name,
+		"description": desc,
+		"type":        "object",
+		"properties":  props,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if _, err := gjs.NewSchema(gjs.NewGoLoader(schema)); err != nil {
+		return nil, errgo.Notef(err, "invalid schema")
+	}
+	return schema, nil
+}
+
+// paramsSchema returns the JSON schema properties for the given
+// parameters, and the names of the required parameters in
+// alphabetical order.
+func paramsSchema(params map[string]ActionParam) (map[string]interface{}, []interface{}, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	props := make(map[string]interface{})
+	var required []interface{}
+	for _, name := range names {
+		param := params[name]
+		schema, err := param.schema()
+		if err != nil {
+			return nil, nil, errgo.Notef(err, "parameter %q", name)
+		}
+		props[name] = schema
+		if param.Required {
+			required = append(required, name)
+		}
+	}
+	return props, required, nil
+}
+
+func (p ActionParam) schema() (map[string]interface{}, error) {
+	if !actionParamTypes[p.Type] {
+		return nil, errgo.Newf("invalid type %q", p.Type)
+	}
+	schema := map[string]interface{}{
+		"type": p.Type,
+	}
+	if p.Description != "" {
+		schema["description"] = p.Description
+	}
+	if p.Default != nil {
+		schema["default"] = p.Default
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	if p.Items != nil {
+		if p.Type != "array" {
+			return nil, errgo.Newf("items specified for non-array type %q", p.Type)
+		}
+		items, err := p.Items.schema()
+		if err != nil {
+			return nil, errgo.Notef(err, "items")
+		}
+		schema["items"] = items
+	}
+	if p.Properties != nil {
+		if p.Type != "object" {
+			return nil, errgo.Newf("properties specified for non-object type %q", p.Type)
+		}
+		props, required, err := paramsSchema(p.Properties)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		schema["properties"] = props
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	return schema, nil
+}
+
+// RegisterAction registers an action with the given name and
+// parameters, to be included in the charm's actions.yaml. It panics
+// if the name is not a valid action name, if the parameters do not
+// produce a valid JSON schema (see ActionParams.Schema), or if the
+// action has already been registered with different parameters.
+func (r *Registry) RegisterAction(name string, params ActionParams) {
+	if !charm.GetActionNameRule().MatchString(name) {
+		panic(errgo.Newf("invalid action name %q", name))
+	}
+	schema, err := params.Schema(name)
+	if err != nil {
+		panic(errgo.Notef(err, "invalid parameters for action %q", name))
+	}
+	spec := charm.ActionSpec{
+		Description: schema["description"].(string),
+		Params:      schema,
+	}
+	if old, ok := r.actions[name]; ok {
+		if !reflect.DeepEqual(old, spec) {
+			panic(errgo.Newf("action %q is already registered with different parameters", name))
+		}
+		return
+	}
+	r.actions[name] = spec
+}
+
+// RegisteredActions returns the actions that have been
+// registered with RegisterAction, keyed by action name.
+func (r *Registry) RegisteredActions() map[string]charm.ActionSpec {
+	return r.actions
+}
@@ -0,0 +1,176 @@
+package hook_test
+
+import (
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type actionSuite struct{}
+
+var _ = gc.Suite(&actionSuite{})
+
+var backupParams = hook.ActionParams{
+	Description: "Back up the database.",
+	Params: map[string]hook.ActionParam{
+		"target": {
+			Type:        "object",
+			Description: "Where to store the backup.",
+			Required:    true,
+			Properties: map[string]hook.ActionParam{
+				"bucket": {
+					Type:     "string",
+					Required: true,
+				},
+				"region": {
+					Type:    "string",
+					Default: "us-east-1",
+				},
+			},
+		},
+		"tables": {
+			Type:        "array",
+			Description: "Tables to back up; all tables if empty.",
+			Items:       &hook.ActionParam{Type: "string"},
+		},
+		"compression": {
+			Type: "string",
+			Enum: []interface{}{"gzip", "none"},
+		},
+	},
+}
+
+func (*actionSuite) TestSchema(c *gc.C) {
+	schema, err := backupParams.Schema("backup")
+	c.Assert(err, gc.IsNil)
+	c.Assert(schema, jc.DeepEquals, map[string]interface{}{
+		"title":       "backup",
+		"description": "Back up the database.",
+		"type":        "object",
+		"required":    []interface{}{"target"},
+		"properties": map[string]interface{}{
+			"target": map[string]interface{}{
+				"type":        "object",
+				"description": "Where to store the backup.",
+				"required":    []interface{}{"bucket"},
+				"properties": map[string]interface{}{
+					"bucket": map[string]interface{}{
+						"type": "string",
+					},
+					"region": map[string]interface{}{
+						"type":    "string",
+						"default": "us-east-1",
+					},
+				},
+			},
+			"tables": map[string]interface{}{
+				"type":        "array",
+				"description": "Tables to back up; all tables if empty.",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"compression": map[string]interface{}{
+				"type": "string",
+				"enum": []interface{}{"gzip", "none"},
+			},
+		},
+	})
+
+	// Check that the schema is usable by Juju to validate parameters.
+	spec := charm.ActionSpec{Params: schema}
+	err = spec.ValidateParams(map[string]interface{}{
+		"target": map[string]interface{}{"bucket": "backups"},
+		"tables": []interface{}{"users"},
+	})
+	c.Assert(err, gc.IsNil)
+	err = spec.ValidateParams(map[string]interface{}{
+		"target": map[string]interface{}{"region": "eu-west-1"},
+	})
+	c.Assert(err, gc.ErrorMatches, `validation failed: .*bucket.*`)
+	err = spec.ValidateParams(map[string]interface{}{
+		"target":      map[string]interface{}{"bucket": "backups"},
+		"compression": "zip",
+	})
+	c.Assert(err, gc.ErrorMatches, `validation failed: .*compression.*`)
+}
+
+func (*actionSuite) TestSchemaNoParams(c *gc.C) {
+	schema, err := hook.ActionParams{}.Schema("ping")
+	c.Assert(err, gc.IsNil)
+	c.Assert(schema, jc.DeepEquals, map[string]interface{}{
+		"title":       "ping",
+		"description": "No description",
+		"type":        "object",
+		"properties":  map[string]interface{}{},
+	})
+}
+
+var invalidSchemaTests = []struct {
+	about       string
+	params      map[string]hook.ActionParam
+	expectError string
+}{{
+	about: "unknown type",
+	params: map[string]hook.ActionParam{
+		"n": {Type: "int"},
+	},
+	expectError: `parameter "n": invalid type "int"`,
+}, {
+	about: "nested unknown type",
+	params: map[string]hook.ActionParam{
+		"obj": {
+			Type: "object",
+			Properties: map[string]hook.ActionParam{
+				"x": {},
+			},
+		},
+	},
+	expectError: `parameter "obj": parameter "x": invalid type ""`,
+}, {
+	about: "items on non-array",
+	params: map[string]hook.ActionParam{
+		"s": {Type: "string", Items: &hook.ActionParam{Type: "string"}},
+	},
+	expectError: `parameter "s": items specified for non-array type "string"`,
+}, {
+	about: "properties on non-object",
+	params: map[string]hook.ActionParam{
+		"s": {Type: "array", Properties: map[string]hook.ActionParam{}},
+	},
+	expectError: `parameter "s": properties specified for non-object type "array"`,
+}}
+
+func (*actionSuite) TestInvalidSchema(c *gc.C) {
+	for i, test := range invalidSchemaTests {
+		c.Logf("test %d: %s", i, test.about)
+		_, err := hook.ActionParams{Params: test.params}.Schema("act")
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*actionSuite) TestRegisterAction(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterAction("backup", backupParams)
+	r.Clone("sub").RegisterAction("backup", backupParams)
+	actions := r.RegisteredActions()
+	c.Assert(actions, gc.HasLen, 1)
+	c.Assert(actions["backup"].Description, gc.Equals, "Back up the database.")
+	expectSchema, err := backupParams.Schema("backup")
+	c.Assert(err, gc.IsNil)
+	c.Assert(actions["backup"].Params, jc.DeepEquals, expectSchema)
+
+	c.Assert(func() {
+		r.RegisterAction("backup", hook.ActionParams{})
+	}, gc.PanicMatches, `action "backup" is already registered with different parameters`)
+	c.Assert(func() {
+		r.RegisterAction("Backup", hook.ActionParams{})
+	}, gc.PanicMatches, `invalid action name "Backup"`)
+	c.Assert(func() {
+		r.RegisterAction("restore", hook.ActionParams{
+			Params: map[string]hook.ActionParam{"n": {Type: "int"}},
+		})
+	}, gc.PanicMatches, `invalid parameters for action "restore": parameter "n": invalid type "int"`)
+}
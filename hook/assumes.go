@@ -0,0 +1,126 @@
+package hook
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"gopkg.in/errgo.v1"
+)
+
+// AssumesExpr represents an expression in the "assumes" section of
+// a charm's metadata.yaml, which declares the features that the charm
+// requires from the Juju controller and the substrate it is deployed
+// to. Exactly one of the fields must be set; use the AssumesFeature,
+// AnyOf and AllOf functions to create expressions.
+type AssumesExpr struct {
+	// Feature holds the name of a required feature, optionally
+	// followed by a version constraint, for example "k8s-api"
+	// or "juju >= 3.0".
+	Feature string
+
+	// AnyOf holds expressions of which at least one must hold.
+	AnyOf []AssumesExpr
+
+	// AllOf holds expressions all of which must hold.
+	AllOf []AssumesExpr
+}
+
+// AssumesFeature returns an expression that requires the
+// given feature, for example "k8s-api" or "juju >= 3.0".
+func AssumesFeature(feature string) AssumesExpr {
+	return AssumesExpr{Feature: feature}
+}
+
+// AnyOf returns an expression that holds
+// if any of the given expressions holds.
+func AnyOf(exprs ...AssumesExpr) AssumesExpr {
+	return AssumesExpr{AnyOf: nonNil(exprs)}
+}
+
+// AllOf returns an expression that holds
+// if all of the given expressions hold.
+func AllOf(exprs ...AssumesExpr) AssumesExpr {
+	return AssumesExpr{AllOf: nonNil(exprs)}
+}
+
+// nonNil returns exprs, or an empty slice if it is nil, so
+// that an empty any-of or all-of expression can be told
+// apart from an expression with no fields set.
+func nonNil(exprs []AssumesExpr) []AssumesExpr {
+	if exprs == nil {
+		return []AssumesExpr{}
+	}
+	return exprs
+}
+
+// validFeature matches a feature name with an
+// optional version constraint.
+var validFeature = regexp.MustCompile(`^[a-z][a-z0-9-]*( (>=|<) [0-9]+(\.[0-9]+){0,2})?$`)
+
+// Validate checks that the expression is well formed.
+func (e AssumesExpr) Validate() error {
+	n := 0
+	if e.Feature != "" {
+		n++
+	}
+	if e.AnyOf != nil {
+		n++
+	}
+	if e.AllOf != nil {
+		n++
+	}
+	if n != 1 {
+		return errgo.New("assumes expression must have exactly one of feature, any-of or all-of")
+	}
+	if e.Feature != "" {
+		if !validFeature.MatchString(e.Feature) {
+			return errgo.Newf("invalid assumes feature %q", e.Feature)
+		}
+		return nil
+	}
+	kind, exprs := "any-of", e.AnyOf
+	if e.AllOf != nil {
+		kind, exprs = "all-of", e.AllOf
+	}
+	if len(exprs) == 0 {
+		return errgo.Newf("empty %s assumes expression", kind)
+	}
+	for _, e1 := range exprs {
+		if err := e1.Validate(); err != nil {
+			return errgo.Notef(err, "invalid %s assumes expression", kind)
+		}
+	}
+	return nil
+}
+
+// value returns the expression in the form that it
+// takes in metadata.yaml: a string for a feature or
+// a single-entry map for any-of and all-of.
+func (e AssumesExpr) value() interface{} {
+	if e.Feature != "" {
+		return e.Feature
+	}
+	kind, exprs := "any-of", e.AnyOf
+	if e.AllOf != nil {
+		kind, exprs = "all-of", e.AllOf
+	}
+	vals := make([]interface{}, len(exprs))
+	for i, e1 := range exprs {
+		vals[i] = e1.value()
+	}
+	return map[string]interface{}{
+		kind: vals,
+	}
+}
+
+// MarshalYAML implements yaml.Marshaler by
+// marshaling the expression in metadata.yaml form.
+func (e AssumesExpr) MarshalYAML() (interface{}, error) {
+	return e.value(), nil
+}
+
+// MarshalJSON implements json.Marshaler by
+// marshaling the expression in metadata.yaml form.
+func (e AssumesExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.value())
+}
@@ -0,0 +1,27 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/errgo.v1"
+)
+
+// FileChecksum returns the hex-encoded SHA-256 checksum of the
+// contents of the file at the given path. A charm can save the
+// checksum of a rendered configuration file in its persistent state
+// and reload its workload only when the checksum changes.
+func (ctxt *Context) FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errgo.Mask(err, os.IsNotExist)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errgo.Notef(err, "cannot read %q", path)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
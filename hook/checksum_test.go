@@ -0,0 +1,39 @@
+package hook_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type checksumSuite struct{}
+
+var _ = gc.Suite(&checksumSuite{})
+
+func (*checksumSuite) TestFileChecksum(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "service.conf")
+	err := ioutil.WriteFile(path, []byte("hello, world"), 0644)
+	c.Assert(err, gc.IsNil)
+	ctxt := &hook.Context{}
+	sum, err := ctxt.FileChecksum(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sum, gc.Equals, "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b")
+
+	// The checksum changes with the content.
+	err = ioutil.WriteFile(path, []byte("hello, world!"), 0644)
+	c.Assert(err, gc.IsNil)
+	sum1, err := ctxt.FileChecksum(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sum1, gc.Not(gc.Equals), sum)
+}
+
+func (*checksumSuite) TestFileChecksumNotFound(c *gc.C) {
+	ctxt := &hook.Context{}
+	_, err := ctxt.FileChecksum(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(os.IsNotExist(errgo.Cause(err)), gc.Equals, true)
+}
@@ -0,0 +1,139 @@
+package hook
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// containerStatusStateName holds the name of the persistent state
+// that records the status of each workload container set with
+// SetContainerStatus. Registry names always start with "root", so
+// it cannot clash with the state of any registry.
+const containerStatusStateName = "container-status"
+
+// containerStatus holds the status of a workload container.
+type containerStatus struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// containerStatusCache holds the statuses of the workload
+// containers, loaded from state when first needed.
+type containerStatusCache struct {
+	// state holds the persistent state that the statuses
+	// are saved in. If it is nil, they are only kept in memory.
+	state    PersistentState
+	loaded   bool
+	statuses map[string]containerStatus
+}
+
+// statusSeverity orders the workload statuses so that the most
+// severe status of any container becomes the status of the unit.
+var statusSeverity = map[Status]int{
+	StatusActive:      1,
+	StatusWaiting:     2,
+	StatusMaintenance: 3,
+	StatusBlocked:     4,
+}
+
+// SetContainerStatus records the status of the given workload
+// container of a Kubernetes sidecar charm. Juju provides no hook tool
+// for setting the status of an individual container, so the unit's
+// workload status is set to a summary of the statuses recorded for
+// all the containers: the most severe of them (blocked, then
+// maintenance, waiting and active), with a message that lists each
+// container that is not active or has a message, in name order, for
+// example "db: waiting for database; workload: starting". The
+// statuses are kept in persistent state, so the summary covers
+// containers whose status was set in earlier hooks. A call to
+// SetStatus replaces the summary until SetContainerStatus is next
+// called. It returns an error if there is no workload container with
+// the given name.
+func (ctxt *Context) SetContainerStatus(container string, st Status, message string) error {
+	if err := checkContainer(container); err != nil {
+		return errgo.Notef(err, "cannot set status of container %q", container)
+	}
+	if ctxt.containerStatus == nil {
+		ctxt.containerStatus = &containerStatusCache{}
+	}
+	cache := ctxt.containerStatus
+	if err := cache.load(); err != nil {
+		return errgo.Mask(err)
+	}
+	cache.statuses[container] = containerStatus{
+		Status:  st,
+		Message: message,
+	}
+	if err := cache.save(); err != nil {
+		return errgo.Mask(err)
+	}
+	unitStatus, unitMessage := cache.summary()
+	return errgo.Mask(ctxt.SetStatus(unitStatus, unitMessage))
+}
+
+// summary returns the unit status and message
+// that summarize the container statuses.
+func (cache *containerStatusCache) summary() (Status, string) {
+	names := make([]string, 0, len(cache.statuses))
+	for name := range cache.statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	unitStatus := StatusActive
+	var msgs []string
+	for _, name := range names {
+		st := cache.statuses[name]
+		if statusSeverity[st.Status] > statusSeverity[unitStatus] {
+			unitStatus = st.Status
+		}
+		switch {
+		case st.Message != "":
+			msgs = append(msgs, name+": "+st.Message)
+		case st.Status != StatusActive:
+			msgs = append(msgs, name+": "+string(st.Status))
+		}
+	}
+	return unitStatus, strings.Join(msgs, "; ")
+}
+
+func (cache *containerStatusCache) load() error {
+	if cache.loaded {
+		return nil
+	}
+	cache.statuses = make(map[string]containerStatus)
+	cache.loaded = true
+	if cache.state == nil {
+		return nil
+	}
+	data, err := cache.state.Load(containerStatusStateName)
+	if err != nil {
+		return errgo.Notef(err, "cannot load container statuses")
+	}
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &cache.statuses); err != nil {
+		return errgo.Notef(err, "cannot unmarshal container statuses")
+	}
+	if cache.statuses == nil {
+		cache.statuses = make(map[string]containerStatus)
+	}
+	return nil
+}
+
+func (cache *containerStatusCache) save() error {
+	if cache.state == nil {
+		return nil
+	}
+	data, err := json.Marshal(cache.statuses)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal container statuses")
+	}
+	if err := cache.state.Save(containerStatusStateName, data); err != nil {
+		return errgo.Notef(err, "cannot save container statuses")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package hook
+
+// eventBus holds the subscribers to the events published
+// during a hook. It is shared between all contexts derived
+// from the same hook context.
+type eventBus struct {
+	subscribers map[string][]func(data interface{})
+}
+
+// Subscribe arranges for f to be called whenever the given event is
+// published with Publish during the current hook. This allows
+// charmbits to react to each other's events (for example "db-ready")
+// without depending on each other directly. Subscribers are usually
+// added by a context setter registered with Registry.RegisterContext,
+// so that they are in place before any hook functions run.
+//
+// Subscriptions apply to all contexts derived from the
+// current hook context and last until the hook completes.
+func (ctxt *Context) Subscribe(event string, f func(data interface{})) {
+	if ctxt.events == nil {
+		ctxt.events = &eventBus{}
+	}
+	if ctxt.events.subscribers == nil {
+		ctxt.events.subscribers = make(map[string][]func(interface{}))
+	}
+	ctxt.events.subscribers[event] = append(ctxt.events.subscribers[event], f)
+}
+
+// Publish calls all the functions subscribed to the given event with
+// Subscribe, in the order that they were subscribed, passing each
+// of them the given data. It returns when they have all returned.
+// Functions subscribed while the event is being published are not
+// called until the event is next published.
+func (ctxt *Context) Publish(event string, data interface{}) {
+	if ctxt.events == nil {
+		return
+	}
+	for _, f := range ctxt.events.subscribers[event] {
+		f(data)
+	}
+}
@@ -0,0 +1,73 @@
+package hook_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type eventSuite struct{}
+
+var _ = gc.Suite(&eventSuite{})
+
+func (*eventSuite) TestPublishSubscribe(c *gc.C) {
+	var received []string
+	subscriber := func(name string) func(*hook.Context) error {
+		return func(ctxt *hook.Context) error {
+			ctxt.Subscribe("db-ready", func(data interface{}) {
+				received = append(received, name+" "+data.(string))
+			})
+			return nil
+		}
+	}
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.Clone("a").RegisterContext(subscriber("a"), nil)
+			r.Clone("b").RegisterContext(subscriber("b"), nil)
+
+			var ctxt *hook.Context
+			pub := r.Clone("publisher")
+			pub.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			pub.RegisterHook("config-changed", func() error {
+				ctxt.Publish("db-ready", "first")
+				ctxt.Publish("other", "ignored")
+				ctxt.Publish("db-ready", "second")
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(received, jc.DeepEquals, []string{
+		"a first",
+		"b first",
+		"a second",
+		"b second",
+	})
+}
+
+func (*eventSuite) TestSubscribeDuringPublish(c *gc.C) {
+	var received []string
+	ctxt := &hook.Context{}
+	ctxt.Publish("ev", "no subscribers")
+	ctxt.Subscribe("ev", func(data interface{}) {
+		received = append(received, "outer "+data.(string))
+		ctxt.Subscribe("ev", func(data interface{}) {
+			received = append(received, "inner "+data.(string))
+		})
+	})
+	ctxt.Publish("ev", "one")
+	ctxt.Publish("ev", "two")
+	c.Assert(received, jc.DeepEquals, []string{
+		"outer one",
+		"outer two",
+		"inner two",
+	})
+}
@@ -0,0 +1,75 @@
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// RunCommand runs the named command with the given arguments in the
+// environment inherited from the hook, and returns its standard output.
+//
+// If the command fails, the returned error will include everything the
+// command printed to its standard output and standard error, so that
+// the cause of the failure can be seen in the charm log.
+//
+// The command is logged at LevelTrace when debugging is
+// enabled (see Context.Debugging).
+func (ctxt *Context) RunCommand(name string, args ...string) (stdout string, err error) {
+	if ctxt.Debugging() {
+		ctxt.logLevelf(LevelTrace, "running command %s %s", name, strings.Join(args, " "))
+	}
+	c := osexec.Command(name, args...)
+	var outBuf bytes.Buffer
+	combined := &lockedWriter{}
+	c.Stdout = io.MultiWriter(&outBuf, combined)
+	c.Stderr = combined
+	if err := c.Run(); err != nil {
+		output := strings.TrimSpace(combined.buf.String())
+		if output == "" {
+			return "", errgo.Notef(err, "command %s failed", name)
+		}
+		return "", errgo.Notef(err, "command %s failed with output %q", name, output)
+	}
+	return outBuf.String(), nil
+}
+
+// lockedWriter is a bytes.Buffer that may be written to
+// concurrently, as happens when a command's standard output
+// and standard error are copied to it by separate goroutines.
+type lockedWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(data)
+}
+
+// RunHookTool runs the named hook tool with the given arguments
+// and returns its raw standard output. The tool is run by
+// ctxt.Runner, so it is found in the charm's tool path and sees
+// the environment of the running hook, like the tools used by the
+// other methods on Context. It allows a charm to use hook tools that
+// have no typed wrapper in this package.
+//
+// If the hook tool is not provided by the running Juju, the
+// returned error will have ErrUnimplemented as its cause.
+func (ctxt *Context) RunHookTool(tool string, args ...string) ([]byte, error) {
+	if tool == "" || strings.ContainsAny(tool, `/\`) {
+		return nil, errgo.Newf("invalid hook tool name %q", tool)
+	}
+	ctxt.logLevelf(LevelTrace, "running hook tool %s %s", tool, strings.Join(args, " "))
+	out, err := ctxt.Runner.Run(tool, args...)
+	if err != nil {
+		return nil, errgo.NoteMask(err, fmt.Sprintf("hook tool %s failed", tool), errgo.Is(ErrUnimplemented))
+	}
+	return out, nil
+}
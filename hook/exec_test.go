@@ -0,0 +1,141 @@
+package hook_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type execSuite struct {
+	savedEnv map[string]string
+}
+
+var _ = gc.Suite(&execSuite{})
+
+func (s *execSuite) SetUpTest(c *gc.C) {
+	s.savedEnv = make(map[string]string)
+	for _, name := range []string{"PATH", "JUJU_CONTEXT_ID", "JUJU_UNIT_NAME"} {
+		s.savedEnv[name] = os.Getenv(name)
+	}
+}
+
+func (s *execSuite) TearDownTest(c *gc.C) {
+	for name, val := range s.savedEnv {
+		os.Setenv(name, val)
+	}
+}
+
+func (*execSuite) TestRunCommand(c *gc.C) {
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: c},
+	}
+	out, err := ctxt.RunCommand("sh", "-c", "echo hello; echo world >&2")
+	c.Assert(err, gc.IsNil)
+	c.Assert(out, gc.Equals, "hello\n")
+}
+
+func (*execSuite) TestRunCommandTrace(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	_, err := ctxt.RunCommand("true", "arg")
+	c.Assert(err, gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 0)
+
+	ctxt.EnableDebug()
+	_, err = ctxt.RunCommand("true", "arg")
+	c.Assert(err, gc.IsNil)
+	c.Assert(logger.msgs, jc.DeepEquals, []string{"TRACE: running command true arg"})
+}
+
+func (*execSuite) TestRunCommandFailure(c *gc.C) {
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: c},
+	}
+	out, err := ctxt.RunCommand("sh", "-c", "echo oops >&2; exit 1")
+	c.Assert(err, gc.ErrorMatches, `command sh failed with output "oops": exit status 1`)
+	c.Assert(out, gc.Equals, "")
+}
+
+func (*execSuite) TestRunHookTool(c *gc.C) {
+	r := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			if cmd == "juju-log" {
+				return nil, nil
+			}
+			return []byte("raw\x00output"), nil
+		},
+	}
+	ctxt := &hook.Context{
+		Runner: r,
+	}
+	out, err := ctxt.RunHookTool("new-tool", "--format", "json", "x")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, "raw\x00output")
+	c.Assert(r.Record[len(r.Record)-1], jc.DeepEquals, []string{"new-tool", "--format", "json", "x"})
+}
+
+func (*execSuite) TestRunHookToolInvalidName(c *gc.C) {
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: c},
+	}
+	_, err := ctxt.RunHookTool("../bin/sh")
+	c.Assert(err, gc.ErrorMatches, `invalid hook tool name "../bin/sh"`)
+	_, err = ctxt.RunHookTool("")
+	c.Assert(err, gc.ErrorMatches, `invalid hook tool name ""`)
+}
+
+func (*execSuite) TestRunHookToolEnvironment(c *gc.C) {
+	writeHookTools(c, map[string]string{
+		"new-tool": `echo "$JUJU_CONTEXT_ID $JUJU_UNIT_NAME $*"`,
+	})
+	os.Setenv("JUJU_CONTEXT_ID", "someunit/0-install-1")
+	os.Setenv("JUJU_UNIT_NAME", "someunit/0")
+	ctxt := &hook.Context{
+		Runner: hook.NewExecToolRunner(),
+	}
+	out, err := ctxt.RunHookTool("new-tool", "a", "b")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, "someunit/0-install-1 someunit/0 a b\n")
+}
+
+func (*execSuite) TestRunHookToolFailure(c *gc.C) {
+	writeHookTools(c, map[string]string{
+		"new-tool":     `echo partial; echo "error: something went wrong" >&2; exit 1`,
+		"unknown-tool": `echo 'error: bad request: unknown command "unknown-tool"' >&2; exit 2`,
+	})
+	ctxt := &hook.Context{
+		Runner: hook.NewExecToolRunner(),
+	}
+	out, err := ctxt.RunHookTool("new-tool")
+	c.Assert(err, gc.ErrorMatches, `hook tool new-tool failed: something went wrong`)
+	c.Assert(out, gc.IsNil)
+
+	_, err = ctxt.RunHookTool("unknown-tool")
+	c.Assert(err, gc.ErrorMatches, `hook tool unknown-tool failed: bad request: unknown command "unknown-tool"`)
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrUnimplemented)
+
+	_, err = ctxt.RunHookTool("missing-tool")
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrUnimplemented)
+}
+
+// writeHookTools writes shell scripts implementing the given hook
+// tools, keyed by name, to a new directory and makes that directory
+// the only one in $PATH. The path is restored by TearDownTest.
+func writeHookTools(c *gc.C, tools map[string]string) {
+	dir := c.MkDir()
+	for name, script := range tools {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		c.Assert(err, gc.IsNil)
+	}
+	os.Setenv("PATH", dir)
+}
@@ -0,0 +1,21 @@
+package hook
+
+// PatchDetectVirt replaces the function used by
+// Context.Platform to detect the virtualization
+// technology and clears the cached platform. It
+// returns a function that restores the original.
+func PatchDetectVirt(f func() (string, error)) (restore func()) {
+	old := detectVirt
+	detectVirt = f
+	platformCache.platform = ""
+	return func() {
+		detectVirt = old
+		platformCache.platform = ""
+	}
+}
+
+// NewExecToolRunner returns the ToolRunner used to run
+// hook tools when running inside a hook.
+func NewExecToolRunner() ToolRunner {
+	return execToolRunner{}
+}
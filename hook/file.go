@@ -0,0 +1,83 @@
+package hook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+)
+
+// WriteFileAtomic writes the given content to the file at path with
+// the given mode by writing a temporary file in the same directory
+// and renaming it, so that readers never see a partially written
+// file. If the file already holds exactly that content and mode,
+// nothing is written. It reports whether the file has been changed.
+func WriteFileAtomic(path string, content []byte, mode os.FileMode) (changed bool, err error) {
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() == mode.Perm() {
+		old, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Equal(old, content) {
+			return false, nil
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return false, errgo.Notef(err, "cannot create temporary file")
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(content); err != nil {
+		return false, errgo.Notef(err, "cannot write %q", f.Name())
+	}
+	if err := f.Chmod(mode); err != nil {
+		return false, errgo.Notef(err, "cannot set mode of %q", f.Name())
+	}
+	if err := f.Sync(); err != nil {
+		return false, errgo.Notef(err, "cannot sync %q", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		return false, errgo.Notef(err, "cannot close %q", f.Name())
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return false, errgo.Notef(err, "cannot replace %q", path)
+	}
+	return true, nil
+}
+
+// Restarter is implemented by services that can be restarted
+// so that they pick up changes to their configuration, such as
+// service.OSService (from github.com/mever/gocharm/v2/charmbits/service).
+// Restart should do nothing if the service is not running.
+type Restarter interface {
+	Restart() error
+}
+
+// UpdateFileAndReload writes the given content to the file at path
+// with the given mode using WriteFileAtomic, so that the service
+// never sees a partially written file. If the file has changed, the
+// service is restarted so that it picks up the change.
+//
+// It returns the checksum of the content, as returned by
+// FileChecksum, so that the caller can store it and compare
+// it later.
+func (ctxt *Context) UpdateFileAndReload(svc Restarter, path string, content []byte, mode os.FileMode) (checksum string, err error) {
+	checksum = fmt.Sprintf("%x", sha256.Sum256(content))
+	changed, err := WriteFileAtomic(path, content, mode)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if !changed {
+		return checksum, nil
+	}
+	if err := svc.Restart(); err != nil {
+		return "", errgo.Notef(err, "cannot restart service")
+	}
+	return checksum, nil
+}
@@ -0,0 +1,93 @@
+package hook_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type fileSuite struct{}
+
+var _ = gc.Suite(&fileSuite{})
+
+func (*fileSuite) TestWriteFileAtomic(c *gc.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "service.conf")
+	changed, err := hook.WriteFileAtomic(path, []byte("hello"), 0640)
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, jc.IsTrue)
+	assertFile(c, path, "hello", 0640)
+
+	// Writing the same content again changes nothing.
+	changed, err = hook.WriteFileAtomic(path, []byte("hello"), 0640)
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, jc.IsFalse)
+
+	// A different mode is a change.
+	changed, err = hook.WriteFileAtomic(path, []byte("hello"), 0644)
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, jc.IsTrue)
+	assertFile(c, path, "hello", 0644)
+
+	changed, err = hook.WriteFileAtomic(path, []byte("goodbye"), 0644)
+	c.Assert(err, gc.IsNil)
+	c.Assert(changed, jc.IsTrue)
+	assertFile(c, path, "goodbye", 0644)
+
+	// No temporary files are left behind.
+	names, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 1)
+}
+
+func (*fileSuite) TestWriteFileAtomicNoDirectory(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "missing", "service.conf")
+	_, err := hook.WriteFileAtomic(path, []byte("hello"), 0644)
+	c.Assert(err, gc.ErrorMatches, "cannot create temporary file: .*")
+}
+
+func assertFile(c *gc.C, path, content string, mode os.FileMode) {
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, content)
+	info, err := os.Stat(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm(), gc.Equals, mode)
+}
+
+func (*fileSuite) TestUpdateFileAndReloadChanged(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "service.conf")
+	err := ioutil.WriteFile(path, []byte("old"), 0600)
+	c.Assert(err, gc.IsNil)
+
+	ctxt := &hook.Context{}
+	svc := &hooktest.RecordingService{IsRunning: true}
+	sum, err := ctxt.UpdateFileAndReload(svc, path, []byte("new"), 0644)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sum, gc.Equals, "11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437")
+	c.Assert(svc.Calls, jc.DeepEquals, []string{"Restart"})
+
+	// The checksum is the same as that of the file.
+	fileSum, err := ctxt.FileChecksum(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(fileSum, gc.Equals, sum)
+	assertFile(c, path, "new", 0644)
+}
+
+func (*fileSuite) TestUpdateFileAndReloadUnchanged(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "service.conf")
+	err := ioutil.WriteFile(path, []byte("same"), 0644)
+	c.Assert(err, gc.IsNil)
+
+	svc := &hooktest.RecordingService{IsRunning: true}
+	sum, err := (&hook.Context{}).UpdateFileAndReload(svc, path, []byte("same"), 0644)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sum, gc.Equals, "0967115f2813a3541eaef77de9d9d5773f1c0c04314b0bbfe4ff3b3b1c55b5d5")
+	c.Assert(svc.Calls, gc.HasLen, 0)
+}
@@ -38,8 +38,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/juju/charm/v9"
 	"github.com/juju/names/v4"
 	"gopkg.in/errgo.v1"
 )
@@ -112,6 +114,23 @@ type Context struct {
 	// for a relation-broken hook.
 	RemoteUnit UnitId
 
+	// RemoteApp holds the name of the application that the
+	// current relation hook is running for. Unlike RemoteUnit,
+	// this is also set for relation-broken hooks.
+	RemoteApp string
+
+	// Fields valid for secret-related hooks only.
+
+	// SecretId holds the URI of the secret that the current
+	// secret hook is running for.
+	SecretId string
+
+	// SecretRevision holds the revision of the secret that the
+	// current secret hook is running for. It is only set for
+	// hooks that concern a particular revision, such as
+	// secret-expired.
+	SecretRevision int
+
 	// Runner is used to run hook tools by methods on the context.
 	Runner ToolRunner
 
@@ -125,6 +144,48 @@ type Context struct {
 	// RunCommandArgs holds any arguments that were passed to
 	// the above command.
 	RunCommandArgs []string
+
+	// logs holds the log buffer used by Logf. It is shared
+	// between all contexts derived from the same hook context.
+	logs *logBuffer
+
+	// events holds the subscribers to events published with
+	// Publish. It is shared between all contexts derived from
+	// the same hook context.
+	events *eventBus
+
+	// zone caches the availability zone returned by
+	// AvailabilityZone. It is shared between all contexts
+	// derived from the same hook context.
+	zone *zoneCache
+
+	// localSettings caches the local unit's relation settings
+	// read and written by SetRelationWithId. It is shared
+	// between all contexts derived from the same hook context.
+	localSettings *relationSettingsCache
+
+	// containerStatus holds the statuses of the workload
+	// containers set with SetContainerStatus. It is shared
+	// between all contexts derived from the same hook context.
+	containerStatus *containerStatusCache
+
+	// tracer records the spans started with StartSpan. It is
+	// nil if span export is not enabled, and is shared between
+	// all contexts derived from the same hook context.
+	tracer *tracer
+
+	// requiredConfig holds the names of the configuration
+	// options registered with Registry.RegisterRequiredConfig.
+	requiredConfig []string
+
+	// relations holds the relations registered with
+	// Registry.RegisterRelation, keyed by relation name.
+	relations map[string]charm.Relation
+
+	// attempt holds the number of times the current hook has
+	// been attempted since it last succeeded, including this
+	// attempt. It is zero if the hook is not being run by Main.
+	attempt int
 }
 
 // Relation holds the current relation settings for the unit
@@ -161,7 +222,27 @@ func (ctxt *Context) StateDir() string {
 	if ctxt.HookStateDir == "" {
 		panic("empty hook state directory")
 	}
-	return filepath.Join(ctxt.HookStateDir, ctxt.UUID+"-"+ctxt.UnitTag(), ctxt.registryName)
+	return filepath.Join(ctxt.unitStateDir(), ctxt.registryName)
+}
+
+// unitStateDir returns the path to the directory where local state
+// for the context's unit is stored. Each unit on the machine has
+// its own directory within the hook state directory.
+func (ctxt *Context) unitStateDir() string {
+	return filepath.Join(ctxt.HookStateDir, ctxt.UUID+"-"+ctxt.UnitTag())
+}
+
+// CharmPath returns the path of the file in the charm directory
+// (see CharmDir) with the given path elements, joined as by
+// filepath.Join. With no elements, it returns the charm directory
+// itself. It panics if the charm directory is not set; a context
+// created with NewContextFromEnvironment always has it set, because
+// $CHARM_DIR is required to be set.
+func (ctxt *Context) CharmPath(elem ...string) string {
+	if ctxt.CharmDir == "" {
+		panic("empty charm directory")
+	}
+	return filepath.Join(append([]string{ctxt.CharmDir}, elem...)...)
 }
 
 // CommandName returns a value that can be used to make runhook run the
@@ -220,9 +301,29 @@ func (ctxt *Context) PrivateAddress() (string, error) {
 }
 
 // Log logs a message through the juju logging facility.
+// If log buffering has been enabled with BufferLogs,
+// the message may not be sent until later.
+//
+// When the GOCHARM_LOG_CALLER environment variable is set to
+// a true value such as "1", messages logged with Logf, Debugf,
+// LogError and the methods of Logger are prefixed with the file
+// name and line number of the code that logged them.
 func (ctxt *Context) Logf(f string, a ...interface{}) error {
-	_, err := ctxt.Runner.Run("juju-log", fmt.Sprintf(f, a...))
-	return errgo.Mask(err)
+	msg := ctxt.identify(withCaller(0, fmt.Sprintf(f, a...)))
+	if ctxt.logs != nil && ctxt.logs.enabled {
+		return ctxt.logs.add(ctxt.Runner, msg)
+	}
+	return jujuLog(ctxt.Runner, msg)
+}
+
+// logLevelf logs a message through the juju logging facility
+// at the given level. Any buffered messages are flushed first
+// so that ordering is preserved.
+func (ctxt *Context) logLevelf(level LogLevel, f string, a ...interface{}) error {
+	if err := ctxt.FlushLogs(); err != nil {
+		return errgo.Mask(err)
+	}
+	return jujuLog(ctxt.Runner, ctxt.identify(fmt.Sprintf(f, a...)), "-l", level.String())
 }
 
 // getAllRelationUnit returns all the settings from the given unit associated
@@ -260,8 +361,51 @@ func (ctxt *Context) SetRelation(keyvals ...string) error {
 	return errgo.Mask(err)
 }
 
+// SetRelationData sets all the given settings on the
+// relation with the given id with a single relation-set
+// invocation. As with SetRelationWithId, only the values
+// that have changed are set, and an empty value removes
+// the setting.
+func (ctxt *Context) SetRelationData(relationId RelationId, data map[string]string) error {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keyvals := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		keyvals = append(keyvals, key, data[key])
+	}
+	return errgo.Mask(ctxt.SetRelationWithId(relationId, keyvals...))
+}
+
+// SetRelationDataAll sets all the given settings, as with
+// SetRelationData, on every current relation with the given
+// name, in the order they appear in RelationIds. It does
+// nothing if there are no such relations.
+func (ctxt *Context) SetRelationDataAll(relationName string, data map[string]string) error {
+	if ctxt.relations != nil {
+		if _, ok := ctxt.relations[relationName]; !ok {
+			return errgo.Newf("relation %q not registered", relationName)
+		}
+	}
+	for _, id := range ctxt.RelationIds[relationName] {
+		if err := ctxt.SetRelationData(id, data); err != nil {
+			return errgo.Notef(err, "cannot set data on relation %s", id)
+		}
+	}
+	return nil
+}
+
 // SetRelationWithId sets the given key-value pairs
 // on the relation with the given id.
+//
+// Only the values that differ from the unit's current settings for
+// the relation are set, so that setting unchanged values does not
+// cause a spurious relation-changed hook on the remote side. If
+// nothing has changed, relation-set is not run at all. The current
+// settings are read the first time they are needed in a hook and
+// cached for the rest of it.
 func (ctxt *Context) SetRelationWithId(relationId RelationId, keyvals ...string) error {
 	if len(keyvals)%2 != 0 {
 		return errgo.Newf("invalid key/value count")
@@ -269,13 +413,73 @@ func (ctxt *Context) SetRelationWithId(relationId RelationId, keyvals ...string)
 	if len(keyvals) == 0 {
 		return nil
 	}
+	current, err := ctxt.localRelationSettings(relationId)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	changed := make(map[string]string)
 	args := make([]string, 0, 3+len(keyvals)/2)
 	args = append(args, "-r", string(relationId), "--")
 	for i := 0; i < len(keyvals); i += 2 {
-		args = append(args, fmt.Sprintf("%s=%s", keyvals[i], keyvals[i+1]))
+		key, val := keyvals[i], keyvals[i+1]
+		// Note that an empty value deletes the key, so it
+		// is unchanged if the key is not currently set.
+		prev, ok := changed[key]
+		if !ok {
+			prev = current[key]
+		}
+		if prev == val {
+			continue
+		}
+		changed[key] = val
+		args = append(args, fmt.Sprintf("%s=%s", key, val))
 	}
-	_, err := ctxt.Runner.Run("relation-set", args...)
-	return errgo.Mask(err)
+	if len(args) == 3 {
+		return nil
+	}
+	if _, err := ctxt.Runner.Run("relation-set", args...); err != nil {
+		return errgo.Mask(err)
+	}
+	for key, val := range changed {
+		if val == "" {
+			delete(current, key)
+		} else {
+			current[key] = val
+		}
+	}
+	return nil
+}
+
+// relationSettingsCache holds the settings of the local
+// unit for the relations that have been read during
+// the current hook, keyed by relation id.
+type relationSettingsCache struct {
+	settings map[RelationId]map[string]string
+}
+
+// localRelationSettings returns the local unit's settings for the
+// relation with the given id. They are read with relation-get only
+// the first time they are needed in the hook; the returned map is
+// cached and must be updated when the settings are changed.
+func (ctxt *Context) localRelationSettings(relationId RelationId) (map[string]string, error) {
+	if ctxt.localSettings == nil {
+		ctxt.localSettings = &relationSettingsCache{}
+	}
+	if settings, ok := ctxt.localSettings.settings[relationId]; ok {
+		return settings, nil
+	}
+	settings, err := ctxt.getAllRelationUnit(relationId, ctxt.Unit)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get current relation settings")
+	}
+	if settings == nil {
+		settings = make(map[string]string)
+	}
+	if ctxt.localSettings.settings == nil {
+		ctxt.localSettings.settings = make(map[RelationId]map[string]string)
+	}
+	ctxt.localSettings.settings[relationId] = settings
+	return settings, nil
 }
 
 // GetConfig reads the charm configuration value for the given
@@ -347,6 +551,36 @@ func (ctxt *Context) GetAllConfig(val interface{}) error {
 	return nil
 }
 
+// CheckRequiredConfig returns the names of all the configuration options
+// registered with Registry.RegisterRequiredConfig that have not been set,
+// in alphabetical order. An option holding the empty string is treated
+// as unset. It is intended to be used by a config-changed hook
+// to set a blocked status, for example:
+//
+//	missing, err := ctxt.CheckRequiredConfig()
+//	if err != nil {
+//		return err
+//	}
+//	if len(missing) > 0 {
+//		return ctxt.SetStatus(hook.StatusBlocked, "missing config: "+strings.Join(missing, ", "))
+//	}
+func (ctxt *Context) CheckRequiredConfig() ([]string, error) {
+	if len(ctxt.requiredConfig) == 0 {
+		return nil, nil
+	}
+	var config map[string]interface{}
+	if err := ctxt.GetAllConfig(&config); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var missing []string
+	for _, name := range ctxt.requiredConfig {
+		if val := config[name]; val == nil || val == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
 // Status represents the current status of a charm.
 type Status string
 
@@ -372,7 +606,7 @@ func (ctxt *Context) SetStatus(st Status, message string) error {
 func (ctxt *Context) runJSON(dst interface{}, cmd string, args ...string) error {
 	out, err := ctxt.Runner.Run(cmd, args...)
 	if err != nil {
-		return errgo.Mask(err)
+		return errgo.Mask(err, errgo.Is(ErrUnimplemented))
 	}
 	if err := json.Unmarshal(out, dst); err != nil {
 		return errgo.Notef(err, "cannot parse command output %q", out)
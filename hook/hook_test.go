@@ -0,0 +1,44 @@
+package hook_test
+
+import (
+	"os"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type contextSuite struct{}
+
+var _ = gc.Suite(&contextSuite{})
+
+func (*contextSuite) TestCharmPath(c *gc.C) {
+	ctxt := &hook.Context{
+		CharmDir: "/var/lib/juju/agents/unit-someunit-0/charm",
+	}
+	c.Assert(ctxt.CharmPath(), gc.Equals, "/var/lib/juju/agents/unit-someunit-0/charm")
+	c.Assert(ctxt.CharmPath("bin", "runhook"), gc.Equals, "/var/lib/juju/agents/unit-someunit-0/charm/bin/runhook")
+	c.Assert(ctxt.CharmPath("templates/../config.yaml"), gc.Equals, "/var/lib/juju/agents/unit-someunit-0/charm/config.yaml")
+}
+
+func (*contextSuite) TestCharmPathUnset(c *gc.C) {
+	ctxt := &hook.Context{}
+	c.Assert(func() {
+		ctxt.CharmPath("bin")
+	}, gc.PanicMatches, "empty charm directory")
+}
+
+func (*contextSuite) TestCharmDirFromEnvironment(c *gc.C) {
+	for _, v := range []string{"JUJU_MODEL_UUID", "JUJU_UNIT_NAME", "JUJU_CONTEXT_ID", "JUJU_RELATION"} {
+		defer os.Setenv(v, os.Getenv(v))
+	}
+	os.Setenv("JUJU_MODEL_UUID", "373b309b-4a86-4f13-88e2-c213d97075b8")
+	os.Setenv("JUJU_UNIT_NAME", "someunit/0")
+	os.Setenv("JUJU_CONTEXT_ID", "someunit/0-install-1")
+	os.Setenv("JUJU_RELATION", "")
+	defer os.Setenv("CHARM_DIR", os.Getenv("CHARM_DIR"))
+
+	os.Unsetenv("CHARM_DIR")
+	_, _, err := hook.NewContextFromEnvironment(hook.NewRegistry(), c.MkDir(), "install", nil)
+	c.Assert(err, gc.ErrorMatches, `required environment variable "CHARM_DIR" not set`)
+}
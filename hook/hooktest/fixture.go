@@ -0,0 +1,96 @@
+package hooktest
+
+import (
+	"fmt"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+// RelationFixture describes a relation instance and the
+// remote units that have joined it. It can be added to
+// a Runner with Runner.AddRelation, which makes its
+// settings available to hooks through the hook context
+// and the relation-ids, relation-list and relation-get
+// hook tools.
+//
+// The methods return the fixture itself so that calls
+// can be chained, for example:
+//
+//	id := runner.AddRelation(
+//		hooktest.NewRelationFixture("db").
+//			AddUnit("mysql/0", map[string]string{"host": "10.0.0.1"}).
+//			AddUnit("mysql/1", map[string]string{"host": "10.0.0.2"}),
+//	)
+type RelationFixture struct {
+	name  string
+	id    hook.RelationId
+	units map[hook.UnitId]map[string]string
+	app   map[string]string
+}
+
+// NewRelationFixture returns a fixture for an instance of
+// the relation with the given name, as declared in the
+// charm's metadata.
+func NewRelationFixture(relName string) *RelationFixture {
+	return &RelationFixture{
+		name:  relName,
+		units: make(map[hook.UnitId]map[string]string),
+	}
+}
+
+// WithId sets the relation id of the fixture. If this is not
+// called, Runner.AddRelation will choose an unused id.
+func (f *RelationFixture) WithId(id hook.RelationId) *RelationFixture {
+	f.id = id
+	return f
+}
+
+// AddUnit adds a remote unit with the given relation
+// settings to the fixture.
+func (f *RelationFixture) AddUnit(unit hook.UnitId, settings map[string]string) *RelationFixture {
+	if settings == nil {
+		settings = make(map[string]string)
+	}
+	f.units[unit] = settings
+	return f
+}
+
+// SetAppSettings sets the application settings of the
+// remote application, as returned by relation-get --app.
+func (f *RelationFixture) SetAppSettings(settings map[string]string) *RelationFixture {
+	f.app = settings
+	return f
+}
+
+// AddRelation adds the given relation fixture to the runner's
+// Relations, RelationIds and AppRelations fields and returns
+// the relation id of the fixture.
+func (runner *Runner) AddRelation(f *RelationFixture) hook.RelationId {
+	if runner.Relations == nil {
+		runner.Relations = make(map[hook.RelationId]map[hook.UnitId]map[string]string)
+	}
+	if runner.RelationIds == nil {
+		runner.RelationIds = make(map[string][]hook.RelationId)
+	}
+	id := f.id
+	if id == "" {
+		for n := 0; ; n++ {
+			id = hook.RelationId(fmt.Sprintf("%s:%d", f.name, n))
+			if _, ok := runner.Relations[id]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := runner.Relations[id]; ok {
+		panic(fmt.Errorf("relation id %q added twice", id))
+	}
+	runner.Relations[id] = f.units
+	runner.RelationIds[f.name] = append(runner.RelationIds[f.name], id)
+	if f.app != nil {
+		if runner.AppRelations == nil {
+			runner.AppRelations = make(map[hook.RelationId]map[string]string)
+		}
+		runner.AppRelations[id] = f.app
+	}
+	return id
+}
@@ -0,0 +1,98 @@
+package hooktest_test
+
+import (
+	"sort"
+
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type fixtureSuite struct{}
+
+var _ = gc.Suite(&fixtureSuite{})
+
+func (*fixtureSuite) TestRelationChangedWithFixture(c *gc.C) {
+	var addrs []string
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterRelation(charm.Relation{
+				Name:      "db",
+				Role:      charm.RoleRequirer,
+				Interface: "mysql",
+			})
+			var ctxt *hook.Context
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			r.RegisterHook("db-relation-changed", func() error {
+				if ctxt.RemoteUnit != "mysql/1" {
+					return nil
+				}
+				for _, settings := range ctxt.Relations[ctxt.RelationId] {
+					addrs = append(addrs, settings["host"])
+				}
+				sort.Strings(addrs)
+				return ctxt.SetRelation("seen", ctxt.Relation()["host"])
+			})
+		},
+	}
+	id := runner.AddRelation(hooktest.NewRelationFixture("db").
+		AddUnit("mysql/0", map[string]string{"host": "10.0.0.1"}).
+		AddUnit("mysql/1", map[string]string{"host": "10.0.0.2"}),
+	)
+	c.Assert(id, gc.Equals, hook.RelationId("db:0"))
+
+	err := runner.RunHook("db-relation-changed", id, "mysql/1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(addrs, jc.DeepEquals, []string{"10.0.0.1", "10.0.0.2"})
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"relation-get", "-r", "db:0", "--format", "json", "--", "-", "someunit/0"},
+		{"relation-set", "-r", "db:0", "--", "seen=10.0.0.2"},
+	})
+}
+
+func (*fixtureSuite) TestFixtureServesHookTools(c *gc.C) {
+	runner := &hooktest.Runner{}
+	id0 := runner.AddRelation(hooktest.NewRelationFixture("db").
+		AddUnit("mysql/1", map[string]string{"host": "10.0.0.2"}).
+		AddUnit("mysql/0", nil).
+		SetAppSettings(map[string]string{"version": "8"}),
+	)
+	id1 := runner.AddRelation(hooktest.NewRelationFixture("db").WithId("db:7"))
+	id2 := runner.AddRelation(hooktest.NewRelationFixture("db"))
+	c.Assert([]hook.RelationId{id0, id1, id2}, jc.DeepEquals, []hook.RelationId{"db:0", "db:7", "db:1"})
+
+	out, err := runner.Run("relation-ids", "--format", "json", "--", "db")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, `["db:0","db:7","db:1"]`)
+
+	out, err = runner.Run("relation-list", "--format", "json", "-r", "db:0")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, `["mysql/0","mysql/1"]`)
+
+	out, err = runner.Run("relation-get", "-r", "db:0", "--format", "json", "--", "-", "mysql/1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, `{"host":"10.0.0.2"}`)
+
+	out, err = runner.Run("relation-get", "-r", "db:0", "--app", "--format", "json", "--", "-", "mysql")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, `{"version":"8"}`)
+
+	// None of the above calls are recorded.
+	c.Assert(runner.Record, gc.HasLen, 0)
+}
+
+func (*fixtureSuite) TestAddRelationDuplicateId(c *gc.C) {
+	runner := &hooktest.Runner{}
+	runner.AddRelation(hooktest.NewRelationFixture("db").WithId("db:0"))
+	c.Assert(func() {
+		runner.AddRelation(hooktest.NewRelationFixture("db").WithId("db:0"))
+	}, gc.PanicMatches, `relation id "db:0" added twice`)
+}
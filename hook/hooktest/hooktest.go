@@ -3,6 +3,7 @@ package hooktest
 
 import (
 	"encoding/json"
+	"sort"
 	"strings"
 
 	"gopkg.in/errgo.v1"
@@ -18,7 +19,14 @@ import (
 // exception of the calls mentioned below.
 //
 // Any calls to juju-log are logged using Logger, but otherwise ignored.
+// Messages logged at an explicit level are prefixed with that level.
 // Calls to config-get from the Config field and not invoked through RunFunc.
+// Calls to relation-get --app are satisfied from the AppRelations field.
+// Calls to relation-ids, relation-list and relation-get for relations
+// and units that are present in the RelationIds and Relations fields
+// (see AddRelation) are satisfied from those fields. Other calls
+// to relation-get for a single unit are recorded like any other
+// call and, if RunFunc is nil, satisfied from LocalRelations.
 // Likewise, calls to unit-get will be satisfied from the PublicAddress
 // and PrivateAddress fields.
 type Runner struct {
@@ -30,6 +38,18 @@ type Runner struct {
 	RelationIds map[string][]hook.RelationId
 	Config      map[string]interface{}
 
+	// AppRelations holds the application settings of the remote
+	// application for each relation id. They are used to satisfy
+	// calls to relation-get --app.
+	AppRelations map[hook.RelationId]map[string]string
+
+	// LocalRelations holds the settings of the local unit for
+	// each relation id. When RunFunc is nil, they are used to
+	// satisfy calls to relation-get for units not mentioned in
+	// Relations. They are updated by successful calls to
+	// relation-set.
+	LocalRelations map[hook.RelationId]map[string]string
+
 	PublicAddress  string
 	PrivateAddress string
 
@@ -128,10 +148,14 @@ func (runner *Runner) RunCommand(cmdName string, args []string) (hook.Command, e
 // Run implements hook.Runner.Run.
 func (runner *Runner) Run(cmd string, args ...string) ([]byte, error) {
 	if cmd == "juju-log" {
-		if len(args) != 1 {
+		switch {
+		case len(args) == 1:
+			runner.Logger.Logf("%s", args[0])
+		case len(args) == 3 && args[0] == "-l":
+			runner.Logger.Logf("%s: %s", args[1], args[2])
+		default:
 			panic("expected exactly one argument to juju-log")
 		}
-		runner.Logger.Logf("%s", args[0])
 		return nil, nil
 	}
 	switch cmd {
@@ -150,6 +174,34 @@ func (runner *Runner) Run(cmd string, args ...string) ([]byte, error) {
 			panic(err)
 		}
 		return data, nil
+	case "relation-get":
+		if relId, ok := appRelationGetId(args); ok {
+			return marshalJSON(runner.AppRelations[relId]), nil
+		}
+		if relId, ok := unitRelationGetId(args); ok {
+			if settings, ok := runner.Relations[relId][hook.UnitId(args[6])]; ok {
+				return marshalJSON(settings), nil
+			}
+		}
+	case "relation-ids":
+		// relation-ids --format json -- name
+		if len(args) == 4 {
+			if ids, ok := runner.RelationIds[args[3]]; ok {
+				return marshalJSON(ids), nil
+			}
+		}
+	case "relation-list":
+		// relation-list --format json -r id
+		if len(args) == 4 && args[2] == "-r" {
+			if units, ok := runner.Relations[hook.RelationId(args[3])]; ok {
+				ids := make([]string, 0, len(units))
+				for unit := range units {
+					ids = append(ids, string(unit))
+				}
+				sort.Strings(ids)
+				return marshalJSON(ids), nil
+			}
+		}
 	case "unit-get":
 		if len(args) != 1 {
 			panic("expected exactly one argument to unit-get")
@@ -166,10 +218,87 @@ func (runner *Runner) Run(cmd string, args ...string) ([]byte, error) {
 	rec := []string{cmd}
 	rec = append(rec, args...)
 	runner.Record = append(runner.Record, rec)
+	var out []byte
 	if runner.RunFunc != nil {
-		return runner.RunFunc(cmd, args...)
+		var err error
+		out, err = runner.RunFunc(cmd, args...)
+		if err != nil {
+			return out, err
+		}
+	} else if relId, ok := unitRelationGetId(args); ok && cmd == "relation-get" {
+		out = marshalJSON(runner.LocalRelations[relId])
+	}
+	if cmd == "relation-set" {
+		runner.setLocalRelation(args)
+	}
+	return out, nil
+}
+
+// setLocalRelation updates LocalRelations from the
+// given relation-set arguments.
+func (runner *Runner) setLocalRelation(args []string) {
+	// relation-set -r id -- key=val...
+	if len(args) < 3 || args[0] != "-r" || args[2] != "--" {
+		return
+	}
+	relId := hook.RelationId(args[1])
+	if runner.LocalRelations == nil {
+		runner.LocalRelations = make(map[hook.RelationId]map[string]string)
+	}
+	settings := runner.LocalRelations[relId]
+	if settings == nil {
+		settings = make(map[string]string)
+		runner.LocalRelations[relId] = settings
+	}
+	for _, kv := range args[3:] {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[1] == "" {
+			delete(settings, kv[0])
+		} else {
+			settings[kv[0]] = kv[1]
+		}
+	}
+}
+
+func marshalJSON(val interface{}) []byte {
+	data, err := json.Marshal(val)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// unitRelationGetId reports whether the given relation-get
+// arguments ask for all the settings of a single unit and
+// returns the relation id that they are for.
+func unitRelationGetId(args []string) (hook.RelationId, bool) {
+	// relation-get -r id --format json -- - unit
+	if len(args) == 7 && args[0] == "-r" && args[5] == "-" {
+		return hook.RelationId(args[1]), true
+	}
+	return "", false
+}
+
+// appRelationGetId reports whether the given relation-get
+// arguments ask for application settings and returns the
+// relation id that they are for.
+func appRelationGetId(args []string) (hook.RelationId, bool) {
+	var relId hook.RelationId
+	isApp := false
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return relId, isApp
+		case arg == "--app":
+			isApp = true
+		case arg == "-r" && i+1 < len(args):
+			relId = hook.RelationId(args[i+1])
+		}
 	}
-	return nil, nil
+	return relId, isApp
 }
 
 // Run implements hook.Runner.Close.
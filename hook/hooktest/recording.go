@@ -0,0 +1,122 @@
+package hooktest
+
+import (
+	"context"
+	"time"
+
+	"github.com/mever/gocharm/v2/charmbits/service"
+)
+
+var _ service.OSService = (*RecordingService)(nil)
+
+// RecordingService is an implementation of service.OSService that
+// runs nothing but records the calls made to it. Unlike the services
+// created by NewServiceFunc, it can be used without a Runner, for
+// testing code that manages an OSService directly.
+type RecordingService struct {
+	// Params holds the parameters passed to NewService.
+	Params service.OSServiceParams
+
+	// IsRunning holds whether the service is running.
+	IsRunning bool
+
+	// Calls records the names of the methods called
+	// that change the service, in order.
+	Calls []string
+}
+
+// NewService records the given parameters in svc.Params and returns
+// svc. It can be assigned to service.NewService so that svc is used
+// for every service created.
+func (svc *RecordingService) NewService(p service.OSServiceParams) (service.OSService, error) {
+	svc.Params = p
+	return svc, nil
+}
+
+// Install implements service.OSService.Install.
+func (svc *RecordingService) Install() error {
+	svc.Calls = append(svc.Calls, "Install")
+	return nil
+}
+
+// StopAndRemove implements service.OSService.StopAndRemove.
+func (svc *RecordingService) StopAndRemove() error {
+	svc.Calls = append(svc.Calls, "StopAndRemove")
+	svc.IsRunning = false
+	return nil
+}
+
+// Status implements service.OSService.Status.
+func (svc *RecordingService) Status() (service.ServiceStatus, error) {
+	if svc.IsRunning {
+		return service.ServiceRunning, nil
+	}
+	return service.ServiceStopped, nil
+}
+
+// Running implements service.OSService.Running.
+func (svc *RecordingService) Running() bool {
+	return svc.IsRunning
+}
+
+// Stop implements service.OSService.Stop.
+func (svc *RecordingService) Stop() error {
+	svc.Calls = append(svc.Calls, "Stop")
+	svc.IsRunning = false
+	return nil
+}
+
+// StopWithTimeout implements service.OSService.StopWithTimeout.
+// It is recorded as a call to Stop.
+func (svc *RecordingService) StopWithTimeout(d time.Duration) error {
+	return svc.Stop()
+}
+
+// StopContext implements service.OSService.StopContext.
+// It is recorded as a call to Stop.
+func (svc *RecordingService) StopContext(ctx context.Context) error {
+	return svc.Stop()
+}
+
+// Start implements service.OSService.Start.
+func (svc *RecordingService) Start() error {
+	svc.Calls = append(svc.Calls, "Start")
+	svc.IsRunning = true
+	return nil
+}
+
+// StartContext implements service.OSService.StartContext.
+// It is recorded as a call to Start.
+func (svc *RecordingService) StartContext(ctx context.Context) error {
+	return svc.Start()
+}
+
+// StartWithRetry implements service.OSService.StartWithRetry.
+// It is recorded as a single call to Start.
+func (svc *RecordingService) StartWithRetry(attempts int, initial time.Duration) error {
+	return svc.Start()
+}
+
+// Restart implements service.OSService.Restart.
+// Like the real implementation, it does nothing,
+// and is not recorded, if the service is not running.
+func (svc *RecordingService) Restart() error {
+	if !svc.IsRunning {
+		return nil
+	}
+	svc.Calls = append(svc.Calls, "Restart")
+	return nil
+}
+
+// RunAsServiceUser implements service.OSService.RunAsServiceUser.
+// It runs nothing and returns no output.
+func (svc *RecordingService) RunAsServiceUser(cmd string, args ...string) (string, error) {
+	svc.Calls = append(svc.Calls, "RunAsServiceUser")
+	return "", nil
+}
+
+// Healthy implements service.OSService.Healthy.
+// The service is healthy when it is running.
+func (svc *RecordingService) Healthy() (bool, error) {
+	return svc.IsRunning, nil
+}
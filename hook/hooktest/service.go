@@ -1,7 +1,10 @@
 package hooktest
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"gopkg.in/errgo.v1"
 
@@ -18,17 +21,17 @@ import (
 // If the notify channel is not nil, it will be used to send
 // events about services created with the function.
 // It should be buffered with a size of at least 2.
-func NewServiceFunc(r *Runner, notify chan ServiceEvent) func(service.OSServiceParams) service.OSService {
+func NewServiceFunc(r *Runner, notify chan ServiceEvent) func(service.OSServiceParams) (service.OSService, error) {
 	services := &osServices{
 		notify:    notify,
 		runner:    r,
 		installed: make(map[string]*installedOSService),
 	}
-	return func(p service.OSServiceParams) service.OSService {
+	return func(p service.OSServiceParams) (service.OSService, error) {
 		return &osService{
 			params:   p,
 			services: services,
-		}
+		}, nil
 	}
 }
 
@@ -169,12 +172,24 @@ func (svc *osService) StopAndRemove() error {
 	return nil
 }
 
-// Running implements service.OSService.Running.
-func (svc *osService) Running() bool {
+// Status implements service.OSService.Status.
+func (svc *osService) Status() (service.ServiceStatus, error) {
 	svc.services.mu.Lock()
 	defer svc.services.mu.Unlock()
 	isvc := svc.installedService()
-	return isvc != nil && isvc.cmd != nil
+	switch {
+	case isvc == nil:
+		return service.ServiceNotInstalled, nil
+	case isvc.cmd == nil:
+		return service.ServiceStopped, nil
+	}
+	return service.ServiceRunning, nil
+}
+
+// Running implements service.OSService.Running.
+func (svc *osService) Running() bool {
+	st, err := svc.Status()
+	return err == nil && st == service.ServiceRunning
 }
 
 // Stop implements service.OSService.Stop.
@@ -195,6 +210,32 @@ func (svc *osService) Stop() error {
 	return nil
 }
 
+// StopWithTimeout implements service.OSService.StopWithTimeout.
+// The service always stops immediately, so the
+// timeout is ignored.
+func (svc *osService) StopWithTimeout(d time.Duration) error {
+	return svc.Stop()
+}
+
+// StopContext implements service.OSService.StopContext.
+// The service always stops immediately, so the context
+// is only checked before stopping it.
+func (svc *osService) StopContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("can not stop service %q", svc.params.Name), errgo.Any)
+	}
+	return svc.Stop()
+}
+
+// Restart implements service.OSService.Restart.
+func (svc *osService) Restart() error {
+	if !svc.Running() {
+		return nil
+	}
+	svc.Stop()
+	return svc.Start()
+}
+
 // Start implements service.OSService.Start.
 func (svc *osService) Start() error {
 	svc.services.mu.Lock()
@@ -234,3 +275,56 @@ func (svc *osService) Start() error {
 	}()
 	return nil
 }
+
+// StartContext implements service.OSService.StartContext.
+// The service always starts immediately, so the context
+// is only checked before starting it.
+func (svc *osService) StartContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("can not start service %q", svc.params.Name), errgo.Any)
+	}
+	return svc.Start()
+}
+
+// StartWithRetry implements service.OSService.StartWithRetry.
+// The service always starts immediately, so
+// it is only started once.
+func (svc *osService) StartWithRetry(attempts int, initial time.Duration) error {
+	if attempts < 1 {
+		return errgo.Newf("can not start service %q: invalid number of attempts %d", svc.params.Name, attempts)
+	}
+	return svc.Start()
+}
+
+// Healthy implements service.OSService.Healthy.
+// Rather than reporting the result of a periodic health
+// check, it calls the service's health check directly
+// if the service is running.
+func (svc *osService) Healthy() (bool, error) {
+	if svc.params.HealthCheck == nil {
+		return false, errgo.WithCausef(nil, service.ErrHealthUnknown, "service %q has no health check", svc.params.Name)
+	}
+	if !svc.Running() {
+		return false, errgo.Newf("service %q is not running", svc.params.Name)
+	}
+	if err := svc.params.HealthCheck(); err != nil {
+		return false, errgo.Notef(err, "service %q health check failed", svc.params.Name)
+	}
+	return true, nil
+}
+
+// RunAsServiceUser implements service.OSService.RunAsServiceUser.
+// Rather than running the command, it passes the sudo command
+// that would be used to the Runner's Run method, so it is recorded
+// and can be faked with RunFunc.
+func (svc *osService) RunAsServiceUser(cmd string, args ...string) (string, error) {
+	if svc.params.UserName == "" {
+		return "", errgo.Newf("service %q: no service user configured", svc.params.Name)
+	}
+	sudoArgs := append([]string{"-n", "-H", "-u", svc.params.UserName, "--", cmd}, args...)
+	out, err := svc.services.runner.Run("sudo", sudoArgs...)
+	if err != nil {
+		return string(out), errgo.Notef(err, "service %q", svc.params.Name)
+	}
+	return string(out), nil
+}
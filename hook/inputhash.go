@@ -0,0 +1,46 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/errgo.v1"
+)
+
+// InputHash returns a hash of the values of the given configuration
+// options and of the settings of all the units in the relations with
+// the given names. The hash is the same whenever the inputs are the
+// same, regardless of the order in which the names are given, so a
+// charm can save it in its persistent state and restart its workload
+// only when the hash changes.
+func (ctxt *Context) InputHash(configKeys []string, relNames []string) (string, error) {
+	input := struct {
+		Config    map[string]interface{}                                 `json:"config"`
+		Relations map[string]map[RelationId]map[UnitId]map[string]string `json:"relations"`
+	}{
+		Config:    make(map[string]interface{}),
+		Relations: make(map[string]map[RelationId]map[UnitId]map[string]string),
+	}
+	for _, key := range configKeys {
+		var val interface{}
+		if err := ctxt.GetConfig(key, &val); err != nil {
+			return "", errgo.Mask(err)
+		}
+		input.Config[key] = val
+	}
+	for _, name := range relNames {
+		rels := make(map[RelationId]map[UnitId]map[string]string)
+		for _, id := range ctxt.RelationIds[name] {
+			rels[id] = ctxt.Relations[id]
+		}
+		input.Relations[name] = rels
+	}
+	// Maps are marshaled with their keys in sorted
+	// order, so the encoding is deterministic.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot marshal inputs")
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
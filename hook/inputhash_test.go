@@ -0,0 +1,87 @@
+package hook_test
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type inputHashSuite struct{}
+
+var _ = gc.Suite(&inputHashSuite{})
+
+// newInputHashContext returns a context with some configuration
+// and a "db" relation with a single remote unit.
+func newInputHashContext(c *gc.C) *hook.Context {
+	runner := &hooktest.Runner{
+		Logger: c,
+		Config: map[string]interface{}{
+			"port":    8080,
+			"debug":   false,
+			"unused":  "x",
+			"motd":    "hello",
+			"unused2": 3.5,
+		},
+	}
+	return &hook.Context{
+		Runner: runner,
+		RelationIds: map[string][]hook.RelationId{
+			"db":    {"db:0"},
+			"cache": {"cache:1"},
+		},
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"db:0": {
+				"mysql/0": {"host": "10.0.0.1", "port": "3306"},
+			},
+			"cache:1": {
+				"redis/0": {"host": "10.0.0.2"},
+			},
+		},
+	}
+}
+
+func inputHash(c *gc.C, ctxt *hook.Context, configKeys, relNames []string) string {
+	h, err := ctxt.InputHash(configKeys, relNames)
+	c.Assert(err, gc.IsNil)
+	c.Assert(h, gc.Matches, "[0-9a-f]{64}")
+	return h
+}
+
+func (*inputHashSuite) TestStable(c *gc.C) {
+	h := inputHash(c, newInputHashContext(c), []string{"port", "debug"}, []string{"db"})
+	for i := 0; i < 10; i++ {
+		c.Assert(inputHash(c, newInputHashContext(c), []string{"port", "debug"}, []string{"db"}), gc.Equals, h)
+	}
+	// The order of the names does not matter.
+	c.Assert(inputHash(c, newInputHashContext(c), []string{"debug", "port"}, []string{"db"}), gc.Equals, h)
+}
+
+func (*inputHashSuite) TestIgnoresUnselectedInputs(c *gc.C) {
+	ctxt := newInputHashContext(c)
+	h := inputHash(c, ctxt, []string{"port"}, []string{"db"})
+	ctxt.Runner.(*hooktest.Runner).Config["unused"] = "y"
+	ctxt.Relations["cache:1"]["redis/0"]["host"] = "10.0.0.3"
+	c.Assert(inputHash(c, ctxt, []string{"port"}, []string{"db"}), gc.Equals, h)
+}
+
+func (*inputHashSuite) TestChangesWithInputs(c *gc.C) {
+	ctxt := newInputHashContext(c)
+	h0 := inputHash(c, ctxt, []string{"port"}, []string{"db"})
+
+	ctxt.Runner.(*hooktest.Runner).Config["port"] = 8081
+	h1 := inputHash(c, ctxt, []string{"port"}, []string{"db"})
+	c.Assert(h1, gc.Not(gc.Equals), h0)
+
+	ctxt.Relations["db:0"]["mysql/0"]["host"] = "10.0.0.9"
+	h2 := inputHash(c, ctxt, []string{"port"}, []string{"db"})
+	c.Assert(h2, gc.Not(gc.Equals), h1)
+
+	ctxt.Relations["db:0"]["mysql/1"] = map[string]string{"host": "10.0.0.10"}
+	h3 := inputHash(c, ctxt, []string{"port"}, []string{"db"})
+	c.Assert(h3, gc.Not(gc.Equals), h2)
+
+	// Selecting a different set of inputs changes the hash.
+	c.Assert(inputHash(c, ctxt, []string{"port", "motd"}, []string{"db"}), gc.Not(gc.Equals), h3)
+	c.Assert(inputHash(c, ctxt, []string{"port"}, []string{"db", "cache"}), gc.Not(gc.Equals), h3)
+}
@@ -0,0 +1,62 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/errgo.v1"
+)
+
+// maintenanceWindowKey holds the leader setting used to record
+// that the application is in a maintenance window.
+const maintenanceWindowKey = "maintenance-window"
+
+// IsLeader reports whether the current unit is the
+// leader of its application. If the version of Juju does
+// not support leadership, the returned error will have
+// ErrUnimplemented as its cause.
+func (ctxt *Context) IsLeader() (bool, error) {
+	var leader bool
+	if err := ctxt.runJSON(&leader, "is-leader", "--format", "json"); err != nil {
+		return false, errgo.NoteMask(err, "cannot determine leadership", errgo.Is(ErrUnimplemented))
+	}
+	return leader, nil
+}
+
+// InMaintenanceWindow reports whether the leader of the application
+// has declared a maintenance window with SetMaintenanceWindow.
+// Charms coordinating upgrades can use this to gate disruptive work.
+func (ctxt *Context) InMaintenanceWindow() (bool, error) {
+	out, err := ctxt.Runner.Run("leader-get", "--format", "json", "--", maintenanceWindowKey)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot get leader setting %q", maintenanceWindowKey)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return false, nil
+	}
+	var val string
+	if err := json.Unmarshal(out, &val); err != nil {
+		return false, errgo.Notef(err, "cannot parse command output %q", out)
+	}
+	return val == "true", nil
+}
+
+// SetMaintenanceWindow starts or ends the application's maintenance
+// window. It returns an error if the current unit is not the leader.
+func (ctxt *Context) SetMaintenanceWindow(inWindow bool) error {
+	leader, err := ctxt.IsLeader()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !leader {
+		return errgo.New("cannot set maintenance window: unit is not the leader")
+	}
+	val := ""
+	if inWindow {
+		val = "true"
+	}
+	if _, err := ctxt.Runner.Run("leader-set", maintenanceWindowKey+"="+val); err != nil {
+		return errgo.Notef(err, "cannot set leader setting %q", maintenanceWindowKey)
+	}
+	return nil
+}
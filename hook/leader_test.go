@@ -0,0 +1,112 @@
+package hook_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type leaderSuite struct{}
+
+var _ = gc.Suite(&leaderSuite{})
+
+var inMaintenanceWindowTests = []struct {
+	about       string
+	output      string
+	expect      bool
+	expectError string
+}{{
+	about:  "window set",
+	output: `"true"` + "\n",
+	expect: true,
+}, {
+	about:  "window cleared",
+	output: `""`,
+}, {
+	about:  "setting not present",
+	output: "",
+}, {
+	about:  "null setting",
+	output: "null\n",
+}, {
+	about:       "invalid output",
+	output:      "true",
+	expectError: `cannot parse command output "true": .*`,
+}}
+
+func (*leaderSuite) TestInMaintenanceWindow(c *gc.C) {
+	for i, test := range inMaintenanceWindowTests {
+		c.Logf("test %d: %s", i, test.about)
+		runner := &hooktest.Runner{
+			Logger: c,
+			RunFunc: func(cmd string, args ...string) ([]byte, error) {
+				return []byte(test.output), nil
+			},
+		}
+		ctxt := &hook.Context{Runner: runner}
+		inWindow, err := ctxt.InMaintenanceWindow()
+		c.Assert(runner.Record, jc.DeepEquals, [][]string{
+			{"leader-get", "--format", "json", "--", "maintenance-window"},
+		})
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(inWindow, gc.Equals, test.expect)
+	}
+}
+
+func (*leaderSuite) TestSetMaintenanceWindow(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			if cmd == "is-leader" {
+				return []byte("true\n"), nil
+			}
+			return nil, nil
+		},
+	}
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.SetMaintenanceWindow(true)
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetMaintenanceWindow(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"is-leader", "--format", "json"},
+		{"leader-set", "maintenance-window=true"},
+		{"is-leader", "--format", "json"},
+		{"leader-set", "maintenance-window="},
+	})
+}
+
+func (*leaderSuite) TestSetMaintenanceWindowNotLeader(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return []byte("false\n"), nil
+		},
+	}
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.SetMaintenanceWindow(true)
+	c.Assert(err, gc.ErrorMatches, "cannot set maintenance window: unit is not the leader")
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"is-leader", "--format", "json"},
+	})
+}
+
+func (*leaderSuite) TestIsLeaderUnimplemented(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return nil, errgo.WithCausef(nil, hook.ErrUnimplemented, "is-leader")
+		},
+	}
+	ctxt := &hook.Context{Runner: runner}
+	_, err := ctxt.IsLeader()
+	c.Assert(err, gc.ErrorMatches, "cannot determine leadership: is-leader")
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrUnimplemented)
+}
@@ -0,0 +1,402 @@
+package hook
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// LogLevel represents the severity of a log message.
+type LogLevel int
+
+const (
+	LevelTrace LogLevel = iota
+	LevelDebug
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var logLevelNames = []string{
+	LevelTrace:   "TRACE",
+	LevelDebug:   "DEBUG",
+	LevelInfo:    "INFO",
+	LevelWarning: "WARNING",
+	LevelError:   "ERROR",
+}
+
+// String returns the name of the level as understood by juju-log.
+func (level LogLevel) String() string {
+	if level < 0 || int(level) >= len(logLevelNames) {
+		return "INFO"
+	}
+	return logLevelNames[level]
+}
+
+// ParseLogLevel returns the level with the given name,
+// which is not case sensitive.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(level), nil
+		}
+	}
+	return 0, errgo.Newf("unknown log level %q", s)
+}
+
+// envLogLevels holds the name of the environment variable that
+// configures the level of each Logger. It holds a comma-separated
+// list of module=level entries, for example:
+//
+//	GOCHARM_LOG_LEVELS=httpservice=DEBUG,mongodb=WARNING
+const envLogLevels = "GOCHARM_LOG_LEVELS"
+
+// envDebug holds the name of the environment variable that
+// enables the messages logged with Context.Debugf.
+const envDebug = "GOCHARM_DEBUG"
+
+// envLogCaller holds the name of the environment variable that
+// enables the source location prefix on logged messages.
+const envLogCaller = "GOCHARM_LOG_CALLER"
+
+// withCaller returns msg prefixed with the file name and line number
+// of the caller of the function that called it, skipping skip
+// further frames, when the GOCHARM_LOG_CALLER environment variable
+// holds a true value such as "1". Otherwise it returns msg unchanged.
+func withCaller(skip int, msg string) string {
+	if enabled, _ := strconv.ParseBool(os.Getenv(envLogCaller)); !enabled {
+		return msg
+	}
+	_, file, line, ok := runtime.Caller(skip + 2)
+	if !ok {
+		return msg
+	}
+	return fmt.Sprintf("%s:%d: %s", filepath.Base(file), line, msg)
+}
+
+// Logger logs messages on behalf of a module of the charm,
+// such as a charmbit. Each message is prefixed with the
+// module name, and messages below the module's configured
+// level are discarded.
+type Logger struct {
+	ctxt   *Context
+	module string
+	level  LogLevel
+}
+
+// Logger returns a Logger for the given module. Its level is taken
+// from the GOCHARM_LOG_LEVELS environment variable, which holds a
+// comma-separated list of module=level entries (for example
+// "httpservice=DEBUG,mongodb=WARNING"); modules not mentioned there
+// log at LevelInfo and above.
+func (ctxt *Context) Logger(module string) *Logger {
+	return &Logger{
+		ctxt:   ctxt,
+		module: module,
+		level:  moduleLogLevel(os.Getenv(envLogLevels), module),
+	}
+}
+
+// moduleLogLevel returns the level configured for the given
+// module in the given GOCHARM_LOG_LEVELS value. Malformed
+// entries are ignored.
+func moduleLogLevel(config, module string) LogLevel {
+	for _, entry := range strings.Split(config, ",") {
+		f := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(f) != 2 || f[0] != module {
+			continue
+		}
+		if level, err := ParseLogLevel(f[1]); err == nil {
+			return level
+		}
+	}
+	return LevelInfo
+}
+
+// Level returns the minimum level of messages
+// that will be logged by l.
+func (l *Logger) Level() LogLevel {
+	return l.level
+}
+
+// Logf logs a message at the given level if the level is
+// enabled for the logger's module.
+func (l *Logger) Logf(level LogLevel, f string, a ...interface{}) error {
+	return l.logf(level, f, a...)
+}
+
+// Tracef logs a message at LevelTrace.
+func (l *Logger) Tracef(f string, a ...interface{}) error {
+	return l.logf(LevelTrace, f, a...)
+}
+
+// Debugf logs a message at LevelDebug.
+func (l *Logger) Debugf(f string, a ...interface{}) error {
+	return l.logf(LevelDebug, f, a...)
+}
+
+// Infof logs a message at LevelInfo.
+func (l *Logger) Infof(f string, a ...interface{}) error {
+	return l.logf(LevelInfo, f, a...)
+}
+
+// Warningf logs a message at LevelWarning.
+func (l *Logger) Warningf(f string, a ...interface{}) error {
+	return l.logf(LevelWarning, f, a...)
+}
+
+// Errorf logs a message at LevelError.
+func (l *Logger) Errorf(f string, a ...interface{}) error {
+	return l.logf(LevelError, f, a...)
+}
+
+// logf implements the logging methods of Logger. It must be
+// called directly by them so that the caller prefix added
+// when GOCHARM_LOG_CALLER is set refers to their caller.
+func (l *Logger) logf(level LogLevel, f string, a ...interface{}) error {
+	if level < l.level {
+		return nil
+	}
+	msg := withCaller(1, l.module+": "+fmt.Sprintf(f, a...))
+	return l.ctxt.logLevelf(level, "%s", msg)
+}
+
+// maxBufferedLogLines holds the maximum number of log
+// messages that will be buffered before they are flushed.
+const maxBufferedLogLines = 100
+
+// logBuffer holds log messages that have not yet
+// been sent to juju-log.
+type logBuffer struct {
+	enabled bool
+	lines   []string
+
+	// ident holds the identifier set by EnableLogIdentifier.
+	ident string
+
+	// debug holds whether EnableDebug has been called.
+	debug bool
+}
+
+func (b *logBuffer) add(runner ToolRunner, msg string) error {
+	b.lines = append(b.lines, msg)
+	if len(b.lines) >= maxBufferedLogLines {
+		return b.flush(runner)
+	}
+	return nil
+}
+
+// flush sends all the buffered messages as a single
+// multi-line juju-log entry.
+func (b *logBuffer) flush(runner ToolRunner) error {
+	if len(b.lines) == 0 {
+		return nil
+	}
+	msg := strings.Join(b.lines, "\n")
+	b.lines = b.lines[:0]
+	return jujuLog(runner, msg)
+}
+
+// jujuLog sends msg to juju-log with the given flags. If that fails,
+// the message is written using the standard log package instead, so
+// that it ends up in the unit's log file rather than being lost.
+func jujuLog(runner ToolRunner, msg string, flags ...string) error {
+	_, err := runner.Run("juju-log", append(flags, msg)...)
+	if err != nil {
+		log.Print(msg)
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// EnableLogIdentifier prefixes all subsequent messages logged through
+// the context (including those logged with Logger and LogError) with a
+// syslog-style identifier derived from the unit name, such as
+// "unit-mysql-0: ". This makes it possible to pick out the messages
+// from a particular unit when several units log to the same place.
+// The identifier is also included in messages written by the fallback
+// logger when juju-log cannot be run.
+//
+// The identifier applies to all contexts derived from
+// the current hook context.
+func (ctxt *Context) EnableLogIdentifier() {
+	if ctxt.logs == nil {
+		ctxt.logs = &logBuffer{}
+	}
+	ctxt.logs.ident = ctxt.UnitTag()
+}
+
+// LogIdentifier returns the identifier enabled by
+// EnableLogIdentifier, or the empty string if
+// there is none.
+func (ctxt *Context) LogIdentifier() string {
+	if ctxt.logs == nil {
+		return ""
+	}
+	return ctxt.logs.ident
+}
+
+// identify returns msg with each line
+// prefixed with the log identifier, if any.
+func (ctxt *Context) identify(msg string) string {
+	ident := ctxt.LogIdentifier()
+	if ident == "" {
+		return msg
+	}
+	prefix := ident + ": "
+	return prefix + strings.Replace(msg, "\n", "\n"+prefix, -1)
+}
+
+// EnableDebug enables the messages logged with Debugf for the rest
+// of the hook, regardless of the GOCHARM_DEBUG environment variable.
+// A charm might call this when a debug configuration option is set,
+// for example.
+//
+// Debugging applies to all contexts derived from the
+// current hook context.
+func (ctxt *Context) EnableDebug() {
+	if ctxt.logs == nil {
+		ctxt.logs = &logBuffer{}
+	}
+	ctxt.logs.debug = true
+}
+
+// Debugging reports whether messages logged with Debugf
+// will be emitted. This is true when EnableDebug has been
+// called or when the GOCHARM_DEBUG environment variable
+// holds a true value such as "1" or "true".
+func (ctxt *Context) Debugging() bool {
+	if ctxt.logs != nil && ctxt.logs.debug {
+		return true
+	}
+	debug, _ := strconv.ParseBool(os.Getenv(envDebug))
+	return debug
+}
+
+// Debugf logs a message at LevelDebug if debugging is enabled
+// (see Debugging) and does nothing otherwise. It is intended for
+// verbose internal messages that should not appear in normal runs.
+func (ctxt *Context) Debugf(f string, a ...interface{}) error {
+	if !ctxt.Debugging() {
+		return nil
+	}
+	return ctxt.logLevelf(LevelDebug, "%s", withCaller(0, fmt.Sprintf(f, a...)))
+}
+
+// BufferLogs enables buffering of messages logged with Logf for
+// the rest of the hook. Each juju-log invocation runs a separate
+// process, so a hook that logs many messages can run considerably
+// faster when they are sent together. Buffered messages are sent as
+// a single multi-line log entry when the buffer fills up, when
+// FlushLogs is called, and when the hook completes.
+//
+// Buffering applies to all contexts derived from the
+// current hook context.
+func (ctxt *Context) BufferLogs() {
+	if ctxt.logs == nil {
+		ctxt.logs = &logBuffer{}
+	}
+	ctxt.logs.enabled = true
+}
+
+// FlushLogs sends any messages buffered since BufferLogs
+// was called.
+func (ctxt *Context) FlushLogs() error {
+	if ctxt.logs == nil {
+		return nil
+	}
+	return ctxt.logs.flush(ctxt.Runner)
+}
+
+// LogError logs the given error at LevelError, followed by one
+// line for each error in its chain of wrapped errors, including
+// the source location where it is known, and its cause if that
+// is not part of the chain. This works with errors from errgo,
+// github.com/pkg/errors and any other errors that implement an
+// Unwrap method. It does nothing if err is nil.
+func (ctxt *Context) LogError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return ctxt.logLevelf(LevelError, "%s", withCaller(0, formatErrorChain(err)))
+}
+
+// formatErrorChain returns a multi-line description of
+// err and all the errors that it wraps.
+func formatErrorChain(err error) string {
+	lines := []string{err.Error()}
+	var chain []error
+	for e := err; e != nil; e = unwrapError(e) {
+		chain = append(chain, e)
+		msg := errorMessage(e)
+		if msg == "" {
+			// The error only adds information such as a
+			// stack trace, not a message of its own.
+			continue
+		}
+		if loc, ok := e.(errgo.Locationer); ok {
+			if file, line := loc.Location(); file != "" {
+				msg = fmt.Sprintf("%s:%d: %s", file, line, msg)
+			}
+		}
+		lines = append(lines, "  "+msg)
+	}
+	if cause := errgo.Cause(err); cause != nil && !containsError(chain, cause) {
+		lines = append(lines, "  cause: "+cause.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// containsError reports whether err is one of the given errors.
+// An error whose dynamic type is not comparable is never
+// found, because comparing it would panic.
+func containsError(errs []error, err error) bool {
+	if !reflect.TypeOf(err).Comparable() {
+		return false
+	}
+	for _, e := range errs {
+		if e == err {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapError returns the error wrapped by err, or
+// nil if there is none.
+func unwrapError(err error) error {
+	switch err := err.(type) {
+	case errgo.Wrapper:
+		return err.Underlying()
+	case interface{ Unwrap() error }:
+		return err.Unwrap()
+	case interface{ Cause() error }:
+		// Versions of github.com/pkg/errors before 0.9
+		// do not implement Unwrap.
+		return err.Cause()
+	}
+	return nil
+}
+
+// errorMessage returns the message added by err
+// itself, not including that of any wrapped error.
+func errorMessage(err error) string {
+	if w, ok := err.(errgo.Wrapper); ok {
+		return w.Message()
+	}
+	msg := err.Error()
+	if next := unwrapError(err); next != nil {
+		if msg == next.Error() {
+			return ""
+		}
+		msg = strings.TrimSuffix(msg, ": "+next.Error())
+	}
+	return msg
+}
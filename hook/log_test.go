@@ -0,0 +1,337 @@
+package hook_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type logSuite struct{}
+
+var _ = gc.Suite(&logSuite{})
+
+// recordingLogger records all the messages sent to juju-log.
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) Logf(f string, a ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(f, a...))
+}
+
+func (*logSuite) TestBufferLogs(c *gc.C) {
+	var logger recordingLogger
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       &logger,
+		RegisterHooks: func(r *hook.Registry) {
+			var ctxt *hook.Context
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				ctxt.BufferLogs()
+				return nil
+			}, nil)
+			r.RegisterHook("config-changed", func() error {
+				for i := 0; i < 10; i++ {
+					ctxt.Logf("message %d", i)
+				}
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(logger.msgs, gc.DeepEquals, []string{
+		"running hook config-changed {",
+		"message 0\nmessage 1\nmessage 2\nmessage 3\nmessage 4\nmessage 5\nmessage 6\nmessage 7\nmessage 8\nmessage 9\n} config-changed",
+	})
+}
+
+func (*logSuite) TestUnbufferedLogs(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	ctxt.Logf("one")
+	ctxt.Logf("two")
+	c.Assert(logger.msgs, gc.DeepEquals, []string{"one", "two"})
+}
+
+func (*logSuite) TestFlushWhenFull(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	ctxt.BufferLogs()
+	for i := 0; i < 250; i++ {
+		ctxt.Logf("message %d", i)
+	}
+	c.Assert(logger.msgs, gc.HasLen, 2)
+	err := ctxt.FlushLogs()
+	c.Assert(err, gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 3)
+}
+
+func (*logSuite) TestLoggerLevels(c *gc.C) {
+	defer os.Setenv("GOCHARM_LOG_LEVELS", os.Getenv("GOCHARM_LOG_LEVELS"))
+	os.Setenv("GOCHARM_LOG_LEVELS", "quiet=WARNING, chatty=debug,bad=NOSUCHLEVEL")
+
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	quiet := ctxt.Logger("quiet")
+	c.Assert(quiet.Level(), gc.Equals, hook.LevelWarning)
+	quiet.Debugf("quiet debug")
+	quiet.Infof("quiet info")
+	quiet.Warningf("quiet warning")
+
+	chatty := ctxt.Logger("chatty")
+	chatty.Tracef("chatty trace")
+	chatty.Debugf("chatty debug")
+
+	// Modules with no configured level, or with an invalid
+	// level, default to INFO.
+	for _, module := range []string{"other", "bad"} {
+		l := ctxt.Logger(module)
+		c.Assert(l.Level(), gc.Equals, hook.LevelInfo)
+		l.Debugf("%s debug", module)
+		l.Errorf("%s error", module)
+	}
+
+	c.Assert(logger.msgs, gc.DeepEquals, []string{
+		"WARNING: quiet: quiet warning",
+		"DEBUG: chatty: chatty debug",
+		"ERROR: other: other error",
+		"ERROR: bad: bad error",
+	})
+}
+
+func (*logSuite) TestDebugfDisabled(c *gc.C) {
+	defer os.Setenv("GOCHARM_DEBUG", os.Getenv("GOCHARM_DEBUG"))
+	for _, val := range []string{"", "0", "false", "nonsense"} {
+		os.Setenv("GOCHARM_DEBUG", val)
+		var logger recordingLogger
+		ctxt := &hook.Context{
+			Runner: &hooktest.Runner{Logger: &logger},
+		}
+		c.Assert(ctxt.Debugging(), gc.Equals, false)
+		err := ctxt.Debugf("hidden %d", 1)
+		c.Assert(err, gc.IsNil)
+		ctxt.Logf("shown")
+		c.Assert(logger.msgs, gc.DeepEquals, []string{"shown"})
+	}
+}
+
+func (*logSuite) TestDebugfEnabledByEnvironment(c *gc.C) {
+	defer os.Setenv("GOCHARM_DEBUG", os.Getenv("GOCHARM_DEBUG"))
+	os.Setenv("GOCHARM_DEBUG", "1")
+
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	c.Assert(ctxt.Debugging(), gc.Equals, true)
+	err := ctxt.Debugf("shown %d", 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(logger.msgs, gc.DeepEquals, []string{"DEBUG: shown 1"})
+}
+
+func (*logSuite) TestDebugfEnabledExplicitly(c *gc.C) {
+	defer os.Setenv("GOCHARM_DEBUG", os.Getenv("GOCHARM_DEBUG"))
+	os.Setenv("GOCHARM_DEBUG", "")
+
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	ctxt.Debugf("hidden")
+	ctxt.EnableDebug()
+	c.Assert(ctxt.Debugging(), gc.Equals, true)
+	ctxt.BufferLogs()
+	ctxt.Logf("buffered")
+	ctxt.Debugf("shown")
+	c.Assert(logger.msgs, gc.DeepEquals, []string{"buffered", "DEBUG: shown"})
+}
+
+func (*logSuite) TestLogError(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	base := errgo.New("permission denied")
+	err := errgo.Notef(base, "cannot write config")
+	err = pkgerrors.Wrap(err, "cannot install service")
+	err = fmt.Errorf("start hook: %w", err)
+	err = errgo.Notef(err, "cannot start")
+
+	c.Assert(ctxt.LogError(err), gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 1)
+	lines := strings.Split(logger.msgs[0], "\n")
+	c.Assert(lines, gc.HasLen, 6)
+	c.Assert(lines[0], gc.Equals, "ERROR: cannot start: start hook: cannot install service: cannot write config: permission denied")
+	c.Assert(lines[1], gc.Matches, `  .*/log_test\.go:[0-9]+: cannot start`)
+	c.Assert(lines[2], gc.Equals, "  start hook")
+	c.Assert(lines[3], gc.Equals, "  cannot install service")
+	c.Assert(lines[4], gc.Matches, `  .*/log_test\.go:[0-9]+: cannot write config`)
+	c.Assert(lines[5], gc.Matches, `  .*/log_test\.go:[0-9]+: permission denied`)
+}
+
+func (*logSuite) TestLogErrorWithCause(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	errNotFound := errors.New("not found")
+	err := errgo.WithCausef(nil, errNotFound, "no such unit")
+	c.Assert(ctxt.LogError(err), gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 1)
+	c.Assert(logger.msgs[0], gc.Matches, `ERROR: no such unit
+  .*/log_test\.go:[0-9]+: no such unit
+  cause: not found`)
+
+	logger.msgs = nil
+	c.Assert(ctxt.LogError(nil), gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 0)
+}
+
+// multiError is an error type that is not comparable.
+type multiError []error
+
+func (e multiError) Error() string {
+	return fmt.Sprintf("%d errors occurred", len(e))
+}
+
+func (*logSuite) TestLogErrorNotComparable(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	multi := multiError{errors.New("a"), errors.New("b")}
+	err := errgo.NoteMask(fmt.Errorf("checking: %w", multi), "cannot check", errgo.Any)
+	c.Assert(ctxt.LogError(err), gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 1)
+	c.Assert(logger.msgs[0], gc.Matches, `ERROR: cannot check: checking: 2 errors occurred
+  .*/log_test\.go:[0-9]+: cannot check
+  checking
+  2 errors occurred`)
+
+	logger.msgs = nil
+	err = errgo.WithCausef(nil, multi, "no good")
+	c.Assert(ctxt.LogError(err), gc.IsNil)
+	c.Assert(logger.msgs, gc.HasLen, 1)
+	c.Assert(logger.msgs[0], gc.Matches, `ERROR: no good
+  .*/log_test\.go:[0-9]+: no good
+  cause: 2 errors occurred`)
+}
+
+func (*logSuite) TestLogIdentifier(c *gc.C) {
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Unit:   "mysql/0",
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	c.Assert(ctxt.LogIdentifier(), gc.Equals, "")
+	ctxt.Logf("before")
+	ctxt.EnableLogIdentifier()
+	c.Assert(ctxt.LogIdentifier(), gc.Equals, "unit-mysql-0")
+	ctxt.Logf("plain")
+	ctxt.Logger("db").Warningf("leveled")
+	ctxt.BufferLogs()
+	ctxt.Logf("one")
+	ctxt.Logf("two\nthree")
+	ctxt.FlushLogs()
+	c.Assert(logger.msgs, gc.DeepEquals, []string{
+		"before",
+		"unit-mysql-0: plain",
+		"WARNING: unit-mysql-0: db: leveled",
+		"unit-mysql-0: one\nunit-mysql-0: two\nunit-mysql-0: three",
+	})
+}
+
+// brokenRunner is a hook.ToolRunner for which
+// every hook tool fails.
+type brokenRunner struct{}
+
+func (brokenRunner) Run(cmd string, args ...string) ([]byte, error) {
+	return nil, errgo.Newf("%s not available", cmd)
+}
+
+func (brokenRunner) Close() error {
+	return nil
+}
+
+func (*logSuite) TestLogFallback(c *gc.C) {
+	var buf bytes.Buffer
+	defer log.SetOutput(os.Stderr)
+	log.SetOutput(&buf)
+	defer log.SetFlags(log.Flags())
+	log.SetFlags(0)
+
+	ctxt := &hook.Context{
+		Unit:   "mysql/0",
+		Runner: brokenRunner{},
+	}
+	ctxt.EnableLogIdentifier()
+	err := ctxt.Logf("hello")
+	c.Assert(err, gc.ErrorMatches, "juju-log not available")
+	err = ctxt.LogError(errgo.New("oops"))
+	c.Assert(err, gc.ErrorMatches, "juju-log not available")
+	c.Assert(buf.String(), gc.Matches, `unit-mysql-0: hello
+unit-mysql-0: oops
+unit-mysql-0:   .*log_test.go:[0-9]+: oops
+`)
+}
+
+func (*logSuite) TestLogCaller(c *gc.C) {
+	defer os.Setenv("GOCHARM_LOG_CALLER", os.Getenv("GOCHARM_LOG_CALLER"))
+	os.Setenv("GOCHARM_LOG_CALLER", "1")
+
+	var logger recordingLogger
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: &logger},
+	}
+	ctxt.EnableDebug()
+	l := ctxt.Logger("db")
+	_, _, line, _ := runtime.Caller(0)
+	ctxt.Logf("plain")
+	ctxt.Debugf("debug")
+	l.Warningf("leveled")
+	l.Logf(hook.LevelError, "explicit")
+	ctxt.LogError(errors.New("oops"))
+	c.Assert(logger.msgs, gc.DeepEquals, []string{
+		fmt.Sprintf("log_test.go:%d: plain", line+1),
+		fmt.Sprintf("DEBUG: log_test.go:%d: debug", line+2),
+		fmt.Sprintf("WARNING: log_test.go:%d: db: leveled", line+3),
+		fmt.Sprintf("ERROR: log_test.go:%d: db: explicit", line+4),
+		fmt.Sprintf("ERROR: log_test.go:%d: oops\n  oops", line+5),
+	})
+}
+
+func (*logSuite) TestLogCallerDisabled(c *gc.C) {
+	defer os.Setenv("GOCHARM_LOG_CALLER", os.Getenv("GOCHARM_LOG_CALLER"))
+	for _, val := range []string{"", "0", "false", "nonsense"} {
+		os.Setenv("GOCHARM_LOG_CALLER", val)
+		var logger recordingLogger
+		ctxt := &hook.Context{
+			Runner: &hooktest.Runner{Logger: &logger},
+		}
+		ctxt.Logf("plain")
+		ctxt.Logger("db").Infof("leveled")
+		c.Assert(logger.msgs, gc.DeepEquals, []string{
+			"plain",
+			"INFO: db: leveled",
+		})
+	}
+}
@@ -6,20 +6,25 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/errgo.v1"
 )
 
 const (
-	envUUID          = "JUJU_MODEL_UUID"
-	envUnitName      = "JUJU_UNIT_NAME"
-	envCharmDir      = "CHARM_DIR"
-	envJujuContextId = "JUJU_CONTEXT_ID"
-	envRelationName  = "JUJU_RELATION"
-	envRelationId    = "JUJU_RELATION_ID"
-	envRemoteUnit    = "JUJU_REMOTE_UNIT"
-	envSocketPath    = "JUJU_AGENT_SOCKET"
+	envUUID             = "JUJU_MODEL_UUID"
+	envUnitName         = "JUJU_UNIT_NAME"
+	envCharmDir         = "CHARM_DIR"
+	envJujuContextId    = "JUJU_CONTEXT_ID"
+	envRelationName     = "JUJU_RELATION"
+	envRelationId       = "JUJU_RELATION_ID"
+	envRemoteUnit       = "JUJU_REMOTE_UNIT"
+	envRemoteApp        = "JUJU_REMOTE_APP"
+	envSocketPath       = "JUJU_AGENT_SOCKET"
+	envSecretId         = "JUJU_SECRET_ID"
+	envSecretRevision   = "JUJU_SECRET_REVISION"
+	envAvailabilityZone = "JUJU_AVAILABILITY_ZONE"
 )
 
 var mustEnvVars = []string{
@@ -59,13 +64,62 @@ func Main(r *Registry, ctxt *Context, state PersistentState) (_ Command, err err
 		}
 		return cmd(ctxt.RunCommandArgs)
 	}
+	if ctxt.logs == nil {
+		ctxt.logs = &logBuffer{}
+	}
+	if ctxt.events == nil {
+		ctxt.events = &eventBus{}
+	}
+	if ctxt.zone == nil {
+		ctxt.zone = &zoneCache{}
+	}
+	if ctxt.localSettings == nil {
+		ctxt.localSettings = &relationSettingsCache{}
+	}
+	if ctxt.containerStatus == nil {
+		ctxt.containerStatus = &containerStatusCache{
+			state: state,
+		}
+	}
+	ctxt.requiredConfig = r.RegisteredRequiredConfig()
+	ctxt.relations = r.RegisteredRelations()
+	if ctxt.tracer == nil {
+		ctxt.tracer = newTracer(os.Getenv(envTraceOTLP))
+	}
+	endHookSpan := ctxt.startHookSpan()
 	ctxt.Logf("running hook %s {", ctxt.HookName)
-	defer ctxt.Logf("} %s", ctxt.HookName)
+	defer func() {
+		endHookSpan()
+		ctxt.exportSpans()
+		ctxt.Logf("} %s", ctxt.HookName)
+		// Send any log messages that have been buffered.
+		ctxt.FlushLogs()
+	}()
 	// Retrieve all persistent state.
 	// TODO read all of the state in one operation from a single file?
 	if err := loadState(r, state); err != nil {
 		return nil, errgo.Mask(err)
 	}
+	if r.retries {
+		// Failing to count the attempts should not fail
+		// a hook that would otherwise succeed, so errors
+		// are only logged.
+		attempt, startErr := startAttempt(state, ctxt.attemptKey())
+		if startErr != nil {
+			ctxt.Logf("cannot record hook attempt: %v", startErr)
+		}
+		ctxt.attempt = attempt
+		defer func() {
+			// Once the hook has succeeded, the next time
+			// it runs will not be a retry.
+			if err != nil {
+				return
+			}
+			if endErr := endAttempt(state, ctxt.attemptKey()); endErr != nil {
+				ctxt.Logf("cannot record hook success: %v", endErr)
+			}
+		}()
+	}
 	// Notify everyone about the context.
 	for _, setter := range r.contexts {
 		if err := setter(ctxt); err != nil {
@@ -85,11 +139,40 @@ func Main(r *Registry, ctxt *Context, state PersistentState) (_ Command, err err
 		ctxt.Logf("cannot save local state: %v", saveErr)
 	}()
 
+	if ctxt.HookName == "config-changed" && len(r.validators) > 0 {
+		valid, err := validateConfig(r, ctxt)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if !valid {
+			return nil, nil
+		}
+	}
+
 	// The wildcard hook always runs after any other
 	// registered hooks.
 	hookFuncs := r.hooks[ctxt.HookName]
+	if ctxt.HookName == "stop" {
+		// Stop functions always run before any other
+		// stop hooks, most recently registered first.
+		stopFuncs := make([]hookFunc, 0, len(r.stopHooks)+len(hookFuncs))
+		for i := len(r.stopHooks) - 1; i >= 0; i-- {
+			stopFuncs = append(stopFuncs, r.stopHooks[i])
+		}
+		hookFuncs = append(stopFuncs, hookFuncs...)
+	}
+	if ctxt.HookName == "remove" && len(r.cleanups) > 0 {
+		// Cleanup functions always run before any other
+		// remove hooks.
+		hookFuncs = append([]hookFunc{{
+			run: func() error {
+				runCleanups(r, ctxt)
+				return nil
+			},
+		}}, hookFuncs...)
+	}
 
-	if len(hookFuncs) == 0 {
+	if len(hookFuncs) == 0 && !contains(requiredHooks, ctxt.HookName) {
 		ctxt.Logf("hook %q not registered", ctxt.HookName)
 		return nil, usageError(r)
 	}
@@ -104,6 +187,37 @@ func Main(r *Registry, ctxt *Context, state PersistentState) (_ Command, err err
 	return nil, nil
 }
 
+// runCleanups runs the functions registered with RegisterCleanup
+// in reverse order of registration, logging any errors.
+func runCleanups(r *Registry, ctxt *Context) {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		f := r.cleanups[i]
+		if err := f.run(ctxt.withRegistryName(f.registryName)); err != nil {
+			ctxt.logLevelf(LevelError, "cleanup for %s failed: %v", f.registryName, err)
+		}
+	}
+}
+
+// validateConfig runs the functions registered with
+// RegisterConfigValidator. If any of them fails, it sets
+// blocked status with the error message and returns false.
+func validateConfig(r *Registry, ctxt *Context) (bool, error) {
+	var cfg map[string]interface{}
+	if err := ctxt.GetAllConfig(&cfg); err != nil {
+		return false, errgo.Notef(err, "cannot get configuration")
+	}
+	for _, f := range r.validators {
+		if err := f(cfg); err != nil {
+			ctxt.Logf("invalid configuration: %v", err)
+			if err := ctxt.SetStatus(StatusBlocked, err.Error()); err != nil {
+				return false, errgo.Notef(err, "cannot set blocked status")
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func loadState(r *Registry, state PersistentState) error {
 	for _, val := range r.state {
 		data, err := state.Load(val.registryName)
@@ -146,6 +260,19 @@ func usageError(r *Registry) error {
 	return errgo.Newf("usage: runhook %s", strings.Join(allowed, "\n\t| runhook "))
 }
 
+// requiredHooks holds the hooks that every charm must have
+// for Juju to treat it as a valid charm. Main treats them
+// as no-ops if nothing has registered them.
+var requiredHooks = []string{"install", "start"}
+
+// RequiredHooks returns the names of the hooks that every charm
+// must have for Juju to treat it as a valid charm. Main treats
+// them as no-ops if nothing has registered them, and gocharm
+// always writes them to the charm.
+func RequiredHooks() []string {
+	return append([]string(nil), requiredHooks...)
+}
+
 func nop() error {
 	return nil
 }
@@ -158,8 +285,9 @@ func nop() error {
 // generated code only.
 func RegisterMainHooks(r *Registry) {
 	// We always need install and start hooks.
-	r.RegisterHook("install", nop)
-	r.RegisterHook("start", nop)
+	for _, name := range requiredHooks {
+		r.RegisterHook(name, nop)
+	}
 	// TODO Perhaps... ensure that we have a stop hook, and make
 	// it clean up our persistent state. But that may not be
 	// right if "stop" is considered something we can start
@@ -217,9 +345,18 @@ func NewContextFromEnvironment(r *Registry, stateDir string, hookName string, ar
 		RelationName: os.Getenv(envRelationName),
 		RelationId:   RelationId(os.Getenv(envRelationId)),
 		RemoteUnit:   UnitId(os.Getenv(envRemoteUnit)),
+		RemoteApp:    os.Getenv(envRemoteApp),
 		HookName:     hookName,
 		Runner:       runner,
 		HookStateDir: stateDir,
+		SecretId:     os.Getenv(envSecretId),
+	}
+	if rev := os.Getenv(envSecretRevision); rev != "" {
+		n, err := strconv.Atoi(rev)
+		if err != nil {
+			return nil, nil, errgo.Newf("invalid secret revision %q", rev)
+		}
+		ctxt.SecretRevision = n
 	}
 
 	// Populate the relation fields of the ContextInfo
@@ -0,0 +1,66 @@
+package hook
+
+import (
+	"os"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Model configuration keys that are made available to charms.
+const (
+	ModelHTTPProxy  = "juju-http-proxy"
+	ModelHTTPSProxy = "juju-https-proxy"
+	ModelFTPProxy   = "juju-ftp-proxy"
+	ModelNoProxy    = "juju-no-proxy"
+)
+
+// modelConfigEnvVars maps each model configuration key available to
+// charms to the environment variable that Juju uses to pass it to hooks.
+var modelConfigEnvVars = map[string]string{
+	ModelHTTPProxy:  "JUJU_CHARM_HTTP_PROXY",
+	ModelHTTPSProxy: "JUJU_CHARM_HTTPS_PROXY",
+	ModelFTPProxy:   "JUJU_CHARM_FTP_PROXY",
+	ModelNoProxy:    "JUJU_CHARM_NO_PROXY",
+}
+
+// ModelConfig returns the value of the given model configuration
+// key. Juju does not provide charms with general access to the model
+// configuration, so only the keys defined above (ModelHTTPProxy
+// and so on) are available; ModelConfig returns an error for
+// any other key. An unset key yields the empty string.
+func (ctxt *Context) ModelConfig(key string) (string, error) {
+	envVar, ok := modelConfigEnvVars[key]
+	if !ok {
+		return "", errgo.Newf("model configuration key %q is not available to charms", key)
+	}
+	return os.Getenv(envVar), nil
+}
+
+// HTTPProxy returns the HTTP proxy configured for charms
+// in the model, or the empty string if there is none.
+func (ctxt *Context) HTTPProxy() string {
+	return modelConfigEnv(ModelHTTPProxy)
+}
+
+// HTTPSProxy returns the HTTPS proxy configured for charms
+// in the model, or the empty string if there is none.
+func (ctxt *Context) HTTPSProxy() string {
+	return modelConfigEnv(ModelHTTPSProxy)
+}
+
+// FTPProxy returns the FTP proxy configured for charms
+// in the model, or the empty string if there is none.
+func (ctxt *Context) FTPProxy() string {
+	return modelConfigEnv(ModelFTPProxy)
+}
+
+// NoProxy returns the comma-separated list of hosts that
+// should be accessed without a proxy, as configured for
+// charms in the model.
+func (ctxt *Context) NoProxy() string {
+	return modelConfigEnv(ModelNoProxy)
+}
+
+func modelConfigEnv(key string) string {
+	return os.Getenv(modelConfigEnvVars[key])
+}
@@ -0,0 +1,57 @@
+package hook_test
+
+import (
+	"os"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type modelConfigSuite struct {
+	savedEnv map[string]string
+}
+
+var _ = gc.Suite(&modelConfigSuite{})
+
+var proxyEnvVars = []string{
+	"JUJU_CHARM_HTTP_PROXY",
+	"JUJU_CHARM_HTTPS_PROXY",
+	"JUJU_CHARM_FTP_PROXY",
+	"JUJU_CHARM_NO_PROXY",
+}
+
+func (s *modelConfigSuite) SetUpTest(c *gc.C) {
+	s.savedEnv = make(map[string]string)
+	for _, name := range proxyEnvVars {
+		s.savedEnv[name] = os.Getenv(name)
+		os.Unsetenv(name)
+	}
+}
+
+func (s *modelConfigSuite) TearDownTest(c *gc.C) {
+	for name, val := range s.savedEnv {
+		os.Setenv(name, val)
+	}
+}
+
+func (*modelConfigSuite) TestProxyAccessors(c *gc.C) {
+	os.Setenv("JUJU_CHARM_HTTP_PROXY", "http://squid:3128")
+	os.Setenv("JUJU_CHARM_HTTPS_PROXY", "https://squid:3129")
+	os.Setenv("JUJU_CHARM_NO_PROXY", "localhost,10.0.0.0/8")
+	ctxt := &hook.Context{}
+	c.Assert(ctxt.HTTPProxy(), gc.Equals, "http://squid:3128")
+	c.Assert(ctxt.HTTPSProxy(), gc.Equals, "https://squid:3129")
+	c.Assert(ctxt.FTPProxy(), gc.Equals, "")
+	c.Assert(ctxt.NoProxy(), gc.Equals, "localhost,10.0.0.0/8")
+
+	val, err := ctxt.ModelConfig(hook.ModelHTTPProxy)
+	c.Assert(err, gc.IsNil)
+	c.Assert(val, gc.Equals, "http://squid:3128")
+}
+
+func (*modelConfigSuite) TestUnknownModelConfigKey(c *gc.C) {
+	ctxt := &hook.Context{}
+	_, err := ctxt.ModelConfig("default-series")
+	c.Assert(err, gc.ErrorMatches, `model configuration key "default-series" is not available to charms`)
+}
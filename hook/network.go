@@ -0,0 +1,58 @@
+package hook
+
+import (
+	"gopkg.in/errgo.v1"
+)
+
+// NetworkInfo holds the network configuration for a binding,
+// as returned by the network-get hook tool.
+type NetworkInfo struct {
+	// BindAddresses holds the addresses that the unit
+	// should bind to for the binding, grouped by interface.
+	BindAddresses []BindAddress `json:"bind-addresses"`
+
+	// EgressSubnets holds the subnets, in CIDR form, that
+	// traffic from the unit will originate from. It is
+	// empty if Juju does not know them.
+	EgressSubnets []string `json:"egress-subnets"`
+
+	// IngressAddresses holds the addresses that other
+	// units should use to connect to the unit.
+	IngressAddresses []string `json:"ingress-addresses"`
+}
+
+// BindAddress holds the addresses associated with
+// a single network interface.
+type BindAddress struct {
+	MACAddress    string             `json:"mac-address"`
+	InterfaceName string             `json:"interface-name"`
+	Addresses     []InterfaceAddress `json:"addresses"`
+}
+
+// InterfaceAddress holds an address of a network interface.
+type InterfaceAddress struct {
+	Hostname string `json:"hostname"`
+	Value    string `json:"value"`
+	CIDR     string `json:"cidr"`
+}
+
+// NetworkInfo returns the network configuration for the
+// given binding, which is usually the name of a relation
+// or an extra binding declared by the charm.
+func (ctxt *Context) NetworkInfo(binding string) (*NetworkInfo, error) {
+	var info NetworkInfo
+	if err := ctxt.runJSON(&info, "network-get", "--format", "json", "--", binding); err != nil {
+		return nil, errgo.Notef(err, "cannot get network information for %q", binding)
+	}
+	return &info, nil
+}
+
+// EgressSubnets returns the egress subnets for the given binding,
+// in CIDR form. It returns an empty slice if there are none.
+func (ctxt *Context) EgressSubnets(binding string) ([]string, error) {
+	info, err := ctxt.NetworkInfo(binding)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return info.EgressSubnets, nil
+}
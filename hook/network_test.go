@@ -0,0 +1,81 @@
+package hook_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type networkSuite struct{}
+
+var _ = gc.Suite(&networkSuite{})
+
+const networkGetOutput = `{
+	"bind-addresses": [{
+		"mac-address": "00:16:3e:12:34:56",
+		"interface-name": "eth0",
+		"addresses": [{
+			"hostname": "",
+			"value": "10.0.0.5",
+			"cidr": "10.0.0.0/24"
+		}]
+	}],
+	"egress-subnets": ["10.0.0.5/32", "192.168.1.0/24"],
+	"ingress-addresses": ["10.0.0.5"]
+}`
+
+const networkGetOutputNoEgress = `{
+	"bind-addresses": [{
+		"interface-name": "eth0",
+		"addresses": [{"value": "10.0.0.5", "cidr": "10.0.0.0/24"}]
+	}],
+	"ingress-addresses": ["10.0.0.5"]
+}`
+
+func networkContext(c *gc.C, output string) (*hook.Context, *hooktest.Runner) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			c.Check(cmd, gc.Equals, "network-get")
+			return []byte(output), nil
+		},
+	}
+	return &hook.Context{Runner: runner}, runner
+}
+
+func (*networkSuite) TestNetworkInfo(c *gc.C) {
+	ctxt, runner := networkContext(c, networkGetOutput)
+	info, err := ctxt.NetworkInfo("website")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info, jc.DeepEquals, &hook.NetworkInfo{
+		BindAddresses: []hook.BindAddress{{
+			MACAddress:    "00:16:3e:12:34:56",
+			InterfaceName: "eth0",
+			Addresses: []hook.InterfaceAddress{{
+				Value: "10.0.0.5",
+				CIDR:  "10.0.0.0/24",
+			}},
+		}},
+		EgressSubnets:    []string{"10.0.0.5/32", "192.168.1.0/24"},
+		IngressAddresses: []string{"10.0.0.5"},
+	})
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"network-get", "--format", "json", "--", "website"},
+	})
+}
+
+func (*networkSuite) TestEgressSubnets(c *gc.C) {
+	ctxt, _ := networkContext(c, networkGetOutput)
+	subnets, err := ctxt.EgressSubnets("website")
+	c.Assert(err, gc.IsNil)
+	c.Assert(subnets, jc.DeepEquals, []string{"10.0.0.5/32", "192.168.1.0/24"})
+}
+
+func (*networkSuite) TestEgressSubnetsNone(c *gc.C) {
+	ctxt, _ := networkContext(c, networkGetOutputNoEgress)
+	subnets, err := ctxt.EgressSubnets("website")
+	c.Assert(err, gc.IsNil)
+	c.Assert(subnets, gc.HasLen, 0)
+}
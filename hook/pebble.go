@@ -0,0 +1,421 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	// envContainerNames holds the name of the environment variable
+	// that holds the comma-separated names of the workload containers
+	// of a sidecar charm.
+	envContainerNames = "JUJU_CONTAINER_NAMES"
+
+	// envPebbleSocket holds the name of the environment variable that,
+	// if set, overrides the path of the Pebble socket used to talk to
+	// every container.
+	envPebbleSocket = "PEBBLE_SOCKET"
+)
+
+// pebbleSocketDir holds the directory in the charm container that
+// holds the Pebble socket of each workload container, in a
+// subdirectory named after the container.
+const pebbleSocketDir = "/charm/containers"
+
+// Pebble provides access to the Pebble service manager of a workload
+// container in a Kubernetes sidecar charm.
+type Pebble struct {
+	container  string
+	socketPath string
+	client     *http.Client
+}
+
+// Pebble returns a client for the Pebble service manager running in the
+// workload container with the given name. It returns an error if the
+// charm is not running in a Kubernetes sidecar context or if there is no
+// such container.
+func (ctxt *Context) Pebble(container string) (*Pebble, error) {
+	if err := checkContainer(container); err != nil {
+		return nil, errgo.Notef(err, "cannot access container %q", container)
+	}
+	socketPath := os.Getenv(envPebbleSocket)
+	if socketPath == "" {
+		socketPath = filepath.Join(pebbleSocketDir, container, "pebble.socket")
+	}
+	p := &Pebble{
+		container:  container,
+		socketPath: socketPath,
+	}
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", p.socketPath)
+			},
+		},
+	}
+	return p, nil
+}
+
+// checkContainer returns an error if the charm is not running in a
+// Kubernetes sidecar context or if it has no workload container with
+// the given name.
+func checkContainer(container string) error {
+	if !validContainerName.MatchString(container) {
+		return errgo.Newf("invalid container name %q", container)
+	}
+	names := os.Getenv(envContainerNames)
+	if names == "" {
+		return errgo.New("not running in a Kubernetes sidecar charm")
+	}
+	for _, name := range strings.Split(names, ",") {
+		if strings.TrimSpace(name) == container {
+			return nil
+		}
+	}
+	return errgo.Newf("no container %q found in %s", container, names)
+}
+
+// Container returns the name of the container
+// that p talks to.
+func (p *Pebble) Container() string {
+	return p.container
+}
+
+// pebbleResponse holds a response from the Pebble API.
+type pebbleResponse struct {
+	Type   string          `json:"type"`
+	Change string          `json:"change"`
+	Result json.RawMessage `json:"result"`
+}
+
+// pebbleError holds the result of an error response
+// from the Pebble API.
+type pebbleError struct {
+	Message string `json:"message"`
+}
+
+// pebbleChange holds the parts of a Pebble change
+// that we are interested in.
+type pebbleChange struct {
+	Status string `json:"status"`
+	Ready  bool   `json:"ready"`
+	Err    string `json:"err"`
+	Tasks  []struct {
+		Data struct {
+			ExitCode *int `json:"exit-code"`
+		} `json:"data"`
+	} `json:"tasks"`
+}
+
+// Exec runs the given command in the container and waits for it to
+// complete. It returns the combined standard output and standard error
+// of the command. If the command exits with a non-zero status, the
+// output is returned along with an error.
+func (p *Pebble) Exec(command ...string) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, errgo.New("no command given")
+	}
+	var result struct {
+		TaskId string `json:"task-id"`
+	}
+	// Pebble waits for a stderr websocket to be connected too
+	// unless standard error is combined with standard output.
+	changeId, err := p.do("POST", "/v1/exec", map[string]interface{}{
+		"command":        command,
+		"combine-stderr": true,
+	}, &result)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot exec %q", command[0])
+	}
+	// Pebble does not start the command until the control
+	// and stdio websockets are connected.
+	control, err := p.websocket(result.TaskId, "control")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer control.Close()
+	stdio, err := p.websocket(result.TaskId, "stdio")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer stdio.Close()
+	// The command has no input.
+	if err := websocket.JSON.Send(stdio, pebbleEndCommand); err != nil {
+		return nil, errgo.Notef(err, "cannot close standard input")
+	}
+	var out bytes.Buffer
+	for {
+		var msg wsMessage
+		if err := wsCodec.Receive(stdio, &msg); err != nil {
+			return nil, errgo.Notef(err, "cannot read output")
+		}
+		if msg.payloadType == websocket.TextFrame {
+			// The only text message is the end of output.
+			break
+		}
+		out.Write(msg.data)
+	}
+	change, err := p.waitChange(changeId)
+	if err != nil {
+		return out.Bytes(), errgo.Mask(err)
+	}
+	if len(change.Tasks) == 0 || change.Tasks[0].Data.ExitCode == nil {
+		if change.Err != "" {
+			return out.Bytes(), errgo.Newf("cannot exec %q: %s", command[0], change.Err)
+		}
+		return out.Bytes(), errgo.Newf("cannot exec %q: no exit code", command[0])
+	}
+	if code := *change.Tasks[0].Data.ExitCode; code != 0 {
+		return out.Bytes(), errgo.Newf("command %q exited with code %d", command[0], code)
+	}
+	return out.Bytes(), nil
+}
+
+// pebbleEndCommand is sent and received on the stdio
+// websocket to signal the end of the data.
+var pebbleEndCommand = map[string]string{"command": "end"}
+
+// wsMessage holds a websocket message and its type.
+type wsMessage struct {
+	data        []byte
+	payloadType byte
+}
+
+// wsCodec receives websocket messages of any type.
+var wsCodec = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		msg := v.(*wsMessage)
+		msg.data = append([]byte(nil), data...)
+		msg.payloadType = payloadType
+		return nil
+	},
+}
+
+// websocket connects to the websocket
+// with the given id for the given task.
+func (p *Pebble) websocket(taskId, id string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig("ws://localhost/v1/tasks/"+url.PathEscape(taskId)+"/websocket/"+id, "http://localhost/")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	conn, err := net.Dial("unix", p.socketPath)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot connect to %s websocket", id)
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, errgo.Notef(err, "cannot connect to %s websocket", id)
+	}
+	return ws, nil
+}
+
+// Push writes the given content to the file at the given path in the
+// container with the given permissions, creating any parent
+// directories as needed.
+func (p *Pebble) Push(path string, content []byte, perm os.FileMode) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	req, err := json.Marshal(map[string]interface{}{
+		"action": "write",
+		"files": []map[string]interface{}{{
+			"path":        path,
+			"make-dirs":   true,
+			"permissions": fmt.Sprintf("%03o", perm.Perm()),
+		}},
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Disposition", `form-data; name="request"`)
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	part.Write(req)
+	part, err = w.CreateFormFile("files", path)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	part.Write(content)
+	if err := w.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	var results []fileResult
+	if _, err := p.doRaw("POST", "/v1/files", w.FormDataContentType(), &body, &results); err != nil {
+		return errgo.Notef(err, "cannot push %q", path)
+	}
+	if err := checkFileResults(results); err != nil {
+		return errgo.Notef(err, "cannot push %q", path)
+	}
+	return nil
+}
+
+// fileResult holds the result of an
+// operation on a file in the Pebble API.
+type fileResult struct {
+	Path  string       `json:"path"`
+	Error *pebbleError `json:"error"`
+}
+
+func checkFileResults(results []fileResult) error {
+	for _, r := range results {
+		if r.Error != nil {
+			return errgo.New(r.Error.Message)
+		}
+	}
+	return nil
+}
+
+// Pull returns the content of the file
+// at the given path in the container.
+func (p *Pebble) Pull(path string) ([]byte, error) {
+	query := url.Values{
+		"action": {"read"},
+		"path":   {path},
+	}
+	req, err := http.NewRequest("GET", "http://localhost/v1/files?"+query.Encode(), nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	req.Header.Set("Accept", "multipart/form-data")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot pull %q", path)
+	}
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		// An error that applies to the whole request.
+		_, err := decodePebbleResponse(resp.Body, nil)
+		return nil, errgo.Notef(err, "cannot pull %q", path)
+	}
+	var content []byte
+	var results []fileResult
+	r := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot pull %q", path)
+		}
+		switch part.FormName() {
+		case "files":
+			content, err = ioutil.ReadAll(part)
+		case "response":
+			_, err = decodePebbleResponse(part, &results)
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot pull %q", path)
+		}
+	}
+	if err := checkFileResults(results); err != nil {
+		return nil, errgo.Notef(err, "cannot pull %q", path)
+	}
+	return content, nil
+}
+
+// Restart restarts the given services in the container
+// and waits for them to be running again.
+func (p *Pebble) Restart(services ...string) error {
+	changeId, err := p.do("POST", "/v1/services", map[string]interface{}{
+		"action":   "restart",
+		"services": services,
+	}, nil)
+	if err != nil {
+		return errgo.Notef(err, "cannot restart %s", strings.Join(services, ", "))
+	}
+	change, err := p.waitChange(changeId)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if change.Err != "" {
+		return errgo.Newf("cannot restart %s: %s", strings.Join(services, ", "), change.Err)
+	}
+	return nil
+}
+
+// waitChange waits for the change with the given id to complete.
+func (p *Pebble) waitChange(id string) (*pebbleChange, error) {
+	var change pebbleChange
+	if _, err := p.do("GET", "/v1/changes/"+url.PathEscape(id)+"/wait", nil, &change); err != nil {
+		return nil, errgo.Notef(err, "cannot wait for change %s", id)
+	}
+	return &change, nil
+}
+
+// do makes a request to the Pebble API with the given JSON body, if
+// any, and unmarshals the result into result if it is not nil. It
+// returns the change id of an asynchronous request.
+func (p *Pebble) do(method, path string, body interface{}, result interface{}) (string, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	return p.doRaw(method, path, "application/json", r, result)
+}
+
+// doRaw is like do except that the body is sent
+// as is, with the given content type.
+func (p *Pebble) doRaw(method, path string, contentType string, body io.Reader, result interface{}) (string, error) {
+	req, err := http.NewRequest(method, "http://localhost"+path, body)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	defer resp.Body.Close()
+	return decodePebbleResponse(resp.Body, result)
+}
+
+// decodePebbleResponse decodes a Pebble API response from r
+// into result, if it is not nil, and returns its change id.
+func decodePebbleResponse(r io.Reader, result interface{}) (string, error) {
+	var resp pebbleResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return "", errgo.Notef(err, "cannot decode response")
+	}
+	if resp.Type == "error" {
+		var perr pebbleError
+		if err := json.Unmarshal(resp.Result, &perr); err != nil || perr.Message == "" {
+			return "", errgo.Newf("unknown error %s", resp.Result)
+		}
+		return "", errgo.New(perr.Message)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return "", errgo.Notef(err, "cannot unmarshal result")
+		}
+	}
+	return resp.Change, nil
+}
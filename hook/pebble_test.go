@@ -0,0 +1,411 @@
+package hook_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/websocket"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type pebbleSuite struct {
+	savedEnv map[string]string
+	server   *fakePebble
+}
+
+var _ = gc.Suite(&pebbleSuite{})
+
+func (s *pebbleSuite) SetUpTest(c *gc.C) {
+	s.server = newFakePebble(c)
+	env := map[string]string{
+		"JUJU_CONTAINER_NAMES": "workload,sidecar",
+		"PEBBLE_SOCKET":        s.server.socketPath,
+	}
+	s.savedEnv = make(map[string]string)
+	for name, val := range env {
+		s.savedEnv[name] = os.Getenv(name)
+		os.Setenv(name, val)
+	}
+}
+
+func (s *pebbleSuite) TearDownTest(c *gc.C) {
+	for name, val := range s.savedEnv {
+		os.Setenv(name, val)
+	}
+	s.server.Close()
+}
+
+func (s *pebbleSuite) pebble(c *gc.C) *hook.Pebble {
+	p, err := (&hook.Context{}).Pebble("workload")
+	c.Assert(err, gc.IsNil)
+	c.Assert(p.Container(), gc.Equals, "workload")
+	return p
+}
+
+func (s *pebbleSuite) TestNotSidecar(c *gc.C) {
+	os.Setenv("JUJU_CONTAINER_NAMES", "")
+	_, err := (&hook.Context{}).Pebble("workload")
+	c.Assert(err, gc.ErrorMatches, `cannot access container "workload": not running in a Kubernetes sidecar charm`)
+}
+
+func (s *pebbleSuite) TestUnknownContainer(c *gc.C) {
+	_, err := (&hook.Context{}).Pebble("other")
+	c.Assert(err, gc.ErrorMatches, `cannot access container "other": no container "other" found in workload,sidecar`)
+}
+
+func (s *pebbleSuite) TestPushPull(c *gc.C) {
+	p := s.pebble(c)
+	err := p.Push("/etc/app/app.conf", []byte("port = 8080\n"), 0640)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.server.files, jc.DeepEquals, map[string]fakeFile{
+		"/etc/app/app.conf": {
+			content:     "port = 8080\n",
+			permissions: "640",
+			makeDirs:    true,
+		},
+	})
+	data, err := p.Pull("/etc/app/app.conf")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "port = 8080\n")
+}
+
+func (s *pebbleSuite) TestPullNotFound(c *gc.C) {
+	_, err := s.pebble(c).Pull("/etc/nothing")
+	c.Assert(err, gc.ErrorMatches, `cannot pull "/etc/nothing": stat /etc/nothing: no such file or directory`)
+}
+
+func (s *pebbleSuite) TestRestart(c *gc.C) {
+	err := s.pebble(c).Restart("app", "worker")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.server.restarted, jc.DeepEquals, []string{"app", "worker"})
+}
+
+func (s *pebbleSuite) TestRestartUnknownService(c *gc.C) {
+	err := s.pebble(c).Restart("nothing")
+	c.Assert(err, gc.ErrorMatches, `cannot restart nothing: service "nothing" does not exist`)
+}
+
+func (s *pebbleSuite) TestExec(c *gc.C) {
+	s.server.execOutput = "hello world\n"
+	out, err := s.pebble(c).Exec("echo", "hello", "world")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(out), gc.Equals, "hello world\n")
+	c.Assert(s.server.execCommand, jc.DeepEquals, []string{"echo", "hello", "world"})
+}
+
+func (s *pebbleSuite) TestExecFailure(c *gc.C) {
+	s.server.execOutput = "no such table\n"
+	s.server.execExitCode = 3
+	out, err := s.pebble(c).Exec("migrate")
+	c.Assert(err, gc.ErrorMatches, `command "migrate" exited with code 3`)
+	c.Assert(string(out), gc.Equals, "no such table\n")
+}
+
+func (s *pebbleSuite) TestSetContainerStatus(c *gc.C) {
+	runner := &hooktest.Runner{Logger: c}
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.SetContainerStatus("workload", hook.StatusWaiting, "waiting for database")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetContainerStatus("sidecar", hook.StatusMaintenance, "")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetContainerStatus("sidecar", hook.StatusActive, "ready")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetContainerStatus("workload", hook.StatusActive, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"status-set", "waiting", "workload: waiting for database"},
+		{"status-set", "maintenance", "sidecar: maintenance; workload: waiting for database"},
+		{"status-set", "waiting", "sidecar: ready; workload: waiting for database"},
+		{"status-set", "active", "sidecar: ready"},
+	})
+}
+
+func (s *pebbleSuite) TestSetContainerStatusPersists(c *gc.C) {
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var ctxt *hook.Context
+			r.RegisterContext(func(hctxt *hook.Context) error {
+				ctxt = hctxt
+				return nil
+			}, nil)
+			r.RegisterHook("install", func() error {
+				return ctxt.SetContainerStatus("workload", hook.StatusBlocked, "no config")
+			})
+			r.RegisterHook("start", func() error {
+				return ctxt.SetContainerStatus("sidecar", hook.StatusActive, "")
+			})
+		},
+	}
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	err = runner.RunHook("start", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"status-set", "blocked", "workload: no config"},
+		{"status-set", "blocked", "workload: no config"},
+	})
+}
+
+func (s *pebbleSuite) TestSetContainerStatusInvalidContainer(c *gc.C) {
+	runner := &hooktest.Runner{Logger: c}
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.SetContainerStatus("Workload!", hook.StatusActive, "")
+	c.Assert(err, gc.ErrorMatches, `cannot set status of container "Workload!": invalid container name "Workload!"`)
+	err = ctxt.SetContainerStatus("other", hook.StatusActive, "")
+	c.Assert(err, gc.ErrorMatches, `cannot set status of container "other": no container "other" found in workload,sidecar`)
+	os.Setenv("JUJU_CONTAINER_NAMES", "")
+	err = ctxt.SetContainerStatus("workload", hook.StatusActive, "")
+	c.Assert(err, gc.ErrorMatches, `cannot set status of container "workload": not running in a Kubernetes sidecar charm`)
+	c.Assert(runner.Record, gc.HasLen, 0)
+}
+
+// fakePebble implements a small subset of the
+// Pebble API over a Unix socket.
+type fakePebble struct {
+	c          *gc.C
+	socketPath string
+	listener   net.Listener
+
+	mu           sync.Mutex
+	files        map[string]fakeFile
+	restarted    []string
+	changes      map[string]interface{}
+	execCommand  []string
+	execOutput   string
+	execExitCode int
+}
+
+type fakeFile struct {
+	content     string
+	permissions string
+	makeDirs    bool
+}
+
+func newFakePebble(c *gc.C) *fakePebble {
+	p := &fakePebble{
+		c:          c,
+		socketPath: filepath.Join(c.MkDir(), "pebble.socket"),
+		files:      make(map[string]fakeFile),
+		changes:    make(map[string]interface{}),
+	}
+	l, err := net.Listen("unix", p.socketPath)
+	c.Assert(err, gc.IsNil)
+	p.listener = l
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/files", p.serveFiles)
+	mux.HandleFunc("/v1/services", p.serveServices)
+	mux.HandleFunc("/v1/changes/", p.serveChange)
+	mux.HandleFunc("/v1/exec", p.serveExec)
+	mux.Handle("/v1/tasks/T2/websocket/control", websocket.Handler(p.serveControl))
+	mux.Handle("/v1/tasks/T2/websocket/stdio", websocket.Handler(p.serveStdio))
+	go http.Serve(l, mux)
+	return p
+}
+
+func (p *fakePebble) Close() {
+	p.listener.Close()
+}
+
+func writeResponse(w http.ResponseWriter, resp map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (p *fakePebble) serveFiles(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req.Method == "GET" {
+		path := req.URL.Query().Get("path")
+		p.c.Check(req.URL.Query().Get("action"), gc.Equals, "read")
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", mw.FormDataContentType())
+		result := map[string]interface{}{"path": path}
+		if f, ok := p.files[path]; ok {
+			part, _ := mw.CreateFormFile("files", path)
+			part.Write([]byte(f.content))
+		} else {
+			result["error"] = map[string]string{
+				"kind":    "not-found",
+				"message": fmt.Sprintf("stat %s: no such file or directory", path),
+			}
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/json")
+		h.Set("Content-Disposition", `form-data; name="response"`)
+		part, _ := mw.CreatePart(h)
+		json.NewEncoder(part).Encode(map[string]interface{}{
+			"type":   "sync",
+			"result": []interface{}{result},
+		})
+		mw.Close()
+		return
+	}
+	r, err := req.MultipartReader()
+	if !p.c.Check(err, gc.IsNil) {
+		return
+	}
+	var request struct {
+		Action string `json:"action"`
+		Files  []struct {
+			Path        string `json:"path"`
+			MakeDirs    bool   `json:"make-dirs"`
+			Permissions string `json:"permissions"`
+		} `json:"files"`
+	}
+	var results []interface{}
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		switch part.FormName() {
+		case "request":
+			err := json.NewDecoder(part).Decode(&request)
+			p.c.Check(err, gc.IsNil)
+			p.c.Check(request.Action, gc.Equals, "write")
+		case "files":
+			data, _ := ioutil.ReadAll(part)
+			// Part.FileName returns only the base name.
+			_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+			for _, f := range request.Files {
+				if f.Path == params["filename"] {
+					p.files[f.Path] = fakeFile{
+						content:     string(data),
+						permissions: f.Permissions,
+						makeDirs:    f.MakeDirs,
+					}
+					results = append(results, map[string]string{"path": f.Path})
+				}
+			}
+		}
+	}
+	writeResponse(w, map[string]interface{}{
+		"type":   "sync",
+		"result": results,
+	})
+}
+
+func (p *fakePebble) serveServices(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var request struct {
+		Action   string   `json:"action"`
+		Services []string `json:"services"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&request)
+	p.c.Check(err, gc.IsNil)
+	p.c.Check(request.Action, gc.Equals, "restart")
+	change := map[string]interface{}{
+		"id":     "1",
+		"status": "Done",
+		"ready":  true,
+	}
+	for _, svc := range request.Services {
+		if svc == "nothing" {
+			change["status"] = "Error"
+			change["err"] = `service "nothing" does not exist`
+		}
+	}
+	if change["err"] == nil {
+		p.restarted = append(p.restarted, request.Services...)
+	}
+	p.changes["1"] = change
+	writeResponse(w, map[string]interface{}{
+		"type":   "async",
+		"change": "1",
+	})
+}
+
+func (p *fakePebble) serveChange(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/changes/"), "/wait")
+	change, ok := p.changes[id]
+	if !ok {
+		writeResponse(w, map[string]interface{}{
+			"type":   "error",
+			"result": map[string]string{"message": "cannot find change with id " + id},
+		})
+		return
+	}
+	writeResponse(w, map[string]interface{}{
+		"type":   "sync",
+		"result": change,
+	})
+}
+
+func (p *fakePebble) serveExec(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var request struct {
+		Command       []string `json:"command"`
+		CombineStderr bool     `json:"combine-stderr"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&request)
+	p.c.Check(err, gc.IsNil)
+	if !request.CombineStderr {
+		// Real Pebble would not start the command until
+		// the stderr websocket was connected, which this
+		// fake does not serve.
+		writeResponse(w, map[string]interface{}{
+			"type":   "error",
+			"result": map[string]string{"message": "stderr websocket not supported"},
+		})
+		return
+	}
+	p.execCommand = request.Command
+	writeResponse(w, map[string]interface{}{
+		"type":   "async",
+		"change": "2",
+		"result": map[string]string{"task-id": "T2"},
+	})
+}
+
+func (p *fakePebble) serveControl(ws *websocket.Conn) {
+	// Wait for the client to close the connection.
+	var msg string
+	for websocket.Message.Receive(ws, &msg) == nil {
+	}
+}
+
+func (p *fakePebble) serveStdio(ws *websocket.Conn) {
+	// The client sends an end command to close standard input.
+	var cmd map[string]string
+	err := websocket.JSON.Receive(ws, &cmd)
+	p.c.Check(err, gc.IsNil)
+	p.c.Check(cmd, jc.DeepEquals, map[string]string{"command": "end"})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err = websocket.Message.Send(ws, []byte(p.execOutput))
+	p.c.Check(err, gc.IsNil)
+	err = websocket.JSON.Send(ws, map[string]string{"command": "end"})
+	p.c.Check(err, gc.IsNil)
+	p.changes["2"] = map[string]interface{}{
+		"id":     "2",
+		"status": "Done",
+		"ready":  true,
+		"tasks": []interface{}{
+			map[string]interface{}{
+				"id":   "T2",
+				"data": map[string]int{"exit-code": p.execExitCode},
+			},
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package hook
+
+import (
+	"bytes"
+	"io/ioutil"
+	osexec "os/exec"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Platforms returned by Context.Platform.
+const (
+	PlatformBareMetal = "bare-metal"
+	PlatformLXD       = "lxd"
+	PlatformKVM       = "kvm"
+
+	// PlatformContainer is returned for containers
+	// other than LXD, such as docker.
+	PlatformContainer = "container"
+
+	// PlatformVM is returned for virtual machines
+	// other than KVM, such as VMware or Xen.
+	PlatformVM = "vm"
+)
+
+var platformCache struct {
+	mu       sync.Mutex
+	platform string
+}
+
+// Platform returns the kind of host that the unit is running on,
+// one of PlatformBareMetal, PlatformLXD, PlatformKVM,
+// PlatformContainer or PlatformVM. The result is determined with
+// systemd-detect-virt and is cached for the lifetime of the process.
+func (ctxt *Context) Platform() (string, error) {
+	platformCache.mu.Lock()
+	defer platformCache.mu.Unlock()
+	if platformCache.platform != "" {
+		return platformCache.platform, nil
+	}
+	virt, err := detectVirt()
+	if err != nil {
+		return "", errgo.Notef(err, "cannot detect platform")
+	}
+	platformCache.platform = normalizePlatform(virt)
+	return platformCache.platform, nil
+}
+
+// normalizePlatform returns the platform corresponding
+// to the given virtualization technology as named by
+// systemd-detect-virt.
+func normalizePlatform(virt string) string {
+	switch virt {
+	case "none", "":
+		return PlatformBareMetal
+	case "lxc", "lxc-libvirt":
+		return PlatformLXD
+	case "kvm", "qemu":
+		return PlatformKVM
+	case "docker", "podman", "rkt", "systemd-nspawn", "openvz", "wsl", "proot", "pouch", "container-other":
+		return PlatformContainer
+	}
+	return PlatformVM
+}
+
+// detectVirt returns the name of the virtualization technology in
+// use, as printed by systemd-detect-virt ("none" if there is none).
+// It is defined as a variable so that it can be replaced for testing.
+var detectVirt = func() (string, error) {
+	var out bytes.Buffer
+	c := osexec.Command("systemd-detect-virt")
+	c.Stdout = &out
+	err := c.Run()
+	virt := strings.TrimSpace(out.String())
+	if virt != "" {
+		// Note that systemd-detect-virt exits with a non-zero
+		// status when it prints "none".
+		return virt, nil
+	}
+	if execErr, ok := err.(*osexec.Error); !ok || execErr.Err != osexec.ErrNotFound {
+		return "", errgo.Notef(err, "systemd-detect-virt failed")
+	}
+	// No systemd: fall back to the container type
+	// that container managers conventionally record.
+	data, err := ioutil.ReadFile("/run/systemd/container")
+	if err != nil {
+		return "none", nil
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,68 @@
+package hook_test
+
+import (
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type platformSuite struct{}
+
+var _ = gc.Suite(&platformSuite{})
+
+var platformTests = []struct {
+	virt   string
+	expect string
+}{
+	{"none", hook.PlatformBareMetal},
+	{"lxc", hook.PlatformLXD},
+	{"kvm", hook.PlatformKVM},
+	{"qemu", hook.PlatformKVM},
+	{"docker", hook.PlatformContainer},
+	{"vmware", hook.PlatformVM},
+	{"xen", hook.PlatformVM},
+}
+
+func (*platformSuite) TestPlatform(c *gc.C) {
+	for i, test := range platformTests {
+		c.Logf("test %d: %s", i, test.virt)
+		restore := hook.PatchDetectVirt(func() (string, error) {
+			return test.virt, nil
+		})
+		platform, err := (&hook.Context{}).Platform()
+		restore()
+		c.Assert(err, gc.IsNil)
+		c.Assert(platform, gc.Equals, test.expect)
+	}
+}
+
+func (*platformSuite) TestPlatformCached(c *gc.C) {
+	calls := 0
+	defer hook.PatchDetectVirt(func() (string, error) {
+		calls++
+		return "lxc", nil
+	})()
+	ctxt := &hook.Context{}
+	for i := 0; i < 3; i++ {
+		platform, err := ctxt.Platform()
+		c.Assert(err, gc.IsNil)
+		c.Assert(platform, gc.Equals, hook.PlatformLXD)
+	}
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (*platformSuite) TestPlatformError(c *gc.C) {
+	calls := 0
+	defer hook.PatchDetectVirt(func() (string, error) {
+		calls++
+		return "", errgo.New("no detection")
+	})()
+	ctxt := &hook.Context{}
+	_, err := ctxt.Platform()
+	c.Assert(err, gc.ErrorMatches, "cannot detect platform: no detection")
+	// Errors are not cached.
+	_, err = ctxt.Platform()
+	c.Assert(err, gc.NotNil)
+	c.Assert(calls, gc.Equals, 2)
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/juju/charm/v9"
@@ -38,16 +39,42 @@ type Registry struct {
 // sharedRegistry holds registry values that
 // are shared across all clones of a Registry.
 type sharedRegistry struct {
-	hooks     map[string][]hookFunc
-	commands  map[string]func([]string) (Command, error)
-	relations map[string]charm.Relation
-	resources map[string]resource.Meta
-	config    map[string]charm.Option
-	contexts  []ContextSetter
-	state     []localState
-	charmInfo CharmInfo
+	hooks       map[string][]hookFunc
+	stopHooks   []hookFunc
+	cleanups    []cleanupFunc
+	commands    map[string]func([]string) (Command, error)
+	relations   map[string]charm.Relation
+	resources   map[string]resource.Meta
+	containers  map[string]charm.Container
+	config      map[string]charm.Option
+	actions     map[string]charm.ActionSpec
+	required    map[string]bool
+	patterns    map[string]string
+	validators  []func(cfg map[string]interface{}) error
+	contexts    []ContextSetter
+	state       []localState
+	series      []string
+	tags        []string
+	assumes     []AssumesExpr
+	subordinate bool
+	retries     bool
+	once        *onceState
+	charmInfo   CharmInfo
 }
 
+// onceState holds the persistent state of the functions
+// registered with RegisterOnce.
+type onceState struct {
+	// Done holds an entry for each key whose
+	// function has completed successfully.
+	Done map[string]bool
+}
+
+// onceStateName holds the name under which onceState is
+// persisted. It cannot clash with any registry name because
+// those always start with "root".
+const onceStateName = "gocharm-once"
+
 // CharmInfo holds descriptive information associated with
 // a charm.
 type CharmInfo struct {
@@ -61,6 +88,11 @@ type hookFunc struct {
 	run          func() error
 }
 
+type cleanupFunc struct {
+	registryName string
+	run          func(ctxt *Context) error
+}
+
 // localState holds a registered persistent local state value.
 type localState struct {
 	registryName string
@@ -73,11 +105,15 @@ func NewRegistry() *Registry {
 		name:   "root",
 		clones: make(map[string]bool),
 		sharedRegistry: &sharedRegistry{
-			hooks:     make(map[string][]hookFunc),
-			commands:  make(map[string]func([]string) (Command, error)),
-			relations: make(map[string]charm.Relation),
-			resources: make(map[string]resource.Meta),
-			config:    make(map[string]charm.Option),
+			hooks:      make(map[string][]hookFunc),
+			commands:   make(map[string]func([]string) (Command, error)),
+			relations:  make(map[string]charm.Relation),
+			resources:  make(map[string]resource.Meta),
+			containers: make(map[string]charm.Container),
+			config:     make(map[string]charm.Option),
+			actions:    make(map[string]charm.ActionSpec),
+			required:   make(map[string]bool),
+			patterns:   make(map[string]string),
 			charmInfo: CharmInfo{
 				Name: "anon",
 			},
@@ -148,6 +184,54 @@ func (r *Registry) RegisterHook(name string, f func() error) {
 	})
 }
 
+// RegisterStop registers the given function to be called when the
+// stop hook is invoked. Functions registered with RegisterStop run in
+// reverse order of registration, before any functions registered with
+// RegisterHook("stop", ...). Charmbits such as the service package
+// register their own stop functions when they are registered, so a
+// function registered after them runs while their services are still
+// available. This gives the charm a chance to drain its workload
+// gracefully (for example by calling a method on a running service)
+// before it is shut down.
+func (r *Registry) RegisterStop(f func() error) {
+	r.stopHooks = append(r.stopHooks, hookFunc{
+		run:          f,
+		registryName: r.name,
+	})
+	if _, ok := r.hooks["stop"]; !ok {
+		// Make sure that the stop hook is generated even
+		// if nothing else registers it.
+		r.hooks["stop"] = nil
+	}
+}
+
+// RegisterCleanup registers the given function to be called when the
+// remove hook is invoked, which happens when the unit is being removed,
+// after the stop hook has run. It should be used to clean up external
+// resources created by the charm. The function is passed a context
+// associated with r.
+//
+// Cleanup functions run in reverse order of registration, before
+// any functions registered with RegisterHook("remove", ...). An error
+// returned by a cleanup function is logged but does not stop the
+// other cleanup functions from running or cause the hook to fail,
+// so that Juju can complete the removal of the unit.
+//
+// Versions of Juju before 2.8 do not run the remove hook; cleanup
+// that must happen with those versions should be registered with
+// RegisterStop instead.
+func (r *Registry) RegisterCleanup(f func(ctxt *Context) error) {
+	r.cleanups = append(r.cleanups, cleanupFunc{
+		run:          f,
+		registryName: r.name,
+	})
+	if _, ok := r.hooks["remove"]; !ok {
+		// Make sure that the remove hook is generated even
+		// if nothing else registers it.
+		r.hooks["remove"] = nil
+	}
+}
+
 // RegisterContext registers a function that will be called
 // to set up a context before hook function execution.
 //
@@ -183,6 +267,62 @@ func (r *Registry) RegisterContext(setter ContextSetter, state interface{}) {
 	})
 }
 
+// RegisterOnce registers the given function to be called when the
+// config-changed hook runs, but only until it has completed
+// successfully once. After that it will never be called again for the
+// lifetime of the unit, even across charm upgrades, unless it is
+// reset with ResetOnce. If the function returns an error, it will be
+// tried again the next time config-changed runs.
+//
+// The key identifies the function in the persistent local state, so it
+// should not change between versions of the charm; RegisterOnce panics
+// if the same key is registered twice.
+func (r *Registry) RegisterOnce(key string, f func(ctxt *Context) error) {
+	if r.once == nil {
+		r.once = &onceState{
+			Done: make(map[string]bool),
+		}
+		r.state = append(r.state, localState{
+			registryName: onceStateName,
+			val:          r.once,
+		})
+	}
+	if _, ok := r.once.Done[key]; ok {
+		panic(errgo.Newf("once function %q registered twice", key))
+	}
+	r.once.Done[key] = false
+	var ctxt *Context
+	r.contexts = append(r.contexts, func(c *Context) error {
+		ctxt = c.withRegistryName(r.name)
+		return nil
+	})
+	r.RegisterHook("config-changed", func() error {
+		if r.once.Done[key] {
+			return nil
+		}
+		if err := f(ctxt); err != nil {
+			return errgo.Notef(err, "once function %q failed", key)
+		}
+		r.once.Done[key] = true
+		return nil
+	})
+}
+
+// ResetOnce arranges for the function registered with RegisterOnce
+// under the given key to be called again the next time the
+// config-changed hook runs. It should be called from within a hook,
+// for example by an action that forces the setup to be redone.
+// It panics if no function has been registered with that key.
+func (r *Registry) ResetOnce(key string) {
+	if r.once == nil {
+		panic(errgo.Newf("once function %q not registered", key))
+	}
+	if _, ok := r.once.Done[key]; !ok {
+		panic(errgo.Newf("once function %q not registered", key))
+	}
+	r.once.Done[key] = false
+}
+
 // Command is implemented by running commands
 // that implement long-lived services.
 type Command interface {
@@ -249,11 +389,22 @@ func (r *Registry) RegisterRelation(rel charm.Relation) {
 // RegisterResource registers a resource to be included in the charm's
 // metadata.yaml. If a resource is registered twice with the same
 // name, all of the details must also match.
+//
+// The resource's Type must be either resource.TypeFile or
+// resource.TypeContainerImage ("oci-image"). A file resource must
+// specify the name of the file in Path (emitted as "filename"); an
+// oci-image resource must leave it empty. The Description is
+// optional for both.
 func (r *Registry) RegisterResource(res resource.Meta) {
 	if res.Name == "" {
 		panic(fmt.Errorf("no resource name given in %#v", res))
 	}
-
+	if err := res.Validate(); err != nil {
+		panic(errgo.Notef(err, "invalid resource %q", res.Name))
+	}
+	if res.Type == resource.TypeContainerImage && res.Path != "" {
+		panic(errgo.Newf("invalid resource %q: oci-image resource cannot have a filename", res.Name))
+	}
 	old, ok := r.resources[res.Name]
 	if ok {
 		if old != res {
@@ -261,13 +412,54 @@ func (r *Registry) RegisterResource(res resource.Meta) {
 		}
 		return
 	}
-	if e := res.Validate(); e != nil {
-		panic(errgo.NoteMask(e, "validation failed"))
-	}
-
 	r.resources[res.Name] = res
 }
 
+// ContainerOpts holds options for a workload container
+// registered with Registry.RegisterContainer.
+type ContainerOpts struct {
+	// Resource holds the name of the oci-image resource that
+	// provides the container's image. If it is empty, the
+	// container name followed by "-image" is used.
+	Resource string
+
+	// Description holds an optional description
+	// of the image resource.
+	Description string
+}
+
+// RegisterContainer registers a workload container for a sidecar
+// Kubernetes charm, to be included in the "containers" section of
+// the charm's metadata.yaml, along with the oci-image resource that
+// supplies its image in the "resources" section. If a container is
+// registered twice with the same name, all of the details must also
+// match.
+//
+// Container names must be lower case words separated by
+// hyphens, such as "workload" or "web-server".
+func (r *Registry) RegisterContainer(name string, opts ContainerOpts) {
+	if !validContainerName.MatchString(name) {
+		panic(errgo.Newf("invalid container name %q", name))
+	}
+	if opts.Resource == "" {
+		opts.Resource = name + "-image"
+	}
+	container := charm.Container{
+		Resource: opts.Resource,
+	}
+	if old, ok := r.containers[name]; ok {
+		if !reflect.DeepEqual(old, container) {
+			panic(errgo.Newf("container %q is already registered with different details (%#v)", name, old))
+		}
+	}
+	r.RegisterResource(resource.Meta{
+		Name:        opts.Resource,
+		Type:        resource.TypeContainerImage,
+		Description: opts.Description,
+	})
+	r.containers[name] = container
+}
+
 // RegisterConfig registers a configuration option to be included in
 // the charm's config.yaml. If an option is registered twice with the
 // same name, all of the details must also match.
@@ -282,6 +474,174 @@ func (r *Registry) RegisterConfig(name string, opt charm.Option) {
 	}
 }
 
+// RegisterRequiredConfig is like RegisterConfig except that the option
+// is also marked as required: it has no sensible default, and the
+// charm cannot operate until the user has set it. Context.CheckRequiredConfig
+// can be used to find out which required options are unset.
+//
+// It panics if the option has a default value.
+func (r *Registry) RegisterRequiredConfig(name string, opt charm.Option) {
+	if opt.Default != nil {
+		panic(errgo.Newf("required configuration option %q has a default value", name))
+	}
+	r.RegisterConfig(name, opt)
+	r.required[name] = true
+}
+
+// RegisterConfigPattern is like RegisterConfig except that the value
+// of the option, which must be of type "string", must also match the
+// given regular expression in its entirety. The pattern is added to
+// the option's description in config.yaml, and the value is checked
+// when the config-changed hook runs, before any config-changed hook
+// functions, in the same way as the functions registered with
+// RegisterConfigValidator. An unset or empty value is not checked;
+// use RegisterRequiredConfig as well if the option must be set.
+//
+// It panics if the option is not of type "string", if the pattern
+// does not compile, if the option has a default value that does not
+// match it, or if the option has already been registered with a
+// different pattern.
+func (r *Registry) RegisterConfigPattern(name string, opt charm.Option, pattern string) {
+	if opt.Type != "string" {
+		panic(errgo.Newf("configuration option %q with a pattern has type %q, not string", name, opt.Type))
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		panic(errgo.Notef(err, "invalid pattern for configuration option %q", name))
+	}
+	if def, ok := opt.Default.(string); ok && def != "" && !re.MatchString(def) {
+		panic(errgo.Newf("default value %q of configuration option %q does not match pattern %q", def, name, pattern))
+	}
+	if old, ok := r.patterns[name]; ok && old != pattern {
+		panic(errgo.Newf("configuration option %q is already registered with pattern %q", name, old))
+	}
+	if opt.Description != "" {
+		opt.Description += " "
+	}
+	opt.Description += "Must match the regular expression: " + pattern
+	r.RegisterConfig(name, opt)
+	if _, ok := r.patterns[name]; ok {
+		return
+	}
+	r.patterns[name] = pattern
+	r.RegisterConfigValidator(func(cfg map[string]interface{}) error {
+		val, _ := cfg[name].(string)
+		if val == "" || re.MatchString(val) {
+			return nil
+		}
+		return errgo.Newf("invalid value %q for configuration option %q: does not match %q", val, name, pattern)
+	})
+}
+
+// RegisterConfigValidator registers a function that checks the
+// charm's configuration when the config-changed hook runs. The
+// function is passed all the configuration values, as returned
+// by Context.GetAllConfig.
+//
+// Validators run in order of registration before any functions
+// registered for the config-changed hook. If a validator returns an
+// error, the unit's status is set to blocked with the error message
+// and no further validators or config-changed hook functions are run.
+func (r *Registry) RegisterConfigValidator(f func(cfg map[string]interface{}) error) {
+	if len(r.validators) == 0 {
+		// Make sure that the config-changed hook is generated
+		// even if nothing else registers it.
+		r.RegisterHook("config-changed", nop)
+	}
+	r.validators = append(r.validators, f)
+}
+
+// RegisterRetries arranges for the attempts to run each hook to be
+// counted in persistent state, so that Context.IsRetry can be used.
+// The attempts are only counted when this has been called, so that
+// charms that do not need them do not pay for saving the state
+// every time a hook runs.
+func (r *Registry) RegisterRetries() {
+	r.retries = true
+}
+
+// RegisterSubordinate marks the charm as a subordinate charm, so that
+// "subordinate: true" is included in the charm's metadata.yaml. A
+// subordinate charm must also register at least one requirer relation
+// with container scope (charm.ScopeContainer); see Validate.
+func (r *Registry) RegisterSubordinate() {
+	r.subordinate = true
+}
+
+// IsSubordinate reports whether RegisterSubordinate
+// has been called.
+func (r *Registry) IsSubordinate() bool {
+	return r.subordinate
+}
+
+// Validate checks that everything registered in r is consistent
+// and returns an error if not. Currently this checks that a charm
+// registered as subordinate has a requirer relation with container
+// scope, as Juju requires, and that a charm with workload containers
+// or assumes expressions does not also register series, which Juju
+// does not allow in the same metadata.
+func (r *Registry) Validate() error {
+	if len(r.containers) > 0 && len(r.series) > 0 {
+		return errgo.New("charm with workload containers cannot register series")
+	}
+	if len(r.assumes) > 0 && len(r.series) > 0 {
+		return errgo.New("charm with assumes expressions cannot register series")
+	}
+	if !r.subordinate {
+		return nil
+	}
+	for _, rel := range r.relations {
+		if rel.Role == charm.RoleRequirer && rel.Scope == charm.ScopeContainer {
+			return nil
+		}
+	}
+	return errgo.New("subordinate charm has no requirer relation with container scope")
+}
+
+// RegisterSeries registers the given series as supported by the
+// charm, to be included in the charm's metadata.yaml. The first series
+// ever registered is treated by Juju as the default series. Registering
+// a series more than once is allowed. It panics if any of the series
+// is not known to Juju.
+func (r *Registry) RegisterSeries(series ...string) {
+	for _, s := range series {
+		if !knownSeries[s] {
+			panic(errgo.Newf("unknown series %q", s))
+		}
+		if !contains(r.series, s) {
+			r.series = append(r.series, s)
+		}
+	}
+}
+
+// RegisterTags registers the given tags to be included in the charm's
+// metadata.yaml. Tags must follow the Juju convention of being
+// lower case words separated by hyphens, such as "databases" or
+// "app-servers"; RegisterTags will panic if they do not.
+// Registering a tag more than once is allowed.
+func (r *Registry) RegisterTags(tags ...string) {
+	for _, t := range tags {
+		if !validTag.MatchString(t) {
+			panic(errgo.Newf("invalid tag %q", t))
+		}
+		if !contains(r.tags, t) {
+			r.tags = append(r.tags, t)
+		}
+	}
+}
+
+// RegisterAssumes registers an expression to be included in the
+// "assumes" section of the charm's metadata.yaml, declaring features
+// that the charm requires, such as a minimum Juju version. All the
+// registered expressions must hold for the charm to be deployed.
+// It panics if the expression is not valid (see AssumesExpr.Validate).
+func (r *Registry) RegisterAssumes(expr AssumesExpr) {
+	if err := expr.Validate(); err != nil {
+		panic(errgo.Notef(err, "cannot register assumes expression"))
+	}
+	r.assumes = append(r.assumes, expr)
+}
+
 // RegisteredHooks returns the names of all currently
 // registered hooks, excluding wildcard ("*") hooks.
 func (r *Registry) RegisteredHooks() []string {
@@ -306,12 +666,99 @@ func (r *Registry) RegisteredResources() map[string]resource.Meta {
 	return r.resources
 }
 
+// RegisteredContainers returns the workload containers that
+// have been registered with RegisterContainer, keyed by name.
+func (r *Registry) RegisteredContainers() map[string]charm.Container {
+	return r.containers
+}
+
 // RegisteredConfig returns the configuration options
 // that have been registered with RegisterConfig.
 func (r *Registry) RegisteredConfig() map[string]charm.Option {
 	return r.config
 }
 
+// RegisteredConfigKeys returns the names of all the configuration
+// options that have been registered with RegisterConfig, in
+// alphabetical order. As the registry is populated before any hook
+// runs, this can be used from hook functions to iterate over the
+// charm's configuration without running any hook tools.
+func (r *Registry) RegisteredConfigKeys() []string {
+	keys := make([]string, 0, len(r.config))
+	for key := range r.config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RegisteredRequiredConfig returns the names of all the configuration
+// options that have been registered with RegisterRequiredConfig,
+// in alphabetical order.
+func (r *Registry) RegisteredRequiredConfig() []string {
+	keys := make([]string, 0, len(r.required))
+	for key := range r.required {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RegisteredSeries returns the series that have been
+// registered with RegisterSeries, in registration order.
+func (r *Registry) RegisteredSeries() []string {
+	return r.series
+}
+
+// RegisteredTags returns the tags that have been
+// registered with RegisterTags, in registration order.
+func (r *Registry) RegisteredTags() []string {
+	return r.tags
+}
+
+// RegisteredAssumes returns the expressions that have been
+// registered with RegisterAssumes, in registration order.
+func (r *Registry) RegisteredAssumes() []AssumesExpr {
+	return r.assumes
+}
+
+// knownSeries holds all the series that may be
+// registered with RegisterSeries.
+var knownSeries = map[string]bool{
+	"precise":      true,
+	"trusty":       true,
+	"xenial":       true,
+	"bionic":       true,
+	"focal":        true,
+	"groovy":       true,
+	"hirsute":      true,
+	"impish":       true,
+	"jammy":        true,
+	"centos7":      true,
+	"centos8":      true,
+	"opensuseleap": true,
+	"genericlinux": true,
+	"kubernetes":   true,
+	"win2012":      true,
+	"win2012r2":    true,
+	"win2016":      true,
+	"win2019":      true,
+	"win10":        true,
+}
+
+var validTag = regexp.MustCompile("^[a-z][a-z0-9]*(-[a-z0-9]+)*$")
+
+var validContainerName = regexp.MustCompile("^[a-z][a-z0-9]*(-[a-z0-9]+)*$")
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
 var relationHookPattern = regexp.MustCompile("^(?:(" + names.RelationSnippet + ")-)?(relation-[a-z]+)$")
 
 var hookNames = map[hooks.Kind]bool{
@@ -320,6 +767,7 @@ var hookNames = map[hooks.Kind]bool{
 	hooks.ConfigChanged:      true,
 	hooks.UpgradeCharm:       true,
 	hooks.Stop:               true,
+	hooks.Remove:             true,
 	hooks.Action:             true,
 	hooks.CollectMetrics:     true,
 	hooks.MeterStatusChanged: true,
@@ -327,6 +775,8 @@ var hookNames = map[hooks.Kind]bool{
 	hooks.RelationChanged:    true,
 	hooks.RelationDeparted:   true,
 	hooks.RelationBroken:     true,
+	secretRotate:             true,
+	secretExpired:            true,
 }
 
 func validHookName(s string) bool {
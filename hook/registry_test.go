@@ -0,0 +1,699 @@
+package hook_test
+
+import (
+	"fmt"
+
+	"github.com/juju/charm/v9"
+	"github.com/juju/charm/v9/resource"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type registrySuite struct{}
+
+var _ = gc.Suite(&registrySuite{})
+
+func (*registrySuite) TestRegisterSeries(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterSeries("focal", "bionic")
+	r.Clone("sub").RegisterSeries("focal", "xenial")
+	c.Assert(r.RegisteredSeries(), jc.DeepEquals, []string{"focal", "bionic", "xenial"})
+}
+
+func (*registrySuite) TestRegisterUnknownSeries(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(func() {
+		r.RegisterSeries("focal", "nosuchseries")
+	}, gc.PanicMatches, `unknown series "nosuchseries"`)
+}
+
+func (*registrySuite) TestRegisterTags(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterTags("databases", "app-servers", "databases")
+	c.Assert(r.RegisteredTags(), jc.DeepEquals, []string{"databases", "app-servers"})
+}
+
+func (*registrySuite) TestRegisterInvalidTag(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(func() {
+		r.RegisterTags("Big Data")
+	}, gc.PanicMatches, `invalid tag "Big Data"`)
+}
+
+func (*registrySuite) TestRegisterOnce(c *gc.C) {
+	count := 0
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterOnce("setup", func(ctxt *hook.Context) error {
+				c.Check(ctxt.HookName, gc.Equals, "config-changed")
+				count++
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 1)
+
+	// The second time around, the function should be skipped.
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 1)
+}
+
+func (*registrySuite) TestRegisterOnceRetriesAfterError(c *gc.C) {
+	count := 0
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterOnce("setup", func(ctxt *hook.Context) error {
+				count++
+				if count == 1 {
+					return errgo.New("not yet")
+				}
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.ErrorMatches, `once function "setup" failed: not yet`)
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 2)
+}
+
+func (*registrySuite) TestResetOnce(c *gc.C) {
+	count := 0
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterOnce("setup", func(ctxt *hook.Context) error {
+				count++
+				return nil
+			})
+			r.RegisterHook("upgrade-charm", func() error {
+				r.ResetOnce("setup")
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 1)
+
+	// After a reset, the function runs again, but only once.
+	err = runner.RunHook("upgrade-charm", "", "")
+	c.Assert(err, gc.IsNil)
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	err = runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 2)
+}
+
+func (*registrySuite) TestResetOnceNotRegistered(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(func() {
+		r.ResetOnce("setup")
+	}, gc.PanicMatches, `once function "setup" not registered`)
+	r.RegisterOnce("other", func(*hook.Context) error { return nil })
+	c.Assert(func() {
+		r.ResetOnce("setup")
+	}, gc.PanicMatches, `once function "setup" not registered`)
+}
+
+func (*registrySuite) TestRegisterOnceTwice(c *gc.C) {
+	r := hook.NewRegistry()
+	f := func(*hook.Context) error { return nil }
+	r.RegisterOnce("setup", f)
+	c.Assert(func() {
+		r.Clone("sub").RegisterOnce("setup", f)
+	}, gc.PanicMatches, `once function "setup" registered twice`)
+}
+
+func (*registrySuite) TestRegisterResource(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterResource(resource.Meta{
+		Name:        "software",
+		Type:        resource.TypeFile,
+		Path:        "software.tgz",
+		Description: "The software to install",
+	})
+	r.RegisterResource(resource.Meta{
+		Name: "image",
+		Type: resource.TypeContainerImage,
+	})
+	c.Assert(r.RegisteredResources(), jc.DeepEquals, map[string]resource.Meta{
+		"software": {
+			Name:        "software",
+			Type:        resource.TypeFile,
+			Path:        "software.tgz",
+			Description: "The software to install",
+		},
+		"image": {
+			Name: "image",
+			Type: resource.TypeContainerImage,
+		},
+	})
+}
+
+var registerInvalidResourceTests = []struct {
+	res         resource.Meta
+	expectPanic string
+}{{
+	res:         resource.Meta{Name: "foo"},
+	expectPanic: `invalid resource "foo": resource missing type`,
+}, {
+	res:         resource.Meta{Name: "foo", Type: resource.TypeFile},
+	expectPanic: `invalid resource "foo": resource missing filename`,
+}, {
+	res:         resource.Meta{Name: "foo", Type: resource.TypeContainerImage, Path: "foo.tgz"},
+	expectPanic: `invalid resource "foo": oci-image resource cannot have a filename`,
+}}
+
+func (*registrySuite) TestRegisterInvalidResource(c *gc.C) {
+	for i, test := range registerInvalidResourceTests {
+		c.Logf("test %d: %#v", i, test.res)
+		r := hook.NewRegistry()
+		c.Check(func() {
+			r.RegisterResource(test.res)
+		}, gc.PanicMatches, test.expectPanic)
+	}
+}
+
+func (*registrySuite) TestRegisterContainer(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterContainer("workload", hook.ContainerOpts{
+		Description: "The workload image",
+	})
+	r.Clone("sub").RegisterContainer("web-server", hook.ContainerOpts{
+		Resource: "nginx",
+	})
+	// Registering the same container again is allowed.
+	r.RegisterContainer("web-server", hook.ContainerOpts{
+		Resource: "nginx",
+	})
+	c.Assert(r.RegisteredContainers(), jc.DeepEquals, map[string]charm.Container{
+		"workload": {
+			Resource: "workload-image",
+		},
+		"web-server": {
+			Resource: "nginx",
+		},
+	})
+	c.Assert(r.RegisteredResources(), jc.DeepEquals, map[string]resource.Meta{
+		"workload-image": {
+			Name:        "workload-image",
+			Type:        resource.TypeContainerImage,
+			Description: "The workload image",
+		},
+		"nginx": {
+			Name: "nginx",
+			Type: resource.TypeContainerImage,
+		},
+	})
+	c.Assert(r.Validate(), gc.IsNil)
+
+	r.RegisterSeries("focal")
+	c.Assert(r.Validate(), gc.ErrorMatches, "charm with workload containers cannot register series")
+}
+
+func (*registrySuite) TestRegisterAssumes(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterAssumes(hook.AssumesFeature("juju >= 3.0"))
+	r.Clone("sub").RegisterAssumes(hook.AnyOf(
+		hook.AssumesFeature("k8s-api"),
+		hook.AllOf(
+			hook.AssumesFeature("juju >= 2.9"),
+			hook.AssumesFeature("juju < 3"),
+		),
+	))
+	c.Assert(r.RegisteredAssumes(), jc.DeepEquals, []hook.AssumesExpr{{
+		Feature: "juju >= 3.0",
+	}, {
+		AnyOf: []hook.AssumesExpr{{
+			Feature: "k8s-api",
+		}, {
+			AllOf: []hook.AssumesExpr{{
+				Feature: "juju >= 2.9",
+			}, {
+				Feature: "juju < 3",
+			}},
+		}},
+	}})
+	c.Assert(r.Validate(), gc.IsNil)
+
+	r.RegisterSeries("focal")
+	c.Assert(r.Validate(), gc.ErrorMatches, "charm with assumes expressions cannot register series")
+}
+
+var registerInvalidAssumesTests = []struct {
+	expr        hook.AssumesExpr
+	expectPanic string
+}{{
+	expr:        hook.AssumesExpr{},
+	expectPanic: `cannot register assumes expression: assumes expression must have exactly one of feature, any-of or all-of`,
+}, {
+	expr: hook.AssumesExpr{
+		Feature: "k8s-api",
+		AnyOf:   []hook.AssumesExpr{{Feature: "juju"}},
+	},
+	expectPanic: `cannot register assumes expression: assumes expression must have exactly one of feature, any-of or all-of`,
+}, {
+	expr:        hook.AssumesFeature("juju >= three"),
+	expectPanic: `cannot register assumes expression: invalid assumes feature "juju >= three"`,
+}, {
+	expr:        hook.AssumesFeature("juju>=3"),
+	expectPanic: `cannot register assumes expression: invalid assumes feature "juju>=3"`,
+}, {
+	expr:        hook.AnyOf(),
+	expectPanic: `cannot register assumes expression: empty any-of assumes expression`,
+}, {
+	expr:        hook.AssumesExpr{AllOf: []hook.AssumesExpr{}},
+	expectPanic: `cannot register assumes expression: empty all-of assumes expression`,
+}, {
+	expr:        hook.AllOf(hook.AssumesFeature("k8s-api"), hook.AnyOf(hook.AssumesFeature("Bad"))),
+	expectPanic: `cannot register assumes expression: invalid all-of assumes expression: invalid any-of assumes expression: invalid assumes feature "Bad"`,
+}}
+
+func (*registrySuite) TestRegisterInvalidAssumes(c *gc.C) {
+	for i, test := range registerInvalidAssumesTests {
+		c.Logf("test %d: %#v", i, test.expr)
+		r := hook.NewRegistry()
+		c.Check(func() {
+			r.RegisterAssumes(test.expr)
+		}, gc.PanicMatches, test.expectPanic)
+	}
+}
+
+func (*registrySuite) TestRegisterInvalidContainer(c *gc.C) {
+	for _, name := range []string{"", "Workload", "1workload", "work_load", "workload-", "work--load"} {
+		c.Logf("name %q", name)
+		r := hook.NewRegistry()
+		c.Check(func() {
+			r.RegisterContainer(name, hook.ContainerOpts{})
+		}, gc.PanicMatches, fmt.Sprintf("invalid container name %q", name))
+	}
+}
+
+func (*registrySuite) TestRegisterContainerConflict(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterContainer("workload", hook.ContainerOpts{})
+	c.Assert(func() {
+		r.RegisterContainer("workload", hook.ContainerOpts{Resource: "other"})
+	}, gc.PanicMatches, `container "workload" is already registered with different details .*`)
+
+	// A resource with the same name but a different type is rejected.
+	r.RegisterResource(resource.Meta{
+		Name: "software",
+		Type: resource.TypeFile,
+		Path: "software.tgz",
+	})
+	c.Assert(func() {
+		r.RegisterContainer("app", hook.ContainerOpts{Resource: "software"})
+	}, gc.PanicMatches, `resource "software" is already registered with different details .*`)
+}
+
+func (*registrySuite) TestRegisteredConfigKeys(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterConfig("port", charm.Option{
+		Type:    "int",
+		Default: 80,
+	})
+	r.Clone("sub").RegisterConfig("hostname", charm.Option{
+		Type: "string",
+	})
+	r.RegisterConfig("debug", charm.Option{
+		Type:    "boolean",
+		Default: false,
+	})
+	r.RegisterConfig("ratio", charm.Option{
+		Type: "float",
+	})
+	c.Assert(r.RegisteredConfigKeys(), jc.DeepEquals, []string{"debug", "hostname", "port", "ratio"})
+}
+
+func (*registrySuite) TestRegisterStop(c *gc.C) {
+	var called []string
+	record := func(name string) func() error {
+		return func() error {
+			called = append(called, name)
+			return nil
+		}
+	}
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterHook("stop", record("hook1"))
+			r.RegisterStop(record("stop1"))
+			r.Clone("sub").RegisterStop(record("stop2"))
+			r.RegisterHook("stop", record("hook2"))
+		},
+	}
+	err := runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(called, jc.DeepEquals, []string{"stop2", "stop1", "hook1", "hook2"})
+}
+
+func (*registrySuite) TestRegisterCleanupRegistersRemoveHook(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterCleanup(func(*hook.Context) error {
+		return nil
+	})
+	c.Assert(r.RegisteredHooks(), jc.DeepEquals, []string{"remove"})
+}
+
+func (*registrySuite) TestRegisterCleanup(c *gc.C) {
+	var called []string
+	var logger recordingLogger
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       &logger,
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterCleanup(func(ctxt *hook.Context) error {
+				called = append(called, "cleanup1 "+ctxt.HookName)
+				return nil
+			})
+			r.Clone("sub").RegisterCleanup(func(ctxt *hook.Context) error {
+				called = append(called, "cleanup2 "+ctxt.HookName)
+				return errgo.New("cannot delete bucket")
+			})
+			r.RegisterHook("stop", func() error {
+				called = append(called, "stop")
+				return nil
+			})
+		},
+	}
+	// Cleanups do not run when the unit is stopped.
+	err := runner.RunHook("stop", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(called, jc.DeepEquals, []string{"stop"})
+
+	// The failing cleanup does not stop the other
+	// cleanup from running or the hook from succeeding.
+	called = nil
+	logger.msgs = nil
+	err = runner.RunHook("remove", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(called, jc.DeepEquals, []string{"cleanup2 remove", "cleanup1 remove"})
+	c.Assert(logger.msgs, jc.DeepEquals, []string{
+		"running hook remove {",
+		"ERROR: cleanup for root.sub failed: cannot delete bucket",
+		"} remove",
+	})
+}
+
+func (*registrySuite) TestUnregisteredRequiredHook(c *gc.C) {
+	// Make a registry without calling RegisterMainHooks,
+	// so that the install and start hooks are not registered.
+	var called []string
+	r := hook.NewRegistry()
+	r.RegisterHook("config-changed", func() error {
+		called = append(called, "config-changed")
+		return nil
+	})
+	r.RegisterHook("*", func() error {
+		called = append(called, "*")
+		return nil
+	})
+	for _, name := range []string{"install", "start"} {
+		ctxt := &hook.Context{
+			HookName: name,
+			Runner:   &hooktest.Runner{Logger: c},
+		}
+		_, err := hook.Main(r, ctxt, make(hooktest.MemState))
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(called, jc.DeepEquals, []string{"*", "*"})
+
+	ctxt := &hook.Context{
+		HookName: "stop",
+		Runner:   &hooktest.Runner{Logger: c},
+	}
+	_, err := hook.Main(r, ctxt, make(hooktest.MemState))
+	c.Assert(err, gc.ErrorMatches, `(?s)usage: runhook .*`)
+}
+
+func (*registrySuite) TestRegisterStopOnly(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterStop(func() error { return nil })
+	c.Assert(r.RegisteredHooks(), jc.DeepEquals, []string{"stop"})
+}
+
+func (*registrySuite) TestRegisterRequiredConfigWithDefault(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(func() {
+		r.RegisterRequiredConfig("password", charm.Option{
+			Type:    "string",
+			Default: "secret",
+		})
+	}, gc.PanicMatches, `required configuration option "password" has a default value`)
+}
+
+var checkRequiredConfigTests = []struct {
+	about         string
+	config        map[string]interface{}
+	expectMissing []string
+}{{
+	about: "all set",
+	config: map[string]interface{}{
+		"password": "secret",
+		"port":     8080,
+	},
+}, {
+	about: "some missing",
+	config: map[string]interface{}{
+		"password": "",
+		"hostname": "example.com",
+	},
+	expectMissing: []string{"password", "port"},
+}}
+
+func (*registrySuite) TestCheckRequiredConfig(c *gc.C) {
+	for i, test := range checkRequiredConfigTests {
+		c.Logf("test %d: %s", i, test.about)
+		var missing []string
+		runner := &hooktest.Runner{
+			HookStateDir: c.MkDir(),
+			Logger:       c,
+			Config:       test.config,
+			RegisterHooks: func(r *hook.Registry) {
+				var ctxt *hook.Context
+				r.RegisterContext(func(c *hook.Context) error {
+					ctxt = c
+					return nil
+				}, nil)
+				r.RegisterRequiredConfig("password", charm.Option{Type: "string"})
+				r.Clone("sub").RegisterRequiredConfig("port", charm.Option{Type: "int"})
+				r.RegisterConfig("hostname", charm.Option{Type: "string"})
+				r.RegisterHook("config-changed", func() error {
+					var err error
+					missing, err = ctxt.CheckRequiredConfig()
+					return err
+				})
+			},
+		}
+		err := runner.RunHook("config-changed", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(missing, jc.DeepEquals, test.expectMissing)
+	}
+}
+
+var configValidatorTests = []struct {
+	about        string
+	config       map[string]interface{}
+	expectCalled []string
+	expectRecord [][]string
+}{{
+	about: "valid configuration",
+	config: map[string]interface{}{
+		"port": 8080,
+	},
+	expectCalled: []string{"validate1", "validate2", "config-changed", "*"},
+}, {
+	about: "invalid configuration",
+	config: map[string]interface{}{
+		"port": 0,
+	},
+	expectCalled: []string{"validate1"},
+	expectRecord: [][]string{{"status-set", "blocked", "port must be set"}},
+}}
+
+func (*registrySuite) TestRegisterConfigValidator(c *gc.C) {
+	for i, test := range configValidatorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var called []string
+		runner := &hooktest.Runner{
+			HookStateDir: c.MkDir(),
+			Logger:       c,
+			Config:       test.config,
+			RegisterHooks: func(r *hook.Registry) {
+				r.RegisterConfig("port", charm.Option{Type: "int"})
+				r.RegisterHook("config-changed", func() error {
+					called = append(called, "config-changed")
+					return nil
+				})
+				r.RegisterHook("*", func() error {
+					called = append(called, "*")
+					return nil
+				})
+				r.RegisterConfigValidator(func(cfg map[string]interface{}) error {
+					called = append(called, "validate1")
+					if cfg["port"] == 0.0 {
+						return errgo.New("port must be set")
+					}
+					return nil
+				})
+				r.Clone("sub").RegisterConfigValidator(func(cfg map[string]interface{}) error {
+					called = append(called, "validate2")
+					return nil
+				})
+			},
+		}
+		err := runner.RunHook("config-changed", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(called, jc.DeepEquals, test.expectCalled)
+		c.Assert(runner.Record, jc.DeepEquals, test.expectRecord)
+	}
+}
+
+func (*registrySuite) TestRegisterConfigValidatorOnly(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterConfigValidator(func(cfg map[string]interface{}) error {
+		return nil
+	})
+	c.Assert(r.RegisteredHooks(), jc.DeepEquals, []string{"config-changed"})
+}
+
+var configPatternTests = []struct {
+	about        string
+	config       map[string]interface{}
+	expectCalled bool
+	expectRecord [][]string
+}{{
+	about: "matching value",
+	config: map[string]interface{}{
+		"hostname": "db.example.com",
+	},
+	expectCalled: true,
+}, {
+	about: "unset value",
+	config: map[string]interface{}{
+		"hostname": "",
+	},
+	expectCalled: true,
+}, {
+	about: "non-matching value",
+	config: map[string]interface{}{
+		"hostname": "db.example.com; rm -rf /",
+	},
+	expectRecord: [][]string{{"status-set", "blocked", `invalid value "db.example.com; rm -rf /" for configuration option "hostname": does not match "[a-z0-9.-]+"`}},
+}}
+
+func (*registrySuite) TestRegisterConfigPattern(c *gc.C) {
+	for i, test := range configPatternTests {
+		c.Logf("test %d: %s", i, test.about)
+		called := false
+		runner := &hooktest.Runner{
+			HookStateDir: c.MkDir(),
+			Logger:       c,
+			Config:       test.config,
+			RegisterHooks: func(r *hook.Registry) {
+				r.RegisterConfigPattern("hostname", charm.Option{
+					Type:        "string",
+					Description: "The database host.",
+				}, "[a-z0-9.-]+")
+				r.RegisterHook("config-changed", func() error {
+					called = true
+					return nil
+				})
+			},
+		}
+		err := runner.RunHook("config-changed", "", "")
+		c.Assert(err, gc.IsNil)
+		c.Assert(called, gc.Equals, test.expectCalled)
+		c.Assert(runner.Record, jc.DeepEquals, test.expectRecord)
+	}
+}
+
+func (*registrySuite) TestRegisterConfigPatternDescription(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterConfigPattern("version", charm.Option{
+		Type:        "string",
+		Description: "The version to install.",
+		Default:     "1.2",
+	}, `[0-9]+\.[0-9]+`)
+	// Registering the same option again is allowed.
+	r.RegisterConfigPattern("version", charm.Option{
+		Type:        "string",
+		Description: "The version to install.",
+		Default:     "1.2",
+	}, `[0-9]+\.[0-9]+`)
+	c.Assert(r.RegisteredConfig(), jc.DeepEquals, map[string]charm.Option{
+		"version": {
+			Type:        "string",
+			Description: `The version to install. Must match the regular expression: [0-9]+\.[0-9]+`,
+			Default:     "1.2",
+		},
+	})
+	c.Assert(r.RegisteredHooks(), jc.DeepEquals, []string{"config-changed"})
+}
+
+func (*registrySuite) TestRegisterConfigPatternErrors(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(func() {
+		r.RegisterConfigPattern("hostname", charm.Option{Type: "string"}, "[a-z")
+	}, gc.PanicMatches, `invalid pattern for configuration option "hostname": error parsing regexp: .*`)
+	c.Assert(func() {
+		r.RegisterConfigPattern("port", charm.Option{Type: "int"}, "[0-9]+")
+	}, gc.PanicMatches, `configuration option "port" with a pattern has type "int", not string`)
+	c.Assert(func() {
+		r.RegisterConfigPattern("version", charm.Option{
+			Type:    "string",
+			Default: "latest",
+		}, `[0-9.]+`)
+	}, gc.PanicMatches, `default value "latest" of configuration option "version" does not match pattern "\[0-9.\]\+"`)
+	r.RegisterConfigPattern("name", charm.Option{Type: "string"}, "[a-z]+")
+	c.Assert(func() {
+		r.RegisterConfigPattern("name", charm.Option{Type: "string"}, "[A-Z]+")
+	}, gc.PanicMatches, `configuration option "name" is already registered with pattern "\[a-z\]\+"`)
+}
+
+func (*registrySuite) TestRegisterSubordinate(c *gc.C) {
+	r := hook.NewRegistry()
+	c.Assert(r.IsSubordinate(), jc.IsFalse)
+	c.Assert(r.Validate(), gc.IsNil)
+
+	r.Clone("sub").RegisterSubordinate()
+	c.Assert(r.IsSubordinate(), jc.IsTrue)
+	c.Assert(r.Validate(), gc.ErrorMatches, "subordinate charm has no requirer relation with container scope")
+
+	// A globally scoped requirer relation is not sufficient.
+	r.RegisterRelation(charm.Relation{
+		Name:      "db",
+		Role:      charm.RoleRequirer,
+		Interface: "mysql",
+	})
+	c.Assert(r.Validate(), gc.ErrorMatches, "subordinate charm has no requirer relation with container scope")
+
+	r.RegisterRelation(charm.Relation{
+		Name:      "juju-info",
+		Role:      charm.RoleRequirer,
+		Interface: "juju-info",
+		Scope:     charm.ScopeContainer,
+	})
+	c.Assert(r.Validate(), gc.IsNil)
+}
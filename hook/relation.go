@@ -0,0 +1,279 @@
+package hook
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// GetAppRelationStruct reads the application data bag of the remote
+// application in the relation with the given id into the struct
+// pointed to by v.
+//
+// Each exported field of the struct with a "relation" tag is set from
+// the setting with the name given in the tag. Fields with no tag, or
+// with the tag "-", are ignored, as are settings with no corresponding
+// field. Fields with no corresponding setting are left unchanged.
+// String, boolean and numeric fields are parsed from the setting
+// value directly; fields of any other type are expected to be
+// encoded as JSON.
+func (ctxt *Context) GetAppRelationStruct(relationId RelationId, v interface{}) error {
+	app, err := ctxt.RemoteApplication(relationId)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var settings map[string]string
+	if err := ctxt.runJSON(&settings, "relation-get", "-r", string(relationId), "--app", "--format", "json", "--", "-", app); err != nil {
+		return errgo.Notef(err, "cannot get application settings for relation %s", relationId)
+	}
+	if err := unmarshalRelationStruct(settings, v); err != nil {
+		return errgo.Notef(err, "cannot unmarshal application settings for relation %s", relationId)
+	}
+	return nil
+}
+
+// SetAppRelationStruct sets the local application's data bag in the
+// relation with the given id from the fields of the struct pointed to
+// by v, which are interpreted as for GetAppRelationStruct. Note that
+// Juju only allows the leader unit to set application settings.
+func (ctxt *Context) SetAppRelationStruct(relationId RelationId, v interface{}) error {
+	settings, err := marshalRelationStruct(v)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal application settings")
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := []string{"-r", string(relationId), "--app", "--"}
+	for _, key := range keys {
+		args = append(args, key+"="+settings[key])
+	}
+	if _, err := ctxt.Runner.Run("relation-set", args...); err != nil {
+		return errgo.Notef(err, "cannot set application settings for relation %s", relationId)
+	}
+	return nil
+}
+
+// RemoteApplication returns the name of the remote application
+// in the relation with the given id. For the relation of the current
+// hook, this is taken from the RemoteApp or RemoteUnit fields;
+// otherwise it is derived from any unit known to be in the relation.
+func (ctxt *Context) RemoteApplication(relationId RelationId) (string, error) {
+	if relationId == ctxt.RelationId {
+		if ctxt.RemoteApp != "" {
+			return ctxt.RemoteApp, nil
+		}
+		if ctxt.RemoteUnit != "" {
+			return applicationName(ctxt.RemoteUnit), nil
+		}
+	}
+	units := make([]string, 0, len(ctxt.Relations[relationId]))
+	for unit := range ctxt.Relations[relationId] {
+		units = append(units, string(unit))
+	}
+	if len(units) == 0 {
+		return "", errgo.Newf("no remote units found in relation %s", relationId)
+	}
+	sort.Strings(units)
+	return applicationName(UnitId(units[0])), nil
+}
+
+// RelationInterface returns the interface of the relation with the
+// given id, as declared when the relation was registered with
+// Registry.RegisterRelation. This makes it possible for a hook
+// registered for several relations to find out which kind of
+// relation it is running for.
+func (ctxt *Context) RelationInterface(relationId RelationId) (string, error) {
+	name, err := ctxt.relationName(relationId)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	rel, ok := ctxt.relations[name]
+	if !ok {
+		return "", errgo.Newf("relation %q not registered", name)
+	}
+	return rel.Interface, nil
+}
+
+// relationName returns the name of the relation with the given id.
+// The name is taken from RelationIds if the id is found there;
+// otherwise it is derived from the id itself, which Juju formats
+// as the relation name followed by a colon and a number.
+func (ctxt *Context) relationName(relationId RelationId) (string, error) {
+	if relationId == ctxt.RelationId && ctxt.RelationName != "" {
+		return ctxt.RelationName, nil
+	}
+	for name, ids := range ctxt.RelationIds {
+		for _, id := range ids {
+			if id == relationId {
+				return name, nil
+			}
+		}
+	}
+	i := strings.LastIndex(string(relationId), ":")
+	if i <= 0 {
+		return "", errgo.Newf("invalid relation id %q", relationId)
+	}
+	if _, err := strconv.Atoi(string(relationId[i+1:])); err != nil {
+		return "", errgo.Newf("invalid relation id %q", relationId)
+	}
+	return string(relationId[:i]), nil
+}
+
+// applicationName returns the name of the application
+// that the unit with the given id belongs to.
+func applicationName(unit UnitId) string {
+	return strings.SplitN(string(unit), "/", 2)[0]
+}
+
+// relationStructFields calls f for each field of the struct
+// pointed to by v that has a relation tag.
+func relationStructFields(v interface{}, f func(key string, fv reflect.Value) error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errgo.Newf("expected pointer to struct, got %T", v)
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("relation")
+		if field.PkgPath != "" || key == "" || key == "-" {
+			continue
+		}
+		if err := f(key, rv.Field(i)); err != nil {
+			return errgo.Notef(err, "field %s", field.Name)
+		}
+	}
+	return nil
+}
+
+func unmarshalRelationStruct(settings map[string]string, v interface{}) error {
+	return relationStructFields(v, func(key string, fv reflect.Value) error {
+		val, ok := settings[key]
+		if !ok {
+			return nil
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(val)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			fv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			fv.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			n, err := strconv.ParseFloat(val, fv.Type().Bits())
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			fv.SetFloat(n)
+		default:
+			if err := json.Unmarshal([]byte(val), fv.Addr().Interface()); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	})
+}
+
+func marshalRelationStruct(v interface{}) (map[string]string, error) {
+	settings := make(map[string]string)
+	err := relationStructFields(v, func(key string, fv reflect.Value) error {
+		switch fv.Kind() {
+		case reflect.String:
+			settings[key] = fv.String()
+		case reflect.Bool:
+			settings[key] = strconv.FormatBool(fv.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			settings[key] = strconv.FormatInt(fv.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			settings[key] = strconv.FormatUint(fv.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			settings[key] = strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits())
+		default:
+			data, err := json.Marshal(fv.Interface())
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			settings[key] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return settings, nil
+}
+
+// MergeRelationJSON updates the JSON object stored in the given key of
+// the unit's settings for the relation with the given id by deep-merging
+// patch into it: values in patch that are themselves objects are merged
+// recursively into the existing objects, a nil value removes the
+// key, and other values replace the current ones. If the key is not
+// currently set or is empty, the result is the patch itself (without
+// any nil values).
+func (ctxt *Context) MergeRelationJSON(relationId RelationId, key string, patch map[string]interface{}) error {
+	settings, err := ctxt.localRelationSettings(relationId)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	current := make(map[string]interface{})
+	if data := settings[key]; data != "" {
+		if err := json.Unmarshal([]byte(data), &current); err != nil {
+			return errgo.Notef(err, "cannot unmarshal current value of %q", key)
+		}
+	}
+	mergeJSON(current, patch)
+	data, err := json.Marshal(current)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := ctxt.SetRelationWithId(relationId, key, string(data)); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// mergeJSON deep-merges patch into dst.
+func mergeJSON(dst, patch map[string]interface{}) {
+	for key, val := range patch {
+		if val == nil {
+			delete(dst, key)
+			continue
+		}
+		patchObj, ok := val.(map[string]interface{})
+		if !ok {
+			dst[key] = val
+			continue
+		}
+		dstObj, ok := dst[key].(map[string]interface{})
+		if !ok {
+			dstObj = make(map[string]interface{})
+			dst[key] = dstObj
+		}
+		mergeJSON(dstObj, patchObj)
+	}
+}
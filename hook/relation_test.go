@@ -0,0 +1,492 @@
+package hook_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type relationSuite struct{}
+
+var _ = gc.Suite(&relationSuite{})
+
+type appSettings struct {
+	Host     string            `relation:"host"`
+	Port     int               `relation:"port"`
+	TLS      bool              `relation:"tls"`
+	Weight   float64           `relation:"weight"`
+	Labels   map[string]string `relation:"labels"`
+	Ignored  string
+	Excluded string `relation:"-"`
+}
+
+func (*relationSuite) TestAppRelationStructRoundTrip(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"db:0": {
+				"postgresql/1": {},
+			},
+		},
+	}
+	ctxt := &hook.Context{
+		Runner:    runner,
+		Relations: runner.Relations,
+	}
+	set := appSettings{
+		Host:     "10.0.0.1",
+		Port:     5432,
+		TLS:      true,
+		Weight:   0.5,
+		Labels:   map[string]string{"a": "b"},
+		Ignored:  "ignored",
+		Excluded: "excluded",
+	}
+	err := ctxt.SetAppRelationStruct("db:0", &set)
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{{
+		"relation-set", "-r", "db:0", "--app", "--",
+		"host=10.0.0.1",
+		`labels={"a":"b"}`,
+		"port=5432",
+		"tls=true",
+		"weight=0.5",
+	}})
+
+	// Put the settings that were set into the fake
+	// application data bag and read them back.
+	bag := make(map[string]string)
+	for _, kv := range runner.Record[0][5:] {
+		f := strings.SplitN(kv, "=", 2)
+		bag[f[0]] = f[1]
+	}
+	runner.AppRelations = map[hook.RelationId]map[string]string{
+		"db:0": bag,
+	}
+	runner.Record = nil
+	var got appSettings
+	err = ctxt.GetAppRelationStruct("db:0", &got)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, jc.DeepEquals, appSettings{
+		Host:   "10.0.0.1",
+		Port:   5432,
+		TLS:    true,
+		Weight: 0.5,
+		Labels: map[string]string{"a": "b"},
+	})
+}
+
+func (*relationSuite) TestGetAppRelationStructInvalidValue(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		AppRelations: map[hook.RelationId]map[string]string{
+			"db:0": {"port": "notanumber"},
+		},
+	}
+	ctxt := &hook.Context{
+		Runner:     runner,
+		RelationId: "db:0",
+		RemoteUnit: "postgresql/0",
+	}
+	var got appSettings
+	err := ctxt.GetAppRelationStruct("db:0", &got)
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal application settings for relation db:0: field Port: strconv.ParseInt: parsing "notanumber": invalid syntax`)
+}
+
+func (*relationSuite) TestGetAppRelationStructNoUnits(c *gc.C) {
+	ctxt := &hook.Context{
+		Runner: &hooktest.Runner{Logger: c},
+	}
+	var got appSettings
+	err := ctxt.GetAppRelationStruct("db:0", &got)
+	c.Assert(err, gc.ErrorMatches, `no remote units found in relation db:0`)
+}
+
+// localRelationGet holds the relation-get call that reads
+// the local unit's settings for relation db:0.
+var localRelationGet = []string{"relation-get", "-r", "db:0", "--format", "json", "--", "-", "someunit/0"}
+
+func (*relationSuite) TestSetRelationOnlyWritesChanges(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		LocalRelations: map[hook.RelationId]map[string]string{
+			"db:0": {
+				"host": "10.0.0.1",
+				"port": "5432",
+			},
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+
+	// Identical values, including the deletion of a key
+	// that is not set, cause no relation-set calls.
+	err := ctxt.SetRelationWithId("db:0", "host", "10.0.0.1", "port", "5432", "user", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{localRelationGet})
+
+	// Only the changed values are set, and the current
+	// settings are not read again.
+	err = ctxt.SetRelationWithId("db:0", "host", "10.0.0.1", "port", "5433", "user", "admin")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetRelationWithId("db:0", "host", "", "port", "5433")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		localRelationGet,
+		{"relation-set", "-r", "db:0", "--", "port=5433", "user=admin"},
+		{"relation-set", "-r", "db:0", "--", "host="},
+	})
+	c.Assert(runner.LocalRelations["db:0"], jc.DeepEquals, map[string]string{
+		"port": "5433",
+		"user": "admin",
+	})
+}
+
+func (*relationSuite) TestMergeRelationJSONOverExisting(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		LocalRelations: map[hook.RelationId]map[string]string{
+			"db:0": {
+				"config": `{"host":"10.0.0.1","options":{"tls":true,"pool":5},"old":"x"}`,
+			},
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+	err := ctxt.MergeRelationJSON("db:0", "config", map[string]interface{}{
+		"options": map[string]interface{}{
+			"pool":    10,
+			"timeout": "30s",
+		},
+		"old":  nil,
+		"port": 5432,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, gc.HasLen, 2)
+	c.Assert(runner.Record[0], jc.DeepEquals, localRelationGet)
+	c.Assert(runner.LocalRelations["db:0"]["config"], jc.JSONEquals, map[string]interface{}{
+		"host": "10.0.0.1",
+		"port": 5432,
+		"options": map[string]interface{}{
+			"tls":     true,
+			"pool":    10,
+			"timeout": "30s",
+		},
+	})
+}
+
+func (*relationSuite) TestMergeRelationJSONOverEmpty(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+	err := ctxt.MergeRelationJSON("db:0", "config", map[string]interface{}{
+		"host":    "10.0.0.1",
+		"removed": nil,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		localRelationGet,
+		{"relation-set", "-r", "db:0", "--", `config={"host":"10.0.0.1"}`},
+	})
+}
+
+func (*relationSuite) TestMergeRelationJSONInvalidCurrent(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		LocalRelations: map[hook.RelationId]map[string]string{
+			"db:0": {"config": "not json"},
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+	err := ctxt.MergeRelationJSON("db:0", "config", map[string]interface{}{"a": 1})
+	c.Assert(err, gc.ErrorMatches, `cannot unmarshal current value of "config": .*`)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{localRelationGet})
+}
+
+func (*relationSuite) TestSetRelationData(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		LocalRelations: map[hook.RelationId]map[string]string{
+			"db:0": {"user": "admin"},
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+	err := ctxt.SetRelationData("db:0", map[string]string{
+		"port":     "5432",
+		"host":     "10.0.0.1",
+		"user":     "admin",
+		"password": "secret",
+		"database": "",
+	})
+	c.Assert(err, gc.IsNil)
+	// All the changed settings are set with a single relation-set.
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		localRelationGet,
+		{"relation-set", "-r", "db:0", "--", "host=10.0.0.1", "password=secret", "port=5432"},
+	})
+
+	// Setting no data does nothing.
+	err = ctxt.SetRelationData("db:0", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, gc.HasLen, 2)
+}
+
+func (*relationSuite) TestSetRelationCachesSettingsFromRunFunc(c *gc.C) {
+	setErr := errors.New("relation-set failed")
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			switch cmd {
+			case "relation-get":
+				return []byte(`{"host":"10.0.0.1"}`), nil
+			case "relation-set":
+				return nil, setErr
+			}
+			return nil, nil
+		},
+	}
+	ctxt := &hook.Context{
+		Unit:   "someunit/0",
+		Runner: runner,
+	}
+	err := ctxt.SetRelationWithId("db:0", "host", "10.0.0.2")
+	c.Assert(err, gc.ErrorMatches, "relation-set failed")
+
+	// The failed relation-set does not change the cached
+	// settings, so the value is set again.
+	setErr = nil
+	err = ctxt.SetRelationWithId("db:0", "host", "10.0.0.2")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetRelationWithId("db:0", "host", "10.0.0.2")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		localRelationGet,
+		{"relation-set", "-r", "db:0", "--", "host=10.0.0.2"},
+		{"relation-set", "-r", "db:0", "--", "host=10.0.0.2"},
+	})
+}
+
+var remoteApplicationTests = []struct {
+	about       string
+	ctxt        hook.Context
+	relationId  hook.RelationId
+	expect      string
+	expectError string
+}{{
+	about: "current relation with remote app",
+	ctxt: hook.Context{
+		RelationId: "db:0",
+		RemoteApp:  "postgresql",
+		RemoteUnit: "other/1",
+	},
+	relationId: "db:0",
+	expect:     "postgresql",
+}, {
+	about: "current relation with remote unit only",
+	ctxt: hook.Context{
+		RelationId: "db:0",
+		RemoteUnit: "postgresql/1",
+	},
+	relationId: "db:0",
+	expect:     "postgresql",
+}, {
+	about: "explicit relation id",
+	ctxt: hook.Context{
+		RelationId: "db:0",
+		RemoteApp:  "postgresql",
+		Relations: map[hook.RelationId]map[hook.UnitId]map[string]string{
+			"cache:2": {
+				"redis/3": {},
+				"redis/1": {},
+			},
+		},
+	},
+	relationId: "cache:2",
+	expect:     "redis",
+}, {
+	about: "explicit relation id with no units",
+	ctxt: hook.Context{
+		RelationId: "db:0",
+		RemoteApp:  "postgresql",
+	},
+	relationId:  "cache:2",
+	expectError: `no remote units found in relation cache:2`,
+}}
+
+func (*relationSuite) TestRemoteApplication(c *gc.C) {
+	for i, test := range remoteApplicationTests {
+		c.Logf("test %d: %s", i, test.about)
+		ctxt := test.ctxt
+		ctxt.Runner = &hooktest.Runner{Logger: c}
+		app, err := ctxt.RemoteApplication(test.relationId)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(app, gc.Equals, test.expect)
+	}
+}
+
+var relationBrokenEnv = map[string]string{
+	"JUJU_MODEL_UUID":  hooktest.UUID,
+	"JUJU_UNIT_NAME":   "someunit/0",
+	"CHARM_DIR":        "/dev/null",
+	"JUJU_CONTEXT_ID":  "someunit/0-db-relation-broken-1",
+	"JUJU_RELATION":    "db",
+	"JUJU_RELATION_ID": "db:4",
+	"JUJU_REMOTE_UNIT": "",
+	"JUJU_REMOTE_APP":  "postgresql",
+}
+
+func (*relationSuite) TestRemoteApplicationFromEnvironment(c *gc.C) {
+	for name, val := range relationBrokenEnv {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, val)
+	}
+	ctxt, _, err := hook.NewContextFromEnvironment(hook.NewRegistry(), c.MkDir(), "db-relation-broken", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ctxt.RemoteApp, gc.Equals, "postgresql")
+	app, err := ctxt.RemoteApplication("db:4")
+	c.Assert(err, gc.IsNil)
+	c.Assert(app, gc.Equals, "postgresql")
+}
+
+var relationInterfaceTests = []struct {
+	relationId      hook.RelationId
+	expectInterface string
+	expectError     string
+}{{
+	relationId:      "db:0",
+	expectInterface: "pgsql",
+}, {
+	relationId:      "website:3",
+	expectInterface: "http",
+}, {
+	// The name is derived from the id when
+	// it is not one of the known ids.
+	relationId:      "db:7",
+	expectInterface: "pgsql",
+}, {
+	relationId:  "cache:1",
+	expectError: `relation "cache" not registered`,
+}, {
+	relationId:  "db",
+	expectError: `invalid relation id "db"`,
+}, {
+	relationId:  "db:x",
+	expectError: `invalid relation id "db:x"`,
+}}
+
+func (*relationSuite) TestRelationInterface(c *gc.C) {
+	var ctxt *hook.Context
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"db":      {"db:0"},
+			"website": {"website:3"},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			r.RegisterRelation(charm.Relation{
+				Name:      "db",
+				Role:      charm.RoleRequirer,
+				Interface: "pgsql",
+			})
+			r.Clone("web").RegisterRelation(charm.Relation{
+				Name:      "website",
+				Role:      charm.RoleProvider,
+				Interface: "http",
+			})
+			r.RegisterHook("website-relation-joined", func() error {
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("website-relation-joined", "website:3", "client/0")
+	c.Assert(err, gc.IsNil)
+	for i, test := range relationInterfaceTests {
+		c.Logf("test %d: %s", i, test.relationId)
+		iface, err := ctxt.RelationInterface(test.relationId)
+		if test.expectError != "" {
+			c.Check(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Check(err, gc.IsNil)
+		c.Check(iface, gc.Equals, test.expectInterface)
+	}
+}
+
+func (*relationSuite) TestSetRelationDataAll(c *gc.C) {
+	var ctxt *hook.Context
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"website": {"website:1", "website:4"},
+		},
+		LocalRelations: map[hook.RelationId]map[string]string{
+			"website:1": {"port": "80"},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			r.RegisterRelation(charm.Relation{
+				Name:      "website",
+				Role:      charm.RoleProvider,
+				Interface: "http",
+			})
+			r.RegisterHook("config-changed", func() error {
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	runner.Record = nil
+	err = ctxt.SetRelationDataAll("website", map[string]string{
+		"hostname": "10.0.0.1",
+		"port":     "80",
+	})
+	c.Assert(err, gc.IsNil)
+	var sets [][]string
+	for _, cmd := range runner.Record {
+		if cmd[0] == "relation-set" {
+			sets = append(sets, cmd)
+		}
+	}
+	c.Assert(sets, jc.DeepEquals, [][]string{
+		{"relation-set", "-r", "website:1", "--", "hostname=10.0.0.1"},
+		{"relation-set", "-r", "website:4", "--", "hostname=10.0.0.1", "port=80"},
+	})
+
+	err = ctxt.SetRelationDataAll("db", map[string]string{"port": "80"})
+	c.Assert(err, gc.ErrorMatches, `relation "db" not registered`)
+}
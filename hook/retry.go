@@ -0,0 +1,106 @@
+package hook
+
+import (
+	"encoding/json"
+
+	"gopkg.in/errgo.v1"
+)
+
+// attemptsStateName holds the name of the persistent state that
+// records how many times each hook has been attempted without
+// succeeding. Registry names always start with "root", so it
+// cannot clash with the state of any registry.
+const attemptsStateName = "hook-attempts"
+
+// IsRetry reports whether the current hook is being retried by Juju
+// after it failed the last time it was run. Handlers can use this to
+// behave differently on retry, for example by cleaning up after the
+// failed attempt before trying again.
+//
+// The attempts are counted in persistent state keyed by hook name
+// and, for relation hooks, by relation id and remote unit, so that
+// the same hook run for a different relation or unit is a different
+// event: the count is incremented each time a hook starts and cleared
+// when it succeeds.
+//
+// The attempts are only counted when Registry.RegisterRetries has been
+// called; otherwise, or if the attempt could not be recorded, IsRetry
+// returns an error.
+func (ctxt *Context) IsRetry() (bool, error) {
+	if ctxt.attempt == 0 {
+		return false, errgo.New("hook attempt not known")
+	}
+	return ctxt.attempt > 1, nil
+}
+
+// attemptKey returns the key that attempts to run the
+// current hook are counted under.
+func (ctxt *Context) attemptKey() string {
+	key := ctxt.HookName
+	if ctxt.RelationId != "" {
+		key += " " + string(ctxt.RelationId)
+	}
+	if ctxt.RemoteUnit != "" {
+		key += " " + string(ctxt.RemoteUnit)
+	}
+	return key
+}
+
+// startAttempt records that an attempt to run the hook with the
+// given attempt key has started, and returns the number of attempts
+// so far, including this one.
+func startAttempt(state PersistentState, key string) (int, error) {
+	attempts, err := loadAttempts(state)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	attempts[key]++
+	if err := saveAttempts(state, attempts); err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return attempts[key], nil
+}
+
+// endAttempt records that the hook with the given attempt
+// key has succeeded, so that it will not be treated as a
+// retry when it is next run.
+func endAttempt(state PersistentState, key string) error {
+	attempts, err := loadAttempts(state)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if _, ok := attempts[key]; !ok {
+		return nil
+	}
+	delete(attempts, key)
+	return errgo.Mask(saveAttempts(state, attempts))
+}
+
+func loadAttempts(state PersistentState) (map[string]int, error) {
+	attempts := make(map[string]int)
+	data, err := state.Load(attemptsStateName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot load hook attempts")
+	}
+	if data == nil {
+		return attempts, nil
+	}
+	if err := json.Unmarshal(data, &attempts); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal hook attempts")
+	}
+	if attempts == nil {
+		attempts = make(map[string]int)
+	}
+	return attempts, nil
+}
+
+func saveAttempts(state PersistentState, attempts map[string]int) error {
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal hook attempts")
+	}
+	if err := state.Save(attemptsStateName, data); err != nil {
+		return errgo.Notef(err, "cannot save hook attempts")
+	}
+	return nil
+}
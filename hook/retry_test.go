@@ -0,0 +1,144 @@
+package hook_test
+
+import (
+	"github.com/juju/charm/v9"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type retrySuite struct{}
+
+var _ = gc.Suite(&retrySuite{})
+
+var retryTests = []struct {
+	hookName      string
+	relId         hook.RelationId
+	relUnit       hook.UnitId
+	fail          bool
+	expectIsRetry bool
+}{{
+	hookName: "install",
+	fail:     true,
+}, {
+	hookName:      "install",
+	fail:          true,
+	expectIsRetry: true,
+}, {
+	hookName:      "install",
+	expectIsRetry: true,
+}, {
+	// Once a hook has succeeded, it is no longer a retry.
+	hookName: "install",
+}, {
+	hookName: "config-changed",
+	fail:     true,
+}, {
+	// Attempts are counted separately for each hook.
+	hookName: "start",
+}, {
+	hookName:      "config-changed",
+	expectIsRetry: true,
+}, {
+	hookName: "config-changed",
+}, {
+	hookName: "db-relation-changed",
+	relId:    "db:1",
+	relUnit:  "mysql/0",
+	fail:     true,
+}, {
+	// Attempts are counted separately for each relation.
+	hookName: "db-relation-changed",
+	relId:    "db:2",
+	relUnit:  "mysql/0",
+}, {
+	// and for each remote unit.
+	hookName: "db-relation-changed",
+	relId:    "db:1",
+	relUnit:  "mysql/1",
+}, {
+	hookName:      "db-relation-changed",
+	relId:         "db:1",
+	relUnit:       "mysql/0",
+	expectIsRetry: true,
+}}
+
+func (*retrySuite) TestIsRetry(c *gc.C) {
+	var fail bool
+	var isRetry []bool
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RelationIds: map[string][]hook.RelationId{
+			"db": {"db:1", "db:2"},
+		},
+		RegisterHooks: func(r *hook.Registry) {
+			r.RegisterRetries()
+			var ctxt *hook.Context
+			r.RegisterContext(func(hctxt *hook.Context) error {
+				ctxt = hctxt
+				return nil
+			}, nil)
+			r.RegisterRelation(charm.Relation{
+				Name:      "db",
+				Role:      charm.RoleRequirer,
+				Interface: "mysql",
+			})
+			for _, name := range []string{"install", "start", "config-changed", "db-relation-changed"} {
+				r.RegisterHook(name, func() error {
+					retry, err := ctxt.IsRetry()
+					c.Check(err, gc.IsNil)
+					isRetry = append(isRetry, retry)
+					if fail {
+						return errgo.New("hook failed")
+					}
+					return nil
+				})
+			}
+		},
+	}
+	var expect []bool
+	for i, test := range retryTests {
+		c.Logf("test %d: %s %s %s (fail %v)", i, test.hookName, test.relId, test.relUnit, test.fail)
+		fail = test.fail
+		err := runner.RunHook(test.hookName, test.relId, test.relUnit)
+		if test.fail {
+			c.Assert(err, gc.ErrorMatches, "hook failed")
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+		expect = append(expect, test.expectIsRetry)
+		c.Assert(isRetry, jc.DeepEquals, expect)
+	}
+}
+
+func (*retrySuite) TestIsRetryNotRegistered(c *gc.C) {
+	var retryErr error
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var ctxt *hook.Context
+			r.RegisterContext(func(hctxt *hook.Context) error {
+				ctxt = hctxt
+				return nil
+			}, nil)
+			r.RegisterHook("install", func() error {
+				_, retryErr = ctxt.IsRetry()
+				return nil
+			})
+		},
+	}
+	err := runner.RunHook("install", "", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(retryErr, gc.ErrorMatches, "hook attempt not known")
+}
+
+func (*retrySuite) TestIsRetryOutsideHook(c *gc.C) {
+	ctxt := &hook.Context{}
+	_, err := ctxt.IsRetry()
+	c.Assert(err, gc.ErrorMatches, "hook attempt not known")
+}
@@ -50,6 +50,10 @@ func (execToolRunner) Run(cmd string, args ...string) ([]byte, error) {
 			}
 			return nil, errgo.New(errText)
 		}
+		if execErr, ok := err.(*osexec.Error); ok && execErr.Err == osexec.ErrNotFound {
+			// Older versions of Juju do not provide the tool at all.
+			return nil, errgo.WithCausef(err, ErrUnimplemented, "%s", cmd)
+		}
 		return nil, err
 	}
 	return outBuf.Bytes(), nil
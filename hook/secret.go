@@ -0,0 +1,115 @@
+package hook
+
+import (
+	"strings"
+
+	"github.com/juju/charm/v9/hooks"
+	"gopkg.in/errgo.v1"
+)
+
+// Secret hook kinds. These are not yet defined by
+// github.com/juju/charm/v9/hooks.
+const (
+	secretRotate  hooks.Kind = "secret-rotate"
+	secretExpired hooks.Kind = "secret-expired"
+)
+
+// RegisterSecretRotate registers f to be called when the
+// secret-rotate hook runs, which happens when a secret owned by the
+// charm is due to be rotated. The function is called with the URI of
+// the secret (see Context.SecretId).
+func (r *Registry) RegisterSecretRotate(f func(ctxt *Context, uri string) error) {
+	var ctxt *Context
+	r.contexts = append(r.contexts, func(c *Context) error {
+		ctxt = c.withRegistryName(r.name)
+		return nil
+	})
+	r.RegisterHook(string(secretRotate), func() error {
+		return f(ctxt, ctxt.SecretId)
+	})
+}
+
+// RegisterSecretExpired registers f to be called when the
+// secret-expired hook runs, which happens when a revision of a secret
+// owned by the charm has expired. The function is called with the URI
+// and the expired revision of the secret (see Context.SecretId
+// and Context.SecretRevision).
+func (r *Registry) RegisterSecretExpired(f func(ctxt *Context, uri string, revision int) error) {
+	var ctxt *Context
+	r.contexts = append(r.contexts, func(c *Context) error {
+		ctxt = c.withRegistryName(r.name)
+		return nil
+	})
+	r.RegisterHook(string(secretExpired), func() error {
+		return f(ctxt, ctxt.SecretId, ctxt.SecretRevision)
+	})
+}
+
+// GetSecret returns the content of a secret as a map from key to
+// value. The secret may be referred to either by its URI (for example
+// "secret:9m4e2mr0ui3e8a215n4g") or by the label that the charm has
+// given it; anything that does not start with "secret:" is taken to be
+// a label.
+func (ctxt *Context) GetSecret(ref string) (map[string]string, error) {
+	var args []string
+	what := "secret"
+	switch {
+	case ref == "":
+		return nil, errgo.New("no secret URI or label specified")
+	case strings.HasPrefix(ref, "secret:"):
+		args = []string{ref}
+	default:
+		args = []string{"--label", ref}
+		what = "secret with label"
+	}
+	var content map[string]string
+	if err := ctxt.runJSON(&content, "secret-get", append(args, "--format", "json")...); err != nil {
+		return nil, errgo.Notef(err, "cannot get %s %q", what, ref)
+	}
+	return content, nil
+}
+
+// GrantSecret grants the application at the other end of the relation
+// with the given id read access to the secret with the given URI.
+// Only the leader may grant access to a secret owned by the
+// application, so it returns an error if the current unit is not the
+// leader.
+func (ctxt *Context) GrantSecret(uri string, relationId RelationId) error {
+	if err := ctxt.secretAccessCmd("secret-grant", uri, relationId); err != nil {
+		return errgo.Notef(err, "cannot grant secret %q", uri)
+	}
+	return nil
+}
+
+// RevokeSecret revokes access to the secret with the given URI
+// previously granted with GrantSecret to the relation with the given
+// id. Like GrantSecret, it returns an error if the current unit is not
+// the leader.
+func (ctxt *Context) RevokeSecret(uri string, relationId RelationId) error {
+	if err := ctxt.secretAccessCmd("secret-revoke", uri, relationId); err != nil {
+		return errgo.Notef(err, "cannot revoke secret %q", uri)
+	}
+	return nil
+}
+
+// secretAccessCmd runs the given secret-grant or secret-revoke
+// command for the given secret and relation.
+func (ctxt *Context) secretAccessCmd(cmd, uri string, relationId RelationId) error {
+	if !strings.HasPrefix(uri, "secret:") {
+		return errgo.New("invalid secret URI")
+	}
+	if relationId == "" {
+		return errgo.New("no relation id specified")
+	}
+	leader, err := ctxt.IsLeader()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !leader {
+		return errgo.New("unit is not the leader")
+	}
+	if _, err := ctxt.Runner.Run(cmd, uri, "-r", string(relationId)); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
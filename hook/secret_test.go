@@ -0,0 +1,210 @@
+package hook_test
+
+import (
+	"os"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type secretSuite struct {
+	savedEnv map[string]string
+}
+
+var _ = gc.Suite(&secretSuite{})
+
+var secretHookEnv = map[string]string{
+	"JUJU_MODEL_UUID":      hooktest.UUID,
+	"JUJU_UNIT_NAME":       "someunit/0",
+	"CHARM_DIR":            "/dev/null",
+	"JUJU_CONTEXT_ID":      "someunit/0-secret-1",
+	"JUJU_SECRET_ID":       "secret:9m4e2mr0ui3e8a215n4g",
+	"JUJU_SECRET_REVISION": "",
+	"JUJU_RELATION":        "",
+}
+
+func (s *secretSuite) SetUpTest(c *gc.C) {
+	s.savedEnv = make(map[string]string)
+	for name, val := range secretHookEnv {
+		s.savedEnv[name] = os.Getenv(name)
+		os.Setenv(name, val)
+	}
+}
+
+func (s *secretSuite) TearDownTest(c *gc.C) {
+	for name, val := range s.savedEnv {
+		os.Setenv(name, val)
+	}
+}
+
+type secretCall struct {
+	hook     string
+	uri      string
+	revision int
+}
+
+func (*secretSuite) runSecretHook(c *gc.C, hookName string) ([]secretCall, error) {
+	var calls []secretCall
+	r := hook.NewRegistry()
+	r.RegisterSecretRotate(func(ctxt *hook.Context, uri string) error {
+		c.Check(ctxt.HookName, gc.Equals, "secret-rotate")
+		calls = append(calls, secretCall{"rotate", uri, 0})
+		return nil
+	})
+	r.Clone("sub").RegisterSecretExpired(func(ctxt *hook.Context, uri string, revision int) error {
+		c.Check(ctxt.HookName, gc.Equals, "secret-expired")
+		calls = append(calls, secretCall{"expired", uri, revision})
+		return nil
+	})
+	hook.RegisterMainHooks(r)
+	ctxt, state, err := hook.NewContextFromEnvironment(r, c.MkDir(), hookName, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctxt.Runner = &hooktest.Runner{Logger: c}
+	_, err = hook.Main(r, ctxt, state)
+	return calls, err
+}
+
+func (s *secretSuite) TestSecretRotate(c *gc.C) {
+	calls, err := s.runSecretHook(c, "secret-rotate")
+	c.Assert(err, gc.IsNil)
+	c.Assert(calls, jc.DeepEquals, []secretCall{
+		{"rotate", "secret:9m4e2mr0ui3e8a215n4g", 0},
+	})
+}
+
+func (s *secretSuite) TestSecretExpired(c *gc.C) {
+	os.Setenv("JUJU_SECRET_REVISION", "3")
+	calls, err := s.runSecretHook(c, "secret-expired")
+	c.Assert(err, gc.IsNil)
+	c.Assert(calls, jc.DeepEquals, []secretCall{
+		{"expired", "secret:9m4e2mr0ui3e8a215n4g", 3},
+	})
+}
+
+func (s *secretSuite) TestInvalidSecretRevision(c *gc.C) {
+	os.Setenv("JUJU_SECRET_REVISION", "three")
+	_, err := s.runSecretHook(c, "secret-expired")
+	c.Assert(err, gc.ErrorMatches, `invalid secret revision "three"`)
+}
+
+func (*secretSuite) TestSecretHooksRegistered(c *gc.C) {
+	r := hook.NewRegistry()
+	r.RegisterSecretRotate(func(*hook.Context, string) error { return nil })
+	r.RegisterSecretExpired(func(*hook.Context, string, int) error { return nil })
+	c.Assert(r.RegisteredHooks(), jc.SameContents, []string{"secret-rotate", "secret-expired"})
+}
+
+// secretGetRunner returns a runner that satisfies secret-get
+// calls for the given secrets, keyed by URI or by label.
+func secretGetRunner(c *gc.C, secrets map[string]string) *hooktest.Runner {
+	return &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			c.Assert(cmd, gc.Equals, "secret-get")
+			key := strings.Join(args, " ")
+			if content, ok := secrets[key]; ok {
+				return []byte(content), nil
+			}
+			return nil, errgo.New("secret not found")
+		},
+	}
+}
+
+func (*secretSuite) TestGetSecretByURI(c *gc.C) {
+	runner := secretGetRunner(c, map[string]string{
+		"secret:9m4e2mr0ui3e8a215n4g --format json": `{"password": "hunter2"}`,
+	})
+	ctxt := &hook.Context{Runner: runner}
+	content, err := ctxt.GetSecret("secret:9m4e2mr0ui3e8a215n4g")
+	c.Assert(err, gc.IsNil)
+	c.Assert(content, jc.DeepEquals, map[string]string{"password": "hunter2"})
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"secret-get", "secret:9m4e2mr0ui3e8a215n4g", "--format", "json"},
+	})
+}
+
+func (*secretSuite) TestGetSecretByLabel(c *gc.C) {
+	runner := secretGetRunner(c, map[string]string{
+		"--label db-credentials --format json": `{"username": "admin", "password": "hunter2"}`,
+	})
+	ctxt := &hook.Context{Runner: runner}
+	content, err := ctxt.GetSecret("db-credentials")
+	c.Assert(err, gc.IsNil)
+	c.Assert(content, jc.DeepEquals, map[string]string{"username": "admin", "password": "hunter2"})
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"secret-get", "--label", "db-credentials", "--format", "json"},
+	})
+}
+
+func (*secretSuite) TestGetSecretNotFound(c *gc.C) {
+	ctxt := &hook.Context{Runner: secretGetRunner(c, nil)}
+	_, err := ctxt.GetSecret("secret:nosuchsecret")
+	c.Assert(err, gc.ErrorMatches, `cannot get secret "secret:nosuchsecret": secret not found`)
+	_, err = ctxt.GetSecret("nosuchlabel")
+	c.Assert(err, gc.ErrorMatches, `cannot get secret with label "nosuchlabel": secret not found`)
+	_, err = ctxt.GetSecret("")
+	c.Assert(err, gc.ErrorMatches, `no secret URI or label specified`)
+}
+
+// leaderRunner returns a runner that reports whether the
+// unit is the leader according to leader and succeeds
+// for any other command.
+func leaderRunner(c *gc.C, leader bool) *hooktest.Runner {
+	return &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			if cmd == "is-leader" {
+				if leader {
+					return []byte("true\n"), nil
+				}
+				return []byte("false\n"), nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+func (*secretSuite) TestGrantAndRevokeSecret(c *gc.C) {
+	runner := leaderRunner(c, true)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.GrantSecret("secret:9m4e2mr0ui3e8a215n4g", "db:3")
+	c.Assert(err, gc.IsNil)
+	err = ctxt.RevokeSecret("secret:9m4e2mr0ui3e8a215n4g", "db:3")
+	c.Assert(err, gc.IsNil)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"is-leader", "--format", "json"},
+		{"secret-grant", "secret:9m4e2mr0ui3e8a215n4g", "-r", "db:3"},
+		{"is-leader", "--format", "json"},
+		{"secret-revoke", "secret:9m4e2mr0ui3e8a215n4g", "-r", "db:3"},
+	})
+}
+
+func (*secretSuite) TestGrantAndRevokeSecretNotLeader(c *gc.C) {
+	runner := leaderRunner(c, false)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.GrantSecret("secret:9m4e2mr0ui3e8a215n4g", "db:3")
+	c.Assert(err, gc.ErrorMatches, `cannot grant secret "secret:9m4e2mr0ui3e8a215n4g": unit is not the leader`)
+	err = ctxt.RevokeSecret("secret:9m4e2mr0ui3e8a215n4g", "db:3")
+	c.Assert(err, gc.ErrorMatches, `cannot revoke secret "secret:9m4e2mr0ui3e8a215n4g": unit is not the leader`)
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"is-leader", "--format", "json"},
+		{"is-leader", "--format", "json"},
+	})
+}
+
+func (*secretSuite) TestGrantSecretInvalidArgs(c *gc.C) {
+	runner := leaderRunner(c, true)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.GrantSecret("db-credentials", "db:3")
+	c.Assert(err, gc.ErrorMatches, `cannot grant secret "db-credentials": invalid secret URI`)
+	err = ctxt.RevokeSecret("secret:9m4e2mr0ui3e8a215n4g", "")
+	c.Assert(err, gc.ErrorMatches, `cannot revoke secret "secret:9m4e2mr0ui3e8a215n4g": no relation id specified`)
+	c.Assert(runner.Record, gc.HasLen, 0)
+}
@@ -0,0 +1,33 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/errgo.v1"
+)
+
+// StorageList returns the ids of all the storage instances attached
+// to the unit for the storage with the given name, as declared in
+// the charm's metadata; for example ["data/0", "data/1"]. If name is
+// empty, the ids of all attached storage instances are returned. It
+// returns an empty slice if no storage is attached.
+func (ctxt *Context) StorageList(name string) ([]string, error) {
+	args := []string{"--format", "json"}
+	if name != "" {
+		args = append(args, "--", name)
+	}
+	out, err := ctxt.Runner.Run("storage-list", args...)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	ids := []string{}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return ids, nil
+	}
+	var val []string
+	if err := json.Unmarshal(out, &val); err != nil {
+		return nil, errgo.Notef(err, "cannot parse command output %q", out)
+	}
+	return append(ids, val...), nil
+}
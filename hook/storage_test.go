@@ -0,0 +1,72 @@
+package hook_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type storageSuite struct{}
+
+var _ = gc.Suite(&storageSuite{})
+
+var storageListTests = []struct {
+	about       string
+	name        string
+	output      string
+	expectIds   []string
+	expectArgs  []string
+	expectError string
+}{{
+	about:      "several instances",
+	name:       "data",
+	output:     `["data/0","data/1"]` + "\n",
+	expectIds:  []string{"data/0", "data/1"},
+	expectArgs: []string{"storage-list", "--format", "json", "--", "data"},
+}, {
+	about:      "all storage",
+	output:     `["data/0","logs/2"]`,
+	expectIds:  []string{"data/0", "logs/2"},
+	expectArgs: []string{"storage-list", "--format", "json"},
+}, {
+	about:      "no storage attached",
+	name:       "data",
+	output:     "",
+	expectIds:  []string{},
+	expectArgs: []string{"storage-list", "--format", "json", "--", "data"},
+}, {
+	about:      "null output",
+	name:       "data",
+	output:     "null\n",
+	expectIds:  []string{},
+	expectArgs: []string{"storage-list", "--format", "json", "--", "data"},
+}, {
+	about:       "invalid output",
+	name:        "data",
+	output:      "data/0",
+	expectArgs:  []string{"storage-list", "--format", "json", "--", "data"},
+	expectError: `cannot parse command output "data/0": .*`,
+}}
+
+func (*storageSuite) TestStorageList(c *gc.C) {
+	for i, test := range storageListTests {
+		c.Logf("test %d: %s", i, test.about)
+		runner := &hooktest.Runner{
+			Logger: c,
+			RunFunc: func(cmd string, args ...string) ([]byte, error) {
+				return []byte(test.output), nil
+			},
+		}
+		ctxt := &hook.Context{Runner: runner}
+		ids, err := ctxt.StorageList(test.name)
+		c.Assert(runner.Record, jc.DeepEquals, [][]string{test.expectArgs})
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(ids, jc.DeepEquals, test.expectIds)
+	}
+}
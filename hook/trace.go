@@ -0,0 +1,218 @@
+package hook
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// envTraceOTLP holds the name of the environment variable that
+// enables span export. It holds the base URL of an OTLP/HTTP
+// collector, such as "http://localhost:4318".
+const envTraceOTLP = "GOCHARM_TRACE_OTLP"
+
+// traceExportTimeout holds the maximum time spent
+// exporting the spans recorded during a hook.
+const traceExportTimeout = 5 * time.Second
+
+// StartSpan starts a trace span with the given name and returns a
+// function that ends it. Spans started during a hook are children of
+// a span covering the whole hook, and are exported when the hook
+// completes to the OTLP/HTTP collector whose base URL is held in the
+// $GOCHARM_TRACE_OTLP environment variable. If that is not set,
+// StartSpan does nothing. For example:
+//
+//	defer ctxt.StartSpan("install-packages")()
+func (ctxt *Context) StartSpan(name string) (end func()) {
+	if ctxt.tracer == nil {
+		return func() {}
+	}
+	return ctxt.tracer.start(name, ctxt.tracer.rootId)
+}
+
+// startHookSpan starts the span covering the whole hook, which is
+// the parent of all the spans started with StartSpan, and returns
+// a function that ends it.
+func (ctxt *Context) startHookSpan() (end func()) {
+	if ctxt.tracer == nil {
+		return func() {}
+	}
+	return ctxt.tracer.startWithId(ctxt.tracer.rootId, ctxt.HookName, "")
+}
+
+// exportSpans exports the spans recorded during the hook.
+// Any error is logged rather than causing the hook to fail.
+func (ctxt *Context) exportSpans() {
+	if ctxt.tracer == nil {
+		return
+	}
+	err := ctxt.tracer.export([]attribute{
+		{"service.name", applicationName(ctxt.Unit)},
+		{"juju.unit", string(ctxt.Unit)},
+		{"juju.hook", ctxt.HookName},
+	})
+	if err != nil {
+		ctxt.logLevelf(LevelWarning, "%v", err)
+	}
+}
+
+// tracer records the spans started during a hook. It is shared
+// between all contexts derived from the same hook context.
+type tracer struct {
+	endpoint string
+	traceId  string
+	rootId   string
+
+	mu    sync.Mutex
+	spans []span
+}
+
+// span holds a completed span.
+type span struct {
+	id       string
+	parentId string
+	name     string
+	start    time.Time
+	end      time.Time
+}
+
+// newTracer returns a tracer that exports spans to the
+// collector with the given base URL, or nil if the URL is empty.
+func newTracer(endpoint string) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		traceId:  randomHex(16),
+		rootId:   randomHex(8),
+	}
+}
+
+// start starts a span with the given parent and
+// returns a function that ends it.
+func (t *tracer) start(name, parentId string) func() {
+	return t.startWithId(randomHex(8), name, parentId)
+}
+
+// startWithId is like start except that it
+// also specifies the id of the span.
+func (t *tracer) startWithId(id, name, parentId string) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.spans = append(t.spans, span{
+				id:       id,
+				parentId: parentId,
+				name:     name,
+				start:    start,
+				end:      time.Now(),
+			})
+		})
+	}
+}
+
+// attribute holds a key-value pair attached
+// to the exported resource.
+type attribute struct {
+	key, value string
+}
+
+// export sends all the spans recorded so far to the collector.
+// The given attributes are attached to the exported resource.
+func (t *tracer) export(attrs []attribute) error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(otlpRequest(t.traceId, attrs, spans))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client := &http.Client{
+		Timeout: traceExportTimeout,
+	}
+	resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errgo.Notef(err, "cannot export spans")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		if body = bytes.TrimSpace(body); len(body) > 0 {
+			return errgo.Newf("cannot export spans: %s: %s", resp.Status, body)
+		}
+		return errgo.Newf("cannot export spans: %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpRequest returns the OTLP trace export request holding
+// the given spans, in the JSON encoding defined by OTLP/HTTP.
+func otlpRequest(traceId string, attrs []attribute, spans []span) map[string]interface{} {
+	resourceAttrs := make([]map[string]interface{}, len(attrs))
+	for i, attr := range attrs {
+		resourceAttrs[i] = map[string]interface{}{
+			"key": attr.key,
+			"value": map[string]interface{}{
+				"stringValue": attr.value,
+			},
+		}
+	}
+	otlpSpans := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		otlpSpans[i] = map[string]interface{}{
+			"traceId":           traceId,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentId != "" {
+			otlpSpans[i]["parentSpanId"] = s.parentId
+		}
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": resourceAttrs,
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": "github.com/mever/gocharm/v2/hook",
+						},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(errgo.Notef(err, "cannot read random bytes"))
+	}
+	return hex.EncodeToString(buf)
+}
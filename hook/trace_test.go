@@ -0,0 +1,150 @@
+package hook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type traceSuite struct {
+	savedEndpoint string
+	collector     *httptest.Server
+	requests      []otlpRequest
+	status        int
+}
+
+var _ = gc.Suite(&traceSuite{})
+
+// otlpRequest holds the parts of an OTLP trace
+// export request that are checked by the tests.
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpSpan struct {
+	TraceId           string `json:"traceId"`
+	SpanId            string `json:"spanId"`
+	ParentSpanId      string `json:"parentSpanId"`
+	Name              string `json:"name"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+}
+
+func (s *traceSuite) SetUpTest(c *gc.C) {
+	s.savedEndpoint = os.Getenv("GOCHARM_TRACE_OTLP")
+	os.Unsetenv("GOCHARM_TRACE_OTLP")
+	s.requests = nil
+	s.status = http.StatusOK
+	s.collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.Method, gc.Equals, "POST")
+		c.Check(req.URL.Path, gc.Equals, "/v1/traces")
+		c.Check(req.Header.Get("Content-Type"), gc.Equals, "application/json")
+		data, err := ioutil.ReadAll(req.Body)
+		c.Check(err, gc.IsNil)
+		var r otlpRequest
+		c.Check(json.Unmarshal(data, &r), gc.IsNil)
+		s.requests = append(s.requests, r)
+		w.WriteHeader(s.status)
+	}))
+}
+
+func (s *traceSuite) TearDownTest(c *gc.C) {
+	s.collector.Close()
+	os.Setenv("GOCHARM_TRACE_OTLP", s.savedEndpoint)
+}
+
+// runTracedHook runs a config-changed hook that
+// records a span named "work".
+func runTracedHook(c *gc.C, logger interface {
+	Logf(string, ...interface{})
+}) error {
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       logger,
+		RegisterHooks: func(r *hook.Registry) {
+			var ctxt *hook.Context
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			r.RegisterHook("config-changed", func() error {
+				defer ctxt.StartSpan("work")()
+				return nil
+			})
+		},
+	}
+	return runner.RunHook("config-changed", "", "")
+}
+
+func (s *traceSuite) TestSpansExported(c *gc.C) {
+	os.Setenv("GOCHARM_TRACE_OTLP", s.collector.URL+"/")
+	err := runTracedHook(c, c)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.requests, gc.HasLen, 1)
+	c.Assert(s.requests[0].ResourceSpans, gc.HasLen, 1)
+	rs := s.requests[0].ResourceSpans[0]
+	attrs := make(map[string]string)
+	for _, attr := range rs.Resource.Attributes {
+		attrs[attr.Key] = attr.Value.StringValue
+	}
+	c.Assert(attrs, jc.DeepEquals, map[string]string{
+		"service.name": "someunit",
+		"juju.unit":    "someunit/0",
+		"juju.hook":    "config-changed",
+	})
+	c.Assert(rs.ScopeSpans, gc.HasLen, 1)
+	spans := rs.ScopeSpans[0].Spans
+	c.Assert(spans, gc.HasLen, 2)
+	work, root := spans[0], spans[1]
+	c.Assert(work.Name, gc.Equals, "work")
+	c.Assert(root.Name, gc.Equals, "config-changed")
+	c.Assert(root.ParentSpanId, gc.Equals, "")
+	c.Assert(work.ParentSpanId, gc.Equals, root.SpanId)
+	c.Assert(work.TraceId, gc.Equals, root.TraceId)
+	c.Assert(root.TraceId, gc.Matches, "[0-9a-f]{32}")
+	c.Assert(root.SpanId, gc.Matches, "[0-9a-f]{16}")
+	c.Assert(work.SpanId, gc.Not(gc.Equals), root.SpanId)
+	c.Assert(root.StartTimeUnixNano <= work.StartTimeUnixNano, gc.Equals, true)
+}
+
+func (s *traceSuite) TestSpansNotExportedWhenDisabled(c *gc.C) {
+	err := runTracedHook(c, c)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.requests, gc.HasLen, 0)
+
+	// StartSpan also works on a context that is
+	// not created for a hook.
+	ctxt := &hook.Context{}
+	ctxt.StartSpan("nothing")()
+}
+
+func (s *traceSuite) TestExportErrorDoesNotFailHook(c *gc.C) {
+	os.Setenv("GOCHARM_TRACE_OTLP", s.collector.URL)
+	s.status = http.StatusServiceUnavailable
+	var logger recordingLogger
+	err := runTracedHook(c, &logger)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.requests, gc.HasLen, 1)
+	c.Assert(logger.msgs, gc.HasLen, 3)
+	c.Assert(logger.msgs[1], gc.Matches, `WARNING: cannot export spans: 503 Service Unavailable`)
+}
@@ -0,0 +1,26 @@
+package hook
+
+import (
+	"github.com/juju/names/v4"
+	"gopkg.in/errgo.v1"
+)
+
+// ApplicationName returns the name of the application that the
+// current unit belongs to, for example "myapp" for the unit
+// "myapp/3". It returns an error if the unit name is malformed.
+func (ctxt *Context) ApplicationName() (string, error) {
+	if !names.IsValidUnit(string(ctxt.Unit)) {
+		return "", errgo.Newf("invalid unit name %q", ctxt.Unit)
+	}
+	return names.UnitApplication(string(ctxt.Unit))
+}
+
+// UnitNumber returns the number of the current unit within its
+// application, for example 3 for the unit "myapp/3". It returns
+// an error if the unit name is malformed.
+func (ctxt *Context) UnitNumber() (int, error) {
+	if !names.IsValidUnit(string(ctxt.Unit)) {
+		return 0, errgo.Newf("invalid unit name %q", ctxt.Unit)
+	}
+	return ctxt.Unit.Tag().Number(), nil
+}
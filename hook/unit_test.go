@@ -0,0 +1,64 @@
+package hook_test
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+)
+
+type unitSuite struct{}
+
+var _ = gc.Suite(&unitSuite{})
+
+var unitNameTests = []struct {
+	unit        hook.UnitId
+	expectApp   string
+	expectNum   int
+	expectError string
+}{{
+	unit:      "myapp/3",
+	expectApp: "myapp",
+	expectNum: 3,
+}, {
+	unit:      "my-app2/0",
+	expectApp: "my-app2",
+	expectNum: 0,
+}, {
+	unit:        "",
+	expectError: `invalid unit name ""`,
+}, {
+	unit:        "myapp",
+	expectError: `invalid unit name "myapp"`,
+}, {
+	unit:        "myapp/",
+	expectError: `invalid unit name "myapp/"`,
+}, {
+	unit:        "myapp/x",
+	expectError: `invalid unit name "myapp/x"`,
+}, {
+	unit:        "myapp/-1",
+	expectError: `invalid unit name "myapp/-1"`,
+}, {
+	unit:        "my/app/1",
+	expectError: `invalid unit name "my/app/1"`,
+}}
+
+func (*unitSuite) TestUnitName(c *gc.C) {
+	for i, test := range unitNameTests {
+		c.Logf("test %d: %q", i, test.unit)
+		ctxt := &hook.Context{
+			Unit: test.unit,
+		}
+		app, err := ctxt.ApplicationName()
+		num, numErr := ctxt.UnitNumber()
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(numErr, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(numErr, gc.IsNil)
+		c.Assert(app, gc.Equals, test.expectApp)
+		c.Assert(num, gc.Equals, test.expectNum)
+	}
+}
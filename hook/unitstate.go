@@ -0,0 +1,114 @@
+package hook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// unitStateFile holds the name of the file, relative to the
+// unit's state directory, that is used to store unit state when
+// the state-get and state-set hook tools are not available.
+const unitStateFile = "unit-state.json"
+
+// GetUnitState returns all the unit-local state stored
+// with SetUnitState.
+//
+// The state is held by the Juju controller using the state-get hook tool.
+// When that is not available (in Juju versions before 2.8), the state
+// is read from a file in the unit's state directory instead.
+func (ctxt *Context) GetUnitState() (map[string]string, error) {
+	var state map[string]string
+	out, err := ctxt.Runner.Run("state-get", "--format", "json")
+	switch {
+	case errgo.Cause(err) == ErrUnimplemented:
+		state, err = ctxt.loadUnitStateFile()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	case err != nil:
+		return nil, errgo.Mask(err)
+	default:
+		if err := json.Unmarshal(out, &state); err != nil {
+			return nil, errgo.Notef(err, "cannot parse command output %q", out)
+		}
+	}
+	if state == nil {
+		state = make(map[string]string)
+	}
+	return state, nil
+}
+
+// SetUnitState sets the given keys in the unit-local state.
+// Keys not mentioned in state are left unchanged, and keys
+// with an empty value are removed.
+//
+// As with GetUnitState, the file in the unit's state directory
+// is used when the state-set hook tool is not available.
+func (ctxt *Context) SetUnitState(state map[string]string) error {
+	if len(state) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys)+1)
+	args = append(args, "--")
+	for _, key := range keys {
+		args = append(args, key+"="+state[key])
+	}
+	_, err := ctxt.Runner.Run("state-set", args...)
+	if errgo.Cause(err) != ErrUnimplemented {
+		return errgo.Mask(err)
+	}
+	current, err := ctxt.loadUnitStateFile()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if current == nil {
+		current = make(map[string]string)
+	}
+	for key, val := range state {
+		if val == "" {
+			delete(current, key)
+		} else {
+			current[key] = val
+		}
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := os.MkdirAll(ctxt.unitStateDir(), 0700); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := ioutil.WriteFile(ctxt.unitStatePath(), data, 0600); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+func (ctxt *Context) loadUnitStateFile() (map[string]string, error) {
+	data, err := ioutil.ReadFile(ctxt.unitStatePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var state map[string]string
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal unit state")
+	}
+	return state, nil
+}
+
+func (ctxt *Context) unitStatePath() string {
+	return filepath.Join(ctxt.unitStateDir(), unitStateFile)
+}
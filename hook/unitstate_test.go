@@ -0,0 +1,123 @@
+package hook_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type unitStateSuite struct{}
+
+var _ = gc.Suite(&unitStateSuite{})
+
+func (*unitStateSuite) TestUnitStateWithHookTools(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			if cmd == "state-get" {
+				return []byte(`{"a":"1","b":"2"}`), nil
+			}
+			return nil, nil
+		},
+	}
+	ctxt := &hook.Context{
+		Runner:       runner,
+		HookStateDir: c.MkDir(),
+	}
+	err := ctxt.SetUnitState(map[string]string{"b": "2", "a": "1"})
+	c.Assert(err, gc.IsNil)
+	state, err := ctxt.GetUnitState()
+	c.Assert(err, gc.IsNil)
+	c.Assert(state, jc.DeepEquals, map[string]string{"a": "1", "b": "2"})
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"state-set", "--", "a=1", "b=2"},
+		{"state-get", "--format", "json"},
+	})
+}
+
+func (*unitStateSuite) TestUnitStateFallback(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return nil, errgo.WithCausef(nil, hook.ErrUnimplemented, "bad request: unknown command %q", cmd)
+		},
+	}
+	ctxt := &hook.Context{
+		Runner:       runner,
+		HookStateDir: c.MkDir(),
+		UUID:         "some-uuid",
+		Unit:         "mysql/0",
+	}
+	state, err := ctxt.GetUnitState()
+	c.Assert(err, gc.IsNil)
+	c.Assert(state, jc.DeepEquals, map[string]string{})
+
+	err = ctxt.SetUnitState(map[string]string{"a": "1", "b": "2"})
+	c.Assert(err, gc.IsNil)
+	err = ctxt.SetUnitState(map[string]string{"a": "", "c": "3"})
+	c.Assert(err, gc.IsNil)
+
+	state, err = ctxt.GetUnitState()
+	c.Assert(err, gc.IsNil)
+	c.Assert(state, jc.DeepEquals, map[string]string{"b": "2", "c": "3"})
+
+	data, err := ioutil.ReadFile(filepath.Join(ctxt.HookStateDir, "some-uuid-unit-mysql-0", "unit-state.json"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `{"b":"2","c":"3"}`)
+}
+
+func (*unitStateSuite) TestUnitStateFallbackPerUnit(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return nil, errgo.WithCausef(nil, hook.ErrUnimplemented, "bad request: unknown command %q", cmd)
+		},
+	}
+	stateDir := c.MkDir()
+	ctxt0 := &hook.Context{
+		Runner:       runner,
+		HookStateDir: stateDir,
+		UUID:         "some-uuid",
+		Unit:         "mysql/0",
+	}
+	ctxt1 := &hook.Context{
+		Runner:       runner,
+		HookStateDir: stateDir,
+		UUID:         "some-uuid",
+		Unit:         "logging/0",
+	}
+	err := ctxt0.SetUnitState(map[string]string{"a": "1"})
+	c.Assert(err, gc.IsNil)
+	err = ctxt1.SetUnitState(map[string]string{"a": "2", "b": "3"})
+	c.Assert(err, gc.IsNil)
+
+	state, err := ctxt0.GetUnitState()
+	c.Assert(err, gc.IsNil)
+	c.Assert(state, jc.DeepEquals, map[string]string{"a": "1"})
+	state, err = ctxt1.GetUnitState()
+	c.Assert(err, gc.IsNil)
+	c.Assert(state, jc.DeepEquals, map[string]string{"a": "2", "b": "3"})
+}
+
+func (*unitStateSuite) TestUnitStateError(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return nil, errgo.New("permission denied")
+		},
+	}
+	ctxt := &hook.Context{
+		Runner:       runner,
+		HookStateDir: c.MkDir(),
+	}
+	_, err := ctxt.GetUnitState()
+	c.Assert(err, gc.ErrorMatches, "permission denied")
+	err = ctxt.SetUnitState(map[string]string{"a": "1"})
+	c.Assert(err, gc.ErrorMatches, "permission denied")
+}
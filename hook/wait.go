@@ -0,0 +1,148 @@
+package hook
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrTimeout is the cause of the errors returned by
+// the Wait* methods when they time out.
+var ErrTimeout = errgo.New("timed out")
+
+// Bounds of the delay between polls used by the Wait* methods.
+const (
+	minPollDelay = 10 * time.Millisecond
+	maxPollDelay = time.Second
+)
+
+// WaitForFile waits until a file exists at the given path, polling
+// with an increasing delay between attempts. It is useful for waiting
+// for a service to create a socket or a pid file when it is ready.
+// If the file does not appear within the given timeout, it returns an
+// error with an ErrTimeout cause.
+func (ctxt *Context) WaitForFile(path string, timeout time.Duration) error {
+	err := poll(timeout, func() (bool, error) {
+		_, err := os.Stat(path)
+		switch {
+		case err == nil:
+			return true, nil
+		case os.IsNotExist(err):
+			return false, nil
+		}
+		return false, errgo.Mask(err)
+	})
+	if errgo.Cause(err) == ErrTimeout {
+		return errgo.WithCausef(nil, ErrTimeout, "timed out after %v waiting for %s", timeout, path)
+	}
+	return errgo.Mask(err)
+}
+
+// WaitForPort waits until a TCP connection can be made to the given
+// address, polling with an increasing delay between attempts. The
+// address may be of the form host:port, or just a port number, in
+// which case the port is on the local host. If no connection can be
+// made within the given timeout, it returns an error with an
+// ErrTimeout cause.
+func (ctxt *Context) WaitForPort(addr string, timeout time.Duration) error {
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = net.JoinHostPort("localhost", addr)
+	} else if _, _, err := net.SplitHostPort(addr); err != nil {
+		return errgo.Notef(err, "invalid address %q", addr)
+	}
+	var dialErr error
+	err := poll(timeout, func() (bool, error) {
+		conn, err := net.DialTimeout("tcp", addr, maxPollDelay)
+		if err != nil {
+			dialErr = err
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	})
+	if errgo.Cause(err) == ErrTimeout {
+		return errgo.WithCausef(nil, ErrTimeout, "timed out after %v waiting for %s: %v", timeout, addr, dialErr)
+	}
+	return errgo.Mask(err)
+}
+
+// WaitForPeerQuorum waits until at least min units, including this
+// one, are members of the peer relation with the given name, polling
+// with an increasing delay between attempts. It is useful for
+// clustered charms that must not act until a quorum of peers is
+// present. If the quorum is not reached within the given timeout, it
+// returns an error with an ErrTimeout cause.
+//
+// Note that Juju may not update the relation membership seen by a
+// hook until a later hook runs, so a hook that finds no quorum
+// should usually return and wait for the relation-joined hook
+// rather than use a long timeout.
+func (ctxt *Context) WaitForPeerQuorum(relName string, min int, timeout time.Duration) error {
+	if min < 1 {
+		return errgo.Newf("invalid quorum size %d", min)
+	}
+	var count int
+	err := poll(timeout, func() (bool, error) {
+		n, err := ctxt.peerCount(relName)
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		count = n
+		return count >= min, nil
+	})
+	if errgo.Cause(err) == ErrTimeout {
+		return errgo.WithCausef(nil, ErrTimeout, "timed out after %v waiting for %d units in relation %q (%d present)", timeout, min, relName, count)
+	}
+	return errgo.Mask(err)
+}
+
+// peerCount returns the number of units, including this one,
+// that are members of the peer relation with the given name.
+func (ctxt *Context) peerCount(relName string) (int, error) {
+	ids, err := ctxt.relationIds(relName)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot get relation ids for %q", relName)
+	}
+	units := make(map[UnitId]bool)
+	for _, id := range ids {
+		members, err := ctxt.relationUnits(id)
+		if err != nil {
+			return 0, errgo.Notef(err, "cannot get units of relation %s", id)
+		}
+		for _, unit := range members {
+			units[unit] = true
+		}
+	}
+	return len(units) + 1, nil
+}
+
+// poll calls check until it returns true or an error, doubling the
+// delay between calls up to maxPollDelay. If check has not succeeded
+// within the given timeout, poll returns ErrTimeout.
+func poll(timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	delay := minPollDelay
+	for {
+		ok, err := check()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if ok {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrTimeout
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxPollDelay {
+			delay = maxPollDelay
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package hook_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type waitSuite struct{}
+
+var _ = gc.Suite(&waitSuite{})
+
+func (*waitSuite) TestWaitForFileCreatedLater(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "service.pid")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		err := ioutil.WriteFile(path, []byte("1234"), 0644)
+		c.Check(err, gc.IsNil)
+	}()
+	ctxt := &hook.Context{}
+	err := ctxt.WaitForFile(path, 5*time.Second)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*waitSuite) TestWaitForFileExists(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "service.pid")
+	err := ioutil.WriteFile(path, nil, 0644)
+	c.Assert(err, gc.IsNil)
+	ctxt := &hook.Context{}
+	err = ctxt.WaitForFile(path, 0)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*waitSuite) TestWaitForFileTimeout(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "never")
+	ctxt := &hook.Context{}
+	t0 := time.Now()
+	err := ctxt.WaitForFile(path, 100*time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `timed out after 100ms waiting for .*/never`)
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrTimeout)
+	c.Assert(time.Since(t0) >= 100*time.Millisecond, gc.Equals, true)
+}
+
+func (*waitSuite) TestWaitForPortListeningLater(c *gc.C) {
+	// Find a free port, then close the listener so that
+	// we can start listening on it again later.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gc.IsNil)
+	addr := l.Addr().String()
+	l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if !c.Check(err, gc.IsNil) {
+			return
+		}
+		defer l.Close()
+		conn, err := l.Accept()
+		if c.Check(err, gc.IsNil) {
+			conn.Close()
+		}
+	}()
+	ctxt := &hook.Context{}
+	err = ctxt.WaitForPort(addr, 5*time.Second)
+	c.Assert(err, gc.IsNil)
+	<-done
+}
+
+func (*waitSuite) TestWaitForPortLocalPort(c *gc.C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, gc.IsNil)
+	defer l.Close()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	ctxt := &hook.Context{}
+	err = ctxt.WaitForPort(port, 5*time.Second)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*waitSuite) TestWaitForPortTimeout(c *gc.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gc.IsNil)
+	addr := l.Addr().String()
+	l.Close()
+	ctxt := &hook.Context{}
+	t0 := time.Now()
+	err = ctxt.WaitForPort(addr, 100*time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `timed out after 100ms waiting for 127\.0\.0\.1:[0-9]+: .*connection refused`)
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrTimeout)
+	c.Assert(time.Since(t0) >= 100*time.Millisecond, gc.Equals, true)
+}
+
+func (*waitSuite) TestWaitForPortInvalidAddress(c *gc.C) {
+	ctxt := &hook.Context{}
+	err := ctxt.WaitForPort("localhost", time.Second)
+	c.Assert(err, gc.ErrorMatches, `invalid address "localhost": .*`)
+}
+
+// growingPeersRunner returns a hooktest.Runner for a unit whose
+// "cluster" peer relation gains another member each time
+// relation-list is called, up to the given maximum.
+func growingPeersRunner(c *gc.C, max int) *hooktest.Runner {
+	var peers []string
+	return &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			switch cmd {
+			case "relation-ids":
+				return []byte(`["cluster:0"]`), nil
+			case "relation-list":
+				if len(peers) < max {
+					peers = append(peers, fmt.Sprintf("someunit/%d", len(peers)+1))
+				}
+				return json.Marshal(peers)
+			}
+			return nil, fmt.Errorf("unexpected command %q", cmd)
+		},
+	}
+}
+
+func (*waitSuite) TestWaitForPeerQuorum(c *gc.C) {
+	runner := growingPeersRunner(c, 5)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.WaitForPeerQuorum("cluster", 3, 5*time.Second)
+	c.Assert(err, gc.IsNil)
+	// This unit counts towards the quorum, so two peers are enough.
+	c.Assert(runner.Record, jc.DeepEquals, [][]string{
+		{"relation-ids", "--format", "json", "--", "cluster"},
+		{"relation-list", "--format", "json", "-r", "cluster:0"},
+		{"relation-ids", "--format", "json", "--", "cluster"},
+		{"relation-list", "--format", "json", "-r", "cluster:0"},
+	})
+}
+
+func (*waitSuite) TestWaitForPeerQuorumAlone(c *gc.C) {
+	runner := growingPeersRunner(c, 0)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.WaitForPeerQuorum("cluster", 1, 0)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*waitSuite) TestWaitForPeerQuorumTimeout(c *gc.C) {
+	runner := growingPeersRunner(c, 2)
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.WaitForPeerQuorum("cluster", 5, 100*time.Millisecond)
+	c.Assert(err, gc.ErrorMatches, `timed out after 100ms waiting for 5 units in relation "cluster" \(3 present\)`)
+	c.Assert(errgo.Cause(err), gc.Equals, hook.ErrTimeout)
+}
+
+func (*waitSuite) TestWaitForPeerQuorumError(c *gc.C) {
+	runner := &hooktest.Runner{
+		Logger: c,
+		RunFunc: func(cmd string, args ...string) ([]byte, error) {
+			return nil, fmt.Errorf("no such relation")
+		},
+	}
+	ctxt := &hook.Context{Runner: runner}
+	err := ctxt.WaitForPeerQuorum("cluster", 2, 5*time.Second)
+	c.Assert(err, gc.ErrorMatches, `cannot get relation ids for "cluster": no such relation`)
+
+	err = ctxt.WaitForPeerQuorum("cluster", 0, time.Second)
+	c.Assert(err, gc.ErrorMatches, `invalid quorum size 0`)
+}
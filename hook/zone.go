@@ -0,0 +1,35 @@
+package hook
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// zoneCache holds the availability zone
+// once it has been looked up.
+type zoneCache struct {
+	once sync.Once
+	zone string
+	err  error
+}
+
+// AvailabilityZone returns the availability zone of the machine that
+// the unit is running on, as provided by Juju. It returns the empty
+// string, and no error, if the cloud provider has no concept of
+// availability zones. The result is cached for the rest of the hook.
+func (ctxt *Context) AvailabilityZone() (string, error) {
+	if ctxt.zone == nil {
+		return lookupAvailabilityZone()
+	}
+	ctxt.zone.once.Do(func() {
+		ctxt.zone.zone, ctxt.zone.err = lookupAvailabilityZone()
+	})
+	return ctxt.zone.zone, ctxt.zone.err
+}
+
+// lookupAvailabilityZone returns the availability zone
+// that Juju passes to hooks in the environment.
+func lookupAvailabilityZone() (string, error) {
+	return strings.TrimSpace(os.Getenv(envAvailabilityZone)), nil
+}
@@ -0,0 +1,79 @@
+package hook_test
+
+import (
+	"os"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/mever/gocharm/v2/hook"
+	"github.com/mever/gocharm/v2/hook/hooktest"
+)
+
+type zoneSuite struct {
+	savedZone string
+}
+
+var _ = gc.Suite(&zoneSuite{})
+
+func (s *zoneSuite) SetUpTest(c *gc.C) {
+	s.savedZone = os.Getenv("JUJU_AVAILABILITY_ZONE")
+	os.Unsetenv("JUJU_AVAILABILITY_ZONE")
+}
+
+func (s *zoneSuite) TearDownTest(c *gc.C) {
+	os.Setenv("JUJU_AVAILABILITY_ZONE", s.savedZone)
+}
+
+// runZoneHook runs a hook that calls AvailabilityZone twice,
+// calling between in between, and returns the results.
+func runZoneHook(c *gc.C, between func()) (zone1, zone2 string) {
+	runner := &hooktest.Runner{
+		HookStateDir: c.MkDir(),
+		Logger:       c,
+		RegisterHooks: func(r *hook.Registry) {
+			var ctxt *hook.Context
+			r.RegisterContext(func(c *hook.Context) error {
+				ctxt = c
+				return nil
+			}, nil)
+			r.RegisterHook("config-changed", func() error {
+				var err error
+				zone1, err = ctxt.AvailabilityZone()
+				if err != nil {
+					return err
+				}
+				between()
+				zone2, err = ctxt.AvailabilityZone()
+				return err
+			})
+		},
+	}
+	err := runner.RunHook("config-changed", "", "")
+	c.Assert(err, gc.IsNil)
+	return zone1, zone2
+}
+
+func (*zoneSuite) TestAvailabilityZone(c *gc.C) {
+	os.Setenv("JUJU_AVAILABILITY_ZONE", "us-east-1a")
+	zone1, zone2 := runZoneHook(c, func() {
+		os.Setenv("JUJU_AVAILABILITY_ZONE", "us-east-1b")
+	})
+	c.Assert(zone1, gc.Equals, "us-east-1a")
+	// The zone is cached for the rest of the hook.
+	c.Assert(zone2, gc.Equals, "us-east-1a")
+
+	// The next hook sees the new zone.
+	zone1, _ = runZoneHook(c, func() {})
+	c.Assert(zone1, gc.Equals, "us-east-1b")
+}
+
+func (*zoneSuite) TestNoAvailabilityZone(c *gc.C) {
+	zone1, zone2 := runZoneHook(c, func() {})
+	c.Assert(zone1, gc.Equals, "")
+	c.Assert(zone2, gc.Equals, "")
+
+	ctxt := &hook.Context{}
+	zone, err := ctxt.AvailabilityZone()
+	c.Assert(err, gc.IsNil)
+	c.Assert(zone, gc.Equals, "")
+}